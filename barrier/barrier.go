@@ -0,0 +1,75 @@
+package barrier
+
+// Simple ZooKeeper single barrier recipe.
+//
+// A barrier is "up" for as long as its zNode exists.  Waiters block until the
+// zNode is removed.
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	worldAllAcl = zk.WorldACL(zk.PermAll)
+
+	resources primitives.Tracker // Watches pending in WaitOnBarrier.
+)
+
+// Resources returns an account of the watches pending in WaitOnBarrier
+// invocations, across all connections.
+func Resources() primitives.Resources {
+	return resources.Resources()
+}
+
+// SetBarrier raises the barrier at path, creating any missing parents.  Setting
+// an already raised barrier is not an error.
+func SetBarrier(conn *zk.Conn, path string) error {
+	path = zkutil.NormalizePath(path)
+	if _, err := zkutil.CreateP(conn, path, []byte{}, 0, worldAllAcl); err != nil {
+		return fmt.Errorf("setting barrier path=%v: %s", path, err)
+	}
+	return nil
+}
+
+// RemoveBarrier lowers the barrier at path, releasing all waiters.  Removing
+// an absent barrier is not an error.
+func RemoveBarrier(conn *zk.Conn, path string) error {
+	path = zkutil.NormalizePath(path)
+	if err := conn.Delete(path, -1); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("removing barrier path=%v: %s", path, err)
+	}
+	return nil
+}
+
+// WaitOnBarrier blocks until the barrier at path has been removed or ctx is
+// done.  It returns immediately when no barrier is set.
+func WaitOnBarrier(ctx context.Context, conn *zk.Conn, path string) error {
+	path = zkutil.NormalizePath(path)
+	for {
+		exists, _, watch, err := conn.ExistsW(path)
+		if err != nil {
+			return fmt.Errorf("watching barrier path=%v: %s", path, err)
+		}
+		if !exists {
+			return nil
+		}
+		resources.Watch(path, watch)
+		select {
+		case ev := <-watch:
+			resources.Unwatch(watch)
+			if ev.Err != nil {
+				return fmt.Errorf("watching barrier path=%v: %s", path, ev.Err)
+			}
+			// Re-check existence, re-arming the watch if it is still up.
+		case <-ctx.Done():
+			resources.Unwatch(watch)
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package barrier_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/barrier"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	zkTimeout       = 5 * time.Second
+	zkDeleteRetries = 10
+)
+
+func TestBarrier(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			path := fmt.Sprintf("/%v/barrier", testlib.CurrentRunningTest())
+			if err := zkutil.RecursivelyDelete(conn, path, zkDeleteRetries); err != nil {
+				t.Fatal(err)
+			}
+
+			checkLeaks := zktestutil.CheckLeaks(t, zktestutil.ResourcesFunc(barrier.Resources))
+
+			// No barrier set means no waiting.
+			if err := barrier.WaitOnBarrier(context.Background(), conn, path); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := barrier.SetBarrier(conn, path); err != nil {
+				t.Fatal(err)
+			}
+			if err := barrier.SetBarrier(conn, path); err != nil {
+				t.Fatalf("Setting an already set barrier should not fail: %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+			if err := barrier.WaitOnBarrier(ctx, conn, path); err != context.DeadlineExceeded {
+				t.Fatalf("Expected err=%s while barrier is set but actual=%v", context.DeadlineExceeded, err)
+			}
+			cancel()
+
+			released := make(chan error, 1)
+			go func() {
+				released <- barrier.WaitOnBarrier(context.Background(), conn, path)
+			}()
+
+			if err := barrier.RemoveBarrier(conn, path); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case err := <-released:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(zkTimeout):
+				t.Fatalf("Timed out after %s waiting for barrier waiter to be released", zkTimeout)
+			}
+
+			if err := barrier.RemoveBarrier(conn, path); err != nil {
+				t.Fatalf("Removing an absent barrier should not fail: %s", err)
+			}
+			checkLeaks()
+		})
+	})
+}
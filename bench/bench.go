@@ -0,0 +1,316 @@
+// Package bench provides tooling for measuring Coordinator performance against
+// a real ensemble: election convergence and failover times, Members() latency,
+// and update fan-out.  It backs both the package benchmarks and the zkloadgen
+// command.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+var (
+	DefaultSessionTimeout = 5 * time.Second
+	pollInterval          = 5 * time.Millisecond
+)
+
+// Cluster is a group of simulated members participating in one election.
+type Cluster struct {
+	ZkServers      []string
+	ElectionPath   string
+	SessionTimeout time.Duration
+	Configure      func(cc *cluster.Coordinator) // Optionally invoked on each member before it starts.
+	Members        []*cluster.Coordinator
+	lock           sync.Mutex
+}
+
+// NewCluster starts n members and waits until they have all joined.
+func NewCluster(ctx context.Context, zkServers []string, electionPath string, n int) (*Cluster, error) {
+	return NewConfiguredCluster(ctx, zkServers, electionPath, n, nil)
+}
+
+// NewConfiguredCluster is like NewCluster, with configure invoked on each
+// member before it starts.
+func NewConfiguredCluster(ctx context.Context, zkServers []string, electionPath string, n int, configure func(cc *cluster.Coordinator)) (*Cluster, error) {
+	c := &Cluster{
+		ZkServers:      zkServers,
+		ElectionPath:   electionPath,
+		SessionTimeout: DefaultSessionTimeout,
+		Configure:      configure,
+	}
+	for i := 0; i < n; i++ {
+		if _, err := c.AddMember(ctx); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// AddMember starts one more member and waits for it to join.
+func (c *Cluster) AddMember(ctx context.Context, subscribers ...chan primitives.Update) (*cluster.Coordinator, error) {
+	c.lock.Lock()
+	i := len(c.Members)
+	c.lock.Unlock()
+
+	cc, err := cluster.NewCoordinator(c.ZkServers, c.SessionTimeout, c.ElectionPath, fmt.Sprintf("member-%v", i), subscribers...)
+	if err != nil {
+		return nil, err
+	}
+	if c.Configure != nil {
+		c.Configure(cc)
+	}
+	if err := cc.StartAndWait(ctx); err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.Members = append(c.Members, cc)
+	c.lock.Unlock()
+	return cc, nil
+}
+
+// RemoveMember stops the member and removes it from the cluster.
+func (c *Cluster) RemoveMember(cc *cluster.Coordinator) error {
+	c.lock.Lock()
+	revised := make([]*cluster.Coordinator, 0, len(c.Members))
+	for _, member := range c.Members {
+		if member != cc {
+			revised = append(revised, member)
+		}
+	}
+	c.Members = revised
+	c.lock.Unlock()
+	return cc.Stop()
+}
+
+// Leader returns the member which believes itself to be the leader, or nil.
+func (c *Cluster) Leader() *cluster.Coordinator {
+	for _, cc := range c.members() {
+		if cc.Mode() == primitives.Leader {
+			return cc
+		}
+	}
+	return nil
+}
+
+// WaitForConvergence blocks until every member agrees on the same leader, and
+// returns how long that took.
+func (c *Cluster) WaitForConvergence(ctx context.Context) (time.Duration, error) {
+	started := time.Now()
+	for {
+		if c.converged() {
+			return time.Since(started), nil
+		}
+		select {
+		case <-ctx.Done():
+			return time.Since(started), fmt.Errorf("members did not converge on a leader: %s", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (c *Cluster) converged() bool {
+	var expected *primitives.Node
+	for _, cc := range c.members() {
+		leader := cc.Leader()
+		if leader == nil || (expected != nil && leader.Uuid != expected.Uuid) {
+			return false
+		}
+		expected = leader
+	}
+	return expected != nil
+}
+
+// Failover stops the current leader and returns how long the remaining members
+// took to converge on its successor.  A replacement member is started
+// afterwards so the cluster size stays constant.
+func (c *Cluster) Failover(ctx context.Context) (time.Duration, error) {
+	leader := c.Leader()
+	if leader == nil {
+		return 0, fmt.Errorf("no leader to fail over from")
+	}
+	started := time.Now()
+	if err := c.RemoveMember(leader); err != nil {
+		return 0, err
+	}
+	if _, err := c.WaitForConvergence(ctx); err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(started)
+	if _, err := c.AddMember(ctx); err != nil {
+		return elapsed, err
+	}
+	return elapsed, nil
+}
+
+// Stop stops all members.
+func (c *Cluster) Stop() error {
+	var firstErr error
+	for _, cc := range c.members() {
+		if err := c.RemoveMember(cc); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *Cluster) members() []*cluster.Coordinator {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]*cluster.Coordinator{}, c.Members...)
+}
+
+// MembersLatency times a single Members() call on cc.
+func MembersLatency(cc *cluster.Coordinator) (time.Duration, error) {
+	started := time.Now()
+	if _, err := cc.Members(); err != nil {
+		return 0, err
+	}
+	return time.Since(started), nil
+}
+
+// FanOut subscribes n channels to cc, adds and removes a member to trigger
+// updates rounds times, and returns the total number of updates delivered
+// along with how long it took.
+func FanOut(ctx context.Context, c *Cluster, cc *cluster.Coordinator, n int, rounds int) (delivered int, elapsed time.Duration, err error) {
+	var (
+		subs = make([]chan primitives.Update, n)
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		done = make(chan struct{})
+	)
+	for i := range subs {
+		subs[i] = make(chan primitives.Update, 2*rounds+1)
+		cc.Subscribe(subs[i])
+		wg.Add(1)
+		go func(sub chan primitives.Update) {
+			defer wg.Done()
+			for {
+				select {
+				case <-sub:
+					lock.Lock()
+					delivered++
+					lock.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}(subs[i])
+	}
+	defer func() {
+		for _, sub := range subs {
+			cc.Unsubscribe(sub)
+		}
+		close(done)
+		wg.Wait()
+	}()
+
+	started := time.Now()
+	for i := 0; i < rounds; i++ {
+		member, err := c.AddMember(ctx)
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := c.RemoveMember(member); err != nil {
+			return 0, 0, err
+		}
+	}
+	// Allow in-flight deliveries to land.
+	expected := 2 * rounds * n
+	for time.Since(started) < c.SessionTimeout {
+		lock.Lock()
+		d := delivered
+		lock.Unlock()
+		if d >= expected {
+			break
+		}
+		time.Sleep(pollInterval)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return delivered, time.Since(started), nil
+}
+
+// Notifications subscribes to every current member, then adds and removes a
+// member rounds times, and returns the number of updates the existing members
+// were notified with.  Each update reflects a watch notification the member
+// acted upon.
+func Notifications(ctx context.Context, c *Cluster, rounds int) (int, error) {
+	var (
+		members = c.members()
+		sub     = make(chan primitives.Update, 2*rounds*len(members)+1)
+	)
+	for _, cc := range members {
+		cc.Subscribe(sub)
+	}
+	defer func() {
+		for _, cc := range members {
+			cc.Unsubscribe(sub)
+		}
+	}()
+
+	for i := 0; i < rounds; i++ {
+		member, err := c.AddMember(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.RemoveMember(member); err != nil {
+			return 0, err
+		}
+	}
+	// Allow in-flight notifications to land.
+	time.Sleep(100 * time.Millisecond)
+	return len(sub), nil
+}
+
+// Summary describes a set of duration samples.
+type Summary struct {
+	N    int
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+func Summarize(samples []time.Duration) Summary {
+	if len(samples) == 0 {
+		return Summary{}
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Sort(durations(sorted))
+
+	var total time.Duration
+	for _, sample := range sorted {
+		total += sample
+	}
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	summary := Summary{
+		N:    len(sorted),
+		Min:  sorted[0],
+		Mean: total / time.Duration(len(sorted)),
+		P50:  percentile(0.50),
+		P99:  percentile(0.99),
+		Max:  sorted[len(sorted)-1],
+	}
+	return summary
+}
+
+func (summary Summary) String() string {
+	return fmt.Sprintf("n=%v min=%s mean=%s p50=%s p99=%s max=%s", summary.N, summary.Min, summary.Mean, summary.P50, summary.P99, summary.Max)
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
@@ -0,0 +1,171 @@
+package bench_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/bench"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func withCluster(b *testing.B, n int, fn func(c *bench.Cluster)) {
+	testutil.WithZk(b, 1, "127.0.0.1:2181", func(zkServers []string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n+5)*time.Second)
+		defer cancel()
+		c, err := bench.NewCluster(ctx, zkServers, testutil.Namespace(b), n)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer c.Stop()
+		if _, err := c.WaitForConvergence(ctx); err != nil {
+			b.Fatal(err)
+		}
+		fn(c)
+	})
+}
+
+func BenchmarkElectionConvergence(b *testing.B) {
+	for _, n := range []int{2, 5, 10} {
+		b.Run(fmt.Sprintf("members=%v", n), func(b *testing.B) {
+			withCluster(b, n, func(c *bench.Cluster) {
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					elapsed, err := c.Failover(ctx)
+					cancel()
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.Logf("failover converged in %s", elapsed)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMembers(b *testing.B) {
+	for _, n := range []int{1, 5, 10, 25} {
+		b.Run(fmt.Sprintf("members=%v", n), func(b *testing.B) {
+			withCluster(b, n, func(c *bench.Cluster) {
+				cc := c.Members[0]
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := cc.Members(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMembersConcurrent compares refresh storms, i.e. many concurrent
+// membership listings, with and without BatchReads.
+func BenchmarkMembersConcurrent(b *testing.B) {
+	const (
+		n       = 25
+		callers = 20
+	)
+	for _, batchReads := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batchReads=%v", batchReads), func(b *testing.B) {
+			testutil.WithZk(b, 1, "127.0.0.1:2181", func(zkServers []string) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n+5)*time.Second)
+				defer cancel()
+				configure := func(cc *cluster.Coordinator) {
+					cc.BatchReads = batchReads
+				}
+				c, err := bench.NewConfiguredCluster(ctx, zkServers, testutil.Namespace(b), n, configure)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Stop()
+				if _, err := c.WaitForConvergence(ctx); err != nil {
+					b.Fatal(err)
+				}
+				cc := c.Members[0]
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					var wg sync.WaitGroup
+					wg.Add(callers)
+					for j := 0; j < callers; j++ {
+						go func() {
+							defer wg.Done()
+							if _, err := cc.Members(); err != nil {
+								b.Error(err)
+							}
+						}()
+					}
+					wg.Wait()
+				}
+				b.StopTimer()
+				stats := cc.Stats()
+				b.Logf("reads issued=%v coalesced=%v", stats.ReadsIssued, stats.ReadsCoalesced)
+			})
+		})
+	}
+}
+
+func BenchmarkUpdateFanOut(b *testing.B) {
+	for _, subscribers := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("subscribers=%v", subscribers), func(b *testing.B) {
+			withCluster(b, 1, func(c *bench.Cluster) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(b.N+10)*time.Second)
+				defer cancel()
+				b.ResetTimer()
+				delivered, elapsed, err := bench.FanOut(ctx, c, c.Members[0], subscribers, b.N)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.Logf("delivered %v updates in %s (%.1f updates/s)", delivered, elapsed, float64(delivered)/elapsed.Seconds())
+			})
+		})
+	}
+}
+
+func BenchmarkChurnNotifications(b *testing.B) {
+	const n = 25
+	for _, watchPredecessor := range []bool{false, true} {
+		b.Run(fmt.Sprintf("watchPredecessor=%v", watchPredecessor), func(b *testing.B) {
+			testutil.WithZk(b, 1, "127.0.0.1:2181", func(zkServers []string) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(n+b.N+10)*time.Second)
+				defer cancel()
+				configure := func(cc *cluster.Coordinator) {
+					cc.WatchPredecessor = watchPredecessor
+				}
+				c, err := bench.NewConfiguredCluster(ctx, zkServers, testutil.Namespace(b), n, configure)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer c.Stop()
+				if _, err := c.WaitForConvergence(ctx); err != nil {
+					b.Fatal(err)
+				}
+				b.ResetTimer()
+				notifications, err := bench.Notifications(ctx, c, b.N)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.Logf("%v members were notified %.1f times per join and departure", n, float64(notifications)/float64(2*b.N))
+			})
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	samples := []time.Duration{}
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	summary := bench.Summarize(samples)
+	expected := bench.Summary{N: 100, Min: time.Millisecond, Mean: 50500 * time.Microsecond, P50: 50 * time.Millisecond, P99: 99 * time.Millisecond, Max: 100 * time.Millisecond}
+	if summary != expected {
+		t.Errorf("Expected summary=%v but actual=%v", expected, summary)
+	}
+	if empty := bench.Summarize(nil); empty.N != 0 {
+		t.Errorf("Expected empty summary for no samples, but actual=%v", empty)
+	}
+}
@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	MemberNotFoundError          = errors.New("member not found")
+	SessionMismatchError         = errors.New("member session id does not match, refusing to remove what may be a live member")
+	CannotRemoveLocalMemberError = errors.New("refusing to force-remove the local member, use Stop() instead")
+)
+
+// MemberSession describes a member's election zNode along with the ZooKeeper
+// session which owns it.
+type MemberSession struct {
+	Node      primitives.Node
+	ZNode     string
+	SessionId int64
+}
+
+// ListMemberSessions returns every member of the election along with its
+// owning session id, ordered by zNode.  Children which are not sequential
+// zNodes are ignored, as are those codec fails to decode, which are logged.
+// codec defaults to DefaultCodec when nil.
+//
+// candidatesPath is where the candidate zNodes live, which differs from the
+// election path when a custom PathLayout with a MembersDir is in use (see
+// PathLayout.CandidatesPath).
+func ListMemberSessions(conn *zk.Conn, candidatesPath string, codec NodeCodec) ([]MemberSession, error) {
+	return listMemberSessions(conn, candidatesPath, decoderOf(codec))
+}
+
+// decoderOf returns codec's Decode, defaulting to DefaultCodec when nil.
+func decoderOf(codec NodeCodec) func(data []byte) (primitives.Node, error) {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	return codec.Decode
+}
+
+func listMemberSessions(conn *zk.Conn, candidatesPath string, decode func(data []byte) (primitives.Node, error)) ([]MemberSession, error) {
+	children, _, err := conn.Children(candidatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("listing members under path=%v: %s", candidatesPath, err)
+	}
+	sort.Strings(children)
+	sessions := make([]MemberSession, 0, len(children))
+	for _, child := range children {
+		if _, err := util.SequenceNumber(child); err != nil {
+			continue
+		}
+		zNode := candidatesPath + "/" + child
+		data, stat, err := conn.Get(zNode)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("getting member zNode=%v: %s", zNode, err)
+		}
+		node, err := decode(data)
+		if err != nil {
+			log.Warnf("Skipping member zNode=%v: decoding %v bytes: %s", zNode, len(data), err)
+			continue
+		}
+		sessions = append(sessions, MemberSession{
+			Node:      node,
+			ZNode:     zNode,
+			SessionId: stat.EphemeralOwner,
+		})
+	}
+	return sessions, nil
+}
+
+// ForceRemoveMember deletes the election zNode(s) belonging to the member with
+// the given uuid.  This is intended for administrative cleanup after a host has
+// died without its session expiring promptly.
+//
+// As a guard against deleting a live member, sessionId must match the
+// session which owns the zNode (see ListMemberSessions).  A tombstone is left
+// so the remaining members report the departure as an eviction.
+//
+// As with ListMemberSessions, candidatesPath is where the candidate zNodes
+// live, and is also where the remaining members look for the tombstone.
+// codec is what the members encode their candidate zNodes with, and the
+// tombstone is encrypted with sealer, e.g. the members' EncryptingCodec, unless
+// nil.
+func ForceRemoveMember(conn *zk.Conn, candidatesPath string, codec NodeCodec, sealer payloadSealer, nodeUuid string, sessionId int64) error {
+	return forceRemoveMember(conn, candidatesPath, decoderOf(codec), sealer, nodeUuid, sessionId)
+}
+
+func forceRemoveMember(conn *zk.Conn, candidatesPath string, decode func(data []byte) (primitives.Node, error), sealer payloadSealer, nodeUuid string, sessionId int64) error {
+	sessions, err := listMemberSessions(conn, candidatesPath, decode)
+	if err != nil {
+		return err
+	}
+	var found bool
+	for _, session := range sessions {
+		if session.Node.Uuid.String() != nodeUuid {
+			continue
+		}
+		found = true
+		if session.SessionId != sessionId {
+			return fmt.Errorf("%s (zNode=%v expected-session=0x%x actual-session=0x%x)", SessionMismatchError, session.ZNode, uint64(sessionId), uint64(session.SessionId))
+		}
+		t := tombstone{Reason: primitives.DepartureEvicted, At: time.Now(), Node: session.Node}
+		if err := writeTombstone(conn, candidatesPath, path.Base(session.ZNode), t, sealer); err != nil {
+			return err
+		}
+		if err := conn.Delete(session.ZNode, -1); err != nil && err != zk.ErrNoNode {
+			return fmt.Errorf("deleting member zNode=%v: %s", session.ZNode, err)
+		}
+	}
+	if !found {
+		return MemberNotFoundError
+	}
+	return nil
+}
+
+// ForceRemoveMember deletes another member's stale election zNode, guarded by
+// confirmation of the owning session id.  See the package-level
+// ForceRemoveMember for details.
+func (cc *Coordinator) ForceRemoveMember(nodeUuid string, sessionId int64) error {
+	if nodeUuid == cc.LocalNode.Uuid.String() {
+		return CannotRemoveLocalMemberError
+	}
+
+	return cc.request(func(zkCli *zk.Conn) error {
+		decode := func(data []byte) (primitives.Node, error) {
+			return cc.decodeNode(zkCli, data)
+		}
+		return forceRemoveMember(zkCli, cc.candidatesPath(), decode, cc.sealer(), nodeUuid, sessionId)
+	})
+}
@@ -0,0 +1,149 @@
+package cluster_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestForceRemoveMember(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			subChan = make(chan primitives.Update, 10)
+			cc1     = ncc(t, zkServers, "cc1", subChan)
+			cc2     = ncc(t, zkServers, "cc2")
+		)
+		defer cc1.Stop()
+		defer cc2.Stop()
+
+		var target *cluster.MemberSession
+		deadline := time.Now().Add(5 * time.Second)
+		for target == nil {
+			err := zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+				sessions, err := cluster.ListMemberSessions(conn, testutil.Namespace(t), nil)
+				if err != nil {
+					return err
+				}
+				for _, session := range sessions {
+					if session.Node.Uuid == cc2.LocalNode.Uuid {
+						found := session
+						target = &found
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target == nil {
+				if time.Now().After(deadline) {
+					t.Fatalf("Timed out waiting for cc2 to join the election")
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+
+		if err := cc1.ForceRemoveMember(cc1.LocalNode.Uuid.String(), 0); err != cluster.CannotRemoveLocalMemberError {
+			t.Fatalf("Expected err=%s but actual=%v", cluster.CannotRemoveLocalMemberError, err)
+		}
+		if err := cc1.ForceRemoveMember(target.Node.Uuid.String(), target.SessionId+1); err == nil || !strings.HasPrefix(err.Error(), cluster.SessionMismatchError.Error()) {
+			t.Fatalf("Expected err=%s but actual=%v", cluster.SessionMismatchError, err)
+		}
+		if err := cc1.ForceRemoveMember(target.Node.Uuid.String(), target.SessionId); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc1.ForceRemoveMember(target.Node.Uuid.String(), target.SessionId); err != cluster.MemberNotFoundError {
+			t.Fatalf("Expected err=%s but actual=%v", cluster.MemberNotFoundError, err)
+		}
+
+		nodes, err := cc1.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, node := range nodes {
+			if node.Uuid == cc2.LocalNode.Uuid {
+				t.Fatalf("Expected force-removed member to be absent from members=%+v", nodes)
+			}
+		}
+	})
+}
+
+func TestForceRemoveEncryptedMember(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			codec = cluster.EncryptingCodec{
+				Codec:             cluster.CompressingCodec{},
+				Keys:              zkutil.StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)}},
+				RequireEncryption: true,
+			}
+			subChan = make(chan primitives.Update, 100)
+			ccs     = []*cluster.Coordinator{}
+		)
+		for _, data := range []string{"leader", "evicted"} {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.Codec = codec
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Stop()
+			ccs = append(ccs, cc)
+		}
+		leader, evicted := ccs[0], ccs[1]
+		leader.Subscribe(subChan, cluster.FilterMembershipChanges)
+
+		err := zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			sessions, err := cluster.ListMemberSessions(conn, testutil.Namespace(t), nil)
+			if err != nil {
+				return err
+			}
+			if len(sessions) != 0 {
+				t.Errorf("Expected undecodable members to be skipped but actual sessions=%+v", sessions)
+			}
+			if sessions, err = cluster.ListMemberSessions(conn, testutil.Namespace(t), codec); err != nil {
+				return err
+			}
+			if expected, actual := 2, len(sessions); actual != expected {
+				t.Errorf("Expected %v decoded sessions but actual=%v", expected, actual)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := leader.ForceRemoveMember(evicted.LocalNode.Uuid.String(), evicted.Status().SessionId); err != nil {
+			t.Fatal(err)
+		}
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-subChan:
+				for _, delta := range update.Deltas {
+					if delta.Node.Uuid == evicted.LocalNode.Uuid && !delta.Joined {
+						if expected, actual := primitives.DepartureEvicted, delta.Reason; actual != expected {
+							t.Errorf("Expected evicted member departure reason=%v but actual=%v", expected, actual)
+						}
+						return
+					}
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for the evicted member to depart")
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Reads on hot paths go through get, exists and getAll, which batch them when
+// BatchReads is set.  Each takes the time the caller's operation began, and
+// only joins reads issued since then, so the result is never older than the
+// state at some point during the operation.
+type readOp int
+
+const (
+	readGet readOp = iota
+	readExists
+)
+
+type readKey struct {
+	conn  *zk.Conn
+	op    readOp
+	zNode string
+}
+
+// pendingRead is a read in flight, its result is shared by the callers who
+// joined it and must not be modified.
+type pendingRead struct {
+	issued   time.Time
+	doneChan chan struct{}
+	data     []byte
+	exists   bool
+	stat     *zk.Stat
+	err      error
+}
+
+// readBatcher coalesces concurrent identical reads.  The zero value is ready
+// for use.
+type readBatcher struct {
+	inFlight map[readKey]*pendingRead
+	lock     sync.Mutex
+}
+
+// do performs read unless an identical one issued no earlier than since is
+// already in flight, in which case its result is awaited instead.  coalesced
+// reports which happened.
+func (rb *readBatcher) do(key readKey, since time.Time, read func(p *pendingRead)) (p *pendingRead, coalesced bool) {
+	rb.lock.Lock()
+	if p, ok := rb.inFlight[key]; ok && !p.issued.Before(since) {
+		rb.lock.Unlock()
+		<-p.doneChan
+		return p, true
+	}
+	if rb.inFlight == nil {
+		rb.inFlight = map[readKey]*pendingRead{}
+	}
+	// NB: A read issued earlier and still in flight is superseded, later
+	// callers join this one instead.
+	p = &pendingRead{issued: time.Now(), doneChan: make(chan struct{})}
+	rb.inFlight[key] = p
+	rb.lock.Unlock()
+
+	read(p)
+
+	rb.lock.Lock()
+	if rb.inFlight[key] == p {
+		delete(rb.inFlight, key)
+	}
+	rb.lock.Unlock()
+	close(p.doneChan)
+	return p, false
+}
+
+// get reads zNode, batched when BatchReads is set with reads issued since.  The
+// returned data must not be modified.
+func (cc *Coordinator) get(conn *zk.Conn, zNode string, since time.Time) ([]byte, *zk.Stat, error) {
+	if !cc.BatchReads {
+		return conn.Get(zNode)
+	}
+	p, coalesced := cc.reads.do(readKey{conn, readGet, zNode}, since, func(p *pendingRead) {
+		p.data, p.stat, p.err = conn.Get(zNode)
+	})
+	cc.countRead(coalesced)
+	return p.data, p.stat, p.err
+}
+
+// exists checks for zNode, batched when BatchReads is set with reads issued
+// since.
+func (cc *Coordinator) exists(conn *zk.Conn, zNode string, since time.Time) (bool, *zk.Stat, error) {
+	if !cc.BatchReads {
+		return conn.Exists(zNode)
+	}
+	p, coalesced := cc.reads.do(readKey{conn, readExists, zNode}, since, func(p *pendingRead) {
+		p.exists, p.stat, p.err = conn.Exists(zNode)
+	})
+	cc.countRead(coalesced)
+	return p.exists, p.stat, p.err
+}
+
+// readResult is the outcome of one of the reads performed by getAll.
+type readResult struct {
+	data []byte
+	stat *zk.Stat
+	err  error
+}
+
+// getAll reads every one of zNodes, returning the results in the same order.
+// When BatchReads is set the requests are pipelined, otherwise they are
+// performed one after the other.
+func (cc *Coordinator) getAll(conn *zk.Conn, zNodes []string, since time.Time) []readResult {
+	results := make([]readResult, len(zNodes))
+	if !cc.BatchReads || len(zNodes) < 2 {
+		for i, zNode := range zNodes {
+			results[i].data, results[i].stat, results[i].err = cc.get(conn, zNode, since)
+		}
+		return results
+	}
+	return cc.getPipelined(conn, zNodes, since)
+}
+
+// getPipelined reads every one of zNodes with the requests pipelined,
+// regardless of BatchReads, returning the results in the same order.
+func (cc *Coordinator) getPipelined(conn *zk.Conn, zNodes []string, since time.Time) []readResult {
+	results := make([]readResult, len(zNodes))
+	// NB: The client writes requests out as they are queued, without waiting
+	// for earlier responses, so concurrent requests are pipelined.
+	var wg sync.WaitGroup
+	wg.Add(len(zNodes))
+	for i, zNode := range zNodes {
+		go func(i int, zNode string) {
+			defer wg.Done()
+			results[i].data, results[i].stat, results[i].err = cc.get(conn, zNode, since)
+		}(i, zNode)
+	}
+	wg.Wait()
+	return results
+}
+
+func (cc *Coordinator) countRead(coalesced bool) {
+	cc.countStat(func(stats *Stats) {
+		if coalesced {
+			stats.ReadsCoalesced++
+		} else {
+			stats.ReadsIssued++
+		}
+	})
+}
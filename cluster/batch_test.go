@@ -0,0 +1,70 @@
+package cluster_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestBatchReads(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		const n = 5
+		members := []*cluster.Coordinator{}
+		for i := 0; i < n; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.BatchReads = i == 0
+			members = append(members, cc)
+		}
+		for _, cc := range members {
+			if err := cc.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Stop()
+		}
+		waitForAgreement(t, members)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				nodes, err := members[0].Members()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if len(nodes) != n {
+					t.Errorf("Expected %v members but actual=%v", n, len(nodes))
+				}
+				seen := map[string]bool{}
+				for _, node := range nodes {
+					seen[node.Data] = true
+				}
+				for i := 0; i < n; i++ {
+					if expected := fmt.Sprintf("i=%v", i); !seen[expected] {
+						t.Errorf("Expected a member with data=%v among members=%+v", expected, nodes)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if leader := members[0].Leader(); leader == nil || leader.Data != "i=0" {
+			t.Errorf("Expected leader data=i=0 but actual=%+v", leader)
+		}
+		stats := members[0].Stats()
+		t.Logf("reads issued=%v coalesced=%v", stats.ReadsIssued, stats.ReadsCoalesced)
+		if stats.ReadsIssued == 0 {
+			t.Errorf("Expected batched reads to be counted")
+		}
+		if stats := members[1].Stats(); stats.ReadsIssued != 0 || stats.ReadsCoalesced != 0 {
+			t.Errorf("Expected no batched reads without BatchReads but actual=%+v", stats)
+		}
+	})
+}
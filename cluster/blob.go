@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// BlobStore holds member data moved out of candidate zNodes, see
+// Coordinator.BlobThreshold.  Refs are unique per member and payload, so Put
+// may treat an already existing ref as success.
+type BlobStore interface {
+	Put(ref string, data []byte) error
+	Get(ref string) ([]byte, error)
+	Delete(ref string) error
+}
+
+// blobsDirName holds the default BlobStore's blobs.  It lives alongside the
+// candidates and is not sequential, so is never mistaken for a candidate.
+const blobsDirName = "blobs"
+
+// maxCachedBlobs bounds the number of fetched blobs a Coordinator keeps.
+const maxCachedBlobs = 64
+
+// ZNodeBlobStore keeps blobs as persistent zNodes under Path.  Blobs remain
+// subject to jute.maxbuffer, but are only read when a member's data changes
+// rather than on every election check.
+type ZNodeBlobStore struct {
+	Conn *zk.Conn
+	Path string
+}
+
+func (store ZNodeBlobStore) Put(ref string, data []byte) error {
+	if _, err := util.CreateP(store.Conn, store.Path, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		return fmt.Errorf("creating blobs path=%v: %s", store.Path, err)
+	}
+	if _, err := store.Conn.Create(store.Path+"/"+ref, data, 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+		return fmt.Errorf("creating blob=%v: %s", ref, err)
+	}
+	return nil
+}
+
+func (store ZNodeBlobStore) Get(ref string) ([]byte, error) {
+	data, _, err := store.Conn.Get(store.Path + "/" + ref)
+	if err != nil {
+		return nil, fmt.Errorf("getting blob=%v: %s", ref, err)
+	}
+	return data, nil
+}
+
+func (store ZNodeBlobStore) Delete(ref string) error {
+	if err := store.Conn.Delete(store.Path+"/"+ref, -1); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("deleting blob=%v: %s", ref, err)
+	}
+	return nil
+}
+
+// blobRef returns the ref under which node's data is stored: the member uuid
+// followed by the hex SHA-256 of the data.  When the Codec encrypts, the hash
+// is salted with a secret of the local Coordinator's, so the ref gives nothing
+// away about the data.  Such blobs are then authenticated when decrypted
+// rather than by verifyBlob.
+func (cc *Coordinator) blobRef(node primitives.Node) string {
+	hash := sha256.New()
+	if cc.sealer() != nil {
+		hash.Write(cc.blobSalt)
+	}
+	hash.Write([]byte(node.Data))
+	return node.Uuid.String() + "-" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// verifyBlob checks data against the hash embedded in ref.
+func verifyBlob(ref string, data []byte) error {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if len(ref) < len(hash) || ref[len(ref)-len(hash):] != hash {
+		return fmt.Errorf("blob=%v does not match its content hash=%v", ref, hash)
+	}
+	return nil
+}
+
+func (cc *Coordinator) blobStore(zkCli *zk.Conn) BlobStore {
+	if cc.BlobStore != nil {
+		return cc.BlobStore
+	}
+	return ZNodeBlobStore{Conn: zkCli, Path: cc.candidatesPath() + "/" + blobsDirName}
+}
+
+// inlineNode returns node as stored inline in its candidate zNode, with data
+// over BlobThreshold replaced by a ref.
+func (cc *Coordinator) inlineNode(node primitives.Node) primitives.Node {
+	if cc.BlobThreshold > 0 && len(node.Data) > cc.BlobThreshold {
+		node.DataRef = cc.blobRef(node)
+		node.Data = ""
+	}
+	return node
+}
+
+// encodeLocal encodes node for publishing in the local candidate zNode.
+func (cc *Coordinator) encodeLocal(node primitives.Node) ([]byte, error) {
+	return cc.codec().Encode(cc.inlineNode(node))
+}
+
+// publishBlob stores node's data in the BlobStore if it is over
+// BlobThreshold.  Must be invoked before the data referencing it is written.
+func (cc *Coordinator) publishBlob(zkCli *zk.Conn, node primitives.Node) error {
+	ref := cc.inlineNode(node).DataRef
+	if ref == "" {
+		return nil
+	}
+	data, err := seal(cc.sealer(), []byte(node.Data))
+	if err != nil {
+		return fmt.Errorf("storing blob=%v: %s", ref, err)
+	}
+	return cc.blobStore(zkCli).Put(ref, data)
+}
+
+// withdrawBlob deletes the blob referenced by node, if any.
+func (cc *Coordinator) withdrawBlob(zkCli *zk.Conn, node primitives.Node) {
+	if node.DataRef == "" {
+		return
+	}
+	if err := cc.blobStore(zkCli).Delete(node.DataRef); err != nil {
+		log.Warnf("%v: withdrawing blob: %s", cc.Id(), err)
+	}
+}
+
+// withdrawLocalBlob deletes the local member's blob, if any, upon leaving.
+// The candidate zNode is deleted first so no member resolves a ref to a
+// withdrawn blob.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) withdrawLocalBlob() {
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	localNode := cc.inlineNode(cc.LocalNode)
+	cc.leaderLock.Unlock()
+	if localNode.DataRef == "" {
+		return
+	}
+	if zNode != "" {
+		if err := cc.zkCli.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+			log.Warnf("%v: not withdrawing blob as deleting candidate zNode=%v failed: %s", cc.Id(), zNode, err)
+			return
+		}
+		cc.resources.Disown(zNode)
+	}
+	cc.withdrawBlob(cc.zkCli, localNode)
+}
+
+// decodeNode decodes a candidate zNode's data, fetching data which was moved
+// to the BlobStore and vetting it when ValidateReads is set.
+func (cc *Coordinator) decodeNode(zkCli *zk.Conn, data []byte) (primitives.Node, error) {
+	node, err := cc.codec().Decode(data)
+	if err != nil {
+		return node, err
+	}
+	if node, err = cc.resolveBlob(zkCli, node); err != nil || !cc.ValidateReads {
+		return node, err
+	}
+	return node, cc.validate(node)
+}
+
+// validate vets node's data with the Validators.
+func (cc *Coordinator) validate(node primitives.Node) error {
+	if IsWitness(node) {
+		return nil
+	}
+	return util.Validate(cc.Validators, cc.candidatesPath(), []byte(node.Data))
+}
+
+// resolveBlob fills in the data of a node whose data was moved to the
+// BlobStore.
+func (cc *Coordinator) resolveBlob(zkCli *zk.Conn, node primitives.Node) (primitives.Node, error) {
+	if node.DataRef == "" {
+		return node, nil
+	}
+
+	cc.blobLock.Lock()
+	blob, ok := cc.blobCache[node.DataRef]
+	cc.blobLock.Unlock()
+	if ok {
+		node.Data = blob
+		return node, nil
+	}
+
+	fetched, err := cc.blobStore(zkCli).Get(node.DataRef)
+	if err != nil {
+		return node, err
+	}
+	// NB: Encrypted blobs are authenticated upon decryption instead.
+	_, sealed := util.EncryptionKeyId(fetched)
+	if fetched, err = unseal(cc.sealer(), fetched); err != nil {
+		return node, fmt.Errorf("decrypting blob=%v: %s", node.DataRef, err)
+	}
+	if !sealed {
+		if err := verifyBlob(node.DataRef, fetched); err != nil {
+			return node, err
+		}
+	}
+	node.Data = string(fetched)
+
+	cc.blobLock.Lock()
+	if cc.blobCache == nil || len(cc.blobCache) >= maxCachedBlobs {
+		cc.blobCache = map[string]string{}
+	}
+	cc.blobCache[node.DataRef] = node.Data
+	cc.blobLock.Unlock()
+	return node, nil
+}
+
+// referencesBlob returns whether any of members references the blob ref.
+func referencesBlob(members map[string]primitives.Node, ref string) bool {
+	if ref == "" {
+		return true // Nothing to withdraw.
+	}
+	for _, node := range members {
+		if node.DataRef == ref {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// memBlobStore is an external BlobStore shared by the members of a test.
+type memBlobStore struct {
+	blobs map[string][]byte
+	lock  sync.Mutex
+}
+
+func (store *memBlobStore) Put(ref string, data []byte) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.blobs[ref] = data
+	return nil
+}
+
+func (store *memBlobStore) Get(ref string) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data, ok := store.blobs[ref]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	return data, nil
+}
+
+func (store *memBlobStore) Delete(ref string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	delete(store.blobs, ref)
+	return nil
+}
+
+func (store *memBlobStore) len() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	return len(store.blobs)
+}
+
+func TestBlobData(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		testBlobData(t, zkServers, nil)
+	})
+}
+
+func TestBlobDataExternalStore(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		testBlobData(t, zkServers, &memBlobStore{blobs: map[string][]byte{}})
+	})
+}
+
+func testBlobData(t *testing.T, zkServers []string, store *memBlobStore) {
+	var (
+		electionPath = testutil.Namespace(t)
+		large        = strings.Repeat("rich-metadata ", 1000)
+	)
+	start := func(data string) *cluster.Coordinator {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.BlobThreshold = 256
+		if store != nil {
+			cc.BlobStore = store
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return cc
+	}
+	leader := start(large)
+	follower := start("small")
+	defer follower.Stop()
+
+	if l := follower.Leader(); l == nil || l.Data != large || l.DataRef == "" {
+		t.Fatalf("Expected follower to see the leader's blob data, but leader=%v", l)
+	}
+
+	conn, _, err := zk.Connect(zkServers, zkTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	numBlobs := func() int {
+		if store != nil {
+			return store.len()
+		}
+		children, _, err := conn.Children(electionPath + "/blobs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(children)
+	}
+
+	// Only the ref is stored inline.
+	sessions, err := cluster.ListMemberSessions(conn, electionPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range sessions {
+		data, _, err := conn.Get(session.ZNode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) > 1024 {
+			t.Errorf("Expected candidate zNode=%v to stay small but it is %v bytes", session.ZNode, len(data))
+		}
+	}
+
+	// Replaced blobs are withdrawn.
+	updated := strings.Repeat("more-metadata ", 1000)
+	if err := leader.SetData(updated); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := follower.Members()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if node.Uuid == leader.LocalNode.Uuid && node.Data != updated {
+			t.Errorf("Expected Members() to carry the leader's updated blob data")
+		}
+	}
+	if expected, actual := 1, numBlobs(); actual != expected {
+		t.Errorf("Expected num blobs=%v after SetData but actual=%v", expected, actual)
+	}
+
+	if err := leader.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, numBlobs(); actual != expected {
+		t.Errorf("Expected num blobs=%v after Stop but actual=%v", expected, actual)
+	}
+
+	shutdown := start(large)
+	if expected, actual := 1, numBlobs(); actual != expected {
+		t.Errorf("Expected num blobs=%v after joining but actual=%v", expected, actual)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdown.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 0, numBlobs(); actual != expected {
+		t.Errorf("Expected num blobs=%v after Shutdown but actual=%v", expected, actual)
+	}
+}
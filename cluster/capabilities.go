@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Capabilities returns the capabilities of the ZooKeeper server most recently
+// connected to, as detected upon joining the election, so applications can
+// branch on e.g. TTL support instead of failing at runtime.  The zero value
+// is returned until detection has succeeded.
+func (cc *Coordinator) Capabilities() util.Capabilities {
+	cc.capabilitiesLock.Lock()
+	defer cc.capabilitiesLock.Unlock()
+	return cc.capabilities
+}
+
+// detectCapabilities refreshes the server capabilities.  Failures are logged
+// and otherwise ignored, leaving the previously detected capabilities in
+// place.
+func (cc *Coordinator) detectCapabilities(zkCli *zk.Conn) {
+	caps, err := util.DetectCapabilities(zkCli)
+	if err != nil {
+		log.Warnf("%v: failed detecting server capabilities (non-fatal, will continue): %s", cc.Id(), err)
+		return
+	}
+	log.Debugf("%v: detected server capabilities=%+v", cc.Id(), caps)
+	cc.capabilitiesLock.Lock()
+	cc.capabilities = caps
+	cc.capabilitiesLock.Unlock()
+}
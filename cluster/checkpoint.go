@@ -0,0 +1,173 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// checkpointZNodeName holds the leader's most recent checkpoint beside the
+// candidates.  It is persistent so it survives the leader's departure.
+const checkpointZNodeName = "checkpoint"
+
+const (
+	// DefaultMaxCheckpointSize leaves ample headroom below ZooKeeper's default
+	// jute.maxbuffer of 1MiB.
+	DefaultMaxCheckpointSize = 512 * 1024
+
+	checkpointVersionUnloaded int32 = -2 // Not yet read by the current leadership.
+)
+
+var (
+	NotLeaderError          = errors.New("only the leader may do this")
+	CheckpointTooLargeError = errors.New("checkpoint exceeds MaxCheckpointSize")
+	CheckpointConflictError = errors.New("checkpoint was overwritten by another leader")
+)
+
+// Checkpoint is leader-only state saved for the benefit of future leaders.
+type Checkpoint struct {
+	Data    []byte          `json:"data"`
+	Leader  primitives.Node `json:"leader"` // Who saved it.
+	SavedAt time.Time       `json:"savedAt"`
+	Version int32           `json:"-"` // zNode version, increases with every save.
+}
+
+// SaveCheckpoint persists in-progress leader state so that a newly elected
+// leader can resume from it, see LoadCheckpoint and OnCheckpoint.  Only the
+// leader may save, and saves are conditional on the checkpoint being
+// unchanged since the local leader last loaded or saved it, so a deposed
+// leader unaware of its demotion cannot clobber its successor's progress
+// (CheckpointConflictError).
+func (cc *Coordinator) SaveCheckpoint(data []byte) error {
+	if cc.Conn() == nil || cc.Mode() != primitives.Leader {
+		return NotLeaderError
+	}
+	if len(data) > cc.maxCheckpointSize() {
+		return CheckpointTooLargeError
+	}
+
+	cc.leaderLock.Lock()
+	checkpoint := Checkpoint{Data: data, Leader: cc.inlineNode(cc.LocalNode), SavedAt: cc.clock().Now()}
+	version := cc.checkpointVersion
+	cc.leaderLock.Unlock()
+
+	if version == checkpointVersionUnloaded {
+		// Establish the version to build on.
+		previous, err := cc.LoadCheckpoint()
+		if err != nil {
+			return err
+		}
+		version = -1
+		if previous != nil {
+			version = previous.Version
+		}
+	}
+
+	encoded, err := json.Marshal(&checkpoint)
+	if err == nil {
+		encoded, err = seal(cc.sealer(), encoded)
+	}
+	if err != nil {
+		return fmt.Errorf("%v: encoding checkpoint: %s", cc.Id(), err)
+	}
+	zNode := cc.candidatesPath() + "/" + checkpointZNodeName
+	if err := cc.checkPayloadQuota(zNode, encoded); err != nil {
+		return err
+	}
+	var stat *zk.Stat
+	err = cc.request(func(zkCli *zk.Conn) (err error) {
+		if version == -1 {
+			if _, err = zkCli.Create(zNode, encoded, 0, zk.WorldACL(zk.PermAll)); err == nil {
+				stat = &zk.Stat{Version: 0}
+			} else if err == zk.ErrNodeExists {
+				err = CheckpointConflictError
+			}
+		} else {
+			if stat, err = zkCli.Set(zNode, encoded, version); err == zk.ErrBadVersion || err == zk.ErrNoNode {
+				err = CheckpointConflictError
+			}
+		}
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("%v: saving checkpoint: %s", cc.Id(), err)
+	}
+
+	cc.leaderLock.Lock()
+	cc.checkpointVersion = stat.Version
+	cc.leaderLock.Unlock()
+	return nil
+}
+
+// LoadCheckpoint returns the most recently saved checkpoint, or nil when there
+// is none.  Loading as the leader makes subsequent saves build on the loaded
+// version.
+func (cc *Coordinator) LoadCheckpoint() (*Checkpoint, error) {
+	var (
+		data []byte
+		stat *zk.Stat
+	)
+	err := cc.request(func(zkCli *zk.Conn) (err error) {
+		data, stat, err = zkCli.Get(cc.candidatesPath() + "/" + checkpointZNodeName)
+		return
+	})
+	if err == errorlib.NotRunningError {
+		return nil, err
+	}
+	version := int32(-1)
+	var checkpoint *Checkpoint
+	if err == nil {
+		checkpoint = &Checkpoint{}
+		if data, err = unseal(cc.sealer(), data); err == nil {
+			err = json.Unmarshal(data, checkpoint)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: decoding checkpoint: %s", cc.Id(), err)
+		}
+		checkpoint.Version = stat.Version
+		version = stat.Version
+	} else if err != zk.ErrNoNode {
+		return nil, fmt.Errorf("%v: loading checkpoint: %s", cc.Id(), err)
+	}
+
+	if cc.Mode() == primitives.Leader {
+		cc.leaderLock.Lock()
+		cc.checkpointVersion = version
+		cc.leaderLock.Unlock()
+	}
+	return checkpoint, nil
+}
+
+// resumeFromCheckpoint hands the checkpoint to OnCheckpoint upon acquiring
+// leadership in the incarnation of the given generation.
+func (cc *Coordinator) resumeFromCheckpoint(generation uint64) {
+	checkpoint, err := cc.LoadCheckpoint()
+	if err != nil {
+		log.Warnf("%v: unable to resume from checkpoint: %s", cc.Id(), err)
+		return
+	}
+	if cc.Mode() != primitives.Leader || cc.currentGeneration() != generation {
+		return // Deposed or restarted meanwhile.
+	}
+	cc.OnCheckpoint(checkpoint)
+}
+
+func (cc *Coordinator) maxCheckpointSize() int {
+	if cc.MaxCheckpointSize > 0 {
+		return cc.MaxCheckpointSize
+	}
+	return DefaultMaxCheckpointSize
+}
+
+func (cc *Coordinator) currentGeneration() uint64 {
+	cc.stateLock.Lock()
+	defer cc.stateLock.Unlock()
+	return cc.generation
+}
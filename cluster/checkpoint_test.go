@@ -0,0 +1,84 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestCheckpoint(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			ccs          = []*cluster.Coordinator{}
+			resumed      = make(chan *cluster.Checkpoint, 10)
+		)
+		for i := 0; i < 2; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.MaxCheckpointSize = 16
+			if i == 1 {
+				cc.OnCheckpoint = func(checkpoint *cluster.Checkpoint) {
+					resumed <- checkpoint
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			if err := ccs[1].Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, ccs)
+
+		if err := ccs[1].SaveCheckpoint([]byte("nope")); err != cluster.NotLeaderError {
+			t.Errorf("Expected err=%s when a follower checkpoints but actual=%v", cluster.NotLeaderError, err)
+		}
+		if err := ccs[0].SaveCheckpoint(make([]byte, 17)); err != cluster.CheckpointTooLargeError {
+			t.Errorf("Expected err=%s for an oversized checkpoint but actual=%v", cluster.CheckpointTooLargeError, err)
+		}
+		for _, data := range []string{"step-1", "step-2"} {
+			if err := ccs[0].SaveCheckpoint([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkpoint, err := ccs[1].LoadCheckpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint == nil || string(checkpoint.Data) != "step-2" || checkpoint.Version != 1 {
+			t.Fatalf("Expected latest checkpoint data=step-2 version=1 but actual=%+v", checkpoint)
+		}
+		if checkpoint.Leader.Uuid != ccs[0].LocalNode.Uuid {
+			t.Errorf("Expected checkpoint to record the leader which saved it, but actual=%v", checkpoint.Leader)
+		}
+
+		// The successor resumes from the checkpoint upon taking over.
+		if err := ccs[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case checkpoint := <-resumed:
+			if checkpoint == nil || string(checkpoint.Data) != "step-2" {
+				t.Fatalf("Expected successor to resume from data=step-2 but checkpoint=%+v", checkpoint)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the successor to resume from the checkpoint")
+		}
+		if err := ccs[1].SaveCheckpoint([]byte("step-3")); err != nil {
+			t.Errorf("Expected successor to be able to build on the loaded checkpoint, but err=%s", err)
+		}
+	})
+}
@@ -1,16 +1,21 @@
 package cluster
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"strconv"
+	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gigawattio/concurrency"
-	"github.com/gigawattio/gentle"
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/clock"
 	"github.com/gigawattio/zklib/cluster/primitives"
 	"github.com/gigawattio/zklib/util"
 
@@ -21,7 +26,14 @@ import (
 )
 
 var (
-	backoffDuration = 50 * time.Millisecond
+	AlreadyStartedError = errors.New("coordinator already started")
+	AlreadyStoppedError = errors.New("coordinator already stopped")
+)
+
+var (
+	DefaultConnectTimeout    = 1 * time.Second
+	DefaultWatchRearmTimeout = 5 * time.Second
+	DefaultRetryInterval     = 50 * time.Millisecond
 )
 
 type Coordinator struct {
@@ -29,17 +41,296 @@ type Coordinator struct {
 	sessionTimeout         time.Duration
 	zkCli                  *zk.Conn
 	eventCh                <-chan zk.Event
+	hostProvider           *DynamicHostProvider
+	netConn                net.Conn // Most recently dialed server connection.
+	netConnLock            sync.Mutex
 	leaderElectionPath     string
 	LocalNode              primitives.Node
-	localNodeJson          []byte
+	localNodeData          []byte
+	zNode                  string // Full path of the local candidate zNode.
+	resources              primitives.Tracker
+	reads                  readBatcher
 	leaderNode             *primitives.Node
+	leaderZNode            string // Full path of the leader's candidate zNode.
+	followerRank           int    // Position of the local member among the followers, see ServingRole.
 	leaderLock             sync.Mutex
+	lastSynced             time.Time     // When the most recent successful Sync started.
+	checkpointVersion      int32         // Of the checkpoint as last loaded or saved while leading.
+	handoffGeneration      uint64        // Of the incarnation which last began a handoff.
+	handoffDoneChan        chan struct{} // Closed once the handoff of handoffGeneration has completed.
 	membershipRequestsChan chan chan clusterMembershipResponse
+	syncRequestsChan       chan chan error
 	stateLock              sync.Mutex
-	stopChan               chan chan struct{}
-	subscriberChans        []chan primitives.Update    // part of subscription handler.
-	subAddChan             chan chan primitives.Update // part of subscription handler.
-	subRemoveChan          chan chan primitives.Update // part of subscription handler.
+	generation             uint64        // Incremented by every Start, so late callers (e.g. handOff and resumeFromCheckpoint) can't affect a later incarnation.
+	abortChan              chan struct{} // Closed to make the current election loop exit.
+	loopDoneChan           chan struct{} // Closed once the current election loop has exited.
+	watchdogStopChan       chan chan struct{}
+	lastProgress           time.Time
+	progressLock           sync.Mutex
+	subscriberChans        []chan primitives.Update
+	subscriberFilters      map[chan primitives.Update]UpdateFilter // Absent when unfiltered.
+	subscribersLock        sync.Mutex
+	sessionSubscribers     []chan zk.Event
+	sessionSubscribersLock sync.Mutex
+	history                history
+	historySinkChan        chan HistoryEvent
+	historySinkDoneChan    chan struct{}
+	historySinkLock        sync.Mutex
+	blobCache              map[string]string // Fetched blob data by ref.
+	blobSalt               []byte            // Keys the refs of encrypted blobs, see blobRef.
+	blobLock               sync.Mutex
+	capabilities           util.Capabilities
+	capabilitiesLock       sync.Mutex
+	stats                  Stats
+	leadingSince           time.Time // Zero unless leading.
+	statsLock              sync.Mutex
+
+	// LeaderVerifyInterval enables periodic re-verification of leadership when
+	// non-zero: the leader re-reads its own election zNode and confirms it is
+	// still owned by the current session.  Verification failures demote the
+	// leader immediately, and unverifiable leadership (e.g. due to connectivity
+	// issues) is given up once older than LeaderMaxStaleness.
+	LeaderVerifyInterval time.Duration
+	LeaderMaxStaleness   time.Duration // Defaults to the session timeout when zero.
+
+	// PathLayout customizes candidate zNode naming and placement.  Must be set
+	// before Start().
+	PathLayout PathLayout
+
+	// Codec controls the format of the data stored in candidate zNodes.
+	// Defaults to DefaultCodec when nil.  Must be set before Start().
+	Codec NodeCodec
+
+	// Validators vet the local member's data (see primitives.Node.Data) before
+	// it is written, so Start() and SetData() reject malformed payloads with a
+	// util.ValidationError.  With ValidateReads set other members' data is
+	// vetted too when reading it, so Members() fails on malformed members
+	// rather than passing their data on, and membership changes report them
+	// with an empty Node.  Witnesses, which carry no data, are exempt.  All
+	// members should use compatible validators.
+	Validators    []util.Validator
+	ValidateReads bool
+
+	// Quotas guard the zNodes the Coordinator writes against growing beyond
+	// what the ensemble copes with, e.g. {"/myapp": {MaxPayloadBytes: 64 *
+	// 1024}}.  Writes exceeding them fail with a util.QuotaError before
+	// reaching the server: Start() and SetData() for the local data, and
+	// SaveCheckpoint().  When the candidates path has no room left for
+	// another child the local member stays out of the election, retrying as
+	// others depart, so StartAndWait() blocks meanwhile.  See Usage() and
+	// Stats.QuotaRejections.
+	Quotas util.Quotas
+
+	// MinMembers, when greater than one, withholds leadership until at least
+	// this many members are present: Leader() returns nil, every member is a
+	// follower, and subscribers receive DegradedUpdate updates instead.  This
+	// prevents a lone node from declaring itself leader during a rolling
+	// deploy of a fixed-size cluster.
+	MinMembers int
+
+	// Witness makes the local member a tie-breaker which counts toward
+	// MinMembers but is never elected leader (see NewWitness), so e.g. a
+	// two-node service plus a lightweight witness process can require two
+	// members without running a third full instance.  Must be set before
+	// Start().
+	Witness bool
+
+	// SplitBrainCheckInterval enables a diagnostic mode when non-zero: every
+	// member periodically publishes its view of the leader under
+	// <election-path>/views, and the leader emits a SplitBrainUpdate when more
+	// than SplitBrainThreshold members disagree with it.  Views are also
+	// republished on every leader change, but brief disagreement while a
+	// change propagates is possible, so a small threshold may be desirable.
+	SplitBrainCheckInterval time.Duration
+	SplitBrainThreshold     int
+
+	// WatchdogTimeout enables the watchdog when non-zero: if the Coordinator
+	// has neither a session nor made any progress for longer than this, the
+	// connection is torn down and the election rejoined from scratch, after
+	// which subscribers receive a RecoveredUpdate.
+	WatchdogTimeout time.Duration
+
+	// ConnectTimeout bounds each attempt at dialing an ensemble member.
+	// Defaults to DefaultConnectTimeout.  Must be set before Start().
+	ConnectTimeout time.Duration
+
+	// RequestTimeout bounds the requests made on the caller's behalf which
+	// take no context, e.g. Members(), Followers(), Usage(), syncing reads,
+	// LoadCheckpoint() and SaveCheckpoint().  Exceeding it yields
+	// RequestTimeoutError.  Defaults to the session timeout.
+	RequestTimeout time.Duration
+
+	// RetryInterval is the pause between retries of failed ZooKeeper
+	// operations, defaults to DefaultRetryInterval.
+	RetryInterval time.Duration
+
+	// WatchRearmTimeout bounds how long the election loop keeps trying to
+	// re-arm the election watch before turning its attention to other work
+	// (it tries again shortly afterwards).  Defaults to
+	// DefaultWatchRearmTimeout.
+	WatchRearmTimeout time.Duration
+
+	// EnsembleDiscovery enables following the ZooKeeper 3.5+ dynamic ensemble
+	// configuration: the server list is updated live whenever the ensemble is
+	// reconfigured, so Coordinators need not be restarted.  Has no effect on
+	// older ensembles.  Must be set before Start().
+	EnsembleDiscovery bool
+
+	// ResolveInterval enables periodic re-resolution of the server names when
+	// non-zero, e.g. for an ensemble specified as "zk.service.consul:2181".
+	// Should the connected server's address disappear the client reconnects
+	// to one of the newly resolved addresses, keeping its session.  Must be set
+	// before Start().
+	ResolveInterval time.Duration
+
+	// EnrichIdentity populates the Pid, Version, StartedAt and IP fields of
+	// LocalNode on Start(), so operators can tell which process is which.
+	EnrichIdentity bool
+
+	// HistorySize bounds the number of events retained for History().
+	// Defaults to DefaultHistorySize.
+	HistorySize int
+
+	// HistorySink, when non-nil, additionally receives every event recorded
+	// for History(), e.g. a FileHistorySink or ZNodeHistorySink.  Must be set
+	// before Start().
+	HistorySink HistorySink
+
+	// HandoffDelay enables graceful leadership handoff when non-zero: Stop() on
+	// the leader first announces the successor, which receives a
+	// HandoffUpdate (as do all other members), then waits this long before
+	// withdrawing so the successor can begin warming up.  The announcement is
+	// a non-sequential "handoff" zNode beside the candidates.
+	HandoffDelay time.Duration
+
+	// PreHandoff, when non-nil, is invoked by Stop() on the leader before the
+	// handoff is announced, e.g. to flush leader-only state.  successor is
+	// nil when no other member is present.  Only invoked when HandoffDelay is
+	// set.
+	PreHandoff func(successor *primitives.Node)
+
+	// DrainPeriod bounds how long Drain() keeps the member around, marked as
+	// draining, before stopping it.  Defaults to DefaultDrainPeriod.
+	DrainPeriod time.Duration
+
+	// OnDrain, when non-nil, is invoked on its own goroutine once Drain() has
+	// marked the member as draining, e.g. to finish in-flight work.  Invoking
+	// done lets Drain() stop the member without waiting out DrainPeriod.
+	OnDrain func(done func())
+
+	// SyncFollowers is how many of the followers next in line, by election
+	// sequence, are given the sync-follower serving role, see ServingRole.
+	// Defaults to DefaultSyncFollowers.
+	SyncFollowers int
+
+	// StickyWindow enables sticky leadership when non-zero: a leader which is
+	// deposed involuntarily (e.g. by a brief network hiccup) and rejoins
+	// within this window asks for its leadership back, which the interim
+	// leader grants if it has itself been leader for no longer than the
+	// window.  This avoids ping-ponging expensive leader-only state.  All
+	// members must use the same setting.
+	StickyWindow time.Duration
+
+	// AcceptHandback, when non-nil, is consulted by an interim leader before
+	// granting a handback request from requester.
+	AcceptHandback func(requester primitives.Node) bool
+
+	// PathACL is applied to the zNodes created for a missing election path.
+	// When nil they inherit the ACL of the deepest existing ancestor (see
+	// util.EnsurePath).
+	PathACL []zk.ACL
+
+	// ContainerPaths creates missing election path zNodes as containers on
+	// ZooKeeper 3.5.3+, so they are cleaned up by the server once the last
+	// member departs.  Falls back to persistent zNodes on older servers.
+	ContainerPaths bool
+
+	// WatchPredecessor avoids the herd effect in large elections: rather than
+	// every member watching the whole election, followers watch only their
+	// predecessor's candidate zNode, as per the standard ZooKeeper recipe,
+	// plus the leader's so Leader() stays current.  A non-leader joining or
+	// departing then notifies the leader and its successor instead of every
+	// member.  Membership deltas and history are only complete on the leader.
+	// Incompatible with settings relying on every member seeing every change:
+	// LeaderLabels, StickyWindow, MinMembers, witnesses and alternative
+	// Elections.
+	WatchPredecessor bool
+
+	// Election decides which candidate leads, defaults to DefaultElection.
+	// All members must use the same Election.
+	Election Election
+
+	// LeaderLabels constrains leadership to members whose Node carries all of
+	// these labels, e.g. {"ssd": "true"}.  Other members participate as
+	// followers only, and Election chooses among the rest.  Should no
+	// eligible member be present a DegradedUpdate is delivered instead.  All
+	// members must use the same constraints.
+	LeaderLabels map[string]string
+
+	// BlobThreshold, when non-zero, moves local data longer than this many
+	// bytes out of the candidate zNode and into BlobStore, leaving only a ref
+	// (see primitives.Node.DataRef) behind.  Keeps candidate zNodes small, and
+	// allows rich metadata beyond jute.maxbuffer when BlobStore is external.
+	// Leader() and Members() transparently fetch the data.
+	BlobThreshold int
+
+	// BlobStore holds data moved out of candidate zNodes.  Defaults to a
+	// ZNodeBlobStore using a "blobs" zNode beside the candidates.  All members
+	// must be able to read from it.  Blobs are encrypted when the Codec is an
+	// EncryptingCodec.
+	BlobStore BlobStore
+
+	// PersistentMembership makes candidate zNodes persistent records rather
+	// than ephemerals, for deployments where membership should outlive
+	// sessions, e.g. members which reconnect after long partitions.  Members
+	// instead heartbeat every HeartbeatInterval by touching their record, and
+	// the leader, as well as the member next in line to it, evicts those whose
+	// last heartbeat is older than HeartbeatTTL, the leader's own included,
+	// after which every member receives an EvictedUpdate.  A member finding
+	// its record evicted rejoins.  Stop() withdraws the local record.  All
+	// members must use the same setting.  Incompatible with WatchPredecessor.
+	// Must be set before Start().
+	PersistentMembership bool
+	HeartbeatInterval    time.Duration // Defaults to DefaultHeartbeatInterval.
+	HeartbeatTTL         time.Duration // Defaults to DefaultHeartbeatTTL.
+
+	// SyncReads makes Leader() and Members() first sync() the election path
+	// with the ensemble leader, so they reflect every change committed before
+	// the call rather than the connected server's possibly lagging view.  Each
+	// read then costs a round-trip; see also Sync and LeaderFresh.
+	SyncReads bool
+
+	// BatchReads routes the reads on hot paths, e.g. of membership and of the
+	// leader, through a batching layer: concurrent reads of the same zNode are
+	// coalesced into a single request whose response every caller shares, and
+	// reads of distinct zNodes are pipelined rather than performed one after
+	// the other.  Only requests issued since the call began are joined, so
+	// results are never older than the state at some point during the call.
+	// This cuts round-trips when membership is refreshed by many callers at
+	// once or many members join together.  See Stats for the effect.
+	BatchReads bool
+
+	// UpdateSnapshots makes every update delivered to subscribers carry the
+	// full member list as of the update (see primitives.Update.Members), so
+	// subscribers needn't call back into Members() and race with further
+	// changes.
+	UpdateSnapshots bool
+
+	// MaxCheckpointSize bounds the data accepted by SaveCheckpoint.  Defaults
+	// to DefaultMaxCheckpointSize.
+	MaxCheckpointSize int
+
+	// OnCheckpoint, when non-nil, is invoked with the latest checkpoint (nil
+	// if there is none) whenever the local member acquires leadership, so it
+	// can resume where the previous leader left off.  Invoked on its own
+	// goroutine.
+	OnCheckpoint func(checkpoint *Checkpoint)
+
+	// Clock drives the Coordinator's own timers: retry backoff, request and
+	// re-arm timeouts, and the verification, split-brain, watchdog and
+	// resolution intervals.  ZooKeeper session timing is unaffected.  Defaults
+	// to clock.Real.  Must be set before Start().
+	Clock clock.Clock
 }
 
 type clusterMembershipResponse struct {
@@ -67,9 +358,14 @@ func NewCoordinator(zkServers []string, sessionTimeout time.Duration, leaderElec
 		Hostname: hostname,
 		Data:     data,
 	}
-	localNodeJson, err := json.Marshal(&localNode)
+	localNodeData, err := DefaultCodec.Encode(localNode)
 	if err != nil {
-		return nil, fmt.Errorf("NewCoordinator: failed converting localNode to JSON: %s", err)
+		return nil, fmt.Errorf("NewCoordinator: failed encoding localNode: %s", err)
+	}
+
+	blobSalt := make([]byte, 16)
+	if _, err := rand.Read(blobSalt); err != nil {
+		return nil, fmt.Errorf("NewCoordinator: %s", err)
 	}
 
 	if subscribers == nil {
@@ -79,62 +375,240 @@ func NewCoordinator(zkServers []string, sessionTimeout time.Duration, leaderElec
 	cc := &Coordinator{
 		zkServers:              zkServers,
 		sessionTimeout:         sessionTimeout,
+		hostProvider:           &DynamicHostProvider{},
 		leaderElectionPath:     leaderElectionPath,
 		LocalNode:              localNode,
-		localNodeJson:          localNodeJson,
+		localNodeData:          localNodeData,
 		membershipRequestsChan: make(chan chan clusterMembershipResponse),
-		stopChan:               make(chan chan struct{}),
-		subscriberChans:        subscribers,                       // part of subscription handler.
-		subAddChan:             make(chan chan primitives.Update), // part of subscription handler.
-		subRemoveChan:          make(chan chan primitives.Update), // part of subscription handler.
+		syncRequestsChan:       make(chan chan error),
+		subscriberChans:        subscribers,
+		subscriberFilters:      map[chan primitives.Update]UpdateFilter{},
+		checkpointVersion:      checkpointVersionUnloaded,
+		blobSalt:               blobSalt,
 	}
 
 	return cc, nil
 }
 
+// Start joins the election.  It is safe to invoke concurrently with Start and
+// Stop, and returns AlreadyStartedError when already running.
 func (cc *Coordinator) Start() error {
+	_, _, err := cc.start()
+	return err
+}
+
+// StartAndWait starts the Coordinator and blocks until the local candidate
+// zNode has been created and the first membership snapshot has been processed,
+// i.e. until Leader() and Mode() reflect the election.  If ctx is done first the
+// Coordinator is stopped again and an error is returned.
+func (cc *Coordinator) StartAndWait(ctx context.Context) error {
+	joinedChan, generation, err := cc.start()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-joinedChan:
+		return nil
+	case <-ctx.Done():
+		// NB: Only stops the incarnation started here, not one started after a
+		// concurrent Stop.
+		if err := cc.stop(generation); err != nil && err != AlreadyStoppedError {
+			log.Warnf("%v: problem stopping after failure to join election (non-fatal, will continue): %s", cc.Id(), err)
+		}
+		return fmt.Errorf("%v: joining election: %s", cc.Id(), ctx.Err())
+	}
+}
+
+// start returns a channel which gets closed once the election has been
+// joined, along with the generation of the new incarnation.
+func (cc *Coordinator) start() (chan struct{}, uint64, error) {
 	log.Infof("Coordinator Id=%v starting..", cc.Id())
 	cc.stateLock.Lock()
 	defer cc.stateLock.Unlock()
 
 	if cc.zkCli != nil {
-		return fmt.Errorf("%v: already started", cc.Id())
+		return nil, 0, AlreadyStartedError
+	}
+	if err := cc.PathLayout.Validate(); err != nil {
+		return nil, 0, fmt.Errorf("%v: %s", cc.Id(), err)
+	}
+	if cc.WatchPredecessor {
+		if err := cc.validateWatchPredecessor(); err != nil {
+			return nil, 0, fmt.Errorf("%v: %s", cc.Id(), err)
+		}
 	}
 
+	// Re-encode since the Codec may have been changed since construction.
+	cc.leaderLock.Lock()
+	if cc.EnrichIdentity {
+		enrichIdentity(&cc.LocalNode)
+	}
+	if cc.Witness {
+		cc.markWitness()
+	}
+	if err := cc.validate(cc.LocalNode); err != nil {
+		cc.leaderLock.Unlock()
+		return nil, 0, err
+	}
+	localNodeData, err := cc.encodeLocal(cc.LocalNode)
+	if err == nil {
+		cc.localNodeData = localNodeData
+	}
+	cc.leaderLock.Unlock()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%v: failed encoding localNode: %s", cc.Id(), err)
+	}
+	if err := cc.checkPayloadQuota(cc.candidatesPath()+"/"+cc.PathLayout.NodeName(cc.LocalNode), localNodeData); err != nil {
+		return nil, 0, err
+	}
+
+	cc.generation++
+	joinedChan := make(chan struct{})
+	if err := cc.connectAndRun(joinedChan, false); err != nil {
+		return nil, 0, err
+	}
+
+	cc.startHistorySink()
+	cc.record(HistoryStarted, "", "")
+
+	cc.startWatchdog()
+
+	log.Infof("Coordinator Id=%v started", cc.Id())
+	return joinedChan, cc.generation, nil
+}
+
+// connectAndRun establishes a new ZooKeeper connection and launches the
+// election loop.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) connectAndRun(joinedChan chan struct{}, recovered bool) error {
 	// Assemble the cluster coordinator.
-	zkCli, eventCh, err := zk.Connect(cc.zkServers, cc.sessionTimeout)
+	servers := cc.hostProvider.Configured() // Reflects any discovered changes.
+	if len(servers) == 0 {
+		servers = cc.zkServers
+	}
+	zkCli, eventCh, err := zk.Connect(servers, cc.sessionTimeout, zk.WithDialer(cc.dial), zk.WithHostProvider(cc.hostProvider))
 	if err != nil {
 		return err
 	}
 	cc.zkCli = zkCli
 	cc.eventCh = eventCh
+	cc.abortChan = make(chan struct{})
+	cc.loopDoneChan = make(chan struct{})
+	cc.markProgress()
 
 	// Start the election loop.
-	cc.electionLoop()
+	cc.electionLoop(joinedChan, recovered)
 
-	log.Infof("Coordinator Id=%v started", cc.Id())
+	if cc.EnsembleDiscovery {
+		abortChan := cc.abortChan
+		cc.resources.Go("ensemble-discovery", func() { cc.watchEnsembleConfig(zkCli, abortChan) })
+	}
+	if cc.ResolveInterval > 0 {
+		abortChan := cc.abortChan
+		cc.resources.Go("resolve-loop", func() { cc.resolveLoop(abortChan) })
+	}
 	return nil
 }
 
+// Stop leaves the election.  It is safe to invoke concurrently with Start and
+// Stop, and returns AlreadyStoppedError when not running.
 func (cc *Coordinator) Stop() error {
+	return cc.stop(0)
+}
+
+// stop stops the incarnation of the given generation, or whichever is running
+// when zero.
+func (cc *Coordinator) stop(generation uint64) error {
 	log.Infof("Coordinator Id=%v stopping..", cc.Id())
-	cc.stateLock.Lock()
-	defer cc.stateLock.Unlock()
 
-	if cc.zkCli == nil {
-		return fmt.Errorf("%v: already stopped", cc.Id())
+	// NB: The handoff happens before acquiring stateLock, since the handoff
+	// delay may be lengthy.
+	if err := cc.quiesce(generation, func(current uint64, zkCli *zk.Conn) { cc.handOff(zkCli, current, nil) }); err != nil {
+		return err
 	}
+	defer cc.stateLock.Unlock()
 
-	// Stop the election loop
-	ackChan := make(chan struct{})
-	cc.stopChan <- ackChan
-	<-ackChan // Wait for acknowledgement.
+	cc.record(HistoryStopped, "", "")
+	cc.leaveTombstone()    // NB: Before teardown so the remaining members find it upon noticing the departure.
+	cc.withdrawRecord()    // NB: After the tombstone, as its deletion is the departure.
+	cc.withdrawLocalBlob() // NB: After the tombstone, as it may delete the candidate zNode.
+	cc.stopHistorySink()   // NB: Before teardown so sinks may still use the connection.
+	cc.teardown()
 
+	log.Infof("Coordinator Id=%v stopped", cc.Id())
+	return nil
+}
+
+// quiesce stops the watchdog of the incarnation of the given generation, or
+// whichever is running when zero, then invokes prepare with the generation
+// being stopped and its connection.  Returns holding cc.stateLock, unless the
+// incarnation is already stopped, in which case AlreadyStoppedError is
+// returned.
+func (cc *Coordinator) quiesce(generation uint64, prepare func(current uint64, zkCli *zk.Conn)) error {
+	for {
+		// NB: The watchdog must be stopped before acquiring stateLock since it
+		// may be in the midst of a restart.
+		cc.stateLock.Lock()
+		if cc.zkCli == nil || (generation != 0 && cc.generation != generation) {
+			cc.stateLock.Unlock()
+			return AlreadyStoppedError
+		}
+		current, zkCli := cc.generation, cc.zkCli
+		watchdogStopChan := cc.watchdogStopChan
+		cc.watchdogStopChan = nil
+		cc.stateLock.Unlock()
+		if watchdogStopChan != nil {
+			ackChan := make(chan struct{})
+			watchdogStopChan <- ackChan
+			<-ackChan
+		}
+
+		prepare(current, zkCli)
+
+		cc.stateLock.Lock()
+		if cc.zkCli == nil {
+			cc.stateLock.Unlock()
+			return AlreadyStoppedError
+		}
+		if cc.generation == current {
+			return nil
+		}
+		// Stopped and started again by concurrent callers meanwhile, so the
+		// new incarnation's watchdog is still running.
+		cc.stateLock.Unlock()
+		if generation != 0 {
+			return AlreadyStoppedError
+		}
+	}
+}
+
+// teardown stops the election loop and closes the connection.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) teardown() {
+	// Stop the election loop.  Closing the connection unblocks any in-flight
+	// operations, so this works even when the loop is wedged.
+	close(cc.abortChan)
 	cc.zkCli.Close()
+	<-cc.loopDoneChan // Wait for acknowledgement.
+
+	cc.release()
+}
+
+// release forgets the closed connection along with the state tied to it.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) release() {
 	cc.zkCli = nil
+	cc.resources.DisownAll() // NB: Ephemerals go away with the session.
+	cc.resources.UnwatchAll()
 
-	log.Infof("Coordinator Id=%v stopped", cc.Id())
-	return nil
+	cc.leaderLock.Lock()
+	cc.zNode = ""
+	cc.lastSynced = time.Time{}
+	cc.leaderLock.Unlock()
+	cc.countLeadership(false)
 }
 
 func (cc *Coordinator) Id() (id string) {
@@ -150,6 +624,13 @@ func (cc *Coordinator) Id() (id string) {
 // Leader returns the Node representation of the current leader, or nil if there isn't one right now.
 // string if the current leader is unknown.
 func (cc *Coordinator) Leader() *primitives.Node {
+	if cc.SyncReads {
+		cc.syncForRead()
+	}
+	return cc.leader()
+}
+
+func (cc *Coordinator) leader() *primitives.Node {
 	cc.leaderLock.Lock()
 	defer cc.leaderLock.Unlock()
 
@@ -162,13 +643,10 @@ func (cc *Coordinator) Leader() *primitives.Node {
 }
 
 func (cc *Coordinator) LeaderData() string {
-	cc.leaderLock.Lock()
-	defer cc.leaderLock.Unlock()
-
-	if cc.leaderNode == nil {
-		return ""
+	if leader := cc.Leader(); leader != nil {
+		return leader.Data
 	}
-	return cc.leaderNode.Data
+	return ""
 }
 
 // Mode returns one of:
@@ -187,74 +665,345 @@ func (cc *Coordinator) mode() string {
 	if cc.leaderNode == nil {
 		return primitives.Follower
 	}
-	itsMe := fmt.Sprintf("%+v", cc.LocalNode) == fmt.Sprintf("%+v", *cc.leaderNode)
+	// NB: Compare by identity since the published data may change over time.
+	// The zNode is preferred as not every Codec is able to carry the Uuid.
+	var itsMe bool
+	if cc.zNode != "" && cc.leaderZNode != "" {
+		itsMe = cc.zNode == cc.leaderZNode
+	} else {
+		itsMe = cc.LocalNode.Uuid == cc.leaderNode.Uuid
+	}
 	if itsMe {
 		return primitives.Leader
 	}
 	return primitives.Follower
 }
 
+// SetData replaces the data published for the local node.  When the
+// Coordinator is running the change is written through to the election zNode
+// right away, otherwise it takes effect on the next Start().
+func (cc *Coordinator) SetData(data string) error {
+	return cc.updateLocal("SetData", func(localNode *primitives.Node) {
+		localNode.Data = data
+	})
+}
+
+// updateLocal applies fn to the local node and publishes the result like
+// SetData.  op prefixes errors.
+func (cc *Coordinator) updateLocal(op string, fn func(localNode *primitives.Node)) error {
+	cc.stateLock.Lock()
+	zkCli := cc.zkCli
+	cc.stateLock.Unlock()
+
+	cc.leaderLock.Lock()
+	previous := cc.inlineNode(cc.LocalNode)
+	localNode := cc.LocalNode
+	cc.leaderLock.Unlock()
+
+	fn(&localNode)
+	if err := cc.validate(localNode); err != nil {
+		return err
+	}
+	localNodeData, err := cc.encodeLocal(localNode)
+	if err != nil {
+		return fmt.Errorf("%v: failed encoding localNode: %s", op, err)
+	}
+	if err := cc.checkPayloadQuota(cc.candidatesPath()+"/"+cc.PathLayout.NodeName(localNode), localNodeData); err != nil {
+		return err
+	}
+	if zkCli != nil {
+		if err := cc.publishBlob(zkCli, localNode); err != nil {
+			return fmt.Errorf("%v: publishing blob: %s", op, err)
+		}
+	}
+
+	cc.leaderLock.Lock()
+	cc.LocalNode = localNode
+	cc.localNodeData = localNodeData
+	zNode := cc.zNode
+	cc.leaderLock.Unlock()
+
+	if zkCli == nil || zNode == "" {
+		return nil
+	}
+	if _, err := zkCli.Set(zNode, localNodeData, -1); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("%v: updating zNode=%v: %s", op, zNode, err)
+	}
+	if previous.DataRef != cc.inlineNode(localNode).DataRef {
+		cc.withdrawBlob(zkCli, previous)
+	}
+	return nil
+}
+
 func (cc *Coordinator) Members() (nodes []primitives.Node, err error) {
-	request := make(chan clusterMembershipResponse)
-	cc.membershipRequestsChan <- request
+	// NB: Buffered so the election loop never blocks on a timed-out request.
+	request := make(chan clusterMembershipResponse, 1)
+	timeout := cc.clock().After(cc.requestTimeout())
+	select {
+	case cc.membershipRequestsChan <- request:
+	case <-timeout:
+		err = fmt.Errorf("membership %s after %v", RequestTimeoutError, cc.requestTimeout())
+		return
+	}
 	select {
 	case response := <-request:
 		if err = response.err; err != nil {
 			return
 		}
 		nodes = response.nodes
-	case <-time.After(cc.sessionTimeout):
-		err = fmt.Errorf("membership request timed out after %v", cc.sessionTimeout)
+	case <-timeout:
+		err = fmt.Errorf("membership %s after %v", RequestTimeoutError, cc.requestTimeout())
 	}
 	return
 }
 
-func (cc *Coordinator) electionLoop() {
+// electionLoop launches the election loop goroutine.  joinedChan, if non-nil,
+// is closed once the election has been joined, and recovered indicates the
+// loop is being run as the result of a watchdog restart.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) electionLoop(joinedChan chan struct{}, recovered bool) {
+	var (
+		generation   = cc.generation
+		eventCh      = cc.eventCh
+		abortChan    = cc.abortChan
+		loopDoneChan = cc.loopDoneChan
+		retry        = func(name string, operation func() error) bool {
+			return retryUntilSuccessOrAbort(fmt.Sprintf("%v %v", cc.Id(), name), operation, backoff.NewConstantBackOff(cc.retryInterval()), cc.clock(), abortChan, nil)
+		}
+		retryWithin = func(name string, timeout time.Duration, operation func() error) bool {
+			return retryUntilSuccessOrAbort(fmt.Sprintf("%v %v", cc.Id(), name), operation, backoff.NewConstantBackOff(cc.retryInterval()), cc.clock(), abortChan, cc.clock().After(timeout))
+		}
+	)
+
 	createElectionZNode := func() (zNode string) {
-		log.Debugf("%v: creating election path=%v", cc.Id(), cc.leaderElectionPath)
-		strategy := backoff.NewConstantBackOff(backoffDuration)
-		zNodes := util.MustCreateP(cc.zkCli, cc.leaderElectionPath, []byte{}, 0, zk.WorldACL(zk.PermAll), strategy)
+		candidatesPath := cc.candidatesPath()
+		cc.detectCapabilities(cc.zkCli) // NB: Before EnsurePath so container support is known.
+		log.Debugf("%v: creating election path=%v", cc.Id(), candidatesPath)
+		var (
+			zNodes     []string
+			ensurePath = func() (err error) {
+				zNodes, err = util.EnsurePath(cc.zkCli, candidatesPath, util.EnsurePathOptions{ACL: cc.PathACL, Container: cc.ContainerPaths})
+				return
+			}
+			operation = ensurePath
+		)
+		if !retry("createElectionZNode", operation) {
+			return
+		}
 		log.Debugf("%v: created election path, zNodes=%+v", cc.Id(), zNodes)
 
+		if zNode = cc.existingRecord(cc.zkCli); zNode != "" {
+			log.Debugf("%v: keeping persistent record, zNode=%v", cc.Id(), zNode)
+			return
+		}
+		if err := cc.checkJoinQuota(cc.zkCli); err != nil {
+			log.Errorf("%v: not joining the election for now: %s", cc.Id(), err)
+			return
+		}
+
 		log.Debugf("%v: creating protected ephemeral", cc.Id())
-		strategy = backoff.NewConstantBackOff(backoffDuration)
-		zNode = util.MustCreateProtectedEphemeralSequential(cc.zkCli, cc.leaderElectionPath+"/n_", cc.localNodeJson, zk.WorldACL(zk.PermAll), strategy)
+		cc.leaderLock.Lock()
+		localNode := cc.LocalNode
+		localNodeData := cc.localNodeData
+		nodeName := cc.PathLayout.NodeName(cc.LocalNode)
+		cc.leaderLock.Unlock()
+		operation = func() error {
+			return cc.publishBlob(cc.zkCli, localNode)
+		}
+		if !retry("publishBlob", operation) {
+			return
+		}
+		operation = func() (err error) {
+			zNode, err = cc.createCandidate(cc.zkCli, candidatesPath+"/"+nodeName, localNodeData)
+			if err == zk.ErrNoNode {
+				// An emptied container parent was deleted meanwhile.
+				ensurePath()
+			}
+			return
+		}
+		if !retry("createElectionZNode", operation) {
+			return
+		}
 		log.Debugf("%v: created protected ephemeral, zNode=%v", cc.Id(), zNode)
+		cc.countStat(func(stats *Stats) { stats.ElectionsJoined++ })
+		cc.leaderLock.Lock()
+		if cc.zNode != "" {
+			cc.resources.Disown(cc.zNode) // Gone with its expired session.
+		}
+		if !cc.PersistentMembership {
+			cc.resources.Own(zNode)
+		}
+		cc.zNode = zNode
+		stale := string(localNodeData) != string(cc.localNodeData)
+		localNodeData = cc.localNodeData
+		cc.leaderLock.Unlock()
+		if stale {
+			// SetData was invoked while the zNode was being created.
+			if _, err := cc.zkCli.Set(zNode, localNodeData, -1); err != nil {
+				log.Errorf("%v: failed updating zNode=%v with latest data: %s", cc.Id(), zNode, err)
+			}
+		}
 		return
 	}
 
-	mustSubscribe := func(path string) (children []string, stat *zk.Stat, evCh <-chan zk.Event) {
+	mustSubscribe := func(path string) (children []string, stat *zk.Stat, evCh <-chan zk.Event, ok bool) {
 		var err error
 		operation := func() error {
 			if children, stat, evCh, err = cc.zkCli.ChildrenW(path); err != nil {
-				// Protect against infinite failure loop by ensuring the path to watch exists.
-				createElectionZNode()
+				if err == zk.ErrNoNode {
+					// Protect against infinite failure loop by ensuring the path to watch exists.
+					createElectionZNode()
+				}
 				return err
 			}
 			return nil
 		}
-		log.Debugf("%v: setting watch on path=%v", cc.Id(), cc.leaderElectionPath)
-		gentle.RetryUntilSuccess(fmt.Sprintf("%v mustSubscribe", cc.Id()), operation, backoff.NewConstantBackOff(backoffDuration))
-		log.Debugf("%v: successfully set watch on path=%v", cc.Id(), cc.leaderElectionPath)
+		log.Debugf("%v: setting watch on path=%v", cc.Id(), path)
+		if !retryWithin("mustSubscribe", cc.watchRearmTimeout(), operation) {
+			return
+		}
+		log.Debugf("%v: successfully set watch on path=%v", cc.Id(), path)
+		evCh = cc.resources.Watch(path, evCh)
+		ok = true
 		return
 	}
 
-	go func() {
+	cc.resources.Go("election-loop", func() {
+		defer close(loopDoneChan)
+
 		// var children []string
 		var (
-			childCh <-chan zk.Event
-			zNode   string // Most recent zxid.
+			childCh      <-chan zk.Event
+			zNode        string // Most recent zxid.
+			verifyCh     <-chan time.Time
+			lastVerified time.Time
+			splitBrainCh <-chan time.Time
+			heartbeatCh  <-chan time.Time
+			rearmCh      <-chan time.Time
+			members      map[string]primitives.Node // Candidate children as of the last checkLeader.
+			handedOff    string                     // Leader zNode whose handoff was last announced.
+			sticky       stickyState
+			hadSession   bool              // Whether a session has been established yet.
+			inSession    bool              // Whether a session establishment is being handled.
+			notified     primitives.Update // Last leader or degraded update delivered.
+			syncedCh     = make(chan syncResult)
+			sweptCh      chan struct{} // Non-nil while stale members are being swept.
+
+			// Only used when WatchPredecessor is set.
+			predCh, leaderCh, handoffCh              <-chan zk.Event
+			predWatched, leaderWatched, handoffWatch string // zNodes being watched.
+			watchingAsLeader                         bool
+			watchedMissing                           bool // Whether a watched zNode has already departed.
 		)
 
+		if cc.LeaderVerifyInterval > 0 {
+			verifyTicker := cc.clock().NewTicker(cc.LeaderVerifyInterval)
+			defer verifyTicker.Stop()
+			verifyCh = verifyTicker.C()
+		}
+		if cc.SplitBrainCheckInterval > 0 {
+			splitBrainTicker := cc.clock().NewTicker(cc.SplitBrainCheckInterval)
+			defer splitBrainTicker.Stop()
+			splitBrainCh = splitBrainTicker.C()
+		}
+		if cc.PersistentMembership {
+			heartbeatTicker := cc.clock().NewTicker(cc.heartbeatInterval())
+			defer heartbeatTicker.Stop()
+			heartbeatCh = heartbeatTicker.C()
+		}
+
+		// watchExists arms an existence watch on zNode unless one is already
+		// pending.  An empty zNode clears the watch.
+		watchExists := func(ch *<-chan zk.Event, watched *string, zNode string) bool {
+			if zNode == "" {
+				cc.resources.Unwatch(*ch)
+				*ch, *watched = nil, ""
+				return true
+			}
+			if *ch != nil && *watched == zNode {
+				return true
+			}
+			cc.resources.Unwatch(*ch)
+			exists, _, evCh, err := cc.zkCli.ExistsW(zNode)
+			if err != nil {
+				log.Warnf("%v: watching zNode=%v: %s", cc.Id(), zNode, err)
+				return false
+			}
+			if !exists && zNode != cc.candidatesPath()+"/"+handoffZNodeName {
+				watchedMissing = true
+			}
+			*ch, *watched = cc.resources.Watch(zNode, evCh), zNode
+			return true
+		}
+
+		setPredecessorWatches := func() bool {
+			children, _, err := cc.zkCli.Children(cc.candidatesPath())
+			if err != nil {
+				log.Warnf("%v: listing candidates: %s", cc.Id(), err)
+				return false
+			}
+			cc.leaderLock.Lock()
+			local := path.Base(cc.zNode)
+			leader := cc.leaderZNode
+			cc.leaderLock.Unlock()
+			predecessor := predecessorOf(cc.PathLayout.SortedCandidates(children), local)
+			if predecessor != "" {
+				predecessor = cc.candidatesPath() + "/" + predecessor
+			}
+			if predecessor == leader {
+				predecessor = "" // Covered by the leader watch.
+			}
+			watchedMissing = false
+			return watchExists(&predCh, &predWatched, predecessor) &&
+				watchExists(&leaderCh, &leaderWatched, leader) &&
+				watchExists(&handoffCh, &handoffWatch, cc.candidatesPath()+"/"+handoffZNodeName)
+		}
+
 		setWatch := func() {
-			_ /*children*/, _, childCh = mustSubscribe(cc.leaderElectionPath)
+			if cc.WatchPredecessor && cc.Mode() != primitives.Leader {
+				watchingAsLeader = false
+				cc.resources.Unwatch(childCh)
+				childCh = nil
+				if setPredecessorWatches() {
+					rearmCh = nil
+				} else {
+					log.Warnf("%v: unable to arm predecessor watches, will try again shortly", cc.Id())
+					rearmCh = cc.clock().After(cc.retryInterval())
+				}
+				return
+			}
+			watchingAsLeader = true
+			for _, ch := range []<-chan zk.Event{predCh, leaderCh, handoffCh, childCh} {
+				cc.resources.Unwatch(ch)
+			}
+			predCh, leaderCh, handoffCh = nil, nil, nil
+			predWatched, leaderWatched, handoffWatch = "", "", ""
+
+			var ok bool
+			if _ /*children*/, _, childCh, ok = mustSubscribe(cc.candidatesPath()); ok {
+				rearmCh = nil
+			} else {
+				log.Warnf("%v: unable to re-arm election watch within %s, will try again shortly", cc.Id(), cc.watchRearmTimeout())
+				rearmCh = cc.clock().After(cc.retryInterval())
+			}
 		}
 
 		notifySubscribers := func(updateInfo primitives.Update) {
+			cc.countStat(func(stats *Stats) { stats.UpdatesEmitted++ })
+			if cc.UpdateSnapshots && updateInfo.Members == nil {
+				updateInfo.Members = cc.snapshotMembers(members)
+			}
+			kinds := updateKinds(updateInfo, notified, inSession)
+			if updateInfo.Type == primitives.LeaderUpdate || updateInfo.Type == primitives.DegradedUpdate {
+				notified = updateInfo
+			}
+			cc.subscribersLock.Lock()
+			defer cc.subscribersLock.Unlock()
 			if nSub := len(cc.subscriberChans); nSub > 0 {
 				log.Debugf("%v: broadcasting leader update to %v subscribers", cc.Id(), nSub)
 				for _, subChan := range cc.subscriberChans {
-					if subChan != nil {
+					if subChan != nil && cc.wants(subChan, kinds) {
 						select {
 						case subChan <- updateInfo:
 						default:
@@ -266,60 +1015,230 @@ func (cc *Coordinator) electionLoop() {
 
 		checkLeader := func() {
 			var (
+				since     = time.Now()
 				children  []string
 				stat      *zk.Stat
 				operation = func() error {
 					var err error
-					if children, stat, err = cc.zkCli.Children(cc.leaderElectionPath); err != nil {
+					if children, stat, err = cc.zkCli.Children(cc.candidatesPath()); err != nil {
 						return err
 					}
 					return nil
 				}
-				min      = -1
-				minChild string
 			)
-			gentle.RetryUntilSuccess("checkLeader", operation, backoff.NewConstantBackOff(50*time.Millisecond))
+			if !retry("checkLeader", operation) {
+				return
+			}
 			log.Debugf("%v: checkLeader: children=%+v, stat=%+v", cc.Id(), children, *stat)
-			for _, child := range children {
-				pieces := strings.Split(child, "-n_")
-				if len(pieces) <= 1 {
-					continue
-				}
-				n, err := strconv.Atoi(pieces[1])
-				if err != nil {
-					log.Debugf("%v: Failed to parse child=%v: %s, skipping child", cc.Id(), child, err)
-					continue
-				}
-				if min == -1 || n < min {
-					min = n
-					minChild = child
-				}
+			var deltas []primitives.MemberDelta
+			members, deltas = cc.recordMembershipChanges(members, children, since)
+			if evicted := evictions(deltas); len(evicted) > 0 {
+				defer func() {
+					updateInfo := primitives.Update{
+						Type:         primitives.EvictedUpdate,
+						Mode:         cc.Mode(),
+						ElectionPath: cc.leaderElectionPath,
+						Deltas:       evicted,
+					}
+					if leader := cc.leader(); leader != nil {
+						updateInfo.Leader = *leader
+					}
+					notifySubscribers(updateInfo)
+				}()
 			}
-			if min == -1 {
+			if _, ok := cc.PathLayout.LowestCandidate(children); !ok {
 				log.Warnf("%v: No valid children found in children=%+v, aborting check", cc.Id(), children)
 				return
 			}
-			minChild = cc.leaderElectionPath + "/" + minChild
-			data, stat, err := cc.zkCli.Get(minChild)
+			minChild, eligible := cc.elect(children, members)
+			if numMembers := cc.numCandidates(children); numMembers < cc.MinMembers || !eligible {
+				if !eligible {
+					log.Infof("%v: degraded, none of the %v members are eligible to lead", cc.Id(), numMembers)
+					cc.record(HistoryDegraded, "", fmt.Sprintf("members=%v eligible=0", numMembers))
+				} else {
+					log.Infof("%v: degraded, only %v of the minimum %v members are present", cc.Id(), numMembers, cc.MinMembers)
+					cc.record(HistoryDegraded, "", fmt.Sprintf("members=%v min=%v", numMembers, cc.MinMembers))
+				}
+				cc.leaderLock.Lock()
+				cc.leaderNode = nil
+				cc.leaderZNode = ""
+				cc.leaderLock.Unlock()
+				cc.countLeadership(false)
+				notifySubscribers(primitives.Update{
+					Type:         primitives.DegradedUpdate,
+					Mode:         primitives.Follower,
+					ElectionPath: cc.leaderElectionPath,
+					Deltas:       deltas,
+				})
+				return
+			}
+			lowest := minChild
+			minChild, grant := cc.effectiveLeader(cc.zkCli, children, lowest)
+			minChild = cc.candidatesPath() + "/" + minChild
+			data, stat, err := cc.get(cc.zkCli, minChild, since)
 			if err != nil {
 				log.Error("%v: Error checking leader znode path=%v: %s", cc.Id(), minChild, err)
 			}
 			log.Debugf("%v: Discovered leader znode at %v, data=%v stat=%+v", cc.Id(), minChild, string(data), *stat)
 
-			var leaderNode primitives.Node
-			if err := json.Unmarshal(data, &leaderNode); err != nil {
-				log.Error("%v: Failed parsing Node from JSON=%v: %s", cc.Id(), string(data), err)
+			leaderNode, err := cc.decodeNode(cc.zkCli, data)
+			if err != nil {
+				log.Errorf("%v: Failed decoding Node from data=%v: %s", cc.Id(), string(data), err)
 			}
 
 			cc.leaderLock.Lock()
+			wasLeader := cc.mode() == primitives.Leader
+			elected := cc.leaderZNode != minChild
 			cc.leaderNode = &leaderNode
+			cc.leaderZNode = minChild
+			cc.followerRank = followerRank(cc.PathLayout, children, members, path.Base(minChild), path.Base(cc.zNode))
+			acquired := !wasLeader && cc.mode() == primitives.Leader
+			lost := wasLeader && cc.mode() != primitives.Leader
+			if acquired {
+				lastVerified = cc.clock().Now()
+				cc.checkpointVersion = checkpointVersionUnloaded
+			}
+			localZNode := cc.zNode
 			cc.leaderLock.Unlock()
+			if acquired || lost {
+				cc.advertiseLeadership(cc.zkCli, localZNode, data, acquired)
+			}
+			cc.countLeadership(cc.Mode() == primitives.Leader)
+			if acquired && cc.OnCheckpoint != nil {
+				cc.resources.Go("resume-from-checkpoint", func() { cc.resumeFromCheckpoint(generation) })
+			}
+			if elected {
+				cc.record(HistoryElected, path.Base(minChild), leaderNode.String())
+			}
 
 			updateInfo := primitives.Update{
-				Leader: leaderNode,
-				Mode:   cc.mode(),
+				Leader:       leaderNode,
+				Mode:         cc.mode(),
+				ElectionPath: cc.leaderElectionPath,
+				Deltas:       deltas,
 			}
 			notifySubscribers(updateInfo)
+
+			cc.stickyTransition(&sticky, updateInfo.Mode, lowest, path.Base(minChild))
+			cc.negotiateHandback(cc.zkCli, &sticky, children, lowest, path.Base(minChild), grant)
+
+			if minChild != handedOff && containsString(children, handoffZNodeName) {
+				if successorZNode, successor, err := cc.readHandoff(cc.zkCli, minChild); err != nil {
+					log.Warnf("%v: failed reading handoff: %s", cc.Id(), err)
+				} else if successor != nil {
+					log.Infof("%v: leader is handing off to successor=%v", cc.Id(), successorZNode)
+					handedOff = minChild
+					if updateInfo.Mode != primitives.Leader {
+						cc.record(HistoryHandoff, successorZNode, "")
+					}
+					notifySubscribers(primitives.Update{
+						Type:         primitives.HandoffUpdate,
+						Leader:       *successor,
+						Mode:         updateInfo.Mode,
+						ElectionPath: cc.leaderElectionPath,
+					})
+				}
+			}
+
+			if cc.SplitBrainCheckInterval > 0 {
+				if err := cc.publishLeaderView(); err != nil {
+					log.Warnf("%v: failed publishing leader view: %s", cc.Id(), err)
+				}
+			}
+		}
+
+		checkSplitBrain := func() {
+			if err := cc.publishLeaderView(); err != nil {
+				log.Warnf("%v: failed publishing leader view: %s", cc.Id(), err)
+				return
+			}
+			if cc.Mode() != primitives.Leader {
+				return
+			}
+			disagreeing, err := cc.detectSplitBrain()
+			if err != nil {
+				log.Warnf("%v: split-brain check failed: %s", cc.Id(), err)
+				return
+			}
+			if len(disagreeing) > cc.SplitBrainThreshold {
+				log.Warnf("%v: split-brain detected, %v member(s) disagree about the leader: %+v", cc.Id(), len(disagreeing), disagreeing)
+				cc.record(HistorySplitBrain, "", fmt.Sprintf("disagreeing=%v", len(disagreeing)))
+				notifySubscribers(primitives.Update{
+					Type:         primitives.SplitBrainUpdate,
+					Leader:       cc.LocalNode,
+					Mode:         primitives.Leader,
+					ElectionPath: cc.leaderElectionPath,
+					Disagreeing:  disagreeing,
+				})
+			}
+		}
+
+		verifyLeadership := func() {
+			cc.leaderLock.Lock()
+			isLeader := cc.mode() == primitives.Leader
+			myZNode := cc.zNode
+			cc.leaderLock.Unlock()
+
+			if !isLeader || myZNode == "" {
+				return
+			}
+
+			exists, stat, err := cc.exists(cc.zkCli, myZNode, time.Now())
+			if err == nil && exists && cc.ownsCandidate(cc.zkCli, stat) {
+				lastVerified = cc.clock().Now()
+				return
+			} else if err == nil {
+				log.Warnf("%v: leadership verification failed, election zNode=%v exists=%v", cc.Id(), myZNode, exists)
+			} else if staleness := cc.clock().Since(lastVerified); staleness <= cc.leaderMaxStaleness() {
+				log.Warnf("%v: leadership verification error (staleness=%s is within bound): %s", cc.Id(), staleness, err)
+				return
+			} else {
+				log.Warnf("%v: leadership could not be verified for %s: %s", cc.Id(), staleness, err)
+			}
+
+			log.Infof("%v: demoting self from leader", cc.Id())
+			cc.record(HistoryDemoted, path.Base(myZNode), "leadership verification failed")
+			cc.leaderLock.Lock()
+			cc.leaderNode = nil
+			cc.leaderZNode = ""
+			cc.leaderLock.Unlock()
+			cc.countLeadership(false)
+			notifySubscribers(primitives.Update{
+				Mode:         primitives.Follower,
+				ElectionPath: cc.leaderElectionPath,
+			})
+
+			if err == nil {
+				// Session is healthy but the zNode is gone or not ours, rejoin.
+				zNode = createElectionZNode()
+				log.Debugf("%v: new zNode=%v", cc.Id(), zNode)
+				checkLeader()
+			}
+		}
+
+		// syncWatches re-arms when leadership moved since the watches were
+		// armed, as followers and the leader watch differently when
+		// WatchPredecessor is set.
+		syncWatches := func() {
+			for i := 0; cc.WatchPredecessor && i < 3; i++ {
+				cc.leaderLock.Lock()
+				isLeader := cc.mode() == primitives.Leader
+				leader := cc.leaderZNode
+				cc.leaderLock.Unlock()
+				if isLeader == watchingAsLeader && (isLeader || (leaderWatched == leader && !watchedMissing)) {
+					return
+				}
+				setWatch()
+				checkLeader()
+			}
+		}
+
+		// onWatchedZNode handles the predecessor, leader and handoff watches.
+		onWatchedZNode := func(name string, ev zk.Event) {
+			log.Debugf("%v: %v watch: ev.Path=%v ev=%+v", cc.Id(), name, ev.Path, ev)
+			checkLeader()
+			setWatch()
+			syncWatches()
 		}
 
 		for {
@@ -328,23 +1247,57 @@ func (cc *Coordinator) electionLoop() {
 
 			// log.Debugf("%v: watch children=%+v",cc.Id(), children)
 			select {
-			case ev := <-cc.eventCh: // Watch connection events.
+			case ev := <-eventCh: // Watch connection events.
 				if ev.Err != nil {
 					log.Error("%v: eventCh: error: %s", cc.Id(), ev.Err)
 					continue
 				}
 				log.Debugf("%v: eventCh: received event=%+v", cc.Id(), ev)
 				if ev.Type == zk.EventSession {
+					cc.notifySessionSubscribers(ev)
+					cc.record(HistorySession, "", ev.State.String())
 					switch ev.State {
 					case zk.StateHasSession:
+						cc.countStat(func(stats *Stats) {
+							if hadSession || recovered {
+								stats.Reconnects++
+							}
+							stats.LastSessionId = cc.zkCli.SessionID()
+						})
+						hadSession = true
+						inSession = true
 						zNode = createElectionZNode()
 						log.Debugf("%v: new zNode=%v", cc.Id(), zNode)
 						setWatch()
 						checkLeader()
+						syncWatches()
+						inSession = false
+						if joinedChan != nil && zNode != "" {
+							close(joinedChan)
+							joinedChan = nil
+						}
+						if recovered {
+							log.Infof("%v: recovered by watchdog", cc.Id())
+							cc.record(HistoryRecovered, "", "")
+							updateInfo := primitives.Update{
+								Type:         primitives.RecoveredUpdate,
+								Mode:         cc.Mode(),
+								ElectionPath: cc.leaderElectionPath,
+							}
+							if leader := cc.leader(); leader != nil {
+								updateInfo.Leader = *leader
+							}
+							notifySubscribers(updateInfo)
+							recovered = false
+						}
+					case zk.StateExpired:
+						cc.resources.DisownAll() // NB: Ephemerals go away with the session.
 					}
 				}
 
 			case ev := <-childCh: // Watch election path.
+				cc.resources.Unwatch(childCh)
+				childCh = nil
 				if ev.Err != nil {
 					log.Error("%v: childCh: watcher error %+v", cc.Id(), ev.Err)
 				}
@@ -352,44 +1305,274 @@ func (cc *Coordinator) electionLoop() {
 					checkLeader()
 				}
 				setWatch()
+				syncWatches()
 				log.Debugf("%v: childCh: ev.Path=%v ev=%+v", cc.Id(), ev.Path, ev)
+				if zNode == "" && hadSession {
+					// Kept out by Quotas, there may be room now.
+					if zNode = createElectionZNode(); zNode != "" {
+						setWatch()
+						checkLeader()
+						syncWatches()
+						if joinedChan != nil {
+							close(joinedChan)
+							joinedChan = nil
+						}
+					}
+				}
+
+			case ev := <-predCh:
+				cc.resources.Unwatch(predCh)
+				predCh = nil
+				onWatchedZNode("predecessor", ev)
+
+			case ev := <-leaderCh:
+				cc.resources.Unwatch(leaderCh)
+				leaderCh = nil
+				onWatchedZNode("leader", ev)
+
+			case ev := <-handoffCh:
+				cc.resources.Unwatch(handoffCh)
+				handoffCh = nil
+				onWatchedZNode("handoff", ev)
 
 				// case <-time.After(time.Second * 5):
 				// 	log.Infof("%v: childCh: Child watcher timed out",cc.Id())
 
-			case requestChan := <-cc.membershipRequestsChan:
-				cc.handleMembershipRequest(requestChan)
-
-			case subChan := <-cc.subAddChan: // Add subscriber chan.
-				log.Debugf("%v: received subscriber add request", cc.Id())
-				cc.subscriberChans = append(cc.subscriberChans, subChan)
-
-			case unsubChan := <-cc.subRemoveChan: // Remove subscriber chan.
-				log.Debugf("%v: received subscriber removal request", cc.Id())
-				revisedChans := []chan primitives.Update{}
-				for _, ch := range cc.subscriberChans {
-					if ch != unsubChan {
-						revisedChans = append(revisedChans, ch)
+			case <-rearmCh:
+				setWatch()
+				checkLeader() // Changes may have been missed while unwatched.
+				syncWatches()
+
+			case <-verifyCh:
+				verifyLeadership()
+				syncWatches()
+
+			case <-splitBrainCh:
+				checkSplitBrain()
+
+			case <-heartbeatCh:
+				stat, err := cc.heartbeat(cc.zkCli)
+				if err == zk.ErrNoNode {
+					log.Warnf("%v: persistent record is gone, presumably evicted, rejoining", cc.Id())
+					zNode = createElectionZNode()
+					log.Debugf("%v: new zNode=%v", cc.Id(), zNode)
+					setWatch()
+					checkLeader()
+					syncWatches()
+				} else if err != nil {
+					log.Warnf("%v: heartbeat failed: %s", cc.Id(), err)
+				} else if sweptCh == nil && cc.sweeps(memberNames(members)) {
+					var (
+						zkCli = cc.zkCli
+						swept = make(chan struct{})
+					)
+					sweptCh = swept
+					cc.resources.Go("sweep-stale-members", func() {
+						defer close(swept)
+						cc.sweepStaleMembers(zkCli, stat.Mtime)
+					})
+				}
+
+			case <-sweptCh:
+				sweptCh = nil
+
+			case replyChan := <-cc.syncRequestsChan:
+				// NB: The sync round trip happens off the loop so a slow
+				// ensemble can't stall it; the refresh happens back here.
+				var (
+					zkCli   = cc.zkCli
+					started = cc.clock().Now()
+				)
+				cc.resources.Go("sync-request", func() {
+					_, err := zkCli.Sync(cc.candidatesPath())
+					select {
+					case syncedCh <- syncResult{started: started, err: err, replyChan: replyChan}:
+					case <-abortChan:
+						replyChan <- errorlib.NotRunningError
+					}
+				})
+
+			case result := <-syncedCh:
+				if result.err == nil {
+					checkLeader()
+					syncWatches()
+					cc.leaderLock.Lock()
+					if result.started.After(cc.lastSynced) {
+						cc.lastSynced = result.started
 					}
+					cc.leaderLock.Unlock()
 				}
-				cc.subscriberChans = revisedChans
+				result.replyChan <- result.err
+
+			case requestChan := <-cc.membershipRequestsChan:
+				// NB: Handled asynchronously so a hung ensemble can't stall the loop.
+				zkCli := cc.zkCli
+				cc.resources.Go("membership-request", func() { cc.handleMembershipRequest(zkCli, requestChan) })
 
-			case ack := <-cc.stopChan: // Stop loop.
+			case <-abortChan: // Stop loop.
 				log.Debugf("%v: election loop received stop request", cc.Id())
-				ack <- struct{}{} // Acknowledge stop.
 				log.Debugf("%v: election loop exiting", cc.Id())
 				return
 			}
+			cc.markProgress()
 		}
-	}()
+	})
+}
+
+func (cc *Coordinator) clock() clock.Clock {
+	if cc.Clock != nil {
+		return cc.Clock
+	}
+	return clock.Real
+}
+
+// recordMembershipChanges records members joining and departing since the
+// previous snapshot, and returns the new snapshot along with the changes.
+// Only the local member is recorded when there is no previous snapshot.  The
+// children must have been listed since the given time.
+func (cc *Coordinator) recordMembershipChanges(previous map[string]primitives.Node, children []string, since time.Time) (map[string]primitives.Node, []primitives.MemberDelta) {
+	current := map[string]primitives.Node{}
+	var deltas []primitives.MemberDelta
+	joined, zNodes := []string{}, []string{}
+	for _, child := range children {
+		if !cc.PathLayout.IsCandidate(child) {
+			continue
+		}
+		if node, ok := previous[child]; ok {
+			current[child] = node
+			continue
+		}
+		joined = append(joined, child)
+		zNodes = append(zNodes, cc.candidatesPath()+"/"+child)
+	}
+	for i, result := range cc.getAll(cc.zkCli, zNodes, since) {
+		child, err := joined[i], result.err
+		if err != nil && err != zk.ErrNoNode {
+			log.Warnf("%v: reading member=%v: %s", cc.Id(), child, err)
+		}
+		node, _ := cc.codec().Decode(result.data)
+		if cc.ValidateReads && err == nil {
+			if err := cc.validate(node); err != nil {
+				log.Warnf("%v: member=%v: %s", cc.Id(), child, err)
+				node = primitives.Node{} // NB: Its data isn't passed on, as with Members().
+			}
+		}
+		current[child] = node
+		if previous != nil {
+			cc.record(HistoryJoined, child, "")
+			deltas = append(deltas, primitives.MemberDelta{ZNode: child, Node: node, Joined: true})
+		}
+	}
+	if previous == nil {
+		cc.leaderLock.Lock()
+		local := path.Base(cc.zNode)
+		cc.leaderLock.Unlock()
+		if _, ok := current[local]; ok {
+			cc.record(HistoryJoined, local, "local")
+		}
+		return current, deltas
+	}
+	departed := []string{}
+	for child := range previous {
+		if _, ok := current[child]; !ok {
+			departed = append(departed, child)
+		}
+	}
+	sort.Strings(departed)
+	for i, t := range cc.departures(cc.zkCli, departed) {
+		child, reason, node := departed[i], t.Reason, t.Node
+		if node.Uuid == uuid.Nil {
+			node = previous[child]
+		}
+		if reason == primitives.DepartureSessionExpired && cc.Mode() == primitives.Leader && !referencesBlob(current, previous[child].DataRef) {
+			// NB: Graceful departures withdraw their own blobs.
+			cc.withdrawBlob(cc.zkCli, previous[child])
+		}
+		cc.record(HistoryDeparted, child, reason)
+		deltas = append(deltas, primitives.MemberDelta{ZNode: child, Node: node, Reason: reason})
+	}
+	return current, deltas
+}
+
+// numCandidates returns the number of children which are candidates.
+func (cc *Coordinator) numCandidates(children []string) int {
+	var n int
+	for _, child := range children {
+		if cc.PathLayout.IsCandidate(child) {
+			n++
+		}
+	}
+	return n
+}
+
+// candidatesPath returns the path under which candidate zNodes live.
+func (cc *Coordinator) candidatesPath() string {
+	return cc.PathLayout.CandidatesPath(cc.leaderElectionPath)
 }
 
-func (cc *Coordinator) handleMembershipRequest(requestChan chan clusterMembershipResponse) {
-	children, _, err := cc.zkCli.Children(cc.leaderElectionPath)
+// dial is the zk.Dialer used for all connections, it applies ConnectTimeout.
+func (cc *Coordinator) dial(network string, address string, _ time.Duration) (net.Conn, error) {
+	timeout := cc.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	cc.netConnLock.Lock()
+	cc.netConn = conn
+	cc.netConnLock.Unlock()
+	return conn, nil
+}
+
+func (cc *Coordinator) requestTimeout() time.Duration {
+	if cc.RequestTimeout > 0 {
+		return cc.RequestTimeout
+	}
+	return cc.sessionTimeout
+}
+
+func (cc *Coordinator) retryInterval() time.Duration {
+	if cc.RetryInterval > 0 {
+		return cc.RetryInterval
+	}
+	return DefaultRetryInterval
+}
+
+func (cc *Coordinator) watchRearmTimeout() time.Duration {
+	if cc.WatchRearmTimeout > 0 {
+		return cc.WatchRearmTimeout
+	}
+	return DefaultWatchRearmTimeout
+}
+
+func (cc *Coordinator) leaderMaxStaleness() time.Duration {
+	if cc.LeaderMaxStaleness > 0 {
+		return cc.LeaderMaxStaleness
+	}
+	return cc.sessionTimeout
+}
+
+func (cc *Coordinator) handleMembershipRequest(zkCli *zk.Conn, requestChan chan clusterMembershipResponse) {
+	if cc.SyncReads {
+		if _, err := zkCli.Sync(cc.candidatesPath()); err != nil {
+			requestChan <- clusterMembershipResponse{err: err}
+			return
+		}
+	}
+	since := time.Now()
+	allChildren, _, err := zkCli.Children(cc.candidatesPath())
 	if err != nil {
 		requestChan <- clusterMembershipResponse{err: err}
 		return
 	}
+	children := make([]string, 0, len(allChildren))
+	for _, child := range allChildren {
+		if cc.PathLayout.IsCandidate(child) {
+			children = append(children, child)
+		}
+	}
 	var (
 		numChildren = len(children)
 		nodeGetters = make([]func() error, numChildren)
@@ -399,13 +1582,13 @@ func (cc *Coordinator) handleMembershipRequest(requestChan chan clusterMembershi
 	for i, child := range children {
 		func(i int, child string) {
 			nodeGetters[i] = func() error {
-				data, _, err := cc.zkCli.Get(cc.leaderElectionPath + "/" + child)
+				data, _, err := cc.get(zkCli, cc.candidatesPath()+"/"+child, since)
 				if err != nil {
 					return err
 				}
-				var node primitives.Node
-				if err := json.Unmarshal(data, &node); err != nil {
-					return fmt.Errorf("decoding %v bytes of JSON for child=%v: %s", len(data), child, err)
+				node, err := cc.decodeNode(zkCli, data)
+				if err != nil {
+					return fmt.Errorf("child=%v: %s", child, err)
 				}
 				nodesLock.Lock()
 				nodes[i] = node
@@ -422,12 +1605,40 @@ func (cc *Coordinator) handleMembershipRequest(requestChan chan clusterMembershi
 }
 
 // Subscribe adds a channel to the slice of subscribers who get notified when
-// the leader changes.
-func (cc *Coordinator) Subscribe(subChan chan primitives.Update) {
-	cc.subAddChan <- subChan
+// the leader changes.  When filters are given, only updates of the selected
+// kinds are delivered, e.g. Subscribe(ch, FilterLeaderChanges) isn't woken by
+// every member joining or departing.
+//
+// Subscribe and Unsubscribe never block, whether or not the Coordinator is
+// running.
+func (cc *Coordinator) Subscribe(subChan chan primitives.Update, filters ...UpdateFilter) {
+	var combined UpdateFilter // Zero means unfiltered.
+	for _, filter := range filters {
+		combined |= filter
+	}
+
+	cc.subscribersLock.Lock()
+	defer cc.subscribersLock.Unlock()
+
+	cc.subscriberChans = append(cc.subscriberChans, subChan)
+	if combined != 0 {
+		cc.subscriberFilters[subChan] = combined
+	} else {
+		delete(cc.subscriberFilters, subChan)
+	}
 }
 
-// Unsubscribe removes a channel frmo the slice of subscribers.
+// Unsubscribe removes a channel from the slice of subscribers.
 func (cc *Coordinator) Unsubscribe(unsubChan chan primitives.Update) {
-	cc.subRemoveChan <- unsubChan
+	cc.subscribersLock.Lock()
+	defer cc.subscribersLock.Unlock()
+
+	revisedChans := []chan primitives.Update{}
+	for _, ch := range cc.subscriberChans {
+		if ch != unsubChan {
+			revisedChans = append(revisedChans, ch)
+		}
+	}
+	cc.subscriberChans = revisedChans
+	delete(cc.subscriberFilters, unsubChan)
 }
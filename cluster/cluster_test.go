@@ -1,6 +1,7 @@
 package cluster_test
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
@@ -12,22 +13,50 @@ import (
 	"github.com/gigawattio/zklib/cluster"
 	"github.com/gigawattio/zklib/cluster/primitives"
 	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/testutil/scenario"
 )
 
 var zkTimeout = 1 * time.Second
 
 // ncc creates a new Coordinator for a given test cluster.
 func ncc(t *testing.T, zkServers []string, data string, subscribers ...chan primitives.Update) *cluster.Coordinator {
-	cc, err := cluster.NewCoordinator(zkServers, zkTimeout, "/"+testlib.CurrentRunningTest(), data, subscribers...)
+	cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), data, subscribers...)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := cc.Start(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cc.StartAndWait(ctx); err != nil {
 		t.Fatal(err)
 	}
 	return cc
 }
 
+// waitForAgreement waits until all members agree on who the leader is.  It
+// gives up silently after a while, leaving it to the caller to verify.
+func waitForAgreement(t *testing.T, members []*cluster.Coordinator) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var (
+			expected *primitives.Node
+			agreed   = true
+		)
+		for _, member := range members {
+			leader := member.Leader()
+			if leader == nil || (expected != nil && leader.Uuid != expected.Uuid) {
+				agreed = false
+				break
+			}
+			expected = leader
+		}
+		if agreed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Logf("members still disagree about the leader after 5s")
+}
+
 func TestClusterLeaderElection(t *testing.T) {
 	// NB: tcSz == zookeeper test cluster size.
 	for _, tcSz := range []int{1} {
@@ -35,28 +64,44 @@ func TestClusterLeaderElection(t *testing.T) {
 			for _, sz := range []int{1, 2, 3, 4} {
 				t.Logf("Testing with number of cluster members sz=%v", sz)
 
-				members := make([]*cluster.Coordinator, sz)
+				var (
+					members = make([]*cluster.Coordinator, sz)
+					fc      = testutil.NewFakeClock(time.Now())
+					wg      sync.WaitGroup
+				)
 				for i := 0; i < sz; i++ {
 					cc := ncc(t, zkServers, fmt.Sprintf("i=%v", i))
 					members[i] = cc
 
+					wg.Add(1)
 					go func(i int) {
+						defer wg.Done()
 						if err := cc.Stop(); err != nil {
-							t.Fatalf("Stopping cc member #%v: %s", i, err)
+							t.Errorf("Stopping cc member #%v: %s", i, err)
+							return
 						}
 
 						wait := time.Duration(i*250) * time.Millisecond
-						t.Logf("random wait for member=%s --> %s", cc.Id(), wait)
-						time.Sleep(wait)
+						t.Logf("staggered wait for member=%s --> %s", cc.Id(), wait)
+						fc.Sleep(wait)
 
 						if err := cc.Start(); err != nil {
-							t.Fatalf("Starting cc member #%v: %s", i, err)
+							t.Errorf("Starting cc member #%v: %s", i, err)
 						}
 					}(i)
 				}
 
-				time.Sleep(time.Duration(sz*600) * time.Millisecond)
-				t.Logf("done sleeping")
+				// Release the staggered restarts in order, then wait for them
+				// to settle.  NB: The fake clock only paces the restarts, the
+				// members themselves keep running on the real one.
+				if !fc.BlockUntilTimeout(sz-1, 5*time.Second) {
+					t.Fatalf("Timed out waiting for %v staggered restarts to begin waiting", sz-1)
+				}
+				for i := 1; i < sz; i++ {
+					fc.Advance(250 * time.Millisecond)
+				}
+				wg.Wait()
+				waitForAgreement(t, members)
 
 				verifyState := func(replaceLeader bool) {
 					var retried bool
@@ -129,7 +174,7 @@ func TestClusterLeaderElection(t *testing.T) {
 					t.Logf("iteration #%v tc_sz=%v members_sz=%v [ mutate ]----------------", i, len(zkServers), sz)
 					verifyState(true)
 
-					time.Sleep(100 * time.Millisecond)
+					waitForAgreement(t, members)
 					t.Logf("iteration #%v tc_sz=%v members_sz=%v [ verify ]----------------", i, len(zkServers), sz)
 					verifyState(false)
 				}
@@ -144,6 +189,38 @@ func TestClusterLeaderElection(t *testing.T) {
 	}
 }
 
+// TestClusterLeaderElectionScenario scripts the same churn as
+// TestClusterLeaderElection, but also kills leaders outright rather than only
+// stopping them.
+func TestClusterLeaderElectionScenario(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		for _, sz := range []int{1, 2, 3, 4} {
+			t.Logf("Testing with number of cluster members sz=%v", sz)
+
+			s := scenario.New(t, zkServers, testutil.Namespace(t))
+			s.SessionTimeout = zkTimeout
+			s.Start(sz).ExpectLeader(0)
+
+			// Restart every member in turn.
+			for i := 0; i < sz; i++ {
+				s.Restart(i)
+			}
+			s.ExpectLeader(0).ExpectMembers(sz, 0)
+
+			// Repeatedly replace the leader, alternately stopping and killing it.
+			for i := 0; i < sz*2; i++ {
+				if i%2 == 0 {
+					s.StopLeader()
+				} else {
+					s.KillLeader()
+				}
+				s.Start(1).ExpectNewLeader(0).ExpectMembers(sz, 0)
+			}
+			s.Run()
+		}
+	})
+}
+
 func Test_ClusterSubscriptions(t *testing.T) {
 	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
 		var (
@@ -254,3 +331,30 @@ func TestClusterMembersListing(t *testing.T) {
 		})
 	}
 }
+
+func TestClusterStartAndWait(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc := ncc(t, zkServers, "start-and-wait")
+		// No sleeping required: the election has been joined once ncc returns.
+		if leader := cc.Leader(); leader == nil || leader.Uuid != cc.LocalNode.Uuid {
+			t.Fatalf("Expected sole member to be leader immediately after StartAndWait, but leader=%v", leader)
+		}
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// An unreachable ensemble must produce a definite error.
+	cc, err := cluster.NewCoordinator([]string{"127.0.0.1:1"}, zkTimeout, "/"+testlib.CurrentRunningTest(), "unreachable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if err := cc.StartAndWait(ctx); err == nil {
+		t.Fatalf("Expected StartAndWait against an unreachable ensemble to fail")
+	}
+	if err := cc.Stop(); err == nil {
+		t.Fatalf("Expected Coordinator to have been stopped after StartAndWait failure")
+	}
+}
@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// NodeCodec converts between a Node and the data stored in its candidate
+// zNode.
+type NodeCodec interface {
+	Encode(node primitives.Node) ([]byte, error)
+	Decode(data []byte) (primitives.Node, error)
+}
+
+// DefaultCodec is used when a Coordinator has no Codec configured.
+var DefaultCodec NodeCodec = JSONCodec{}
+
+// JSONCodec stores the entire Node as JSON.
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(node primitives.Node) ([]byte, error) {
+	data, err := json.Marshal(&node)
+	if err != nil {
+		return nil, fmt.Errorf("encoding node to JSON: %s", err)
+	}
+	return data, nil
+}
+
+func (JSONCodec) Decode(data []byte) (primitives.Node, error) {
+	var node primitives.Node
+	if err := json.Unmarshal(data, &node); err != nil {
+		return node, fmt.Errorf("decoding %v bytes of JSON: %s", len(data), err)
+	}
+	return node, nil
+}
+
+func (cc *Coordinator) codec() NodeCodec {
+	if cc.Codec == nil {
+		return DefaultCodec
+	}
+	return cc.Codec
+}
@@ -0,0 +1,204 @@
+package cluster_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// electionFixture describes an election tree as created by participants
+// written in other languages (e.g. Java using Curator).  The fixtures are
+// captured from the real libraries by the programs in testdata/capture.
+type electionFixture struct {
+	Recipe   string `json:"recipe"`
+	Children []struct {
+		Name string `json:"name"`
+		Id   string `json:"id"`
+	} `json:"children"`
+	Leader string `json:"leader"`
+}
+
+func loadElectionFixtures(t *testing.T, filename string) []electionFixture {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []electionFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	return fixtures
+}
+
+func fixtureLayout(t *testing.T, recipe string) cluster.PathLayout {
+	switch recipe {
+	case "LeaderLatch":
+		return cluster.CuratorLeaderLatchLayout
+	case "LeaderSelector":
+		return cluster.CuratorLeaderSelectorLayout
+	case "KazooElection":
+		return cluster.KazooElectionLayout
+	}
+	t.Fatalf("Unrecognized fixture recipe=%q", recipe)
+	return cluster.PathLayout{}
+}
+
+// compatTestCases lists the foreign election recipes the Coordinator
+// interoperates with.  namePrefixExpr matches whatever precedes the candidate
+// prefix in a participant's zNode name.
+var compatTestCases = []struct {
+	name           string
+	fixtures       string
+	codec          cluster.NodeCodec
+	newCoordinator newCompatCoordinator
+	namePrefixExpr string
+}{
+	{
+		name:           "curator",
+		fixtures:       "testdata/curator-fixtures.json",
+		codec:          cluster.CuratorCodec{},
+		newCoordinator: cluster.NewCuratorCoordinator,
+		namePrefixExpr: `^_c_[0-9a-f-]+-`,
+	},
+	{
+		name:           "kazoo",
+		fixtures:       "testdata/kazoo-fixtures.json",
+		codec:          cluster.KazooCodec{},
+		newCoordinator: cluster.NewKazooCoordinator,
+		namePrefixExpr: `^(_c_[0-9a-f]+-)?[0-9a-f]{32}`,
+	},
+}
+
+func TestCompatFixtures(t *testing.T) {
+	for _, testCase := range compatTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			verifyFixtureLeaders(t, loadElectionFixtures(t, testCase.fixtures), testCase.codec)
+		})
+	}
+}
+
+// TestCompatInterop recreates the fixture participants in ZooKeeper exactly as
+// the foreign library would and verifies a Go participant honors them, and
+// that the Go participant's zNode is intelligible to the foreign library.
+func TestCompatInterop(t *testing.T) {
+	for _, testCase := range compatTestCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testElectionInterop(t, loadElectionFixtures(t, testCase.fixtures), testCase.newCoordinator, testCase.namePrefixExpr)
+		})
+	}
+}
+
+func verifyFixtureLeaders(t *testing.T, fixtures []electionFixture, codec cluster.NodeCodec) {
+	for _, fixture := range fixtures {
+		layout := fixtureLayout(t, fixture.Recipe)
+		names := []string{"unrelated", "lock"}
+		ids := map[string]string{}
+		for _, child := range fixture.Children {
+			names = append(names, child.Name)
+			ids[child.Name] = child.Id
+		}
+		leader, ok := layout.LowestCandidate(names)
+		if !ok {
+			t.Fatalf("[recipe=%v] Expected a leader to be found among children=%v", fixture.Recipe, names)
+		}
+		node, err := codec.Decode([]byte(ids[leader]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Data != fixture.Leader {
+			t.Errorf("[recipe=%v] Expected leader id=%v but actual=%v", fixture.Recipe, fixture.Leader, node.Data)
+		}
+	}
+}
+
+type newCompatCoordinator func(zkServers []string, sessionTimeout time.Duration, path string, participantId string, subscribers ...chan primitives.Update) (*cluster.Coordinator, error)
+
+// testElectionInterop creates the fixture participants in ZooKeeper, starts a
+// Go participant alongside them, and verifies both sides agree on leadership.
+func testElectionInterop(t *testing.T, fixtures []electionFixture, newCoordinator newCompatCoordinator, namePrefixExpr string) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		for _, fixture := range fixtures {
+			latchPath := testutil.Namespace(t) + "/" + fixture.Recipe
+
+			foreignConn, _, err := zk.Connect(zkServers, zkTimeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := util.CreateP(foreignConn, latchPath, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil {
+				t.Fatal(err)
+			}
+			for _, child := range fixture.Children {
+				if _, err := foreignConn.Create(latchPath+"/"+child.Name, []byte(child.Id), zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cc, err := newCoordinator(zkServers, zkTimeout, latchPath, "go-participant")
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.PathLayout = fixtureLayout(t, fixture.Recipe)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if leader := cc.Leader(); leader == nil || leader.Data != fixture.Leader {
+				t.Errorf("[recipe=%v] Expected leader id=%v but leader=%+v", fixture.Recipe, fixture.Leader, leader)
+			}
+			if mode := cc.Mode(); mode != primitives.Follower {
+				t.Errorf("[recipe=%v] Expected Go participant mode=%v but actual=%v", fixture.Recipe, primitives.Follower, mode)
+			}
+
+			// Participants are identified by "<lock-name><sequence>" and the
+			// participant id is read as raw UTF-8 bytes.
+			children, _, err := foreignConn.Children(latchPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expr := regexp.MustCompile(namePrefixExpr + regexp.QuoteMeta(fixtureLayout(t, fixture.Recipe).CandidatePrefix) + `[0-9]{10}$`)
+			var found bool
+			for _, child := range children {
+				if !expr.MatchString(child) {
+					t.Errorf("[recipe=%v] Child zNode=%v does not follow expected naming", fixture.Recipe, child)
+				}
+				data, _, err := foreignConn.Get(latchPath + "/" + child)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) == "go-participant" {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("[recipe=%v] Go participant id not found in any child zNode data", fixture.Recipe)
+			}
+
+			// Once the foreign participants go away the Go participant takes over.
+			foreignConn.Close()
+			deadline := time.Now().Add(5 * time.Second)
+			for cc.Mode() != primitives.Leader && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			if mode := cc.Mode(); mode != primitives.Leader {
+				t.Errorf("[recipe=%v] Expected Go participant mode=%v after foreign participants departed but actual=%v", fixture.Recipe, primitives.Leader, mode)
+			}
+
+			if err := cc.Stop(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
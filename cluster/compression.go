@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	"github.com/golang/snappy"
+)
+
+// Compression identifies the algorithm used to compress a zNode payload.  The
+// value doubles as the header byte prefixed to payloads written by
+// CompressingCodec.
+type Compression byte
+
+const (
+	NoCompression     Compression = 0x00
+	GzipCompression   Compression = 0x01
+	SnappyCompression Compression = 0x02
+)
+
+// DefaultCompressionMinSize is the payload size below which compression is
+// skipped when a CompressingCodec has no MinSize configured.
+const DefaultCompressionMinSize = 512
+
+// CompressingCodec transparently compresses the payloads produced by another
+// codec, reducing ZooKeeper memory and network pressure when members publish
+// large data blobs.
+//
+// Every payload written gets a header byte identifying the compression used,
+// so readers auto-detect it.  Payloads without a recognized header (e.g. those
+// written by members not using compression) are passed to the inner codec
+// untouched, so compression may be enabled during a rolling deploy.
+type CompressingCodec struct {
+	Codec     NodeCodec   // Inner codec, defaults to DefaultCodec when nil.
+	Algorithm Compression // Algorithm to compress with.
+	MinSize   int         // Payloads smaller than this are stored uncompressed.
+}
+
+func (codec CompressingCodec) Encode(node primitives.Node) ([]byte, error) {
+	data, err := codec.inner().Encode(node)
+	if err != nil {
+		return nil, err
+	}
+	minSize := codec.MinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+	algorithm := codec.Algorithm
+	if len(data) < minSize {
+		algorithm = NoCompression
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(byte(algorithm))
+	switch algorithm {
+	case NoCompression:
+		buf.Write(data)
+	case GzipCompression:
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("gzip compressing payload: %s", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("gzip compressing payload: %s", err)
+		}
+	case SnappyCompression:
+		buf.Write(snappy.Encode(nil, data))
+	default:
+		return nil, fmt.Errorf("unrecognized compression algorithm=0x%02x", byte(algorithm))
+	}
+	return buf.Bytes(), nil
+}
+
+func (codec CompressingCodec) Decode(data []byte) (primitives.Node, error) {
+	if len(data) == 0 {
+		return codec.inner().Decode(data)
+	}
+	var (
+		payload []byte
+		err     error
+	)
+	switch Compression(data[0]) {
+	case NoCompression:
+		payload = data[1:]
+	case GzipCompression:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data[1:])); err == nil {
+			payload, err = ioutil.ReadAll(r)
+		}
+	case SnappyCompression:
+		payload, err = snappy.Decode(nil, data[1:])
+	default:
+		// No header, written without compression.
+		payload = data
+	}
+	if err != nil {
+		return primitives.Node{}, fmt.Errorf("decompressing %v byte payload: %s", len(data), err)
+	}
+	return codec.inner().Decode(payload)
+}
+
+func (codec CompressingCodec) inner() NodeCodec {
+	if codec.Codec == nil {
+		return DefaultCodec
+	}
+	return codec.Codec
+}
@@ -0,0 +1,54 @@
+package cluster_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+func TestCompressingCodec(t *testing.T) {
+	large := primitives.Node{Hostname: "host-a", Data: strings.Repeat("abcdefgh", 1000)}
+	small := primitives.Node{Hostname: "host-b", Data: "tiny"}
+
+	for _, algorithm := range []cluster.Compression{cluster.GzipCompression, cluster.SnappyCompression} {
+		codec := cluster.CompressingCodec{Algorithm: algorithm}
+		for _, node := range []primitives.Node{large, small} {
+			data, err := codec.Encode(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedHeader := algorithm
+			if node.Data == small.Data {
+				expectedHeader = cluster.NoCompression
+			}
+			if actual := cluster.Compression(data[0]); actual != expectedHeader {
+				t.Errorf("[algorithm=%v] Expected header=%v but actual=%v", algorithm, expectedHeader, actual)
+			}
+			if node.Data == large.Data && len(data) >= len(large.Data)/2 {
+				t.Errorf("[algorithm=%v] Expected large payload to shrink, but len=%v", algorithm, len(data))
+			}
+			decoded, err := codec.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Data != node.Data || decoded.Hostname != node.Hostname {
+				t.Errorf("[algorithm=%v] Round-trip mismatch, decoded=%+v", algorithm, decoded)
+			}
+		}
+
+		// Payloads written without compression are still readable.
+		legacy, err := cluster.JSONCodec{}.Encode(large)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := codec.Decode(legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Data != large.Data {
+			t.Errorf("[algorithm=%v] Failed to decode uncompressed legacy payload", algorithm)
+		}
+	}
+}
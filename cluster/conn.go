@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gigawattio/errorlib"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var RequestTimeoutError = errors.New("request timed out")
+
+// Conn returns the Coordinator's managed ZooKeeper connection, or nil when not
+// running.
+//
+// The connection belongs to the Coordinator: callers must never Close() it,
+// and must not hold on to it since it is replaced whenever the watchdog
+// restarts the Coordinator.  Prefer Do, which takes care of both.
+func (cc *Coordinator) Conn() *zk.Conn {
+	cc.stateLock.Lock()
+	defer cc.stateLock.Unlock()
+	return cc.zkCli
+}
+
+// Do invokes fn with the managed ZooKeeper connection, so applications can
+// perform custom zNode operations without opening a second session.
+//
+// Errors which indicate a connectivity problem (see IsConnectivityError)
+// cause fn to be retried with the current connection until ctx is done; any
+// other error is returned as-is.  fn must therefore be idempotent, and must
+// not Close() the connection.
+func (cc *Coordinator) Do(ctx context.Context, fn func(conn *zk.Conn) error) error {
+	for attempt := 1; ; attempt++ {
+		conn := cc.Conn()
+		if conn == nil {
+			return errorlib.NotRunningError
+		}
+		err := fn(conn)
+		if err == nil || !IsConnectivityError(err) {
+			return err
+		}
+		log.Debugf("%v: Do: attempt #%v failed with connectivity error (will retry): %s", cc.Id(), attempt, err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: Do: giving up after %v attempt(s): %s (last error: %s)", cc.Id(), attempt, ctx.Err(), err)
+		case <-cc.clock().After(cc.retryInterval()):
+		}
+	}
+}
+
+// request invokes fn with the managed ZooKeeper connection, giving up with
+// RequestTimeoutError once RequestTimeout elapses.  fn is left to finish in
+// the background on timeout, so its outcome is then unknown to the caller.
+func (cc *Coordinator) request(fn func(zkCli *zk.Conn) error) error {
+	zkCli := cc.Conn()
+	if zkCli == nil {
+		return errorlib.NotRunningError
+	}
+	errChan := make(chan error, 1)
+	go func() { errChan <- fn(zkCli) }()
+	select {
+	case err := <-errChan:
+		return err
+	case <-cc.clock().After(cc.requestTimeout()):
+		return fmt.Errorf("%v: %s after %v", cc.Id(), RequestTimeoutError, cc.requestTimeout())
+	}
+}
+
+// IsConnectivityError reports whether err indicates a problem with the
+// connection or session rather than with the requested operation.
+func IsConnectivityError(err error) bool {
+	switch err {
+	case zk.ErrConnectionClosed, zk.ErrClosing, zk.ErrNoServer, zk.ErrSessionExpired, zk.ErrSessionMoved:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestCoordinatorDo(t *testing.T) {
+	t.Parallel()
+
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := cc.Do(ctx, func(_ *zk.Conn) error { return nil }); err != errorlib.NotRunningError {
+			t.Fatalf("Expected Do on a stopped Coordinator to return err=%v but actual=%v", errorlib.NotRunningError, err)
+		}
+
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		path := testutil.Namespace(t) + "/custom"
+		err = cc.Do(ctx, func(conn *zk.Conn) error {
+			if _, err := conn.Create(path, []byte("hello"), zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+				return err
+			}
+			data, stat, err := conn.Get(path)
+			if err != nil {
+				return err
+			}
+			if string(data) != "hello" {
+				t.Errorf("Expected data=hello but actual=%s", string(data))
+			}
+			if stat.EphemeralOwner != conn.SessionID() {
+				t.Errorf("Expected custom zNode to be owned by the Coordinator's session")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Non-connectivity errors are returned immediately.
+		if err := cc.Do(ctx, func(conn *zk.Conn) error { _, err := conn.Create(path, nil, 0, zk.WorldACL(zk.PermAll)); return err }); err != zk.ErrNodeExists {
+			t.Errorf("Expected err=%v but actual=%v", zk.ErrNodeExists, err)
+		}
+
+		// Connectivity errors are retried until the context is done.
+		shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer shortCancel()
+		var attempts int
+		if err := cc.Do(shortCtx, func(_ *zk.Conn) error { attempts++; return zk.ErrConnectionClosed }); err == nil {
+			t.Errorf("Expected persistent connectivity errors to eventually fail")
+		}
+		if attempts < 2 {
+			t.Errorf("Expected connectivity errors to be retried, but attempts=%v", attempts)
+		}
+	})
+}
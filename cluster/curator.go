@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// Layouts matching the zNodes created by Apache Curator's leader recipes.
+//
+// Both recipes create protected ephemeral sequential zNodes directly under the
+// election path, and the participant with the lowest sequence number is the
+// leader, which is exactly the algorithm the Coordinator uses.
+var (
+	CuratorLeaderLatchLayout    = PathLayout{CandidatePrefix: "latch-"} // LeaderLatch.
+	CuratorLeaderSelectorLayout = PathLayout{CandidatePrefix: "lock-"}  // LeaderSelector (via InterProcessMutex).
+)
+
+// CuratorCodec stores only the participant id, as UTF-8 bytes, which is the
+// format Curator participants write and expect to read.  The id is carried in
+// Node.Data; decoded nodes have no Uuid or Hostname since Curator does not
+// record them.
+type CuratorCodec struct{}
+
+func (CuratorCodec) Encode(node primitives.Node) ([]byte, error) {
+	return []byte(node.Data), nil
+}
+
+func (CuratorCodec) Decode(data []byte) (primitives.Node, error) {
+	return primitives.Node{Data: string(data)}, nil
+}
+
+// NewCuratorCoordinator creates a Coordinator which participates in the same
+// election as Curator LeaderLatch instances using latchPath.  participantId is
+// published as the Curator participant id.
+//
+// For LeaderSelector interoperability, replace PathLayout with
+// CuratorLeaderSelectorLayout before calling Start().
+func NewCuratorCoordinator(zkServers []string, sessionTimeout time.Duration, latchPath string, participantId string, subscribers ...chan primitives.Update) (*Coordinator, error) {
+	cc, err := NewCoordinator(zkServers, sessionTimeout, latchPath, participantId, subscribers...)
+	if err != nil {
+		return nil, err
+	}
+	cc.PathLayout = CuratorLeaderLatchLayout
+	cc.Codec = CuratorCodec{}
+	return cc, nil
+}
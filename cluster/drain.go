@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DrainingLabel marks the Nodes of members being drained, see Drain.
+const DrainingLabel = "zklib.draining"
+
+var DefaultDrainPeriod = 30 * time.Second
+
+// IsDraining reports whether node belongs to a member being drained, which
+// should no longer be assigned work.
+func IsDraining(node primitives.Node) bool {
+	return node.Labels[DrainingLabel] == "true"
+}
+
+// Drain gracefully takes the member out of service: it is first marked as
+// draining in its published data (see IsDraining) while remaining a member,
+// so schedulers can stop assigning it work, then stopped once DrainPeriod has
+// elapsed or OnDrain signals done, whichever comes first.  Should ctx be done
+// beforehand the member is stopped right away and an error returned.  Returns
+// errorlib.NotRunningError when not running.
+func (cc *Coordinator) Drain(ctx context.Context) error {
+	if cc.Conn() == nil {
+		return errorlib.NotRunningError
+	}
+	if err := cc.updateLocal("Drain", func(localNode *primitives.Node) { setDraining(localNode, true) }); err != nil {
+		return err
+	}
+	period := cc.DrainPeriod
+	if period <= 0 {
+		period = DefaultDrainPeriod
+	}
+	log.Infof("%v: draining, stopping within %s", cc.Id(), period)
+	cc.record(HistoryDraining, "", fmt.Sprintf("period=%s", period))
+
+	doneChan := make(chan struct{})
+	if cc.OnDrain != nil {
+		var once sync.Once
+		done := func() { once.Do(func() { close(doneChan) }) }
+		cc.resources.Go("on-drain", func() { cc.OnDrain(done) })
+	}
+	var err error
+	select {
+	case <-doneChan:
+	case <-cc.clock().After(period):
+		log.Infof("%v: drain period of %s elapsed", cc.Id(), period)
+	case <-ctx.Done():
+		err = fmt.Errorf("%v: draining: %s", cc.Id(), ctx.Err())
+	}
+
+	stopErr := cc.Stop()
+	// NB: So a later Start() rejoins as a regular member.
+	cc.leaderLock.Lock()
+	setDraining(&cc.LocalNode, false)
+	cc.leaderLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+// setDraining adds or removes the draining label, copying the labels so
+// previously published Nodes are unaffected.
+func setDraining(node *primitives.Node, draining bool) {
+	labels := make(map[string]string, len(node.Labels)+1)
+	for key, value := range node.Labels {
+		labels[key] = value
+	}
+	if draining {
+		labels[DrainingLabel] = "true"
+	} else {
+		delete(labels, DrainingLabel)
+	}
+	node.Labels = labels
+}
@@ -0,0 +1,89 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestDrain(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			cc1 = ncc(t, zkServers, "cc1")
+			cc2 = ncc(t, zkServers, "cc2")
+		)
+		defer cc1.Stop()
+
+		var (
+			startedChan = make(chan struct{})
+			releaseChan = make(chan struct{})
+		)
+		cc2.DrainPeriod = 1 * time.Minute
+		cc2.OnDrain = func(done func()) {
+			close(startedChan)
+			<-releaseChan
+			done()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		errChan := make(chan error, 1)
+		go func() { errChan <- cc2.Drain(ctx) }()
+
+		select {
+		case <-startedChan:
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for OnDrain to be invoked")
+		}
+
+		// While draining cc2 is still a member, but marked as such.
+		for draining := false; !draining; {
+			nodes, err := cc1.Members()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range nodes {
+				if node.Uuid == cc2.LocalNode.Uuid {
+					draining = cluster.IsDraining(node)
+				}
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Timed out waiting for cc2 to be published as draining, members=%+v", nodes)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if cluster.IsDraining(cc1.LocalNode) {
+			t.Errorf("Expected only cc2 to be draining")
+		}
+
+		close(releaseChan)
+		select {
+		case err := <-errChan:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for Drain to return once done was signalled")
+		}
+		if err := cc2.Drain(ctx); err != errorlib.NotRunningError {
+			t.Errorf("Expected Drain on a stopped Coordinator to return err=%v but actual=%v", errorlib.NotRunningError, err)
+		}
+		if cluster.IsDraining(cc2.LocalNode) {
+			t.Errorf("Expected the draining mark to be cleared once stopped")
+		}
+
+		// Without a callback the drain period applies.
+		cc3 := ncc(t, zkServers, "cc3")
+		cc3.DrainPeriod = 200 * time.Millisecond
+		started := time.Now()
+		if err := cc3.Drain(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(started); elapsed < cc3.DrainPeriod {
+			t.Errorf("Expected Drain to wait out the drain period=%s but returned after %s", cc3.DrainPeriod, elapsed)
+		}
+	})
+}
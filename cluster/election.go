@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"strconv"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Election decides which candidate leads.  Every member evaluates it
+// independently whenever membership changes, so all members must use the
+// same Election and it must be deterministic given the same candidates.
+type Election interface {
+	// Leader returns the candidate zNode name of the leader among candidates,
+	// which are ordered by sequence number, or ok=false when none may lead.
+	Leader(candidates []Candidate) (zNode string, ok bool)
+}
+
+// Candidate is a member taking part in an election.
+type Candidate struct {
+	ZNode string          // Candidate zNode name.
+	Node  primitives.Node // Data held in a BlobStore is not fetched.
+}
+
+// DefaultElection is used when a Coordinator has no Election configured.
+var DefaultElection Election = LowestSequenceElection{}
+
+// LowestSequenceElection is the classic ZooKeeper recipe: the candidate which
+// joined first leads.
+type LowestSequenceElection struct{}
+
+func (LowestSequenceElection) Leader(candidates []Candidate) (string, bool) {
+	if len(candidates) == 0 {
+		return "", false
+	}
+	return candidates[0].ZNode, true
+}
+
+// DefaultPriorityLabel is the label read by a PriorityWeightedElection with no
+// Label configured.
+const DefaultPriorityLabel = "priority"
+
+// PriorityWeightedElection elects the candidate with the highest integer
+// priority label, e.g. to prefer beefier hosts.  Ties go to the candidate
+// which joined first.  Candidates without a parseable label have priority
+// zero.
+type PriorityWeightedElection struct {
+	Label string // Defaults to DefaultPriorityLabel.
+}
+
+func (election PriorityWeightedElection) Leader(candidates []Candidate) (string, bool) {
+	label := election.Label
+	if label == "" {
+		label = DefaultPriorityLabel
+	}
+	var (
+		leader string
+		best   int64
+		ok     bool
+	)
+	for _, candidate := range candidates {
+		priority, _ := strconv.ParseInt(candidate.Node.Labels[label], 10, 64)
+		if !ok || priority > best {
+			leader, best, ok = candidate.ZNode, priority, true
+		}
+	}
+	return leader, ok
+}
+
+// ArbiterElection defers the decision to an external arbiter, e.g. a
+// scheduler or an operator-controlled service.  Decide returns the uuid of the
+// member which should lead; no leader is elected when it errs or names a
+// member which is not a candidate.  Decide is consulted by every member, so
+// must give consistent answers and should return promptly.
+type ArbiterElection struct {
+	Decide func(candidates []Candidate) (uuid string, err error)
+}
+
+func (election ArbiterElection) Leader(candidates []Candidate) (string, bool) {
+	if election.Decide == nil || len(candidates) == 0 {
+		return "", false
+	}
+	uuid, err := election.Decide(candidates)
+	if err != nil {
+		log.Warnf("Arbiter election failed, no leader will be elected: %s", err)
+		return "", false
+	}
+	for _, candidate := range candidates {
+		if candidate.Node.Uuid.String() == uuid {
+			return candidate.ZNode, true
+		}
+	}
+	return "", false
+}
+
+func (cc *Coordinator) election() Election {
+	if cc.Election == nil {
+		return DefaultElection
+	}
+	return cc.Election
+}
+
+// elect returns the leader among the candidates in children, excluding
+// witnesses and any not satisfying LeaderLabels, as per the Nodes of members.
+func (cc *Coordinator) elect(children []string, members map[string]primitives.Node) (zNode string, ok bool) {
+	candidates := []Candidate{}
+	for _, child := range cc.PathLayout.SortedCandidates(children) {
+		if node := members[child]; node.HasLabels(cc.LeaderLabels) && !IsWitness(node) {
+			candidates = append(candidates, Candidate{ZNode: child, Node: node})
+		}
+	}
+	return cc.election().Leader(candidates)
+}
@@ -0,0 +1,94 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestElections(t *testing.T) {
+	candidate := func(zNode string, priority string) cluster.Candidate {
+		node := primitives.NewNode(zNode)
+		if priority != "" {
+			node.Labels = map[string]string{"priority": priority}
+		}
+		return cluster.Candidate{ZNode: zNode, Node: *node}
+	}
+	candidates := []cluster.Candidate{
+		candidate("n_0000000001", ""),
+		candidate("n_0000000002", "5"),
+		candidate("n_0000000003", "10"),
+		candidate("n_0000000004", "10"),
+	}
+
+	testCases := []struct {
+		election cluster.Election
+		expected string
+	}{
+		{cluster.LowestSequenceElection{}, "n_0000000001"},
+		{cluster.PriorityWeightedElection{}, "n_0000000003"},
+		{cluster.PriorityWeightedElection{Label: "weight"}, "n_0000000001"},
+		{cluster.ArbiterElection{Decide: func(candidates []cluster.Candidate) (string, error) {
+			return candidates[1].Node.Uuid.String(), nil
+		}}, "n_0000000002"},
+		{cluster.ArbiterElection{Decide: func(_ []cluster.Candidate) (string, error) {
+			return "", errors.New("arbiter unreachable")
+		}}, ""},
+		{cluster.ArbiterElection{Decide: func(_ []cluster.Candidate) (string, error) {
+			return primitives.NewNode("departed").Uuid.String(), nil
+		}}, ""},
+	}
+	for i, testCase := range testCases {
+		leader, ok := testCase.election.Leader(candidates)
+		if leader != testCase.expected || ok != (testCase.expected != "") {
+			t.Errorf("[i=%v] Expected %T to elect leader=%q but actual=%q ok=%v", i, testCase.election, testCase.expected, leader, ok)
+		}
+		if _, ok := testCase.election.Leader(nil); ok {
+			t.Errorf("[i=%v] Expected %T not to elect a leader without candidates", i, testCase.election)
+		}
+	}
+}
+
+func TestPriorityWeightedElection(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		start := func(name string, priority string) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.LocalNode.Labels = map[string]string{"priority": priority}
+			cc.Election = cluster.PriorityWeightedElection{}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+		low := start("low", "1")
+		defer low.Stop()
+		high := start("high", "9")
+
+		members := []*cluster.Coordinator{low, high}
+		waitForAgreement(t, members)
+		for _, cc := range members {
+			if leader := cc.Leader(); leader == nil || leader.Uuid != high.LocalNode.Uuid {
+				t.Errorf("%v: Expected the higher priority member to lead despite joining last, but leader=%v", cc.Id(), leader)
+			}
+		}
+
+		if err := high.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		waitForAgreement(t, members[0:1])
+		if mode := low.Mode(); mode != primitives.Leader {
+			t.Errorf("Expected remaining member mode=%v but actual=%v", primitives.Leader, mode)
+		}
+	})
+}
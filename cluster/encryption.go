@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+)
+
+// EncryptingCodec transparently encrypts the payloads produced by another
+// codec with AES-GCM (see util.Encryptor), so member metadata isn't readable
+// by anyone with access to the ensemble but not to the keys.  Wrap a
+// CompressingCodec, rather than the other way around, to combine the two.
+//
+// Every payload records the id of the key it was encrypted with, so keys may
+// be rotated by switching the KeyProvider's current key while retaining the
+// previous ones until every member has re-published its data (e.g. via
+// SetData or a restart).  Payloads without the encryption envelope are passed
+// to the inner codec untouched unless RequireEncryption is set, so encryption
+// may be enabled during a rolling deploy.
+//
+// A Coordinator using an EncryptingCodec encrypts the other payloads it keeps
+// beside the candidates alike: tombstones, checkpoints and blobs (see
+// BlobThreshold) held by the default BlobStore.  Bookkeeping which merely
+// names members, e.g. handoffs, history and leader views, is kept in the
+// clear, as is everything handed out of process, e.g. updates relayed by the
+// bus integration.
+type EncryptingCodec struct {
+	Codec             NodeCodec // Inner codec, defaults to DefaultCodec when nil.
+	Keys              util.KeyProvider
+	RequireEncryption bool // Rejects payloads which aren't encrypted.
+}
+
+func (codec EncryptingCodec) Encode(node primitives.Node) ([]byte, error) {
+	data, err := codec.inner().Encode(node)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := codec.encryptor().Seal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting payload: %s", err)
+	}
+	return sealed, nil
+}
+
+func (codec EncryptingCodec) Decode(data []byte) (primitives.Node, error) {
+	payload, err := codec.encryptor().Open(data)
+	if err != nil {
+		return primitives.Node{}, err
+	}
+	return codec.inner().Decode(payload)
+}
+
+// Seal encrypts data other than a Node, see payloadSealer.
+func (codec EncryptingCodec) Seal(data []byte) ([]byte, error) {
+	return codec.encryptor().Seal(data)
+}
+
+// Open decrypts data sealed by Seal.
+func (codec EncryptingCodec) Open(data []byte) ([]byte, error) {
+	return codec.encryptor().Open(data)
+}
+
+func (codec EncryptingCodec) encryptor() util.Encryptor {
+	return util.Encryptor{Keys: codec.Keys, RequireEncryption: codec.RequireEncryption}
+}
+
+func (codec EncryptingCodec) inner() NodeCodec {
+	if codec.Codec == nil {
+		return DefaultCodec
+	}
+	return codec.Codec
+}
+
+// payloadSealer is implemented by codecs which encrypt, so the payloads a
+// Coordinator writes besides candidate zNodes are encrypted alike.
+type payloadSealer interface {
+	Seal(data []byte) ([]byte, error)
+	Open(data []byte) ([]byte, error)
+}
+
+// sealer returns the Codec as a payloadSealer, or nil when it doesn't encrypt.
+func (cc *Coordinator) sealer() payloadSealer {
+	sealer, _ := cc.codec().(payloadSealer)
+	return sealer
+}
+
+// seal encrypts data when the Codec does, see payloadSealer.
+func seal(sealer payloadSealer, data []byte) ([]byte, error) {
+	if sealer == nil {
+		return data, nil
+	}
+	sealed, err := sealer.Seal(data)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting payload: %s", err)
+	}
+	return sealed, nil
+}
+
+// unseal decrypts data sealed by seal.
+func unseal(sealer payloadSealer, data []byte) ([]byte, error) {
+	if sealer == nil {
+		return data, nil
+	}
+	return sealer.Open(data)
+}
@@ -0,0 +1,123 @@
+package cluster_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestEncryptingCodec(t *testing.T) {
+	keys := util.StaticKeys{
+		Current: "2024-01",
+		Keys:    map[string][]byte{"2024-01": bytes.Repeat([]byte{7}, 32)},
+	}
+	node := primitives.Node{Hostname: "host-a", Data: strings.Repeat("secret", 200)}
+
+	for _, inner := range []cluster.NodeCodec{nil, cluster.CompressingCodec{Algorithm: cluster.SnappyCompression}} {
+		codec := cluster.EncryptingCodec{Codec: inner, Keys: keys}
+		data, err := codec.Encode(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("host-a")) {
+			t.Errorf("[inner=%T] Expected the payload to be unreadable", inner)
+		}
+		if id, ok := util.EncryptionKeyId(data); !ok || id != keys.Current {
+			t.Errorf("[inner=%T] Expected key id=%v but actual=%v (ok=%v)", inner, keys.Current, id, ok)
+		}
+		decoded, err := codec.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Data != node.Data || decoded.Hostname != node.Hostname {
+			t.Errorf("[inner=%T] Round-trip mismatch, decoded=%+v", inner, decoded)
+		}
+	}
+
+	// Payloads written without encryption are readable unless it's required.
+	legacy, err := cluster.JSONCodec{}.Encode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := cluster.EncryptingCodec{Keys: keys}
+	if decoded, err := codec.Decode(legacy); err != nil || decoded.Data != node.Data {
+		t.Errorf("Failed to decode unencrypted legacy payload, err=%v", err)
+	}
+	codec.RequireEncryption = true
+	if _, err := codec.Decode(legacy); err != util.NotEncryptedError {
+		t.Errorf("Expected err=%s but actual=%v", util.NotEncryptedError, err)
+	}
+}
+
+func TestEncryptedPayloads(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		secret := strings.Repeat("secret", 100)
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.Codec = cluster.EncryptingCodec{Keys: util.StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{7}, 32)}}}
+		cc.BlobThreshold = 256
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.SaveCheckpoint([]byte(secret)); err != nil {
+			t.Fatal(err)
+		}
+		checkpoint, err := cc.LoadCheckpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checkpoint == nil || string(checkpoint.Data) != secret || checkpoint.Leader.Hostname != cc.LocalNode.Hostname {
+			t.Errorf("Checkpoint round-trip mismatch, checkpoint=%+v", checkpoint)
+		}
+		if leader := cc.Leader(); leader == nil || leader.Data != secret {
+			t.Errorf("Expected the leader's data to be resolved from its blob but leader=%+v", leader)
+		}
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+
+		// Whatever was left behind, e.g. the tombstone and checkpoint, must be
+		// unreadable.
+		err = util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			var check func(zNode string) error
+			check = func(zNode string) error {
+				data, _, err := conn.Get(zNode)
+				if err != nil {
+					return err
+				}
+				if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte(cc.LocalNode.Hostname)) {
+					t.Errorf("Expected zNode=%v to be unreadable but data=%q", zNode, data)
+				}
+				children, _, err := conn.Children(zNode)
+				if err != nil {
+					return err
+				}
+				for _, child := range children {
+					if err := check(zNode + "/" + child); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			return check(electionPath)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,331 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// ZkConfigPath is where ZooKeeper 3.5+ publishes the dynamic ensemble
+// configuration.
+const ZkConfigPath = "/zookeeper/config"
+
+var (
+	ensembleRetryInterval = 1 * time.Second
+
+	ReconfigUnsupportedError = errors.New("the ZooKeeper client library in use does not support dynamic reconfiguration")
+)
+
+// DynamicHostProvider is a zk.HostProvider whose server list may be replaced
+// while connected.  Like zk.DNSHostProvider, host names are resolved to
+// addresses and shuffled.
+type DynamicHostProvider struct {
+	configured []string // Servers as specified, before resolution.
+	servers    []string // Resolved host:port addresses.
+	curr       int
+	last       int
+	lock       sync.Mutex
+}
+
+// Init is called by zk.Connect with the servers from the connection string.
+func (hp *DynamicHostProvider) Init(servers []string) error {
+	_, err := hp.Update(servers)
+	return err
+}
+
+// Update resolves and installs a new server list.  changed reports whether
+// the set of resolved addresses differs from before.
+func (hp *DynamicHostProvider) Update(servers []string) (changed bool, err error) {
+	found, err := resolveServers(servers)
+	if err != nil {
+		return false, err
+	}
+
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+
+	hp.configured = append([]string{}, servers...)
+	if sameServers(found, hp.servers) {
+		return false, nil
+	}
+	hp.servers = found
+	hp.curr = -1
+	hp.last = -1
+	return true, nil
+}
+
+// Configured returns the server list as most recently specified.
+func (hp *DynamicHostProvider) Configured() []string {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	return append([]string{}, hp.configured...)
+}
+
+// Servers returns the resolved server addresses.
+func (hp *DynamicHostProvider) Servers() []string {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	return append([]string{}, hp.servers...)
+}
+
+// Len returns the number of servers available.
+func (hp *DynamicHostProvider) Len() int {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	return len(hp.servers)
+}
+
+// Next returns the next server to connect to.  retryStart will be true once
+// all known servers have been tried without Connected() being called.
+func (hp *DynamicHostProvider) Next() (server string, retryStart bool) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	hp.curr = (hp.curr + 1) % len(hp.servers)
+	retryStart = hp.curr == hp.last
+	if hp.last == -1 {
+		hp.last = 0
+	}
+	return hp.servers[hp.curr], retryStart
+}
+
+// Connected notifies the HostProvider of a successful connection.
+func (hp *DynamicHostProvider) Connected() {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+	hp.last = hp.curr
+}
+
+func resolveServers(servers []string) ([]string, error) {
+	found := []string{}
+	for _, server := range servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			found = append(found, net.JoinHostPort(addr, port))
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no hosts found for addresses %q", servers)
+	}
+	// Randomize the order of the servers to avoid creating hotspots.
+	for i := len(found) - 1; i > 0; i-- {
+		j := rand.Intn(i + 1)
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, nil
+}
+
+func sameServers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseEnsembleConfig extracts the client host:port addresses from the
+// contents of ZkConfigPath, e.g.:
+//
+//	server.1=10.0.0.1:2888:3888:participant;0.0.0.0:2181
+//	server.2=10.0.0.2:2888:3888:participant;2181
+//	version=100000000
+//
+// Wildcard or omitted client addresses are replaced by the server's address.
+func ParseEnsembleConfig(data []byte) (servers []string, version string, err error) {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "version=") {
+			version = strings.TrimPrefix(line, "version=")
+			continue
+		}
+		if !strings.HasPrefix(line, "server.") {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq == -1 {
+			return nil, "", fmt.Errorf("malformed ensemble config line=%q", line)
+		}
+		pieces := strings.SplitN(line[eq+1:], ";", 2)
+		if len(pieces) != 2 {
+			// No client port, clients can't connect to this server.
+			continue
+		}
+		serverHost := pieces[0]
+		if strings.HasPrefix(serverHost, "[") {
+			end := strings.Index(serverHost, "]")
+			if end == -1 {
+				return nil, "", fmt.Errorf("malformed ensemble config line=%q: unclosed '['", line)
+			}
+			serverHost = serverHost[1:end]
+		} else if i := strings.Index(serverHost, ":"); i != -1 {
+			serverHost = serverHost[0:i]
+		}
+		clientHost, clientPort := "", pieces[1]
+		if i := strings.LastIndex(pieces[1], ":"); i != -1 {
+			clientHost, clientPort = strings.Trim(pieces[1][0:i], "[]"), pieces[1][i+1:]
+		}
+		if clientHost == "" || clientHost == "0.0.0.0" || clientHost == "::" {
+			clientHost = serverHost
+		}
+		servers = append(servers, net.JoinHostPort(clientHost, clientPort))
+	}
+	return servers, version, nil
+}
+
+// GetEnsemble reads the current client addresses of the ensemble.
+func GetEnsemble(conn *zk.Conn) (servers []string, version string, err error) {
+	data, _, err := conn.Get(ZkConfigPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("reading %v: %s", ZkConfigPath, err)
+	}
+	return ParseEnsembleConfig(data)
+}
+
+// reconfigurer is satisfied by ZooKeeper clients which support 3.5 dynamic
+// reconfiguration, i.e. go-zookeeper since 2019-09.
+type reconfigurer interface {
+	IncrementalReconfig(joining []string, leaving []string, version int64) (*zk.Stat, error)
+}
+
+// Reconfig incrementally changes the ensemble membership.  joining entries take
+// the form "server.<id>=<host>:<quorum-port>:<election-port>;<client-port>",
+// leaving entries are server ids.  version must match the current config
+// version, or be -1 to skip the check.  Running Coordinators pick up the new
+// ensemble on their own (see EnsembleDiscovery).
+//
+// Returns ReconfigUnsupportedError when the go-zookeeper client in use
+// predates reconfig support.
+func Reconfig(conn *zk.Conn, joining []string, leaving []string, version int64) error {
+	r, ok := interface{}(conn).(reconfigurer)
+	if !ok {
+		return ReconfigUnsupportedError
+	}
+	if _, err := r.IncrementalReconfig(joining, leaving, version); err != nil {
+		return fmt.Errorf("reconfiguring ensemble: %s", err)
+	}
+	return nil
+}
+
+// Reconfig incrementally changes the ensemble membership via the managed
+// connection.  See the package-level Reconfig for details.
+func (cc *Coordinator) Reconfig(joining []string, leaving []string, version int64) error {
+	zkCli := cc.Conn()
+	if zkCli == nil {
+		return fmt.Errorf("%v: not running", cc.Id())
+	}
+	return Reconfig(zkCli, joining, leaving, version)
+}
+
+// Ensemble returns the resolved addresses of the servers currently in use.
+func (cc *Coordinator) Ensemble() []string {
+	return cc.hostProvider.Servers()
+}
+
+// updateEnsemble installs a new server list, and drops the current connection
+// if its server is no longer part of the ensemble.  The client then reconnects
+// to one of the new servers, keeping its session.
+func (cc *Coordinator) updateEnsemble(servers []string) error {
+	changed, err := cc.hostProvider.Update(servers)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+	resolved := cc.hostProvider.Servers()
+	log.Infof("%v: ensemble changed, servers=%v", cc.Id(), resolved)
+
+	cc.netConnLock.Lock()
+	defer cc.netConnLock.Unlock()
+	if cc.netConn == nil {
+		return nil
+	}
+	current := cc.netConn.RemoteAddr().String()
+	for _, server := range resolved {
+		if server == current {
+			return nil
+		}
+	}
+	log.Infof("%v: connected server=%v was removed from the ensemble, reconnecting", cc.Id(), current)
+	cc.netConn.Close()
+	cc.netConn = nil
+	return nil
+}
+
+// watchEnsembleConfig follows ZkConfigPath and applies ensemble changes until
+// abortChan is closed.
+func (cc *Coordinator) watchEnsembleConfig(zkCli *zk.Conn, abortChan chan struct{}) {
+	for {
+		data, _, evCh, err := zkCli.GetW(ZkConfigPath)
+		evCh = cc.resources.Watch(ZkConfigPath, evCh)
+		if err == zk.ErrNoNode {
+			log.Infof("%v: ensemble does not publish %v (pre-3.5?), discovery disabled", cc.Id(), ZkConfigPath)
+			return
+		} else if err != nil {
+			log.Debugf("%v: watching ensemble config: %s", cc.Id(), err)
+			select {
+			case <-abortChan:
+				return
+			case <-cc.clock().After(ensembleRetryInterval):
+				continue
+			}
+		}
+
+		if servers, version, err := ParseEnsembleConfig(data); err != nil {
+			log.Warnf("%v: ignoring unparseable ensemble config: %s", cc.Id(), err)
+		} else if len(servers) > 0 {
+			log.Debugf("%v: ensemble config version=%v servers=%v", cc.Id(), version, servers)
+			if err := cc.updateEnsemble(servers); err != nil {
+				log.Warnf("%v: applying ensemble config version=%v: %s", cc.Id(), version, err)
+			}
+		}
+
+		select {
+		case <-evCh:
+			cc.resources.Unwatch(evCh)
+		case <-abortChan:
+			return
+		}
+	}
+}
+
+// resolveLoop periodically re-resolves the configured server names until
+// abortChan is closed, so ensembles specified by DNS name (e.g.
+// zk.service.consul:2181) follow address changes.
+func (cc *Coordinator) resolveLoop(abortChan chan struct{}) {
+	ticker := cc.clock().NewTicker(cc.ResolveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := cc.updateEnsemble(cc.hostProvider.Configured()); err != nil {
+				log.Warnf("%v: re-resolving servers: %s", cc.Id(), err)
+			}
+		case <-abortChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cluster_test
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestParseEnsembleConfig(t *testing.T) {
+	data := []byte(`server.1=10.0.0.1:2888:3888:participant;0.0.0.0:2181
+server.2=10.0.0.2:2888:3888:participant;2181
+server.3=10.0.0.3:2888:3888:observer;10.1.0.3:2182
+server.4=[fd00::4]:2888:3888:participant;2181
+server.5=10.0.0.5:2888:3888:participant
+version=100000003
+`)
+	servers, version, err := cluster.ParseEnsembleConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "100000003"; version != expected {
+		t.Errorf("Expected version=%v but actual=%v", expected, version)
+	}
+	expected := []string{"10.0.0.1:2181", "10.0.0.2:2181", "10.1.0.3:2182", "[fd00::4]:2181"}
+	if !reflect.DeepEqual(servers, expected) {
+		t.Errorf("Expected servers=%v but actual=%v", expected, servers)
+	}
+}
+
+func TestParseEnsembleConfigMalformed(t *testing.T) {
+	for _, data := range []string{"server.1", "server.1=[fd00::1:2888:3888:participant;2181"} {
+		if _, _, err := cluster.ParseEnsembleConfig([]byte(data)); err == nil {
+			t.Errorf("Expected error parsing malformed config=%q", data)
+		}
+	}
+}
+
+func TestDynamicHostProvider(t *testing.T) {
+	hp := &cluster.DynamicHostProvider{}
+	if err := hp.Init([]string{"10.0.0.1:2181", "10.0.0.2:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	if hp.Len() != 2 {
+		t.Fatalf("Expected 2 servers but actual=%v", hp.Len())
+	}
+
+	changed, err := hp.Update([]string{"10.0.0.2:2181", "10.0.0.1:2181"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed {
+		t.Errorf("Expected reordering to not count as a change")
+	}
+
+	if changed, err = hp.Update([]string{"10.0.0.2:2181", "10.0.0.3:2181"}); err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Errorf("Expected server set change to be reported")
+	}
+	seen := []string{}
+	for i := 0; i < hp.Len(); i++ {
+		server, _ := hp.Next()
+		seen = append(seen, server)
+	}
+	sort.Strings(seen)
+	if expected := []string{"10.0.0.2:2181", "10.0.0.3:2181"}; !reflect.DeepEqual(seen, expected) {
+		t.Errorf("Expected Next() to yield servers=%v but actual=%v", expected, seen)
+	}
+
+	if _, err := hp.Update([]string{"no-port"}); err == nil {
+		t.Errorf("Expected error for invalid server address")
+	}
+}
+
+// TestEnsembleDiscoveryLegacyEnsemble verifies discovery is harmless against
+// ensembles which predate dynamic reconfiguration.
+func TestEnsembleDiscoveryLegacyEnsemble(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.EnsembleDiscovery = true
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if mode := cc.Mode(); mode != primitives.Leader {
+			t.Errorf("Expected mode=%v but actual=%v", primitives.Leader, mode)
+		}
+		if servers := cc.Ensemble(); len(servers) != len(zkServers) {
+			t.Errorf("Expected ensemble to consist of the configured servers=%v, but actual=%v", zkServers, servers)
+		}
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestResolveInterval(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		_, port, err := net.SplitHostPort(zkServers[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc, err := cluster.NewCoordinator([]string{net.JoinHostPort("localhost", port)}, zkTimeout, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.ResolveInterval = 100 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		time.Sleep(3 * cc.ResolveInterval)
+		if mode := cc.Mode(); mode != primitives.Leader {
+			t.Errorf("Expected mode=%v but actual=%v", primitives.Leader, mode)
+		}
+		for _, server := range cc.Ensemble() {
+			if host, _, _ := net.SplitHostPort(server); net.ParseIP(host) == nil {
+				t.Errorf("Expected ensemble to consist of resolved addresses, but found server=%v", server)
+			}
+		}
+	})
+}
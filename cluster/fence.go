@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// LeaderFence returns a check of the local candidate zNode for inclusion as the
+// first operation of a Multi making writes only the leader may make.  Once the
+// local member has left the election, e.g. because its session expired while
+// it was partitioned, its zNode is gone and the whole Multi fails, so a
+// deposed leader unaware of its demotion cannot clobber its successor's
+// writes.  See Fenced to tell such failures apart.  Returns NotLeaderError
+// when not leading.
+//
+// NB: The fence only follows the candidate zNode, so it does not catch
+// leadership moving away from a member which remains in the election, e.g.
+// with StickyWindow or LeaderLabels.
+func (cc *Coordinator) LeaderFence() (*zk.CheckVersionRequest, error) {
+	if cc.Mode() != primitives.Leader {
+		return nil, NotLeaderError
+	}
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return nil, NotLeaderError
+	}
+	return &zk.CheckVersionRequest{Path: zNode, Version: -1}, nil
+}
+
+// Fenced reports whether a Multi led by a LeaderFence failed because of it.
+func Fenced(responses []zk.MultiResponse, err error) bool {
+	return err != nil && len(responses) > 0 && responses[0].Error != nil
+}
@@ -0,0 +1,59 @@
+package cluster_test
+
+import (
+	"testing"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestLeaderFence(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			leader   = ncc(t, zkServers, "leader")
+			follower = ncc(t, zkServers, "follower")
+		)
+		defer leader.Stop()
+		defer follower.Stop()
+		waitForAgreement(t, []*cluster.Coordinator{leader, follower})
+
+		if _, err := follower.LeaderFence(); err != cluster.NotLeaderError {
+			t.Fatalf("Expected err=%s from a follower but actual=%v", cluster.NotLeaderError, err)
+		}
+		fence, err := leader.LeaderFence()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			zNode := testutil.Namespace(t) + "/fenced"
+			responses, err := conn.Multi(fence, &zk.CreateRequest{Path: zNode, Data: []byte("a"), Acl: zk.WorldACL(zk.PermAll)})
+			if cluster.Fenced(responses, err) || err != nil {
+				t.Fatalf("Expected the leader's write to pass the fence but actual err=%v", err)
+			}
+
+			// Depose the leader as its session expiring would.
+			if err := conn.Delete(leader.Status().ZNode, -1); err != nil {
+				return err
+			}
+			responses, err = conn.Multi(fence, &zk.SetDataRequest{Path: zNode, Data: []byte("b"), Version: -1})
+			if !cluster.Fenced(responses, err) {
+				t.Errorf("Expected the deposed leader's write to be fenced but actual err=%v", err)
+			}
+			data, _, err := conn.Get(zNode)
+			if err != nil {
+				return err
+			}
+			if expected, actual := "a", string(data); actual != expected {
+				t.Errorf("Expected fenced write to leave data=%q but actual=%q", expected, actual)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
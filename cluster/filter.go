@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// UpdateFilter selects the kinds of updates a subscriber is woken by, see
+// Subscribe.  Filters combine with |, and an update is delivered when it is of
+// any of the selected kinds.
+type UpdateFilter int
+
+const (
+	// FilterLeaderChanges selects updates where the leader or the local mode
+	// changed, as well as degraded, split-brain and handoff updates.
+	FilterLeaderChanges UpdateFilter = 1 << iota
+
+	// FilterMembershipChanges selects updates where members joined or
+	// departed, i.e. those carrying Deltas.
+	FilterMembershipChanges
+
+	// FilterSessionEvents selects updates resulting from the ZooKeeper session
+	// being (re-)established, including RecoveredUpdate.
+	FilterSessionEvents
+
+	FilterAll = FilterLeaderChanges | FilterMembershipChanges | FilterSessionEvents
+)
+
+// updateKinds classifies update given the last leader or degraded update
+// delivered, and whether it results from a session being established.
+func updateKinds(update primitives.Update, previous primitives.Update, session bool) UpdateFilter {
+	var kinds UpdateFilter
+	switch update.Type {
+	case primitives.DegradedUpdate, primitives.SplitBrainUpdate, primitives.HandoffUpdate:
+		kinds |= FilterLeaderChanges
+	case primitives.RecoveredUpdate:
+		kinds |= FilterSessionEvents
+	}
+	if update.Type == primitives.LeaderUpdate && (update.Leader.Uuid != previous.Leader.Uuid || update.Mode != previous.Mode || previous.Type != primitives.LeaderUpdate) {
+		kinds |= FilterLeaderChanges
+	}
+	if len(update.Deltas) > 0 {
+		kinds |= FilterMembershipChanges
+	}
+	if session {
+		kinds |= FilterSessionEvents
+	}
+	return kinds
+}
+
+// wants reports whether subChan should be woken by an update of the given
+// kinds.
+//
+// Must only be invoked while holding cc.subscribersLock.
+func (cc *Coordinator) wants(subChan chan primitives.Update, kinds UpdateFilter) bool {
+	filter, ok := cc.subscriberFilters[subChan]
+	return !ok || filter&kinds != 0
+}
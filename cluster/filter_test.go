@@ -0,0 +1,127 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestSubscribeFiltered(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		leader, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, "leader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := leader.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := leader.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, []*cluster.Coordinator{leader})
+
+		var (
+			leaderChanges     = make(chan primitives.Update, 10)
+			membershipChanges = make(chan primitives.Update, 10)
+		)
+		leader.Subscribe(leaderChanges, cluster.FilterLeaderChanges)
+		leader.Subscribe(membershipChanges, cluster.FilterMembershipChanges)
+
+		member, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, "member")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := member.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := member.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		select {
+		case update := <-membershipChanges:
+			if len(update.Deltas) != 1 || !update.Deltas[0].Joined || update.Deltas[0].Node.Uuid != member.LocalNode.Uuid {
+				t.Errorf("Expected a single delta for the member joining, but deltas=%+v", update.Deltas)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a membership change")
+		}
+
+		// The leader is unchanged, so leadership subscribers aren't woken.
+		select {
+		case update := <-leaderChanges:
+			t.Errorf("Expected no leadership updates, but received update=%+v", update)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+}
+
+// TestSubscribeWhileStopped verifies that subscriptions may be changed before
+// Start and after Stop without blocking, and are honored by later
+// incarnations.
+func TestSubscribeWhileStopped(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			kept    = make(chan primitives.Update, 10)
+			dropped = make(chan primitives.Update, 10)
+		)
+		cc.Subscribe(kept)
+		cc.Subscribe(dropped)
+
+		if err := cc.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitForAgreement(t, []*cluster.Coordinator{cc})
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+
+		unsubscribed := make(chan struct{})
+		go func() {
+			cc.Unsubscribe(dropped)
+			close(unsubscribed)
+		}()
+		select {
+		case <-unsubscribed:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for Unsubscribe to return after Stop")
+		}
+		for len(kept) > 0 {
+			<-kept
+		}
+		for len(dropped) > 0 {
+			<-dropped
+		}
+
+		if err := cc.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		select {
+		case <-kept:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for an update after restarting")
+		}
+		select {
+		case update := <-dropped:
+			t.Errorf("Expected no updates after unsubscribing, but received update=%+v", update)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	GroupUpdatesChanSize = 100
+
+	groupCoordinatorDir = "coordinator"
+	groupElectionsDir   = "elections"
+)
+
+// ElectionGroup manages a hierarchy of elections: a parent coordinator election
+// at <basePath>/coordinator plus dynamically created child elections at
+// <basePath>/elections/<name> (e.g. one per topic or shard).
+//
+// Leadership updates for the parent and all locally joined children are
+// delivered on the single Updates() stream; Update.ElectionPath identifies
+// which election an update pertains to (see ChildName).
+//
+// Each child election runs its own Coordinator, and therefore its own session,
+// because a candidate zNode is an ephemeral owned by that session.  Group-level
+// operations (ListChildren, child election teardown) reuse the parent
+// Coordinator's connection.
+type ElectionGroup struct {
+	Parent         *Coordinator
+	zkServers      []string
+	sessionTimeout time.Duration
+	basePath       string
+	data           string
+	children       map[string]*groupChild
+	updates        chan primitives.Update
+	parentSub      chan primitives.Update
+	parentQuit     chan struct{}
+	lock           sync.Mutex
+}
+
+type groupChild struct {
+	coordinator *Coordinator
+	quitChan    chan struct{}
+}
+
+func NewElectionGroup(zkServers []string, sessionTimeout time.Duration, basePath string, data string) (*ElectionGroup, error) {
+	group := &ElectionGroup{
+		zkServers:      zkServers,
+		sessionTimeout: sessionTimeout,
+		basePath:       util.NormalizePath(basePath),
+		data:           data,
+		children:       map[string]*groupChild{},
+		updates:        make(chan primitives.Update, GroupUpdatesChanSize),
+		parentSub:      make(chan primitives.Update, GroupUpdatesChanSize),
+	}
+	parent, err := NewCoordinator(zkServers, sessionTimeout, group.basePath+"/"+groupCoordinatorDir, data, group.parentSub)
+	if err != nil {
+		return nil, fmt.Errorf("NewElectionGroup: %s", err)
+	}
+	group.Parent = parent
+	return group, nil
+}
+
+// Updates returns the stream of leadership updates for the parent and all
+// locally joined child elections.
+func (group *ElectionGroup) Updates() <-chan primitives.Update {
+	return group.updates
+}
+
+// Start joins the parent election.
+func (group *ElectionGroup) Start() error {
+	group.lock.Lock()
+	if group.parentQuit == nil {
+		group.parentQuit = make(chan struct{})
+		group.forward(group.parentSub, group.parentQuit)
+	}
+	group.lock.Unlock()
+
+	if err := group.Parent.Start(); err != nil {
+		group.stopParentForwarder()
+		return err
+	}
+	return nil
+}
+
+// Stop leaves all locally joined child elections and the parent election.
+func (group *ElectionGroup) Stop() error {
+	group.lock.Lock()
+	names := make([]string, 0, len(group.children))
+	for name := range group.children {
+		names = append(names, name)
+	}
+	group.lock.Unlock()
+
+	for _, name := range names {
+		if err := group.RemoveChild(name); err != nil {
+			log.Warnf("ElectionGroup basePath=%v: leaving child election=%v: %s", group.basePath, name, err)
+		}
+	}
+	err := group.Parent.Stop()
+	group.stopParentForwarder()
+	return err
+}
+
+func (group *ElectionGroup) stopParentForwarder() {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if group.parentQuit != nil {
+		close(group.parentQuit)
+		group.parentQuit = nil
+	}
+}
+
+// CreateChild joins (creating if necessary) the child election with the given
+// name.
+func (group *ElectionGroup) CreateChild(name string) (*Coordinator, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("ElectionGroup: invalid child election name=%q, must be non-empty and must not contain '/'", name)
+	}
+
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if _, ok := group.children[name]; ok {
+		return nil, fmt.Errorf("ElectionGroup: already participating in child election=%v", name)
+	}
+
+	quitChan := make(chan struct{})
+	subChan := make(chan primitives.Update, GroupUpdatesChanSize)
+	cc, err := NewCoordinator(group.zkServers, group.sessionTimeout, group.ChildPath(name), group.data, subChan)
+	if err != nil {
+		close(quitChan)
+		return nil, fmt.Errorf("ElectionGroup: creating child election=%v: %s", name, err)
+	}
+	group.forward(subChan, quitChan)
+	if err := cc.Start(); err != nil {
+		close(quitChan)
+		return nil, fmt.Errorf("ElectionGroup: starting child election=%v: %s", name, err)
+	}
+	group.children[name] = &groupChild{
+		coordinator: cc,
+		quitChan:    quitChan,
+	}
+	return cc, nil
+}
+
+// Child returns the Coordinator for a locally joined child election, or nil.
+func (group *ElectionGroup) Child(name string) *Coordinator {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	if child, ok := group.children[name]; ok {
+		return child.coordinator
+	}
+	return nil
+}
+
+// JoinedChildren returns the sorted names of the locally joined child
+// elections.
+func (group *ElectionGroup) JoinedChildren() []string {
+	group.lock.Lock()
+	defer group.lock.Unlock()
+
+	names := make([]string, 0, len(group.children))
+	for name := range group.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListChildren returns the sorted names of all child elections which exist in
+// ZooKeeper, including ones this process has not joined.
+//
+// Requires the group to be started, since it uses the parent Coordinator's
+// connection.
+func (group *ElectionGroup) ListChildren() ([]string, error) {
+	names := []string{}
+	err := group.Parent.request(func(conn *zk.Conn) error {
+		children, _, err := conn.Children(group.basePath + "/" + groupElectionsDir)
+		if err == zk.ErrNoNode {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		names = children
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ElectionGroup: listing child elections: %s", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// RemoveChild leaves the child election with the given name, and tears down
+// the election zNode if no other participants remain.
+func (group *ElectionGroup) RemoveChild(name string) error {
+	group.lock.Lock()
+	child, ok := group.children[name]
+	delete(group.children, name)
+	group.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("ElectionGroup: not participating in child election=%v", name)
+	}
+
+	close(child.quitChan)
+	if err := child.coordinator.Stop(); err != nil {
+		return fmt.Errorf("ElectionGroup: stopping child election=%v: %s", name, err)
+	}
+
+	electionPath := group.ChildPath(name)
+	err := group.Parent.request(func(conn *zk.Conn) error {
+		if err := conn.Delete(electionPath, -1); err != nil && err != zk.ErrNoNode && err != zk.ErrNotEmpty {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ElectionGroup: tearing down child election path=%v: %s", electionPath, err)
+	}
+	return nil
+}
+
+// ChildPath returns the election path for the named child election.
+func (group *ElectionGroup) ChildPath(name string) string {
+	return group.basePath + "/" + groupElectionsDir + "/" + name
+}
+
+// ChildName returns the child election name an update pertains to, or an
+// empty string for parent election updates.
+func (group *ElectionGroup) ChildName(update primitives.Update) string {
+	if path.Dir(update.ElectionPath) != group.basePath+"/"+groupElectionsDir {
+		return ""
+	}
+	return path.Base(update.ElectionPath)
+}
+
+// forward relays updates from subChan to the group's update stream until
+// quitChan is closed.
+func (group *ElectionGroup) forward(subChan chan primitives.Update, quitChan chan struct{}) {
+	go func() {
+		for {
+			select {
+			case update := <-subChan:
+				select {
+				case group.updates <- update:
+				default:
+					log.Warnf("ElectionGroup basePath=%v: updates chan full, dropped update for election=%v", group.basePath, update.ElectionPath)
+				}
+			case <-quitChan:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package cluster_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+)
+
+func TestElectionGroup(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := testutil.Namespace(t)
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, basePath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		group, err := cluster.NewElectionGroup(zkServers, zkTimeout, basePath, "group-member")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := group.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer group.Stop()
+
+		names := []string{"shard-a", "shard-b"}
+		for _, name := range names {
+			if _, err := group.CreateChild(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := group.CreateChild(names[0]); err == nil {
+			t.Fatalf("Expected error when joining an already joined child election")
+		}
+
+		// Every election should report this process as leader on the shared stream.
+		seen := map[string]bool{}
+		deadline := time.After(5 * time.Second)
+		for len(seen) < len(names)+1 {
+			select {
+			case update := <-group.Updates():
+				if update.Mode != primitives.Leader {
+					continue
+				}
+				seen[group.ChildName(update)] = true
+			case <-deadline:
+				t.Fatalf("Timed out waiting for leadership updates, seen=%v", seen)
+			}
+		}
+
+		listed, err := group.ListChildren()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(listed, names) {
+			t.Fatalf("Expected listed child elections=%v but actual=%v", names, listed)
+		}
+
+		if err := group.RemoveChild(names[0]); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := names[1:], group.JoinedChildren(); !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("Expected joined child elections=%v but actual=%v", expected, actual)
+		}
+		if listed, err = group.ListChildren(); err != nil {
+			t.Fatal(err)
+		}
+		if expected := names[1:]; !reflect.DeepEqual(listed, expected) {
+			t.Fatalf("Expected torn down child election to disappear, listed=%v", listed)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// handoffZNodeName is created alongside the candidates by a leader which is
+// stepping down.  It is not sequential, so is never mistaken for a candidate.
+const handoffZNodeName = "handoff"
+
+// handoff is the content of the handoff zNode.
+type handoff struct {
+	Leader    string `json:"leader"`    // Candidate zNode name of the departing leader.
+	Successor string `json:"successor"` // Candidate zNode name of the successor.
+}
+
+// handOff announces the local leader's imminent departure so the successor
+// can begin warming up, then waits for HandoffDelay or until cancel is
+// closed.  Does nothing unless HandoffDelay is set and the local member is the
+// leader.  zkCli is the connection of the incarnation of the given generation,
+// so a stop racing a restart never announces on behalf of the new one.  Only
+// ever hands off once per incarnation: concurrent or repeated stops of the
+// same generation instead wait for the handoff underway, so none of them cuts
+// its delay short by tearing down early.
+func (cc *Coordinator) handOff(zkCli *zk.Conn, generation uint64, cancel <-chan struct{}) {
+	if cc.HandoffDelay <= 0 || zkCli == nil {
+		return
+	}
+	cc.leaderLock.Lock()
+	if cc.handoffGeneration == generation {
+		doneChan := cc.handoffDoneChan
+		cc.leaderLock.Unlock()
+		select {
+		case <-doneChan:
+		case <-cancel:
+		}
+		return
+	}
+	if cc.mode() != primitives.Leader {
+		cc.leaderLock.Unlock()
+		return
+	}
+	doneChan := make(chan struct{})
+	cc.handoffGeneration, cc.handoffDoneChan = generation, doneChan
+	zNode := cc.zNode
+	cc.leaderLock.Unlock()
+	defer close(doneChan)
+
+	successorZNode, successor, err := cc.successor(zkCli, path.Base(zNode))
+	if err != nil {
+		log.Warnf("%v: handoff: determining successor: %s", cc.Id(), err)
+		return
+	}
+
+	if cc.PreHandoff != nil {
+		cc.PreHandoff(successor)
+	}
+	if successor == nil {
+		log.Debugf("%v: handoff: no successor, skipping announcement", cc.Id())
+		return
+	}
+
+	data, err := json.Marshal(handoff{Leader: path.Base(zNode), Successor: successorZNode})
+	if err != nil {
+		log.Warnf("%v: handoff: %s", cc.Id(), err)
+		return
+	}
+	// NB: Ephemeral so it disappears together with the leader's candidate.
+	if _, err := zkCli.Create(cc.candidatesPath()+"/"+handoffZNodeName, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+		log.Warnf("%v: handoff: announcing successor=%v: %s", cc.Id(), successorZNode, err)
+		return
+	}
+	cc.resources.Own(cc.candidatesPath() + "/" + handoffZNodeName)
+	log.Infof("%v: handing off leadership to successor=%v, withdrawing in %s", cc.Id(), successorZNode, cc.HandoffDelay)
+	cc.record(HistoryHandoff, successorZNode, "")
+	select {
+	case <-cc.clock().After(cc.HandoffDelay):
+	case <-cancel:
+	}
+}
+
+// successor returns the candidate which would lead once the one named
+// localZNode departs, or a nil node if there is none.
+func (cc *Coordinator) successor(zkCli *zk.Conn, localZNode string) (string, *primitives.Node, error) {
+	children, _, err := zkCli.Children(cc.candidatesPath())
+	if err != nil {
+		return "", nil, err
+	}
+	var (
+		remaining = []string{}
+		members   = map[string]primitives.Node{}
+		raw       = map[string][]byte{}
+	)
+	for _, child := range cc.PathLayout.SortedCandidates(children) {
+		if child == localZNode {
+			continue
+		}
+		data, _, err := zkCli.Get(cc.candidatesPath() + "/" + child)
+		if err == zk.ErrNoNode {
+			continue // Departed meanwhile.
+		} else if err != nil {
+			return "", nil, err
+		}
+		node, err := cc.codec().Decode(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("decoding candidate=%v: %s", child, err)
+		}
+		remaining = append(remaining, child)
+		members[child] = node
+		raw[child] = data
+	}
+	successorZNode, ok := cc.elect(remaining, members)
+	if !ok {
+		return "", nil, nil
+	}
+	node, err := cc.decodeNode(zkCli, raw[successorZNode])
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding successor=%v: %s", successorZNode, err)
+	}
+	return successorZNode, &node, nil
+}
+
+// readHandoff returns the pending handoff announced by the leader whose
+// candidate zNode is leaderZNode, if any.
+func (cc *Coordinator) readHandoff(zkCli *zk.Conn, leaderZNode string) (successorZNode string, successor *primitives.Node, err error) {
+	data, _, err := zkCli.Get(cc.candidatesPath() + "/" + handoffZNodeName)
+	if err == zk.ErrNoNode {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	var h handoff
+	if err := json.Unmarshal(data, &h); err != nil {
+		return "", nil, fmt.Errorf("decoding handoff: %s", err)
+	}
+	if h.Leader != path.Base(leaderZNode) {
+		return "", nil, nil // Stale.
+	}
+	data, _, err = zkCli.Get(cc.candidatesPath() + "/" + h.Successor)
+	if err == zk.ErrNoNode {
+		return "", nil, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+	node, err := cc.decodeNode(zkCli, data)
+	if err != nil {
+		return "", nil, fmt.Errorf("decoding successor=%v: %s", h.Successor, err)
+	}
+	return h.Successor, &node, nil
+}
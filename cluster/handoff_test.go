@@ -0,0 +1,75 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestLeaderHandoff(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		start := func(data string, subscribers ...chan primitives.Update) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), data, subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.HandoffDelay = 500 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+
+		var (
+			leader      = start("leader")
+			updates     = make(chan primitives.Update, 100)
+			follower    = start("follower", updates)
+			preHandoffs = make(chan *primitives.Node, 1)
+		)
+		defer follower.Stop()
+		leader.PreHandoff = func(successor *primitives.Node) {
+			preHandoffs <- successor
+		}
+
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- leader.Stop()
+		}()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-updates:
+				if update.Type != primitives.HandoffUpdate {
+					continue
+				}
+				if update.Leader.Uuid != follower.LocalNode.Uuid {
+					t.Errorf("Expected handoff to successor=%v but actual=%v", follower.LocalNode, update.Leader)
+				}
+				if follower.Mode() != primitives.Follower {
+					t.Errorf("Expected successor to still be a follower while warming up")
+				}
+				select {
+				case <-stopped:
+					t.Errorf("Expected the leader to still be stopping when the handoff is announced")
+				default:
+				}
+				if successor := <-preHandoffs; successor == nil || successor.Uuid != follower.LocalNode.Uuid {
+					t.Errorf("Expected PreHandoff to receive successor=%v but actual=%v", follower.LocalNode, successor)
+				}
+				if err := <-stopped; err != nil {
+					t.Fatal(err)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("Timed out waiting for handoff update")
+			}
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	DefaultHeartbeatInterval = 1 * time.Second
+	DefaultHeartbeatTTL      = 10 * time.Second
+)
+
+func (cc *Coordinator) heartbeatInterval() time.Duration {
+	if cc.HeartbeatInterval > 0 {
+		return cc.HeartbeatInterval
+	}
+	return DefaultHeartbeatInterval
+}
+
+func (cc *Coordinator) heartbeatTTL() time.Duration {
+	if cc.HeartbeatTTL > 0 {
+		return cc.HeartbeatTTL
+	}
+	return DefaultHeartbeatTTL
+}
+
+// createCandidate creates the local candidate zNode, a persistent record when
+// PersistentMembership is set and a protected ephemeral otherwise.
+func (cc *Coordinator) createCandidate(zkCli *zk.Conn, zNode string, data []byte) (string, error) {
+	if cc.PersistentMembership {
+		return zkCli.Create(zNode, data, zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	}
+	return zkCli.CreateProtectedEphemeralSequential(zNode, data, zk.WorldACL(zk.PermAll))
+}
+
+// existingRecord returns the local persistent record when it survived the
+// loss of the previous session, so it is kept rather than replaced.
+func (cc *Coordinator) existingRecord(zkCli *zk.Conn) string {
+	if !cc.PersistentMembership {
+		return ""
+	}
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return ""
+	}
+	if exists, _, err := zkCli.Exists(zNode); err != nil || !exists {
+		return ""
+	}
+	return zNode
+}
+
+// ownsCandidate reports whether stat, of the local candidate zNode, shows it
+// still belongs to the local member.  Persistent records aren't tied to a
+// session, so their existence suffices.
+func (cc *Coordinator) ownsCandidate(zkCli *zk.Conn, stat *zk.Stat) bool {
+	if cc.PersistentMembership {
+		return stat.EphemeralOwner == 0
+	}
+	return stat.EphemeralOwner == zkCli.SessionID()
+}
+
+// heartbeat touches the local persistent record, bumping its modification
+// time, and returns its stat.  Returns zk.ErrNoNode when the record is gone,
+// e.g. because the member was evicted.
+func (cc *Coordinator) heartbeat(zkCli *zk.Conn) (*zk.Stat, error) {
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	localNodeData := cc.localNodeData
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return nil, zk.ErrNoNode
+	}
+	stat, err := zkCli.Set(zNode, localNodeData, -1)
+	if err != nil {
+		return nil, err
+	}
+	cc.leaderLock.Lock()
+	stale := string(localNodeData) != string(cc.localNodeData)
+	localNodeData = cc.localNodeData
+	cc.leaderLock.Unlock()
+	if stale {
+		// SetData was invoked meanwhile, don't leave its data overwritten.
+		return zkCli.Set(zNode, localNodeData, -1)
+	}
+	return stat, nil
+}
+
+// sweeps reports whether the local member sweeps stale members, given the
+// names of the candidates: the leader does, and so does the member next in
+// line to it, so the record of a leader which crashed gets evicted, too.
+func (cc *Coordinator) sweeps(candidates []string) bool {
+	cc.leaderLock.Lock()
+	isLeader := cc.mode() == primitives.Leader
+	local := path.Base(cc.zNode)
+	leader := path.Base(cc.leaderZNode)
+	cc.leaderLock.Unlock()
+	if isLeader {
+		return true
+	}
+	for _, candidate := range cc.PathLayout.SortedCandidates(candidates) {
+		if candidate != leader {
+			return candidate == local
+		}
+	}
+	return false
+}
+
+// memberNames returns the candidate names of members, as of checkLeader.
+func memberNames(members map[string]primitives.Node) []string {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	return names
+}
+
+// sweepStaleMembers evicts the members, the leader included, whose persistent
+// records were last touched more than HeartbeatTTL before now, a modification
+// time as assigned by the ensemble (in milliseconds), e.g. that of the local
+// member's own freshly touched record.  Comparing times assigned by the
+// ensemble keeps clock skew between members out of the picture.  Each eviction
+// leaves a tombstone, so every member reports the departure as such.  Nothing
+// is evicted unless the local member sweeps, see sweeps.
+//
+// The records are read with the requests pipelined.  Invoked off the election
+// loop, as it costs a few round trips.
+func (cc *Coordinator) sweepStaleMembers(zkCli *zk.Conn, now int64) {
+	candidatesPath := cc.candidatesPath()
+	children, _, err := zkCli.Children(candidatesPath)
+	if err != nil {
+		log.Warnf("%v: sweeping stale members: listing candidates: %s", cc.Id(), err)
+		return
+	}
+	if !cc.sweeps(children) {
+		return
+	}
+	cc.leaderLock.Lock()
+	local := path.Base(cc.zNode)
+	cc.leaderLock.Unlock()
+	ttl := int64(cc.heartbeatTTL() / time.Millisecond)
+
+	var others, zNodes []string
+	for _, child := range children {
+		if child != local && cc.PathLayout.IsCandidate(child) {
+			others = append(others, child)
+			zNodes = append(zNodes, candidatesPath+"/"+child)
+		}
+	}
+	for i, result := range cc.getPipelined(zkCli, zNodes, time.Now()) {
+		child, zNode, data, stat, err := others[i], zNodes[i], result.data, result.stat, result.err
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			log.Warnf("%v: sweeping stale members: reading member=%v: %s", cc.Id(), child, err)
+			continue
+		}
+		if stat.EphemeralOwner != 0 || now-stat.Mtime <= ttl {
+			continue // NB: Ephemeral candidates are looked after by their sessions.
+		}
+		staleness := time.Duration(now-stat.Mtime) * time.Millisecond
+		node, _ := cc.decodeNode(zkCli, data)
+		log.Infof("%v: evicting member=%v, last heartbeat was %s ago", cc.Id(), child, staleness)
+
+		t := tombstone{Reason: primitives.DepartureEvicted, At: cc.clock().Now(), Node: node}
+		if err := writeTombstone(zkCli, candidatesPath, child, t, cc.sealer()); err != nil {
+			log.Warnf("%v: evicting member=%v: %s", cc.Id(), child, err)
+			continue
+		}
+		// NB: Conditional, so a heartbeat arriving meanwhile wins.
+		if err := zkCli.Delete(zNode, stat.Version); err != nil {
+			if err != zk.ErrNoNode {
+				log.Warnf("%v: evicting member=%v: deleting record: %s", cc.Id(), child, err)
+			}
+			if err == zk.ErrBadVersion {
+				zkCli.Delete(candidatesPath+"/"+tombstonesDirName+"/"+child, -1)
+			}
+			continue
+		}
+		cc.countStat(func(stats *Stats) { stats.MembersEvicted++ })
+		cc.record(HistoryEvicted, child, fmt.Sprintf("staleness=%s", staleness))
+	}
+}
+
+// withdrawRecord deletes the local persistent record, as it would otherwise
+// outlive the session until evicted.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) withdrawRecord() {
+	if !cc.PersistentMembership {
+		return
+	}
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return
+	}
+	if err := cc.zkCli.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+		log.Warnf("%v: withdrawing record zNode=%v: %s", cc.Id(), zNode, err)
+	}
+}
+
+// evictions returns the deltas of members which were evicted.
+func evictions(deltas []primitives.MemberDelta) []primitives.MemberDelta {
+	var evicted []primitives.MemberDelta
+	for _, delta := range deltas {
+		if !delta.Joined && delta.Reason == primitives.DepartureEvicted {
+			evicted = append(evicted, delta)
+		}
+	}
+	return evicted
+}
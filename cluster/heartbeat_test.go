@@ -0,0 +1,133 @@
+package cluster_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/testutil/scenario"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/satori/go.uuid"
+)
+
+func TestPersistentMembershipEviction(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		subChan := make(chan primitives.Update, 100)
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, "persistent", subChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.PersistentMembership = true
+		cc.HeartbeatInterval = 100 * time.Millisecond
+		cc.HeartbeatTTL = 500 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// A record left behind by a member which crashed, it never heartbeats.
+		ghost := primitives.Node{Uuid: uuid.Must(uuid.NewV4()), Hostname: "ghost"}
+		data, err := json.Marshal(ghost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ghostZNode string
+		err = zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) (err error) {
+			ghostZNode, err = conn.Create(electionPath+"/ghost-"+cluster.DefaultCandidatePrefix, data, zk.FlagSequence, zk.WorldACL(zk.PermAll))
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		timeout := time.After(5 * time.Second)
+	Wait:
+		for {
+			select {
+			case update := <-subChan:
+				if update.Type != primitives.EvictedUpdate {
+					continue
+				}
+				if len(update.Deltas) != 1 || update.Deltas[0].ZNode != path.Base(ghostZNode) || update.Deltas[0].Node.Uuid != ghost.Uuid {
+					t.Fatalf("Expected the ghost to be evicted but actual deltas=%+v", update.Deltas)
+				}
+				if update.Leader.Uuid != cc.LocalNode.Uuid {
+					t.Errorf("Expected leader=%v but actual=%v", cc.LocalNode.Uuid, update.Leader.Uuid)
+				}
+				break Wait
+			case <-timeout:
+				t.Fatalf("Timed out waiting for the stale member to be evicted")
+			}
+		}
+		if evicted := cc.Stats().MembersEvicted; evicted != 1 {
+			t.Errorf("Expected MembersEvicted=1 but actual=%v", evicted)
+		}
+
+		// The live member's heartbeats keep it around well past the TTL.
+		time.Sleep(2 * cc.HeartbeatTTL)
+		nodes, err := cc.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 || nodes[0].Uuid != cc.LocalNode.Uuid {
+			t.Fatalf("Expected only the local member to remain but actual=%+v", nodes)
+		}
+
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		err = zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			sessions, err := cluster.ListMemberSessions(conn, electionPath, nil)
+			if err != nil {
+				return err
+			}
+			if len(sessions) != 0 {
+				t.Errorf("Expected Stop to withdraw the record but members=%+v", sessions)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPersistentMembershipEvictsKilledLeader(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		s := scenario.New(t, zkServers, testutil.Namespace(t))
+		s.Configure = func(_ int, cc *cluster.Coordinator) {
+			cc.PersistentMembership = true
+			cc.HeartbeatInterval = 100 * time.Millisecond
+			cc.HeartbeatTTL = 1 * time.Second
+		}
+		// The killed leader's record outlives its session, so only eviction by
+		// the member next in line lets leadership move on.
+		s.Start(3).
+			ExpectLeader(0).
+			KillLeader().
+			ExpectNewLeader(10*time.Second).
+			ExpectMembers(2, 10*time.Second).
+			Do("check evictions", func(s *scenario.Scenario) error {
+				var evicted int64
+				for i := 0; i < s.Len(); i++ {
+					evicted += s.Member(i).Stats().MembersEvicted
+				}
+				if evicted < 1 {
+					return fmt.Errorf("expected the killed leader to be evicted but MembersEvicted=%v", evicted)
+				}
+				return nil
+			}).
+			Run()
+	})
+}
@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	DefaultHistorySize     = 256
+	historySinkChanSize    = 64
+	DefaultHistoryMaxBytes = 10 * 1024 * 1024
+)
+
+// Kinds of HistoryEvent.
+const (
+	HistoryStarted    = "started"     // The Coordinator was started.
+	HistoryStopped    = "stopped"     // The Coordinator was stopped.
+	HistorySession    = "session"     // ZooKeeper session state change.
+	HistoryJoined     = "joined"      // A member (possibly the local one) joined the election.
+	HistoryDeparted   = "departed"    // A member left the election.
+	HistoryElected    = "elected"     // A new leader was determined.
+	HistoryDemoted    = "demoted"     // The local member gave up leadership.
+	HistoryDegraded   = "degraded"    // Too few members to elect a leader.
+	HistorySplitBrain = "split-brain" // Members disagree about the leader.
+	HistoryRecovered  = "recovered"   // The watchdog restarted the Coordinator.
+	HistoryHandoff    = "handoff"     // The leader announced its successor before stepping down.
+	HistoryHandback   = "handback"    // The interim leader handed leadership back to a deposed leader.
+	HistorySwitched   = "switched"    // The Coordinator moved to another ensemble.
+	HistoryEvicted    = "evicted"     // The local leader evicted a member whose heartbeat was stale.
+	HistoryDraining   = "draining"    // The local member began draining ahead of being stopped.
+)
+
+// HistoryEvent is an entry in the Coordinator's audit trail.
+type HistoryEvent struct {
+	At          time.Time `json:"at"`
+	Coordinator string    `json:"coordinator"`      // Id() of the recording Coordinator.
+	Kind        string    `json:"kind"`             // One of the History* constants.
+	Member      string    `json:"member,omitempty"` // Candidate zNode name of the member concerned.
+	Detail      string    `json:"detail,omitempty"`
+}
+
+func (event HistoryEvent) String() string {
+	s := fmt.Sprintf("%v %v %v", event.At.Format(time.RFC3339Nano), event.Coordinator, event.Kind)
+	if event.Member != "" {
+		s += " member=" + event.Member
+	}
+	if event.Detail != "" {
+		s += " " + event.Detail
+	}
+	return s
+}
+
+// HistorySink durably records HistoryEvents in addition to the in-memory ring
+// buffer.  Record is invoked from a dedicated goroutine, in order.
+type HistorySink interface {
+	Record(event HistoryEvent) error
+}
+
+// history is a bounded ring buffer of events.
+type history struct {
+	events []HistoryEvent
+	next   int
+	full   bool
+	lock   sync.Mutex
+}
+
+func (h *history) add(event HistoryEvent, size int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.events) != size {
+		// Size was changed, keep the most recent events.
+		events := h.snapshot()
+		if len(events) > size {
+			events = events[len(events)-size:]
+		}
+		h.events = make([]HistoryEvent, size)
+		h.next = copy(h.events, events) % size
+		h.full = len(events) == size
+	}
+	h.events[h.next] = event
+	h.next = (h.next + 1) % size
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// snapshot returns the events oldest first.  Must be invoked while holding
+// h.lock.
+func (h *history) snapshot() []HistoryEvent {
+	if !h.full {
+		return append([]HistoryEvent{}, h.events[0:h.next]...)
+	}
+	return append(append([]HistoryEvent{}, h.events[h.next:]...), h.events[0:h.next]...)
+}
+
+// History returns the most recent coordination events, oldest first.  At most
+// HistorySize events are retained.
+func (cc *Coordinator) History() []HistoryEvent {
+	cc.history.lock.Lock()
+	defer cc.history.lock.Unlock()
+	return cc.history.snapshot()
+}
+
+func (cc *Coordinator) historySize() int {
+	if cc.HistorySize > 0 {
+		return cc.HistorySize
+	}
+	return DefaultHistorySize
+}
+
+// record adds an event to the history and hands it to the HistorySink, if
+// any.  Never blocks; sink events are dropped if the sink falls behind.
+func (cc *Coordinator) record(kind string, member string, detail string) {
+	event := HistoryEvent{
+		At:          cc.clock().Now(),
+		Coordinator: cc.Id(),
+		Kind:        kind,
+		Member:      member,
+		Detail:      detail,
+	}
+	log.Debugf("%v: history: %v", cc.Id(), event)
+	cc.history.add(event, cc.historySize())
+
+	cc.historySinkLock.Lock()
+	defer cc.historySinkLock.Unlock()
+	if cc.historySinkChan == nil {
+		return
+	}
+	select {
+	case cc.historySinkChan <- event:
+	default:
+		log.Warnf("%v: history sink is falling behind, dropped event=%v", cc.Id(), event)
+	}
+}
+
+// startHistorySink launches delivery to the HistorySink, if one is configured.
+func (cc *Coordinator) startHistorySink() {
+	cc.historySinkLock.Lock()
+	defer cc.historySinkLock.Unlock()
+	if cc.HistorySink == nil || cc.historySinkChan != nil {
+		return
+	}
+	cc.historySinkChan = make(chan HistoryEvent, historySinkChanSize)
+	cc.historySinkDoneChan = make(chan struct{})
+	sink, ch, doneChan := cc.HistorySink, cc.historySinkChan, cc.historySinkDoneChan
+	cc.resources.Go("history-sink", func() {
+		defer close(doneChan)
+		for event := range ch {
+			if err := sink.Record(event); err != nil {
+				log.Warnf("%v: history sink: recording event=%v: %s", cc.Id(), event, err)
+			}
+		}
+	})
+}
+
+// stopHistorySink flushes pending events to the HistorySink and stops
+// delivery.
+func (cc *Coordinator) stopHistorySink() {
+	cc.historySinkLock.Lock()
+	ch, doneChan := cc.historySinkChan, cc.historySinkDoneChan
+	cc.historySinkChan, cc.historySinkDoneChan = nil, nil
+	cc.historySinkLock.Unlock()
+	if ch != nil {
+		close(ch)
+		<-doneChan
+	}
+}
+
+// FileHistorySink appends events to a file as JSON lines.  Once the file
+// exceeds MaxBytes it is renamed with a ".1" suffix, replacing any previous
+// one, and a new file is started.
+type FileHistorySink struct {
+	Path     string
+	MaxBytes int64 // Defaults to DefaultHistoryMaxBytes.
+	file     *os.File
+	size     int64
+}
+
+func NewFileHistorySink(path string) *FileHistorySink {
+	sink := &FileHistorySink{
+		Path:     path,
+		MaxBytes: DefaultHistoryMaxBytes,
+	}
+	return sink
+}
+
+func (sink *FileHistorySink) Record(event HistoryEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	maxBytes := sink.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultHistoryMaxBytes
+	}
+	if sink.file != nil && sink.size+int64(len(line)) > maxBytes {
+		sink.file.Close()
+		sink.file = nil
+		if err := os.Rename(sink.Path, sink.Path+".1"); err != nil {
+			return fmt.Errorf("rotating %v: %s", sink.Path, err)
+		}
+	}
+	if sink.file == nil {
+		if sink.file, err = os.OpenFile(sink.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+		info, err := sink.file.Stat()
+		if err != nil {
+			return err
+		}
+		sink.size = info.Size()
+	}
+	n, err := sink.file.Write(line)
+	sink.size += int64(n)
+	return err
+}
+
+// Close closes the underlying file.
+func (sink *FileHistorySink) Close() error {
+	if sink.file == nil {
+		return nil
+	}
+	err := sink.file.Close()
+	sink.file = nil
+	return err
+}
+
+// ZNodeHistorySink stores events as persistent sequential zNodes under Path
+// using the Coordinator's connection, so the trail is visible cluster-wide.
+// The oldest entries are pruned once more than MaxEvents exist.
+type ZNodeHistorySink struct {
+	Coordinator *Coordinator
+	Path        string
+	MaxEvents   int // Defaults to DefaultHistorySize.
+}
+
+func NewZNodeHistorySink(cc *Coordinator, path string) *ZNodeHistorySink {
+	sink := &ZNodeHistorySink{
+		Coordinator: cc,
+		Path:        path,
+		MaxEvents:   DefaultHistorySize,
+	}
+	return sink
+}
+
+func (sink *ZNodeHistorySink) Record(event HistoryEvent) error {
+	zkCli := sink.Coordinator.Conn()
+	if zkCli == nil {
+		return fmt.Errorf("not connected")
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := util.CreateP(zkCli, sink.Path, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		return err
+	}
+	if _, err := zkCli.Create(path.Join(sink.Path, "event-"), data, zk.FlagSequence, zk.WorldACL(zk.PermAll)); err != nil {
+		return err
+	}
+	return sink.prune(zkCli)
+}
+
+func (sink *ZNodeHistorySink) prune(zkCli *zk.Conn) error {
+	maxEvents := sink.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = DefaultHistorySize
+	}
+	children, _, err := zkCli.Children(sink.Path)
+	if err != nil {
+		return err
+	}
+	if len(children) <= maxEvents {
+		return nil
+	}
+	sort.Strings(children) // Sequence suffixes are zero-padded.
+	for _, child := range children[0 : len(children)-maxEvents] {
+		if err := zkCli.Delete(path.Join(sink.Path, child), -1); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadZNodeHistory returns the events stored by a ZNodeHistorySink at path,
+// oldest first.
+func ReadZNodeHistory(conn *zk.Conn, historyPath string) ([]HistoryEvent, error) {
+	children, _, err := conn.Children(historyPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(children)
+	events := make([]HistoryEvent, 0, len(children))
+	for _, child := range children {
+		data, _, err := conn.Get(path.Join(historyPath, child))
+		if err == zk.ErrNoNode {
+			continue // Pruned meanwhile.
+		} else if err != nil {
+			return nil, err
+		}
+		var event HistoryEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("decoding %v: %s", child, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,137 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t) + "/election"
+			historyPath  = testutil.Namespace(t) + "/history"
+		)
+
+		start := func(data string) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.HistorySink = cluster.NewZNodeHistorySink(cc, historyPath)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+
+		leader := start("leader")
+		follower := start("follower")
+		waitUntil := func(description string, fn func() bool) {
+			deadline := time.Now().Add(5 * time.Second)
+			for !fn() {
+				if time.Now().After(deadline) {
+					t.Fatalf("Timed out waiting until %v, history=%v", description, leader.History())
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		hasKinds := func(kinds ...string) func() bool {
+			return func() bool {
+				i := 0
+				for _, event := range leader.History() {
+					if i < len(kinds) && event.Kind == kinds[i] {
+						i++
+					}
+				}
+				return i == len(kinds)
+			}
+		}
+
+		waitUntil("the follower joined", hasKinds(cluster.HistoryStarted, cluster.HistorySession, cluster.HistoryJoined, cluster.HistoryElected, cluster.HistoryJoined))
+		if err := follower.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		waitUntil("the follower departed", hasKinds(cluster.HistoryJoined, cluster.HistoryDeparted))
+
+		conn, _, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if err := leader.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		events, err := cluster.ReadZNodeHistory(conn, historyPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 || events[len(events)-1].Kind != cluster.HistoryStopped {
+			t.Errorf("Expected zNode history to end with kind=%v, but events=%v", cluster.HistoryStopped, events)
+		}
+	})
+}
+
+func TestHistorySize(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.HistorySize = 2
+		for i := 0; i < 3; i++ {
+			if err := cc.Start(); err != nil {
+				t.Fatal(err)
+			}
+			if err := cc.Stop(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		history := cc.History()
+		if len(history) != 2 {
+			t.Fatalf("Expected 2 events to be retained but actual=%v", history)
+		}
+		if last := history[1]; last.Kind != cluster.HistoryStopped {
+			t.Errorf("Expected the most recent event to be kind=%v but actual=%v", cluster.HistoryStopped, last)
+		}
+	})
+}
+
+func TestFileHistorySinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zklib-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := cluster.NewFileHistorySink(filepath.Join(dir, "history.log"))
+	sink.MaxBytes = 256
+	defer sink.Close()
+	for i := 0; i < 10; i++ {
+		if err := sink.Record(cluster.HistoryEvent{At: time.Now(), Kind: cluster.HistoryElected, Detail: fmt.Sprintf("i=%v", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"history.log", "history.log.1"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 || info.Size() > sink.MaxBytes {
+			t.Errorf("Expected %v size to be within (0, %v] but actual=%v", name, sink.MaxBytes, info.Size())
+		}
+	}
+}
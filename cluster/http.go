@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Handler returns an http.Handler which serves the Coordinator's state as
+// JSON, for mounting on an existing admin port:
+//
+//	/leader    - The current leader, 404 when there is none.
+//	/members   - All election members.
+//	/followers - Followers with their serving roles, see Followers.
+//	/status    - See Status.
+//	/health    - 200 when running with a session, 503 otherwise.
+//	/usage     - Usage of the managed paths, see Usage.
+//
+// Use http.StripPrefix to mount it below a path, e.g.:
+//
+//	mux.Handle("/cluster/", http.StripPrefix("/cluster", cc.Handler()))
+func (cc *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/leader", func(w http.ResponseWriter, _ *http.Request) {
+		leader := cc.Leader()
+		if leader == nil {
+			respondJson(w, http.StatusNotFound, map[string]string{"error": "no leader"})
+			return
+		}
+		respondJson(w, http.StatusOK, leader)
+	})
+
+	mux.HandleFunc("/members", func(w http.ResponseWriter, _ *http.Request) {
+		if cc.Conn() == nil {
+			respondJson(w, http.StatusServiceUnavailable, map[string]string{"error": "not running"})
+			return
+		}
+		nodes, err := cc.Members()
+		if err != nil {
+			respondJson(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJson(w, http.StatusOK, nodes)
+	})
+
+	mux.HandleFunc("/followers", func(w http.ResponseWriter, _ *http.Request) {
+		followers, err := cc.Followers()
+		if err != nil {
+			respondJson(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJson(w, http.StatusOK, followers)
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		respondJson(w, http.StatusOK, cc.Status())
+	})
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, _ *http.Request) {
+		status := cc.Status()
+		code := http.StatusOK
+		if !status.Running || status.SessionState != zk.StateHasSession.String() {
+			code = http.StatusServiceUnavailable
+		}
+		respondJson(w, code, map[string]interface{}{
+			"healthy":      code == http.StatusOK,
+			"sessionState": status.SessionState,
+		})
+	})
+
+	mux.HandleFunc("/usage", func(w http.ResponseWriter, _ *http.Request) {
+		usages, err := cc.Usage()
+		if err != nil {
+			respondJson(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJson(w, http.StatusOK, usages)
+	})
+
+	return mux
+}
+
+func respondJson(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Coordinator http handler: encoding response: %s", err)
+	}
+}
@@ -0,0 +1,62 @@
+package cluster_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestCoordinatorHandler(t *testing.T) {
+	t.Parallel()
+
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc := ncc(t, zkServers, "http")
+		server := httptest.NewServer(cc.Handler())
+		defer server.Close()
+
+		get := func(path string, v interface{}) int {
+			resp, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if v != nil {
+				if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+					t.Fatalf("Decoding response for path=%v: %s", path, err)
+				}
+			}
+			return resp.StatusCode
+		}
+
+		var leader primitives.Node
+		if code := get("/leader", &leader); code != http.StatusOK || leader.Uuid != cc.LocalNode.Uuid {
+			t.Errorf("Expected /leader to return the local node, but code=%v leader=%+v", code, leader)
+		}
+		var members []primitives.Node
+		if code := get("/members", &members); code != http.StatusOK || len(members) != 1 {
+			t.Errorf("Expected /members to return 1 member, but code=%v members=%+v", code, members)
+		}
+		var status cluster.Status
+		if code := get("/status", &status); code != http.StatusOK || !status.Running || status.Mode != primitives.Leader {
+			t.Errorf("Expected /status to report a running leader, but code=%v status=%+v", code, status)
+		}
+		if code := get("/health", nil); code != http.StatusOK {
+			t.Errorf("Expected /health code=%v but actual=%v", http.StatusOK, code)
+		}
+
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if code := get("/health", nil); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected /health code=%v after stop but actual=%v", http.StatusServiceUnavailable, code)
+		}
+		if code := get("/members", nil); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected /members code=%v after stop but actual=%v", http.StatusServiceUnavailable, code)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// BinaryVersion is published as Node.Version when identity enrichment is
+// enabled.  Typically set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/gigawattio/zklib/cluster.BinaryVersion=1.2.3"
+var BinaryVersion string
+
+var processStartedAt = time.Now()
+
+// enrichIdentity populates the self-identification fields of node.
+func enrichIdentity(node *primitives.Node) {
+	node.Pid = os.Getpid()
+	node.Version = BinaryVersion
+	node.StartedAt = processStartedAt
+	node.IP = localIP()
+}
+
+// localIP returns the first non-loopback IPv4 address of the host, falling
+// back to the first non-loopback IPv6 address, or an empty string.
+func localIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var fallback string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4.String()
+		} else if fallback == "" {
+			fallback = ipNet.IP.String()
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,55 @@
+package cluster_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestEnrichIdentity(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cluster.BinaryVersion = "v1.2.3-test"
+		defer func() { cluster.BinaryVersion = "" }()
+
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "enriched")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.EnrichIdentity = true
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		nodes, err := cc.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 {
+			t.Fatalf("Expected exactly 1 member but found nodes=%+v", nodes)
+		}
+		node := nodes[0]
+		if expected, actual := os.Getpid(), node.Pid; actual != expected {
+			t.Errorf("Expected published pid=%v but actual=%v", expected, actual)
+		}
+		if expected, actual := "v1.2.3-test", node.Version; actual != expected {
+			t.Errorf("Expected published version=%v but actual=%v", expected, actual)
+		}
+		if node.StartedAt.IsZero() || node.StartedAt.After(time.Now()) {
+			t.Errorf("Expected a valid published start time but actual=%v", node.StartedAt)
+		}
+		if node.Data != "enriched" {
+			t.Errorf("Expected data to be preserved, but actual=%q", node.Data)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// KazooElectionLayout matches the zNodes created by kazoo's Election recipe
+// (which is built on its Lock recipe): "<uuid-hex>__lock__<sequence>" directly
+// under the election path, with the lowest sequence number being the leader.
+//
+// Kazoo locates the sequence number by searching for "__lock__", so the
+// protection prefix added to Go participants' names is harmless.
+var KazooElectionLayout = PathLayout{
+	CandidatePrefix:  "__lock__",
+	NodeNameTemplate: "{uuidhex}",
+}
+
+// KazooCodec stores only the election identifier, as UTF-8 bytes, which is
+// the same wire format Curator uses.
+type KazooCodec struct {
+	CuratorCodec
+}
+
+// NewKazooCoordinator creates a Coordinator which participates in the same
+// election as kazoo Election instances using electionPath.  identifier is
+// published as the kazoo contender identifier.
+func NewKazooCoordinator(zkServers []string, sessionTimeout time.Duration, electionPath string, identifier string, subscribers ...chan primitives.Update) (*Coordinator, error) {
+	cc, err := NewCoordinator(zkServers, sessionTimeout, electionPath, identifier, subscribers...)
+	if err != nil {
+		return nil, err
+	}
+	cc.PathLayout = KazooElectionLayout
+	cc.Codec = KazooCodec{}
+	return cc, nil
+}
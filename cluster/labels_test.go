@@ -0,0 +1,77 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestLeaderLabels(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			updates      = make(chan primitives.Update, 100)
+			ssd          = map[string]string{"ssd": "true"}
+		)
+
+		start := func(name string, labels map[string]string, subscribers ...chan primitives.Update) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, name, subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.LocalNode.Labels = labels
+			cc.LeaderLabels = ssd
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+		waitForLeader := func(expected string) {
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case update := <-updates:
+					if expected == "" && update.Type == primitives.DegradedUpdate {
+						return
+					}
+					if expected != "" && update.Type == primitives.LeaderUpdate && update.Leader.Data == expected {
+						return
+					}
+				case <-timeout:
+					t.Fatalf("Timed out waiting for leader=%q", expected)
+				}
+			}
+		}
+
+		// The first member is lowest but ineligible.
+		spinning := start("spinning", map[string]string{"ssd": "false"}, updates)
+		defer spinning.Stop()
+		waitForLeader("")
+		if leader := spinning.Leader(); leader != nil {
+			t.Errorf("Expected no leader without eligible members, but leader=%v", leader)
+		}
+
+		first := start("first", ssd)
+		second := start("second", map[string]string{"ssd": "true", "zone": "b"})
+		defer second.Stop()
+		waitForLeader("first")
+		if mode := spinning.Mode(); mode != primitives.Follower {
+			t.Errorf("Expected ineligible member mode=%v but actual=%v", primitives.Follower, mode)
+		}
+
+		// Constraints are re-evaluated as members depart.
+		if err := first.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		waitForLeader("second")
+		if mode := second.Mode(); mode != primitives.Leader {
+			t.Errorf("Expected next eligible member mode=%v but actual=%v", primitives.Leader, mode)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package cluster
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const DefaultCandidatePrefix = "n_"
+
+// PathLayout controls how a Coordinator lays out and names its zNodes beneath
+// the election path.  The zero value yields the classic layout, where
+// candidates are created directly under the election path as
+// "_c_<guid>-n_<sequence>".
+//
+// Customizing the layout makes it possible to participate in trees created by
+// other tooling (e.g. Curator), or to keep candidates in their own subtree so
+// the election path may also hold unrelated zNodes.
+type PathLayout struct {
+	// CandidatePrefix is the name prefix ZooKeeper appends the sequence number
+	// to.  Defaults to DefaultCandidatePrefix.
+	CandidatePrefix string
+
+	// MembersDir, when non-empty, places candidate zNodes under
+	// <election-path>/<MembersDir> instead of directly under the election path.
+	MembersDir string
+
+	// LeadersDir, when non-empty, makes the leader advertise itself with an
+	// ephemeral zNode at <election-path>/<LeadersDir>/<candidate-name> holding
+	// its node data, for tooling which watches a leaders subtree.  The
+	// advertisement is informational: leadership is always decided by the
+	// candidate sequence numbers, so during a transition the subtree may
+	// briefly hold both the outgoing and the incoming leader (see
+	// LowestCandidate).
+	LeadersDir string
+
+	// NodeNameTemplate is prepended to CandidatePrefix and may embed the
+	// placeholders {hostname}, {pid}, {uuid} and {uuidhex} (the uuid without
+	// dashes), e.g. "{hostname}-{pid}-".
+	NodeNameTemplate string
+}
+
+// Validate checks that the layout will produce legal zNode names.
+func (layout PathLayout) Validate() error {
+	if strings.Contains(layout.candidatePrefix(), "/") || strings.Contains(layout.NodeNameTemplate, "/") {
+		return fmt.Errorf("invalid path layout: candidate prefix and node name template must not contain '/'")
+	}
+	if dir := strings.Trim(layout.MembersDir, "/"); dir != layout.MembersDir || strings.Contains(dir, "/") {
+		return fmt.Errorf("invalid path layout: members dir=%q must be a single path element", layout.MembersDir)
+	}
+	if dir := strings.Trim(layout.LeadersDir, "/"); dir != layout.LeadersDir || strings.Contains(dir, "/") {
+		return fmt.Errorf("invalid path layout: leaders dir=%q must be a single path element", layout.LeadersDir)
+	}
+	if layout.LeadersDir != "" && layout.LeadersDir == layout.MembersDir {
+		return fmt.Errorf("invalid path layout: leaders dir and members dir must differ")
+	}
+	return nil
+}
+
+// CandidatesPath returns the path under which candidate zNodes live for the
+// given election path.
+func (layout PathLayout) CandidatesPath(electionPath string) string {
+	if layout.MembersDir == "" {
+		return electionPath
+	}
+	return electionPath + "/" + layout.MembersDir
+}
+
+// LeadersPath returns the path under which the leader advertises itself for
+// the given election path, or an empty string when LeadersDir is not set.
+func (layout PathLayout) LeadersPath(electionPath string) string {
+	if layout.LeadersDir == "" {
+		return ""
+	}
+	return electionPath + "/" + layout.LeadersDir
+}
+
+// NodeName returns the (pre-sequence) zNode name for the given node.
+func (layout PathLayout) NodeName(node primitives.Node) string {
+	replacer := strings.NewReplacer(
+		"{hostname}", node.Hostname,
+		"{pid}", strconv.Itoa(os.Getpid()),
+		"{uuid}", node.Uuid.String(),
+		"{uuidhex}", strings.Replace(node.Uuid.String(), "-", "", -1),
+	)
+	return replacer.Replace(layout.NodeNameTemplate) + layout.candidatePrefix()
+}
+
+// IsCandidate reports whether the child zNode name was created by a
+// participant using this layout.
+func (layout PathLayout) IsCandidate(child string) bool {
+	if !strings.Contains(child, layout.candidatePrefix()) {
+		return false
+	}
+	_, err := util.SequenceNumber(child)
+	return err == nil
+}
+
+// LowestCandidate returns the candidate child with the lowest sequence number,
+// i.e. the leader.
+func (layout PathLayout) LowestCandidate(children []string) (child string, ok bool) {
+	var min int64 = -1
+	for _, c := range children {
+		if !layout.IsCandidate(c) {
+			continue
+		}
+		n, _ := util.SequenceNumber(c)
+		if min == -1 || n < min {
+			min = n
+			child = c
+		}
+	}
+	return child, min != -1
+}
+
+// SortedCandidates returns the candidate children ordered by sequence
+// number, i.e. in order of succession.
+func (layout PathLayout) SortedCandidates(children []string) []string {
+	candidates := make([]string, 0, len(children))
+	for _, c := range children {
+		if layout.IsCandidate(c) {
+			candidates = append(candidates, c)
+		}
+	}
+	util.SortBySequence(candidates)
+	return candidates
+}
+
+func (layout PathLayout) candidatePrefix() string {
+	if layout.CandidatePrefix == "" {
+		return DefaultCandidatePrefix
+	}
+	return layout.CandidatePrefix
+}
+
+// advertiseLeadership creates or removes the local leader advertisement under
+// the layout's leaders subtree, if any.
+func (cc *Coordinator) advertiseLeadership(zkCli *zk.Conn, zNode string, data []byte, leader bool) {
+	leadersPath := cc.PathLayout.LeadersPath(cc.leaderElectionPath)
+	if leadersPath == "" || zNode == "" {
+		return
+	}
+	advert := leadersPath + "/" + path.Base(zNode)
+	if !leader {
+		if err := zkCli.Delete(advert, -1); err != nil && err != zk.ErrNoNode {
+			log.Warnf("%v: withdrawing leader advertisement=%v: %s", cc.Id(), advert, err)
+			return
+		}
+		cc.resources.Disown(advert)
+		return
+	}
+	_, err := zkCli.Create(advert, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err == zk.ErrNoNode {
+		if _, err = util.EnsurePath(zkCli, leadersPath, util.EnsurePathOptions{ACL: cc.PathACL, Container: cc.ContainerPaths}); err == nil {
+			_, err = zkCli.Create(advert, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+		}
+	}
+	if err != nil && err != zk.ErrNodeExists {
+		log.Warnf("%v: advertising leadership at %v: %s", cc.Id(), advert, err)
+		return
+	}
+	cc.resources.Own(advert)
+}
@@ -0,0 +1,159 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestPathLayoutNaming(t *testing.T) {
+	node := primitives.Node{Hostname: "host-a"}
+	layout := cluster.PathLayout{
+		CandidatePrefix:  "latch-",
+		NodeNameTemplate: "{hostname}-{pid}-",
+	}
+	if expected, actual := fmt.Sprintf("host-a-%v-latch-", os.Getpid()), layout.NodeName(node); actual != expected {
+		t.Errorf("Expected node name=%q but actual=%q", expected, actual)
+	}
+
+	testCases := map[string]bool{
+		"_c_8b8c0e9b-latch-0000000003": true,
+		"latch-0000000003":             true,
+		"_c_8b8c0e9b-n_0000000003":     false,
+		"latch-":                       false,
+		"lock-0000000001":              false,
+	}
+	for child, expected := range testCases {
+		if actual := layout.IsCandidate(child); actual != expected {
+			t.Errorf("Expected IsCandidate(%q)=%v but actual=%v", child, expected, actual)
+		}
+	}
+	if !(cluster.PathLayout{}).IsCandidate("_c_8b8c0e9b-n_0000000003") {
+		t.Errorf("Expected default layout to recognize classic candidate names")
+	}
+
+	children := []string{"_c_2-latch-0000000010", "handoff", "_c_1-latch-0000000002", "_c_3-n_0000000001", "latch-0000000007"}
+	expected := []string{"_c_1-latch-0000000002", "latch-0000000007", "_c_2-latch-0000000010"}
+	if actual := layout.SortedCandidates(children); strings.Join(actual, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected SortedCandidates=%v but actual=%v", expected, actual)
+	}
+
+	for _, invalid := range []cluster.PathLayout{{CandidatePrefix: "a/b"}, {MembersDir: "/members"}, {MembersDir: "a/b"}, {LeadersDir: "a/b"}, {MembersDir: "x", LeadersDir: "x"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected layout=%+v to be invalid", invalid)
+		}
+	}
+}
+
+func TestPathLayoutMembersDir(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		layout := cluster.PathLayout{
+			MembersDir:       "members",
+			LeadersDir:       "leaders",
+			NodeNameTemplate: "{hostname}-",
+		}
+
+		// Unrelated zNodes living alongside the candidates must be ignored.
+		err := util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			_, err := util.CreateP(conn, layout.CandidatesPath(electionPath)+"/not-a-candidate", []byte{}, 0, zk.WorldACL(zk.PermAll))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ccs := []*cluster.Coordinator{}
+		for i := 0; i < 2; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.PathLayout = layout
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			for _, cc := range ccs {
+				if err := cc.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+
+		if leader := ccs[1].Leader(); leader == nil || leader.Uuid != ccs[0].LocalNode.Uuid {
+			t.Fatalf("Expected first member=%v to be leader but leader=%v", ccs[0].Id(), leader)
+		}
+
+		nodes, err := ccs[1].Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 2, len(nodes); actual != expected {
+			t.Fatalf("Expected num members=%v but actual=%v; nodes=%+v", expected, actual, nodes)
+		}
+
+		err = util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			children, _, err := conn.Children(layout.CandidatesPath(electionPath))
+			if err != nil {
+				return err
+			}
+			var numCandidates int
+			for _, child := range children {
+				if layout.IsCandidate(child) {
+					numCandidates++
+					if !strings.Contains(child, ccs[0].LocalNode.Hostname+"-n_") {
+						t.Errorf("Expected candidate zNode=%v to embed the hostname", child)
+					}
+				}
+			}
+			if numCandidates != 2 {
+				t.Errorf("Expected 2 candidates under members dir but found children=%v", children)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Wait for leadership to move to the remaining member, which must then
+		// be the only one advertised under the leaders dir.
+		if err := ccs[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		ccs = ccs[1:]
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var leaders []string
+			err = util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) (err error) {
+				leaders, _, err = conn.Children(layout.LeadersPath(electionPath))
+				return
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(leaders) == 1 && strings.Contains(leaders[0], ccs[0].LocalNode.Hostname+"-n_") && ccs[0].Mode() == primitives.Leader {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected only the remaining member to be advertised as leader but leaders=%v", leaders)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,281 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var NoLeaderAddressError = errors.New("leader has no address")
+
+// LeaderSource is what a LeaderDialer follows, e.g. a running Coordinator.
+type LeaderSource interface {
+	Leader() *primitives.Node
+	Subscribe(subChan chan primitives.Update, filters ...UpdateFilter)
+	Unsubscribe(unsubChan chan primitives.Update)
+}
+
+// AddressFunc extracts the advertised address of a leader.
+type AddressFunc func(leader primitives.Node) (address string, err error)
+
+// DataAddress is the default AddressFunc, which expects members to publish
+// their address, e.g. "10.0.0.1:8080", as their data.
+func DataAddress(leader primitives.Node) (string, error) {
+	if leader.Data == "" {
+		return "", NoLeaderAddressError
+	}
+	return leader.Data, nil
+}
+
+// LeaderDialer maintains connections which always point at the current
+// leader's advertised address: connections to a leader are closed as soon as
+// it is deposed, and new ones are dialed to its successor.  Use Conn for a
+// single shared connection, or DialContext and HTTPClient for connection
+// pools.
+//
+// Exported fields must be set before Start().
+type LeaderDialer struct {
+	Source LeaderSource
+
+	// Address defaults to DataAddress.
+	Address AddressFunc
+
+	// Dial defaults to a net.Dialer.
+	Dial func(ctx context.Context, network string, address string) (net.Conn, error)
+
+	// Network used by Conn, defaults to "tcp".
+	Network string
+
+	address     string        // Of the current leader, empty when unknown.
+	changedChan chan struct{} // Closed and replaced whenever address changes.
+	conns       map[*leaderConn]struct{}
+	shared      *leaderConn
+	updates     chan primitives.Update
+	quitChan    chan struct{}
+	doneChan    chan struct{}
+	lock        sync.Mutex
+}
+
+func NewLeaderDialer(source LeaderSource) *LeaderDialer {
+	ld := &LeaderDialer{
+		Source:      source,
+		changedChan: make(chan struct{}),
+		conns:       map[*leaderConn]struct{}{},
+	}
+	return ld
+}
+
+// Start begins following the Source, which must already be running.
+func (ld *LeaderDialer) Start() error {
+	ld.lock.Lock()
+	defer ld.lock.Unlock()
+
+	if ld.quitChan != nil {
+		return errors.New("LeaderDialer: already started")
+	}
+	ld.updates = make(chan primitives.Update, 10)
+	ld.quitChan = make(chan struct{})
+	ld.doneChan = make(chan struct{})
+	ld.Source.Subscribe(ld.updates, FilterLeaderChanges|FilterSessionEvents)
+	go ld.run(ld.updates, ld.quitChan, ld.doneChan)
+	return nil
+}
+
+// Stop stops following the Source and closes all connections which were
+// handed out.  Must be invoked before the Source is itself stopped.
+func (ld *LeaderDialer) Stop() error {
+	ld.lock.Lock()
+	updates, quitChan, doneChan := ld.updates, ld.quitChan, ld.doneChan
+	ld.updates, ld.quitChan, ld.doneChan = nil, nil, nil
+	ld.lock.Unlock()
+
+	if quitChan == nil {
+		return errors.New("LeaderDialer: already stopped")
+	}
+	ld.Source.Unsubscribe(updates)
+	close(quitChan)
+	<-doneChan
+
+	ld.lock.Lock()
+	ld.setAddress("")
+	ld.lock.Unlock()
+	return nil
+}
+
+// LeaderAddress returns the advertised address of the current leader, or an
+// empty string when unknown.
+func (ld *LeaderDialer) LeaderAddress() string {
+	ld.lock.Lock()
+	defer ld.lock.Unlock()
+	return ld.address
+}
+
+// DialContext dials the current leader, waiting for one to emerge if need be,
+// regardless of address.  Its signature matches http.Transport.DialContext.
+// The connection is closed once the leader is deposed.
+func (ld *LeaderDialer) DialContext(ctx context.Context, network string, _ string) (net.Conn, error) {
+	for {
+		ld.lock.Lock()
+		address, changedChan := ld.address, ld.changedChan
+		ld.lock.Unlock()
+
+		if address == "" {
+			select {
+			case <-changedChan:
+				continue
+			case <-ctx.Done():
+				return nil, fmt.Errorf("LeaderDialer: waiting for a leader: %s", ctx.Err())
+			}
+		}
+
+		conn, err := ld.dial(ctx, network, address)
+		if err != nil {
+			return nil, fmt.Errorf("LeaderDialer: dialing leader address=%v: %s", address, err)
+		}
+
+		ld.lock.Lock()
+		if ld.address != address {
+			// Leadership changed while dialing.
+			ld.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		lc := &leaderConn{Conn: conn, ld: ld}
+		ld.conns[lc] = struct{}{}
+		ld.lock.Unlock()
+		return lc, nil
+	}
+}
+
+// Conn returns a connection to the current leader which is shared among
+// callers, dialing it only when there is none yet or the previous one has
+// been closed, e.g. due to a leadership change.
+func (ld *LeaderDialer) Conn(ctx context.Context) (net.Conn, error) {
+	ld.lock.Lock()
+	if shared := ld.shared; shared != nil {
+		ld.lock.Unlock()
+		return shared, nil
+	}
+	ld.lock.Unlock()
+
+	network := ld.Network
+	if network == "" {
+		network = "tcp"
+	}
+	conn, err := ld.DialContext(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ld.lock.Lock()
+	defer ld.lock.Unlock()
+	if ld.shared != nil {
+		// Another caller won the race.
+		go conn.Close()
+		return ld.shared, nil
+	}
+	ld.shared = conn.(*leaderConn)
+	return ld.shared, nil
+}
+
+// HTTPClient returns a client whose requests are all sent to the current
+// leader, whichever host the request URL names.
+func (ld *LeaderDialer) HTTPClient() *http.Client {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: ld.DialContext,
+		},
+	}
+	return client
+}
+
+func (ld *LeaderDialer) run(updates chan primitives.Update, quitChan chan struct{}, doneChan chan struct{}) {
+	defer close(doneChan)
+	ld.refresh()
+	for {
+		select {
+		case <-updates:
+			ld.refresh()
+		case <-quitChan:
+			return
+		}
+	}
+}
+
+// refresh re-reads the leader from the Source.
+func (ld *LeaderDialer) refresh() {
+	var address string
+	if leader := ld.Source.Leader(); leader != nil {
+		var err error
+		if address, err = ld.addressOf(*leader); err != nil {
+			log.Warnf("LeaderDialer: leader=%v: %s", leader.Uuid, err)
+		}
+	}
+	ld.lock.Lock()
+	ld.setAddress(address)
+	ld.lock.Unlock()
+}
+
+// setAddress installs a new leader address, closing every connection to the
+// previous one.
+//
+// Must only be invoked while holding ld.lock.
+func (ld *LeaderDialer) setAddress(address string) {
+	if address == ld.address {
+		return
+	}
+	log.Infof("LeaderDialer: leader address changed from=%q to=%q", ld.address, address)
+	ld.address = address
+	close(ld.changedChan)
+	ld.changedChan = make(chan struct{})
+
+	for lc := range ld.conns {
+		// NB: Closed asynchronously since leaderConn.Close takes ld.lock.
+		go lc.Close()
+	}
+	ld.conns = map[*leaderConn]struct{}{}
+	ld.shared = nil
+}
+
+func (ld *LeaderDialer) addressOf(leader primitives.Node) (string, error) {
+	if ld.Address != nil {
+		return ld.Address(leader)
+	}
+	return DataAddress(leader)
+}
+
+func (ld *LeaderDialer) dial(ctx context.Context, network string, address string) (net.Conn, error) {
+	if ld.Dial != nil {
+		return ld.Dial(ctx, network, address)
+	}
+	dialer := &net.Dialer{}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// leaderConn is a connection handed out by a LeaderDialer.
+type leaderConn struct {
+	net.Conn
+	ld   *LeaderDialer
+	once sync.Once
+}
+
+func (lc *leaderConn) Close() error {
+	var err error
+	lc.once.Do(func() {
+		lc.ld.lock.Lock()
+		delete(lc.ld.conns, lc)
+		if lc.ld.shared == lc {
+			lc.ld.shared = nil
+		}
+		lc.ld.lock.Unlock()
+		err = lc.Conn.Close()
+	})
+	return err
+}
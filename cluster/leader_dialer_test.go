@@ -0,0 +1,112 @@
+package cluster_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// fakeLeaderSource is a cluster.LeaderSource whose leader is set by hand.
+type fakeLeaderSource struct {
+	leader      *primitives.Node
+	subscribers []chan primitives.Update
+	lock        sync.Mutex
+}
+
+func (source *fakeLeaderSource) Leader() *primitives.Node {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return source.leader
+}
+
+func (source *fakeLeaderSource) Subscribe(subChan chan primitives.Update, _ ...cluster.UpdateFilter) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	source.subscribers = append(source.subscribers, subChan)
+}
+
+func (source *fakeLeaderSource) Unsubscribe(unsubChan chan primitives.Update) {}
+
+func (source *fakeLeaderSource) elect(address string) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	source.leader = &primitives.Node{Data: address}
+	for _, subChan := range source.subscribers {
+		subChan <- primitives.Update{Leader: *source.leader}
+	}
+}
+
+func TestLeaderDialer(t *testing.T) {
+	listeners := []net.Listener{}
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+		listeners = append(listeners, listener)
+	}
+
+	source := &fakeLeaderSource{}
+	ld := cluster.NewLeaderDialer(source)
+	if err := ld.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer ld.Stop()
+
+	// Without a leader dialing waits.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err := ld.Conn(ctx)
+	cancel()
+	if err == nil {
+		t.Fatalf("Expected dialing to fail while there is no leader")
+	}
+
+	source.elect(listeners[0].Addr().String())
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := ld.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := conn.RemoteAddr().String(); actual != listeners[0].Addr().String() {
+		t.Errorf("Expected connection to leader=%v but actual=%v", listeners[0].Addr(), actual)
+	}
+	if again, err := ld.Conn(ctx); err != nil || again != conn {
+		t.Errorf("Expected the shared connection to be reused, but err=%v", err)
+	}
+
+	// Leadership changes close connections to the deposed leader.
+	source.elect(listeners[1].Addr().String())
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("Expected connection to the deposed leader to be closed")
+	} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Errorf("Expected connection to the deposed leader to be closed, but read timed out")
+	}
+
+	conn, err = ld.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := conn.RemoteAddr().String(); actual != listeners[1].Addr().String() {
+		t.Errorf("Expected connection to new leader=%v but actual=%v", listeners[1].Addr(), actual)
+	}
+	if actual := ld.LeaderAddress(); actual != listeners[1].Addr().String() {
+		t.Errorf("Expected leader address=%v but actual=%v", listeners[1].Addr(), actual)
+	}
+}
@@ -0,0 +1,83 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestMinMembers(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		const minMembers = 3
+
+		var (
+			electionPath = testutil.Namespace(t)
+			ccs          = []*cluster.Coordinator{}
+			updates      = make(chan primitives.Update, 100)
+		)
+
+		start := func(i int, subscribers ...chan primitives.Update) {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i), subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.MinMembers = minMembers
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		waitForType := func(expected primitives.UpdateType) {
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case update := <-updates:
+					if update.Type == expected {
+						return
+					}
+				case <-timeout:
+					t.Fatalf("Timed out waiting for update type=%v", expected)
+				}
+			}
+		}
+
+		start(0, updates)
+		start(1)
+		waitForType(primitives.DegradedUpdate)
+		for _, cc := range ccs {
+			if leader := cc.Leader(); leader != nil {
+				t.Errorf("%v: Expected no leader with %v/%v members, but leader=%v", cc.Id(), len(ccs), minMembers, leader)
+			}
+			if mode := cc.Mode(); mode != primitives.Follower {
+				t.Errorf("%v: Expected mode=%v but actual=%v", cc.Id(), primitives.Follower, mode)
+			}
+		}
+
+		start(2)
+		waitForType(primitives.LeaderUpdate)
+		if mode := ccs[0].Mode(); mode != primitives.Leader {
+			t.Errorf("Expected first member to become leader once quorum reached, but mode=%v", mode)
+		}
+
+		if err := ccs[2].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		waitForType(primitives.DegradedUpdate)
+		if leader := ccs[0].Leader(); leader != nil {
+			t.Errorf("Expected leadership to be withdrawn after dropping below minimum members, but leader=%v", leader)
+		}
+
+		for _, cc := range ccs[0:2] {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+}
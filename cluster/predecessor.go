@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"errors"
+)
+
+// validateWatchPredecessor rejects settings which rely on every member seeing
+// every membership change, since followers only watch their predecessor and
+// the leader when WatchPredecessor is set.
+func (cc *Coordinator) validateWatchPredecessor() error {
+	switch cc.election().(type) {
+	case LowestSequenceElection, *LowestSequenceElection:
+	default:
+		return errors.New("WatchPredecessor requires the lowest-sequence Election")
+	}
+	if len(cc.LeaderLabels) > 0 {
+		return errors.New("WatchPredecessor is incompatible with LeaderLabels")
+	}
+	if cc.StickyWindow > 0 {
+		return errors.New("WatchPredecessor is incompatible with StickyWindow")
+	}
+	if cc.MinMembers > 1 {
+		return errors.New("WatchPredecessor is incompatible with MinMembers")
+	}
+	if cc.Witness {
+		return errors.New("WatchPredecessor is incompatible with witnesses")
+	}
+	if cc.PersistentMembership {
+		// NB: Every heartbeat would fire the predecessor and leader watches.
+		return errors.New("WatchPredecessor is incompatible with PersistentMembership")
+	}
+	return nil
+}
+
+// predecessorOf returns the candidate immediately preceding local among the
+// sorted candidates, or an empty string when local is first or absent.
+func predecessorOf(sorted []string, local string) string {
+	for i, candidate := range sorted {
+		if candidate == local {
+			if i == 0 {
+				return ""
+			}
+			return sorted[i-1]
+		}
+	}
+	return ""
+}
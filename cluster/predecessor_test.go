@@ -0,0 +1,91 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestWatchPredecessor(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			ccs          = []*cluster.Coordinator{}
+			lastUpdates  = make(chan primitives.Update, 100)
+		)
+		for i := 0; i < 4; i++ {
+			subscribers := []chan primitives.Update{}
+			if i == 3 {
+				subscribers = append(subscribers, lastUpdates)
+			}
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i), subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.WatchPredecessor = true
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			for _, cc := range ccs[2:] {
+				if err := cc.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		waitForAgreement(t, ccs)
+		if leader := ccs[3].Leader(); leader == nil || leader.Uuid != ccs[0].LocalNode.Uuid {
+			t.Fatalf("Expected first member to lead, but leader=%v", leader)
+		}
+
+		// Departures of members other than the predecessor and the leader go
+		// unnoticed by the last member.
+		for len(lastUpdates) > 0 {
+			<-lastUpdates
+		}
+		if err := ccs[1].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if n := len(lastUpdates); n != 0 {
+			t.Errorf("Expected no updates on the last member after an unrelated departure, but got %v", n)
+		}
+
+		// Leader departures are noticed by everyone.
+		if err := ccs[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		waitForAgreement(t, ccs[2:])
+		for _, cc := range ccs[2:] {
+			if leader := cc.Leader(); leader == nil || leader.Uuid != ccs[2].LocalNode.Uuid {
+				t.Errorf("%v: Expected the next member to take over, but leader=%v", cc.Id(), leader)
+			}
+		}
+		if mode := ccs[2].Mode(); mode != primitives.Leader {
+			t.Errorf("Expected new leader mode=%v but actual=%v", primitives.Leader, mode)
+		}
+	})
+}
+
+func TestWatchPredecessorValidation(t *testing.T) {
+	cc, err := cluster.NewCoordinator([]string{"127.0.0.1:2181"}, zkTimeout, "/watch-predecessor-validation", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.WatchPredecessor = true
+	cc.MinMembers = 2
+	if err := cc.Start(); err == nil {
+		cc.Stop()
+		t.Fatalf("Expected WatchPredecessor together with MinMembers to be rejected")
+	}
+}
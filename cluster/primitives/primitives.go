@@ -2,6 +2,7 @@ package primitives
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/satori/go.uuid"
 )
@@ -15,6 +16,15 @@ type Node struct {
 	Uuid     uuid.UUID
 	Hostname string
 	Data     string
+	DataRef  string            `json:",omitempty"` // Set when Data is held in a blob store rather than inline.
+	Labels   map[string]string `json:",omitempty"` // e.g. {"ssd": "true"}, see Coordinator.LeaderLabels.
+
+	// Optional self-identification fields, only populated when the publishing
+	// Coordinator has identity enrichment enabled.
+	Pid       int       `json:",omitempty"`
+	Version   string    `json:",omitempty"` // Binary version.
+	StartedAt time.Time // Process start time.
+	IP        string    `json:",omitempty"`
 }
 
 func NewNode(hostname string) *Node {
@@ -25,12 +35,100 @@ func NewNode(hostname string) *Node {
 	return node
 }
 
+// HasLabels returns whether the node carries every one of the given labels.
+func (node Node) HasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if actual, ok := node.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
 func (node Node) String() string {
 	s := fmt.Sprintf("Node{Uuid: %v, Hostname: %v, Data: %v}", node.Uuid.String(), node.Hostname, node.Data)
 	return s
 }
 
+// UpdateType distinguishes the kinds of updates delivered to subscribers.
+type UpdateType int
+
+const (
+	LeaderUpdate     UpdateType = iota // The leader has been (re-)determined.
+	DegradedUpdate                     // Too few members are present to elect a leader.
+	SplitBrainUpdate                   // Members disagree about who the leader is.
+	RecoveredUpdate                    // The watchdog restarted a wedged Coordinator.
+	HandoffUpdate                      // The leader is stepping down, Leader is its successor.
+	EvictedUpdate                      // Members were evicted, Deltas lists them.
+)
+
+func (updateType UpdateType) String() string {
+	switch updateType {
+	case LeaderUpdate:
+		return "leader"
+	case DegradedUpdate:
+		return "degraded"
+	case SplitBrainUpdate:
+		return "split-brain"
+	case RecoveredUpdate:
+		return "recovered"
+	case HandoffUpdate:
+		return "handoff"
+	case EvictedUpdate:
+		return "evicted"
+	}
+	return fmt.Sprintf("UpdateType(%d)", int(updateType))
+}
+
 type Update struct {
-	Leader Node
-	Mode   string
+	Type         UpdateType
+	Leader       Node
+	Mode         string
+	ElectionPath string        // Path of the election the update pertains to.
+	Disagreeing  []LeaderView  // Only populated for SplitBrainUpdate.
+	Deltas       []MemberDelta // Members which joined or departed since the previous update.
+
+	// Members lists every candidate in order of succession as of the update.
+	// Only populated when the publishing Coordinator has UpdateSnapshots set.
+	Members []Node
+}
+
+// Reasons a member departed, see MemberDelta.
+const (
+	DepartureGraceful       = "graceful-stop"   // The member was stopped.
+	DepartureSessionExpired = "session-expired" // No tombstone was left, e.g. the member crashed or was partitioned.
+	DepartureEvicted        = "evicted"         // An administrator forcibly removed the member, or the leader evicted it for a stale heartbeat.
+)
+
+// MemberDelta describes a member joining or departing the election.
+type MemberDelta struct {
+	ZNode  string // Candidate zNode name of the member.
+	Node   Node   // Empty when the member's zNode could not be read.
+	Joined bool
+	Reason string // Why the member departed, empty for joins.
+}
+
+// LeaderView is a member's published view of who the leader is.
+type LeaderView struct {
+	Member      string    `json:"member"`      // Uuid of the member holding the view.
+	LeaderZNode string    `json:"leaderZNode"` // Candidate zNode of the leader, empty when there is none.
+	At          time.Time `json:"at"`          // When the view was published.
+}
+
+// Resources accounts for what a Coordinator or recipe holds on to, e.g. to
+// detect leaks once it has been stopped.
+type Resources struct {
+	Watches    []string `json:"watches"`    // Paths of outstanding watches, once per watch.
+	Goroutines []string `json:"goroutines"` // Names of running background goroutines, once per goroutine.
+	ZNodes     []string `json:"zNodes"`     // Ephemeral zNodes owned.
+}
+
+// Empty returns whether nothing is held.
+func (resources Resources) Empty() bool {
+	return len(resources.Watches) == 0 && len(resources.Goroutines) == 0 && len(resources.ZNodes) == 0
+}
+
+func (resources Resources) String() string {
+	s := fmt.Sprintf("Resources{Watches: %v, Goroutines: %v, ZNodes: %v}", resources.Watches, resources.Goroutines, resources.ZNodes)
+	return s
 }
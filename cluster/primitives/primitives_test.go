@@ -0,0 +1,67 @@
+package primitives_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestNodeHasLabels(t *testing.T) {
+	node := primitives.Node{Labels: map[string]string{"ssd": "true", "zone": "b"}}
+	testCases := []struct {
+		labels   map[string]string
+		expected bool
+	}{
+		{nil, true},
+		{map[string]string{"ssd": "true"}, true},
+		{map[string]string{"ssd": "true", "zone": "b"}, true},
+		{map[string]string{"ssd": "false"}, false},
+		{map[string]string{"gpu": "true"}, false},
+	}
+	for _, testCase := range testCases {
+		if actual := node.HasLabels(testCase.labels); actual != testCase.expected {
+			t.Errorf("Expected HasLabels(%v)=%v but actual=%v", testCase.labels, testCase.expected, actual)
+		}
+	}
+	if (primitives.Node{}).HasLabels(map[string]string{"ssd": "true"}) {
+		t.Errorf("Expected a node without labels not to match")
+	}
+}
+
+func TestTracker(t *testing.T) {
+	var tracker primitives.Tracker
+	if resources := tracker.Resources(); !resources.Empty() {
+		t.Fatalf("Expected a zero Tracker to hold nothing but actual=%v", resources)
+	}
+
+	var (
+		evCh    = make(chan zk.Event)
+		release = make(chan struct{})
+		started = make(chan struct{})
+	)
+	tracker.Watch("/a", evCh)
+	tracker.Own("/b")
+	tracker.Go("worker", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	resources := tracker.Resources()
+	if len(resources.Watches) != 1 || len(resources.ZNodes) != 1 || len(resources.Goroutines) != 1 {
+		t.Fatalf("Expected a watch, zNode and goroutine to be held but actual=%v", resources)
+	}
+
+	tracker.Unwatch(evCh)
+	tracker.Disown("/b")
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for !tracker.Resources().Empty() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected nothing to be held but actual=%v", tracker.Resources())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,123 @@
+package primitives
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Tracker accounts for the Resources of a Coordinator or recipe.  The zero
+// value is ready for use.
+type Tracker struct {
+	watches    map[<-chan zk.Event]string // Pending watches, by channel, of the zNode watched.
+	goroutines map[string]int
+	zNodes     map[string]struct{}
+	lock       sync.Mutex
+}
+
+// Go runs fn in a goroutine known by name.
+func (tracker *Tracker) Go(name string, fn func()) {
+	tracker.adjust(name, 1)
+	go func() {
+		defer tracker.adjust(name, -1)
+		fn()
+	}()
+}
+
+// Watch accounts for the watch on zNode delivering to evCh, and returns evCh.
+// The watch is accounted for until Unwatch is invoked with evCh, which the
+// receiver must do once it has received the event or stopped caring for it,
+// or until the session's watches are forgotten by UnwatchAll.
+func (tracker *Tracker) Watch(zNode string, evCh <-chan zk.Event) <-chan zk.Event {
+	if evCh == nil {
+		return nil
+	}
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.watches == nil {
+		tracker.watches = map[<-chan zk.Event]string{}
+	}
+	tracker.watches[evCh] = zNode
+	return evCh
+}
+
+// Unwatch forgets the watch delivering to evCh, see Watch.
+func (tracker *Tracker) Unwatch(evCh <-chan zk.Event) {
+	if evCh == nil {
+		return
+	}
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	delete(tracker.watches, evCh)
+}
+
+// UnwatchAll forgets every watch, since the connection which armed them has
+// been closed.
+func (tracker *Tracker) UnwatchAll() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.watches = nil
+}
+
+// Own records an ephemeral zNode which has been created.
+func (tracker *Tracker) Own(zNode string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.zNodes == nil {
+		tracker.zNodes = map[string]struct{}{}
+	}
+	tracker.zNodes[zNode] = struct{}{}
+}
+
+// Disown forgets a deleted ephemeral zNode.
+func (tracker *Tracker) Disown(zNode string) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	delete(tracker.zNodes, zNode)
+}
+
+// DisownAll forgets every ephemeral zNode, since the session which owned them
+// has ended.
+func (tracker *Tracker) DisownAll() {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.zNodes = nil
+}
+
+// Resources returns what is currently accounted for.
+func (tracker *Tracker) Resources() Resources {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	resources := Resources{
+		Watches:    []string{},
+		Goroutines: []string{},
+		ZNodes:     []string{},
+	}
+	for _, zNode := range tracker.watches {
+		resources.Watches = append(resources.Watches, zNode)
+	}
+	for name, count := range tracker.goroutines {
+		for i := 0; i < count; i++ {
+			resources.Goroutines = append(resources.Goroutines, name)
+		}
+	}
+	for zNode := range tracker.zNodes {
+		resources.ZNodes = append(resources.ZNodes, zNode)
+	}
+	sort.Strings(resources.Watches)
+	sort.Strings(resources.Goroutines)
+	sort.Strings(resources.ZNodes)
+	return resources
+}
+
+func (tracker *Tracker) adjust(name string, delta int) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	if tracker.goroutines == nil {
+		tracker.goroutines = map[string]int{}
+	}
+	if tracker.goroutines[name] += delta; tracker.goroutines[name] <= 0 {
+		delete(tracker.goroutines, name)
+	}
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// checkPayloadQuota returns a util.QuotaError when writing data to zNode
+// exceeds Quotas.
+func (cc *Coordinator) checkPayloadQuota(zNode string, data []byte) error {
+	if err := cc.Quotas.CheckPayload(zNode, len(data)); err != nil {
+		cc.countStat(func(stats *Stats) { stats.QuotaRejections++ })
+		return err
+	}
+	return nil
+}
+
+// checkJoinQuota returns a util.QuotaError when the candidates path has no
+// room for the local candidate zNode.  Only candidates count, not e.g. the
+// tombstones beside them.  Failures to list the candidates are only logged,
+// leaving it to the creation to fail.
+func (cc *Coordinator) checkJoinQuota(zkCli *zk.Conn) error {
+	if len(cc.Quotas) == 0 {
+		return nil
+	}
+	children, _, err := zkCli.Children(cc.candidatesPath())
+	if err != nil {
+		log.Warnf("%v: listing candidates for quota check: %s", cc.Id(), err)
+		return nil
+	}
+	if err := cc.Quotas.CheckChildren(cc.candidatesPath(), cc.numCandidates(children)); err != nil {
+		cc.countStat(func(stats *Stats) { stats.QuotaRejections++ })
+		return err
+	}
+	return nil
+}
+
+// Usage measures the usage of every path covered by Quotas, along with the
+// candidates path whether covered or not, so operators see growth before
+// ZooKeeper runs into trouble.  Costs a request per child of each path.
+// Returns errorlib.NotRunningError when not running.
+func (cc *Coordinator) Usage() ([]util.Usage, error) {
+	paths := []string{cc.candidatesPath()}
+	for path := range cc.Quotas {
+		if path = util.NormalizePath(path); path != cc.candidatesPath() {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	usages := make([]util.Usage, 0, len(paths))
+	err := cc.request(func(zkCli *zk.Conn) error {
+		for _, path := range paths {
+			quota, _, _ := cc.Quotas.For(path)
+			usage, err := util.MeasureUsage(zkCli, path, quota)
+			if err != nil {
+				return fmt.Errorf("%v: %s", cc.Id(), err)
+			}
+			usages = append(usages, usage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
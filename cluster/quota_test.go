@@ -0,0 +1,89 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+)
+
+func TestQuotas(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		quotas := util.Quotas{electionPath: {MaxPayloadBytes: 512, MaxChildren: 2}}
+		newMember := func(data string) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.Quotas = quotas
+			return cc
+		}
+		startAndWait := func(cc *cluster.Coordinator, timeout time.Duration) error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			return cc.StartAndWait(ctx)
+		}
+
+		huge := newMember(strings.Repeat("x", 1024))
+		if err, ok := huge.Start().(util.QuotaError); !ok || err.Limit != "payload-bytes" {
+			huge.Stop()
+			t.Fatalf("Expected Start to fail with a payload quota error but actual=%#v", err)
+		}
+
+		cc1, cc2 := newMember("cc1"), newMember("cc2")
+		for _, cc := range []*cluster.Coordinator{cc1, cc2} {
+			if err := startAndWait(cc, 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer cc1.Stop()
+
+		if err, ok := cc1.SetData(strings.Repeat("x", 1024)).(util.QuotaError); !ok || err.Limit != "payload-bytes" {
+			t.Errorf("Expected SetData to fail with a payload quota error but actual=%#v", err)
+		}
+
+		// No room for a third member until another departs.
+		cc3 := newMember("cc3")
+		if err := startAndWait(cc3, 1*time.Second); err == nil {
+			cc3.Stop()
+			t.Fatalf("Expected a third member to be kept out by the children quota")
+		}
+		if err := cc3.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cc3.Stop()
+		if err := cc2.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for joined := false; !joined; {
+			nodes, err := cc1.Members()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, node := range nodes {
+				joined = joined || node.Uuid == cc3.LocalNode.Uuid
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for the third member to join once there was room, members=%+v", nodes)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		usages, err := cc1.Usage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(usages) != 1 || usages[0].Path != electionPath || usages[0].MaxChildren != 2 || usages[0].Children < 2 || usages[0].LargestPayload == 0 {
+			t.Errorf("Expected the usage of path=%v but actual=%+v", electionPath, usages)
+		}
+		if rejections := cc1.Stats().QuotaRejections; rejections < 1 {
+			t.Errorf("Expected QuotaRejections>=1 but actual=%v", rejections)
+		}
+	})
+}
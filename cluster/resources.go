@@ -0,0 +1,12 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// Resources returns an account of the watches, background goroutines and
+// ephemeral zNodes the Coordinator currently holds.  Once Stop has returned
+// it should become empty shortly, see testutil.CheckLeaks.
+func (cc *Coordinator) Resources() primitives.Resources {
+	return cc.resources.Resources()
+}
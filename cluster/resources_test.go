@@ -0,0 +1,65 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestResources(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, "resources")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.WatchdogTimeout = 1 * time.Second
+		checkLeaks := testutil.CheckLeaks(t, cc)
+
+		if resources := cc.Resources(); !resources.Empty() {
+			t.Errorf("Expected no resources before Start but actual=%v", resources)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// Exercise the asynchronous membership listing, too.
+		if _, err := cc.Members(); err != nil {
+			t.Fatal(err)
+		}
+
+		resources := cc.Resources()
+		t.Logf("resources=%v", resources)
+		for _, expected := range []string{"election-loop", "watchdog"} {
+			if !containsString(resources.Goroutines, expected) {
+				t.Errorf("Expected goroutine=%v among goroutines=%v", expected, resources.Goroutines)
+			}
+		}
+		if len(resources.Watches) == 0 {
+			t.Errorf("Expected the candidates to be watched")
+		}
+		if len(resources.ZNodes) != 1 || !strings.HasPrefix(resources.ZNodes[0], electionPath+"/") {
+			t.Errorf("Expected the local candidate zNode to be owned but actual=%v", resources.ZNodes)
+		}
+
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		checkLeaks()
+	})
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Serving roles, derived from election position for steering read traffic in
+// read-replica topologies, see Coordinator.ServingRole.
+const (
+	ServingLeader        = "leader"         // Serves writes and consistent reads.
+	ServingSyncFollower  = "sync-follower"  // Among the SyncFollowers next in line, so the most up-to-date replicas.
+	ServingAsyncFollower = "async-follower" // May lag, e.g. for reads which tolerate staleness.
+)
+
+var (
+	DefaultSyncFollowers = 1
+
+	NoLeaderError = errors.New("no leader has been elected")
+)
+
+// Follower is a non-leader member of the election.
+type Follower struct {
+	Node     primitives.Node `json:"node"`
+	ZNode    string          `json:"zNode"` // Candidate zNode name.
+	Sequence int64           `json:"sequence"`
+	Role     string          `json:"role"`
+}
+
+// Followers returns the members other than the leader, ordered by election
+// sequence so the first is next in line, along with their serving roles.
+// Witnesses are omitted as they don't serve.
+func (cc *Coordinator) Followers() (followers []Follower, err error) {
+	err = cc.request(func(zkCli *zk.Conn) (err error) {
+		followers, err = cc.followers(zkCli)
+		return
+	})
+	return
+}
+
+func (cc *Coordinator) followers(zkCli *zk.Conn) ([]Follower, error) {
+	cc.leaderLock.Lock()
+	leaderZNode := cc.leaderZNode
+	cc.leaderLock.Unlock()
+	if leaderZNode == "" {
+		return nil, NoLeaderError
+	}
+
+	candidatesPath := cc.candidatesPath()
+	since := time.Now()
+	children, _, err := zkCli.Children(candidatesPath)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		leader     = path.Base(leaderZNode)
+		candidates = []string{}
+		zNodes     = []string{}
+	)
+	for _, child := range cc.PathLayout.SortedCandidates(children) {
+		if child != leader {
+			candidates = append(candidates, child)
+			zNodes = append(zNodes, candidatesPath+"/"+child)
+		}
+	}
+
+	followers := []Follower{}
+	for i, result := range cc.getAll(zkCli, zNodes, since) {
+		if result.err == zk.ErrNoNode {
+			continue // Departed meanwhile.
+		} else if result.err != nil {
+			return nil, result.err
+		}
+		node, err := cc.decodeNode(zkCli, result.data)
+		if err != nil {
+			return nil, err
+		}
+		if IsWitness(node) {
+			continue
+		}
+		sequence, _ := util.SequenceNumber(candidates[i])
+		follower := Follower{
+			Node:     node,
+			ZNode:    candidates[i],
+			Sequence: sequence,
+			Role:     cc.followerRole(len(followers)),
+		}
+		followers = append(followers, follower)
+	}
+	return followers, nil
+}
+
+// ServingRole returns the local member's serving role as of the most recent
+// election, or "" when no leader is known or the member is a witness.
+func (cc *Coordinator) ServingRole() string {
+	cc.leaderLock.Lock()
+	defer cc.leaderLock.Unlock()
+	if cc.leaderNode == nil || cc.Witness {
+		return ""
+	}
+	if cc.mode() == primitives.Leader {
+		return ServingLeader
+	}
+	return cc.followerRole(cc.followerRank)
+}
+
+// followerRank returns the position of local among the followers, witnesses
+// not counted, given the candidates and the elected leader.
+func followerRank(layout PathLayout, children []string, members map[string]primitives.Node, leader string, local string) int {
+	rank := 0
+	for _, child := range layout.SortedCandidates(children) {
+		if child == local {
+			break
+		}
+		if child != leader && !IsWitness(members[child]) {
+			rank++
+		}
+	}
+	return rank
+}
+
+func (cc *Coordinator) followerRole(rank int) string {
+	if rank < cc.syncFollowers() {
+		return ServingSyncFollower
+	}
+	return ServingAsyncFollower
+}
+
+func (cc *Coordinator) syncFollowers() int {
+	if cc.SyncFollowers > 0 {
+		return cc.SyncFollowers
+	}
+	return DefaultSyncFollowers
+}
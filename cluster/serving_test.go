@@ -0,0 +1,75 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestServingRoles(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+
+		var members []*cluster.Coordinator
+		defer func() {
+			for _, cc := range members {
+				if err := cc.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		// Started one at a time so the election sequence follows this order.
+		for _, name := range []string{"a", "b", "witness", "c"} {
+			var (
+				cc  *cluster.Coordinator
+				err error
+			)
+			if name == "witness" {
+				cc, err = cluster.NewWitness(zkServers, zkTimeout, electionPath)
+			} else {
+				cc, err = cluster.NewCoordinator(zkServers, zkTimeout, electionPath, name)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.SyncFollowers = 1
+			if err := cc.Start(); err != nil {
+				t.Fatal(err)
+			}
+			members = append(members, cc)
+			waitForAgreement(t, members)
+		}
+
+		expected := []string{cluster.ServingLeader, cluster.ServingSyncFollower, "", cluster.ServingAsyncFollower}
+		deadline := time.Now().Add(5 * time.Second)
+		for i, cc := range members {
+			for cc.ServingRole() != expected[i] && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if role := cc.ServingRole(); role != expected[i] {
+				t.Errorf("Expected member #%v to have serving role=%q but actual=%q", i, expected[i], role)
+			}
+		}
+
+		followers, err := members[0].Followers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(followers) != 2 {
+			t.Fatalf("Expected 2 followers, the witness omitted, but actual=%+v", followers)
+		}
+		for i, j := range []int{1, 3} {
+			if followers[i].Node.Uuid != members[j].LocalNode.Uuid || followers[i].Role != expected[j] {
+				t.Errorf("Expected follower #%v to be member #%v with role=%v but actual=%+v", i, j, expected[j], followers[i])
+			}
+		}
+		if followers[0].Sequence >= followers[1].Sequence {
+			t.Errorf("Expected followers ordered by election sequence but actual=%+v", followers)
+		}
+		if status := members[1].Status(); status.ServingRole != cluster.ServingSyncFollower {
+			t.Errorf("Expected status serving role=%v but actual=%q", cluster.ServingSyncFollower, status.ServingRole)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	SessionEventsChanSize = 10
+)
+
+// SessionEvents returns a channel which receives the underlying ZooKeeper
+// session events (zk.EventSession with states such as zk.StateHasSession,
+// zk.StateDisconnected and zk.StateExpired), so applications can tie their own
+// resources to the session lifecycle.
+//
+// The channel remains registered across Stop() and Start() until it is passed
+// to StopSessionEvents.  Delivery never blocks the Coordinator; events are
+// dropped when the channel is full.
+func (cc *Coordinator) SessionEvents() <-chan zk.Event {
+	ch := make(chan zk.Event, SessionEventsChanSize)
+	cc.sessionSubscribersLock.Lock()
+	cc.sessionSubscribers = append(cc.sessionSubscribers, ch)
+	cc.sessionSubscribersLock.Unlock()
+	return ch
+}
+
+// StopSessionEvents unregisters and closes a channel obtained from
+// SessionEvents.
+func (cc *Coordinator) StopSessionEvents(ch <-chan zk.Event) {
+	cc.sessionSubscribersLock.Lock()
+	defer cc.sessionSubscribersLock.Unlock()
+
+	revised := make([]chan zk.Event, 0, len(cc.sessionSubscribers))
+	for _, sub := range cc.sessionSubscribers {
+		if sub == ch {
+			close(sub)
+			continue
+		}
+		revised = append(revised, sub)
+	}
+	cc.sessionSubscribers = revised
+}
+
+func (cc *Coordinator) notifySessionSubscribers(ev zk.Event) {
+	cc.sessionSubscribersLock.Lock()
+	defer cc.sessionSubscribersLock.Unlock()
+
+	for _, sub := range cc.sessionSubscribers {
+		select {
+		case sub <- ev:
+		default:
+			log.Warnf("%v: session events chan full, dropped event=%+v", cc.Id(), ev)
+		}
+	}
+}
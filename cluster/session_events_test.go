@@ -0,0 +1,48 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestSessionEvents(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc := ncc(t, zkServers, "session-events")
+		defer cc.Stop()
+
+		events := cc.SessionEvents()
+
+		// Restart so the session lifecycle is observed from the beginning.
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type != zk.EventSession {
+					t.Fatalf("Received non-session event=%+v", ev)
+				}
+				if ev.State == zk.StateHasSession {
+					cc.StopSessionEvents(events)
+					if _, ok := <-events; ok {
+						// Drain anything buffered prior to stopping.
+						for range events {
+						}
+					}
+					return
+				}
+			case <-deadline:
+				t.Fatalf("Timed out waiting for StateHasSession session event")
+			}
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Steps performed by Shutdown, in order.
+const (
+	ShutdownStopUpdates      = "stop-updates"      // The election loop exits, so subscribers receive no further updates.
+	ShutdownResignLeadership = "resign-leadership" // The leader hands off and withdraws its candidate zNode.
+	ShutdownDeleteEphemerals = "delete-ephemerals" // The remaining owned zNodes are deleted.
+	ShutdownCloseSession     = "close-session"     // The connection is closed.
+)
+
+// ShutdownError reports the Shutdown step which failed or was still underway
+// when the context was done.
+type ShutdownError struct {
+	Step string
+	Err  error
+}
+
+func (err ShutdownError) Error() string {
+	return fmt.Sprintf("shutdown step=%v failed: %s", err.Step, err.Err)
+}
+
+// Shutdown leaves the election like Stop(), but tears down in a defined order
+// (see the Shutdown* steps) so the remaining members observe an orderly
+// departure: updates cease, the leader resigns, owned zNodes are deleted, and
+// only then is the session closed.  Should a step fail or ctx be done before
+// completion, the remaining steps are skipped, the session is closed forcibly
+// and a ShutdownError naming the step is returned.  Either way the
+// Coordinator is stopped upon return.  Returns AlreadyStoppedError when not
+// running.
+//
+// As with Stop(), the leader's handoff (see HandoffDelay) is waited out while
+// updates are still delivered, and counts towards ShutdownResignLeadership.
+func (cc *Coordinator) Shutdown(ctx context.Context) error {
+	log.Infof("Coordinator Id=%v shutting down..", cc.Id())
+
+	// NB: The handoff happens before acquiring stateLock, since the handoff
+	// delay may be lengthy.
+	handOff := func(current uint64, zkCli *zk.Conn) { cc.handOff(zkCli, current, ctx.Done()) }
+	if err := cc.quiesce(0, handOff); err != nil {
+		return err
+	}
+	defer cc.stateLock.Unlock()
+
+	cc.record(HistoryStopped, "", "")
+	var (
+		failed  error
+		pending chan error // Of a step abandoned when ctx was done.
+		run     = func(step string, fn func() error) {
+			if failed != nil {
+				return
+			}
+			if err := ctx.Err(); err != nil {
+				failed = ShutdownError{Step: step, Err: err}
+				return
+			}
+			errCh := make(chan error, 1)
+			cc.resources.Go("shutdown-"+step, func() { errCh <- fn() })
+			select {
+			case err := <-errCh:
+				if err != nil {
+					failed = ShutdownError{Step: step, Err: err}
+				}
+			case <-ctx.Done():
+				failed = ShutdownError{Step: step, Err: ctx.Err()}
+				pending = errCh
+			}
+		}
+	)
+
+	if err := ctx.Err(); err != nil {
+		failed = ShutdownError{Step: ShutdownResignLeadership, Err: err} // Cut short while handing off.
+	}
+	close(cc.abortChan)
+	run(ShutdownStopUpdates, func() error {
+		<-cc.loopDoneChan
+		return nil
+	})
+	run(ShutdownResignLeadership, func() error {
+		if cc.Mode() != primitives.Leader {
+			return nil
+		}
+		return cc.withdrawCandidate()
+	})
+	run(ShutdownDeleteEphemerals, func() error {
+		if err := cc.withdrawCandidate(); err != nil {
+			return err
+		}
+		for _, zNode := range cc.resources.Resources().ZNodes {
+			if err := cc.zkCli.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+				return fmt.Errorf("deleting zNode=%v: %s", zNode, err)
+			}
+			cc.resources.Disown(zNode)
+		}
+		return nil
+	})
+
+	if failed != nil {
+		log.Warnf("Coordinator Id=%v forcibly closing session: %s", cc.Id(), failed)
+	}
+	cc.stopHistorySink() // NB: Before closing so sinks may still use the connection.
+	// NB: Closing the connection unblocks whatever was abandoned.
+	cc.zkCli.Close()
+	<-cc.loopDoneChan
+	if pending != nil {
+		<-pending
+	}
+	cc.release()
+
+	if failed != nil {
+		return failed
+	}
+	log.Infof("Coordinator Id=%v shut down", cc.Id())
+	return nil
+}
+
+// withdrawCandidate leaves a tombstone and then deletes the local candidate
+// zNode, rather than leaving the deletion to the session closing.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) withdrawCandidate() error {
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	localNode := cc.inlineNode(cc.LocalNode)
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return nil
+	}
+	cc.leaveTombstone()
+	if err := cc.zkCli.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("deleting candidate zNode=%v: %s", zNode, err)
+	}
+	cc.resources.Disown(zNode)
+	cc.withdrawBlob(cc.zkCli, localNode) // NB: Only after the candidate zNode referencing it is gone.
+	cc.advertiseLeadership(cc.zkCli, zNode, nil, false)
+	cc.leaderLock.Lock()
+	cc.zNode = ""
+	cc.leaderLock.Unlock()
+	return nil
+}
@@ -0,0 +1,107 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestShutdown(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		start := func(data string, subscribers ...chan primitives.Update) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data, subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.HandoffDelay = 100 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+
+		var (
+			leader   = start("leader")
+			updates  = make(chan primitives.Update, 100)
+			follower = start("follower", updates)
+		)
+		defer follower.Stop()
+		checkLeaks := testutil.CheckLeaks(t, leader)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := leader.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := leader.Shutdown(ctx); err != cluster.AlreadyStoppedError {
+			t.Errorf("Expected shutting down again to return err=%v but actual=%v", cluster.AlreadyStoppedError, err)
+		}
+		checkLeaks()
+
+		for {
+			select {
+			case update := <-updates:
+				for _, delta := range update.Deltas {
+					if !delta.Joined && delta.Reason != primitives.DepartureGraceful {
+						t.Errorf("Expected the departure to be graceful but actual=%+v", delta)
+					}
+				}
+				if update.Mode == primitives.Leader {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatal("Timed out waiting for the follower to take over")
+			}
+		}
+	})
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		var members []*cluster.Coordinator
+		for _, data := range []string{"leader", "follower"} {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data == "leader" {
+				cc.HandoffDelay = 1 * time.Minute // Outlasts the deadline.
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			members = append(members, cc)
+		}
+		defer members[1].Stop()
+		leader := members[0]
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		err := leader.Shutdown(ctx)
+		shutdownErr, ok := err.(cluster.ShutdownError)
+		if !ok {
+			t.Fatalf("Expected a ShutdownError but actual=%v", err)
+		}
+		if shutdownErr.Step != cluster.ShutdownResignLeadership || shutdownErr.Err != context.DeadlineExceeded {
+			t.Errorf("Expected step=%v to fail with err=%v but actual=%+v", cluster.ShutdownResignLeadership, context.DeadlineExceeded, shutdownErr)
+		}
+		if elapsed := time.Since(started); elapsed > 2*time.Second {
+			t.Errorf("Expected the deadline to be honored but shutting down took %s", elapsed)
+		}
+		if leader.Conn() != nil {
+			t.Errorf("Expected the leader to be stopped despite the failure")
+		}
+	})
+}
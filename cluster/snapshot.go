@@ -0,0 +1,25 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// snapshotMembers returns the members as of the last checkLeader in order of
+// succession, for inclusion in updates when UpdateSnapshots is set.
+func (cc *Coordinator) snapshotMembers(members map[string]primitives.Node) []primitives.Node {
+	children := make([]string, 0, len(members))
+	for child := range members {
+		children = append(children, child)
+	}
+	snapshot := make([]primitives.Node, 0, len(children))
+	for _, child := range cc.PathLayout.SortedCandidates(children) {
+		node, err := cc.resolveBlob(cc.zkCli, members[child])
+		if err != nil {
+			log.Warnf("%v: resolving data of member=%v for snapshot: %s", cc.Id(), child, err)
+		}
+		snapshot = append(snapshot, node)
+	}
+	return snapshot
+}
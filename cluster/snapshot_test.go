@@ -0,0 +1,70 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestUpdateSnapshots(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			updates      = make(chan primitives.Update, 10)
+			members      = []*cluster.Coordinator{}
+		)
+		for i, data := range []string{"first", "second"} {
+			var subscribers []chan primitives.Update
+			if i == 0 {
+				subscribers = append(subscribers, updates)
+			}
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data, subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.UpdateSnapshots = true
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func(cc *cluster.Coordinator) {
+				if err := cc.Stop(); err != nil {
+					t.Error(err)
+				}
+			}(cc)
+			members = append(members, cc)
+		}
+
+		// The first member is notified of the second joining, along with a
+		// snapshot listing both in order of succession.
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-updates:
+				if update.Members == nil {
+					t.Fatalf("Expected update=%+v to carry a member snapshot", update)
+				}
+				if len(update.Members) != 2 {
+					continue
+				}
+				for i, member := range members {
+					if expected, actual := member.LocalNode.Uuid, update.Members[i].Uuid; actual != expected {
+						t.Errorf("Expected Members[%v].Uuid=%v but actual=%v", i, expected, actual)
+					}
+				}
+				if update.Leader.Uuid != update.Members[0].Uuid {
+					t.Errorf("Expected leader=%v to be listed first, but Members=%+v", update.Leader.Uuid, update.Members)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("Timed out waiting for an update listing both members")
+			}
+		}
+	})
+}
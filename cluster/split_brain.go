@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const splitBrainViewsDir = "views"
+
+// viewsPath returns the scratch path under which members publish their leader
+// views when split-brain detection is enabled.
+func (cc *Coordinator) viewsPath() string {
+	return cc.leaderElectionPath + "/" + splitBrainViewsDir
+}
+
+// publishLeaderView records the local member's current view of the leader in
+// an ephemeral scratch zNode.
+//
+// Must only be invoked from the election loop.
+func (cc *Coordinator) publishLeaderView() error {
+	cc.leaderLock.Lock()
+	view := primitives.LeaderView{
+		Member:      cc.LocalNode.Uuid.String(),
+		LeaderZNode: cc.leaderZNode,
+		At:          cc.clock().Now(),
+	}
+	cc.leaderLock.Unlock()
+
+	data, err := json.Marshal(&view)
+	if err != nil {
+		return fmt.Errorf("encoding leader view: %s", err)
+	}
+	zNode := cc.viewsPath() + "/" + view.Member
+	if _, err := cc.zkCli.Set(zNode, data, -1); err == zk.ErrNoNode {
+		if _, err := cc.zkCli.Create(cc.viewsPath(), []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+			return fmt.Errorf("creating views path=%v: %s", cc.viewsPath(), err)
+		}
+		if _, err := cc.zkCli.Create(zNode, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+			return fmt.Errorf("creating leader view zNode=%v: %s", zNode, err)
+		}
+		cc.resources.Own(zNode)
+	} else if err != nil {
+		return fmt.Errorf("updating leader view zNode=%v: %s", zNode, err)
+	}
+	return nil
+}
+
+// detectSplitBrain compares every member's published view against the local
+// one, and returns the views which disagree.  Only meaningful on the leader.
+//
+// Must only be invoked from the election loop.
+func (cc *Coordinator) detectSplitBrain() ([]primitives.LeaderView, error) {
+	cc.leaderLock.Lock()
+	expected := cc.leaderZNode
+	cc.leaderLock.Unlock()
+
+	children, _, err := cc.zkCli.Children(cc.viewsPath())
+	if err != nil {
+		return nil, fmt.Errorf("listing leader views: %s", err)
+	}
+	disagreeing := []primitives.LeaderView{}
+	for _, child := range children {
+		data, _, err := cc.zkCli.Get(cc.viewsPath() + "/" + child)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("getting leader view for member=%v: %s", child, err)
+		}
+		var view primitives.LeaderView
+		if err := json.Unmarshal(data, &view); err != nil {
+			return nil, fmt.Errorf("decoding leader view for member=%v: %s", child, err)
+		}
+		if view.LeaderZNode != expected {
+			disagreeing = append(disagreeing, view)
+		}
+	}
+	return disagreeing, nil
+}
@@ -0,0 +1,92 @@
+package cluster_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestSplitBrainDetection(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			updates      = make(chan primitives.Update, 100)
+			ccs          = make([]*cluster.Coordinator, 2)
+		)
+		for i := range ccs {
+			var subscribers []chan primitives.Update
+			if i == 0 {
+				subscribers = append(subscribers, updates)
+			}
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i), subscribers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.SplitBrainCheckInterval = 100 * time.Millisecond
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs[i] = cc
+		}
+		defer func() {
+			for _, cc := range ccs {
+				if err := cc.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+
+		// Members which agree must not trigger detection.
+		timeout := time.After(500 * time.Millisecond)
+	Agreeing:
+		for {
+			select {
+			case update := <-updates:
+				if update.Type == primitives.SplitBrainUpdate {
+					t.Fatalf("Unexpected split-brain update while members agree: %+v", update)
+				}
+			case <-timeout:
+				break Agreeing
+			}
+		}
+
+		// Inject a member view which disagrees.
+		conn, _, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		view := primitives.LeaderView{Member: "rogue", LeaderZNode: electionPath + "/bogus", At: time.Now()}
+		data, _ := json.Marshal(&view)
+		if _, err := conn.Create(electionPath+"/views/rogue", data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+			t.Fatal(err)
+		}
+
+		timeout = time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-updates:
+				if update.Type != primitives.SplitBrainUpdate {
+					continue
+				}
+				if len(update.Disagreeing) != 1 || update.Disagreeing[0].Member != "rogue" {
+					t.Fatalf("Expected exactly the rogue view to disagree, but disagreeing=%+v", update.Disagreeing)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("Timed out waiting for split-brain update")
+			}
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package cluster_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestConcurrentStartStop(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "start-stop")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					if (i+j)%2 == 0 {
+						if err := cc.Start(); err != nil && err != cluster.AlreadyStartedError {
+							t.Errorf("Unexpected Start error: %s", err)
+						}
+					} else if err := cc.Stop(); err != nil && err != cluster.AlreadyStoppedError {
+						t.Errorf("Unexpected Stop error: %s", err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// Whatever state the races left behind, the Coordinator remains usable.
+		if err := cc.Stop(); err != nil && err != cluster.AlreadyStoppedError {
+			t.Fatal(err)
+		}
+		if err := cc.Stop(); err != cluster.AlreadyStoppedError {
+			t.Errorf("Expected err=%s but actual err=%v", cluster.AlreadyStoppedError, err)
+		}
+		if err := cc.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.Start(); err != cluster.AlreadyStartedError {
+			t.Errorf("Expected err=%s but actual err=%v", cluster.AlreadyStartedError, err)
+		}
+		waitForAgreement(t, []*cluster.Coordinator{cc})
+		if leader := cc.Leader(); leader == nil || leader.Uuid != cc.LocalNode.Uuid {
+			t.Errorf("Expected sole member to lead after restarts, but leader=%v", leader)
+		}
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestConcurrentStopsAwaitHandoff(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		ccs := []*cluster.Coordinator{}
+		for i := 0; i < 2; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.HandoffDelay = 500 * time.Millisecond
+			if err := cc.Start(); err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			if err := ccs[1].Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, ccs)
+
+		// Neither Stop may tear down before the handoff delay has elapsed.
+		var (
+			wg      sync.WaitGroup
+			started = time.Now()
+		)
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := ccs[0].Stop(); err != nil && err != cluster.AlreadyStoppedError {
+					t.Errorf("Unexpected Stop error: %s", err)
+				}
+				if elapsed := time.Since(started); elapsed < ccs[0].HandoffDelay {
+					t.Errorf("Expected Stop to await the handoff delay=%s but it returned after %s", ccs[0].HandoffDelay, elapsed)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
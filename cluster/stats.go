@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"time"
+)
+
+// Stats are cumulative counters describing a Coordinator's lifetime, for
+// applications exporting them via their own telemetry systems.
+type Stats struct {
+	ElectionsJoined    int64         `json:"electionsJoined"`    // Candidate zNodes created.
+	TimesLed           int64         `json:"timesLed"`           // Leadership acquisitions.
+	LeadershipDuration time.Duration `json:"leadershipDuration"` // Total time spent leading, including the current term.
+	UpdatesEmitted     int64         `json:"updatesEmitted"`     // Updates broadcast to subscribers.
+	Reconnects         int64         `json:"reconnects"`         // Sessions (re-)established after the first.
+	LastSessionId      int64         `json:"lastSessionId"`
+	MembersEvicted     int64         `json:"membersEvicted"`  // Members evicted by the local member for stale heartbeats.
+	QuotaRejections    int64         `json:"quotaRejections"` // Writes and joins refused for exceeding Quotas.
+
+	// Only counted when BatchReads is set.
+	ReadsIssued    int64 `json:"readsIssued"`    // Reads sent to ZooKeeper.
+	ReadsCoalesced int64 `json:"readsCoalesced"` // Reads which joined an identical one already in flight.
+}
+
+// Stats returns a snapshot of the Coordinator's counters.
+func (cc *Coordinator) Stats() Stats {
+	cc.statsLock.Lock()
+	defer cc.statsLock.Unlock()
+
+	stats := cc.stats
+	if !cc.leadingSince.IsZero() {
+		stats.LeadershipDuration += cc.clock().Since(cc.leadingSince)
+	}
+	return stats
+}
+
+// countStat applies fn to the counters.
+func (cc *Coordinator) countStat(fn func(stats *Stats)) {
+	cc.statsLock.Lock()
+	fn(&cc.stats)
+	cc.statsLock.Unlock()
+}
+
+// countLeadership tracks leadership terms as leading changes.
+func (cc *Coordinator) countLeadership(leading bool) {
+	cc.statsLock.Lock()
+	defer cc.statsLock.Unlock()
+
+	if leading && cc.leadingSince.IsZero() {
+		cc.stats.TimesLed++
+		cc.leadingSince = cc.clock().Now()
+	} else if !leading && !cc.leadingSince.IsZero() {
+		cc.stats.LeadershipDuration += cc.clock().Since(cc.leadingSince)
+		cc.leadingSince = time.Time{}
+	}
+}
@@ -0,0 +1,58 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestStats(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "stats")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for cc.Stats().TimesLed == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		stats := cc.Stats()
+		if stats.ElectionsJoined != 1 {
+			t.Errorf("Expected ElectionsJoined=1 but actual=%v", stats.ElectionsJoined)
+		}
+		if stats.TimesLed != 1 {
+			t.Errorf("Expected TimesLed=1 but actual=%v", stats.TimesLed)
+		}
+		if stats.LeadershipDuration < 50*time.Millisecond {
+			t.Errorf("Expected LeadershipDuration to include the current term, but actual=%v", stats.LeadershipDuration)
+		}
+		if stats.UpdatesEmitted == 0 {
+			t.Errorf("Expected UpdatesEmitted > 0")
+		}
+		if stats.Reconnects != 0 {
+			t.Errorf("Expected Reconnects=0 but actual=%v", stats.Reconnects)
+		}
+		if stats.LastSessionId == 0 || stats.LastSessionId != cc.Conn().SessionID() {
+			t.Errorf("Expected LastSessionId=%v but actual=%v", cc.Conn().SessionID(), stats.LastSessionId)
+		}
+
+		if err := cc.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		stopped := cc.Stats()
+		time.Sleep(20 * time.Millisecond)
+		if duration := cc.Stats().LeadershipDuration; duration != stopped.LeadershipDuration {
+			t.Errorf("Expected LeadershipDuration to stop accruing once stopped, but %v became %v", stopped.LeadershipDuration, duration)
+		}
+	})
+}
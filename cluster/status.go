@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+)
+
+// Status is a point-in-time summary of a Coordinator's state.
+type Status struct {
+	Id           string            `json:"id"`
+	LocalNode    primitives.Node   `json:"localNode"`
+	ElectionPath string            `json:"electionPath"`
+	Running      bool              `json:"running"`
+	SessionState string            `json:"sessionState"`
+	SessionId    int64             `json:"sessionId,omitempty"`
+	ZNode        string            `json:"zNode,omitempty"`
+	Mode         string            `json:"mode"`
+	ServingRole  string            `json:"servingRole,omitempty"`
+	Leader       *primitives.Node  `json:"leader"`
+	Capabilities util.Capabilities `json:"capabilities"`
+}
+
+// Status returns a summary of the Coordinator's current state.
+func (cc *Coordinator) Status() Status {
+	status := Status{
+		Id:           cc.Id(),
+		ElectionPath: cc.leaderElectionPath,
+		SessionState: "disconnected",
+		Mode:         cc.Mode(),
+		ServingRole:  cc.ServingRole(),
+		Leader:       cc.Leader(),
+		Capabilities: cc.Capabilities(),
+	}
+
+	if zkCli := cc.Conn(); zkCli != nil {
+		status.Running = true
+		status.SessionState = zkCli.State().String()
+		status.SessionId = zkCli.SessionID()
+	}
+
+	cc.leaderLock.Lock()
+	status.LocalNode = cc.LocalNode
+	status.ZNode = cc.zNode
+	cc.leaderLock.Unlock()
+
+	return status
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Sticky leadership is negotiated via two non-sequential zNodes beside the
+// candidates:
+//
+//	handback       - Created (ephemeral) by a recently deposed leader which has
+//	                 rejoined, asking for its leadership back.
+//	handback-grant - Created (ephemeral) by the interim leader when it agrees,
+//	                 naming the requester as leader.
+//
+// Every member applies the same rule: a grant overrides the lowest candidate
+// as leader as long as the granting member is still the lowest candidate and
+// the named requester is still a candidate.  Should either depart the grant
+// lapses and ordinary ordering resumes.
+const (
+	handbackZNodeName      = "handback"
+	handbackGrantZNodeName = "handback-grant"
+)
+
+type handbackRequest struct {
+	Requester string `json:"requester"` // Candidate zNode name of the deposed leader.
+}
+
+type handbackGrant struct {
+	GrantedBy string `json:"grantedBy"` // Candidate zNode name of the interim leader.
+	Leader    string `json:"leader"`    // Candidate zNode name of the requester.
+}
+
+// stickyState is the election loop's record of leadership transitions.
+type stickyState struct {
+	lastMode    string
+	deposedAt   time.Time // When the local member last lost leadership.
+	leaderSince time.Time // When the local member last gained leadership.
+	requested   bool      // Whether the local member has an outstanding request.
+}
+
+// effectiveLeader returns the candidate name which leads given the lowest
+// candidate, taking any handback grant into account.
+func (cc *Coordinator) effectiveLeader(zkCli *zk.Conn, children []string, lowest string) (leader string, grant *handbackGrant) {
+	if cc.StickyWindow <= 0 || !containsString(children, handbackGrantZNodeName) {
+		return lowest, nil
+	}
+	data, _, err := zkCli.Get(cc.candidatesPath() + "/" + handbackGrantZNodeName)
+	if err != nil {
+		if err != zk.ErrNoNode {
+			log.Warnf("%v: reading handback grant: %s", cc.Id(), err)
+		}
+		return lowest, nil
+	}
+	grant = &handbackGrant{}
+	if err := json.Unmarshal(data, grant); err != nil {
+		log.Warnf("%v: ignoring undecodable handback grant: %s", cc.Id(), err)
+		return lowest, nil
+	}
+	if grant.GrantedBy == lowest && containsString(children, grant.Leader) {
+		return grant.Leader, grant
+	}
+	return lowest, grant
+}
+
+// stickyTransition tracks leadership gains and losses of the local member.
+// Handing leadership back does not count as being deposed.
+func (cc *Coordinator) stickyTransition(state *stickyState, mode string, lowest string, leader string) {
+	if mode == state.lastMode {
+		return
+	}
+	cc.leaderLock.Lock()
+	handedBack := lowest == path.Base(cc.zNode) && leader != lowest
+	cc.leaderLock.Unlock()
+
+	if mode == primitives.Leader {
+		state.leaderSince = cc.clock().Now()
+	} else if state.lastMode == primitives.Leader && !handedBack {
+		state.deposedAt = cc.clock().Now()
+	}
+	state.lastMode = mode
+}
+
+// negotiateHandback performs the local member's part of the handback
+// protocol: requesting leadership back when recently deposed, granting
+// requests as the interim leader, and cleaning up afterwards.
+func (cc *Coordinator) negotiateHandback(zkCli *zk.Conn, state *stickyState, children []string, lowest string, leader string, grant *handbackGrant) {
+	if cc.StickyWindow <= 0 {
+		return
+	}
+	cc.leaderLock.Lock()
+	local := path.Base(cc.zNode)
+	cc.leaderLock.Unlock()
+
+	var (
+		candidatesPath = cc.candidatesPath()
+		haveRequest    = containsString(children, handbackZNodeName)
+		withinWindow   = func(t time.Time) bool { return !t.IsZero() && cc.clock().Since(t) <= cc.StickyWindow }
+	)
+
+	switch {
+	case leader == local && grant != nil && grant.Leader == local:
+		// Leadership was handed back, the request has served its purpose.
+		state.requested = false
+		if haveRequest {
+			cc.deleteOwned(zkCli, candidatesPath+"/"+handbackZNodeName)
+		}
+
+	case leader != local && withinWindow(state.deposedAt) && !haveRequest && containsString(children, local):
+		data, _ := json.Marshal(handbackRequest{Requester: local})
+		if _, err := zkCli.Create(candidatesPath+"/"+handbackZNodeName, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+			log.Warnf("%v: requesting handback: %s", cc.Id(), err)
+			return
+		}
+		cc.resources.Own(candidatesPath + "/" + handbackZNodeName)
+		state.requested = true
+		log.Infof("%v: recently deposed, requested leadership back from leader=%v", cc.Id(), leader)
+
+	case state.requested && !withinWindow(state.deposedAt):
+		// The window has passed without the request being granted.
+		state.requested = false
+		if haveRequest {
+			cc.deleteOwned(zkCli, candidatesPath+"/"+handbackZNodeName)
+		}
+
+	case lowest == local && leader == local && haveRequest:
+		cc.considerHandback(zkCli, state, candidatesPath, local, children, grant)
+	}
+
+	if grant != nil && grant.GrantedBy == local && !containsString(children, grant.Leader) {
+		// The requester departed, reclaim leadership.
+		cc.deleteOwned(zkCli, candidatesPath+"/"+handbackGrantZNodeName)
+	}
+}
+
+func (cc *Coordinator) considerHandback(zkCli *zk.Conn, state *stickyState, candidatesPath string, local string, children []string, grant *handbackGrant) {
+	data, _, err := zkCli.Get(candidatesPath + "/" + handbackZNodeName)
+	if err != nil {
+		return
+	}
+	var request handbackRequest
+	if err := json.Unmarshal(data, &request); err != nil || !containsString(children, request.Requester) {
+		return
+	}
+	if cc.clock().Since(state.leaderSince) > cc.StickyWindow {
+		log.Debugf("%v: declining handback to requester=%v, leader for longer than %s", cc.Id(), request.Requester, cc.StickyWindow)
+		return
+	}
+	if cc.AcceptHandback != nil {
+		requesterData, _, err := zkCli.Get(candidatesPath + "/" + request.Requester)
+		if err != nil {
+			return
+		}
+		requester, err := cc.codec().Decode(requesterData)
+		if err != nil || !cc.AcceptHandback(requester) {
+			log.Debugf("%v: declining handback to requester=%v", cc.Id(), request.Requester)
+			return
+		}
+	}
+	if grant != nil {
+		cc.deleteOwned(zkCli, candidatesPath+"/"+handbackGrantZNodeName)
+	}
+	data, _ = json.Marshal(handbackGrant{GrantedBy: local, Leader: request.Requester})
+	if _, err := zkCli.Create(candidatesPath+"/"+handbackGrantZNodeName, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+		log.Warnf("%v: granting handback to requester=%v: %s", cc.Id(), request.Requester, err)
+		return
+	}
+	cc.resources.Own(candidatesPath + "/" + handbackGrantZNodeName)
+	log.Infof("%v: handed leadership back to requester=%v", cc.Id(), request.Requester)
+	cc.record(HistoryHandback, request.Requester, "")
+}
+
+func (cc *Coordinator) deleteOwned(zkCli *zk.Conn, zNode string) {
+	if err := zkCli.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+		log.Warnf("%v: deleting %v: %s", cc.Id(), zNode, err)
+		return
+	}
+	cc.resources.Disown(zNode)
+}
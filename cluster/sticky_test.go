@@ -0,0 +1,77 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestStickyLeadership(t *testing.T) {
+	for _, accept := range []bool{true, false} {
+		testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+			proxy := newFlakyProxy(t, zkServers[0])
+			defer proxy.Close()
+
+			start := func(servers []string, data string) *cluster.Coordinator {
+				cc, err := cluster.NewCoordinator(servers, zkTimeout, testutil.Namespace(t), data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cc.StickyWindow = 10 * time.Second
+				cc.AcceptHandback = func(requester primitives.Node) bool {
+					return accept
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := cc.StartAndWait(ctx); err != nil {
+					t.Fatal(err)
+				}
+				return cc
+			}
+			waitForLeader := func(expected *cluster.Coordinator, members ...*cluster.Coordinator) {
+				deadline := time.Now().Add(10 * time.Second)
+				for {
+					agreed := expected.Mode() == primitives.Leader
+					for _, member := range members {
+						if leader := member.Leader(); leader == nil || leader.Uuid != expected.LocalNode.Uuid {
+							agreed = false
+						}
+					}
+					if agreed {
+						return
+					}
+					if time.Now().After(deadline) {
+						t.Fatalf("[accept=%v] Timed out waiting for members to agree on leader=%v", accept, expected.LocalNode)
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+
+			original := start([]string{proxy.Addr()}, "original")
+			defer original.Stop()
+			interim := start(zkServers, "interim")
+			defer interim.Stop()
+			waitForLeader(original, original, interim)
+
+			// Partition the original leader until its session expires.
+			proxy.SetDown(true)
+			waitForLeader(interim, interim)
+			proxy.SetDown(false)
+
+			if accept {
+				waitForLeader(original, original, interim)
+				return
+			}
+			waitForLeader(interim, original, interim)
+			// A declined request must not eventually flip leadership.
+			time.Sleep(500 * time.Millisecond)
+			if mode := interim.Mode(); mode != primitives.Leader {
+				t.Errorf("Expected interim to remain leader after declining, but mode=%v", mode)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// SwitchEnsemble migrates the running Coordinator to another ZooKeeper
+// ensemble without restarting it, e.g. while moving to a new ZooKeeper
+// cluster.  The new ensemble is probed first, and upon failure the Coordinator
+// remains on the current one.  Otherwise the local member leaves the election
+// on the current ensemble, carries the checkpoint over if it leads, and joins
+// the election on the new ensemble.
+//
+// Subscribers stay subscribed throughout, and the last known leader is kept
+// until the new ensemble's election has been processed, so they observe the
+// cutover as a single leader update rather than an interim loss of
+// leadership.
+//
+// Should connecting to the new ensemble fail after the current one has been
+// left, the Coordinator rejoins the election on the current one.  The
+// watchdog, if any, is paused for the duration of the switch.
+//
+// SwitchEnsemble blocks until the election on the new ensemble has been
+// joined.  If ctx is done first an error is returned, but the Coordinator
+// nonetheless remains on the new ensemble and keeps trying to join.
+func (cc *Coordinator) SwitchEnsemble(ctx context.Context, servers []string) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("%v: switching ensemble: no servers", cc.Id())
+	}
+	if _, err := resolveServers(servers); err != nil {
+		return fmt.Errorf("%v: switching ensemble: %s", cc.Id(), err)
+	}
+	zkCli := cc.Conn()
+	if zkCli == nil {
+		return errorlib.NotRunningError
+	}
+
+	probe, err := cc.probeEnsemble(ctx, servers)
+	if err != nil {
+		return fmt.Errorf("%v: switching ensemble to servers=%v: %s", cc.Id(), servers, err)
+	}
+	if err := cc.migrateCheckpoint(zkCli, probe); err != nil {
+		log.Warnf("%v: not carrying over checkpoint to servers=%v (non-fatal, will continue): %s", cc.Id(), servers, err)
+	}
+	probe.Close()
+
+	// NB: Pauses the watchdog so it can't restart on either ensemble midway.
+	if err := cc.quiesce(0, func(uint64, *zk.Conn) {}); err != nil {
+		return errorlib.NotRunningError
+	}
+	log.Infof("%v: switching ensemble to servers=%v", cc.Id(), servers)
+	cc.record(HistorySwitched, "", fmt.Sprint(servers))
+	cc.leaveTombstone() // NB: Before teardown, as in stop.
+	cc.withdrawRecord()
+	cc.withdrawLocalBlob()
+	cc.teardown()
+
+	cc.leaderLock.Lock()
+	cc.checkpointVersion = checkpointVersionUnloaded // Saves must build on the new ensemble's checkpoint.
+	cc.leaderLock.Unlock()
+
+	previous := cc.hostProvider.Configured()
+	joinedChan := make(chan struct{})
+	if _, err = cc.hostProvider.Update(servers); err == nil {
+		if err = cc.connectAndRun(joinedChan, false); err == nil {
+			cc.zkServers = append([]string{}, servers...)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("%v: switching ensemble to servers=%v: %s", cc.Id(), servers, err)
+		if rejoinErr := cc.rejoin(previous); rejoinErr != nil {
+			err = fmt.Errorf("%s (rejoining servers=%v also failed: %s)", err, previous, rejoinErr)
+		}
+	}
+	if cc.zkCli != nil {
+		cc.startWatchdog()
+	}
+	cc.stateLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-joinedChan:
+		log.Infof("%v: switched ensemble to servers=%v", cc.Id(), servers)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%v: joining election on servers=%v: %s", cc.Id(), servers, ctx.Err())
+	}
+}
+
+// rejoin reconnects to the servers of the ensemble left by a failed switch.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) rejoin(servers []string) error {
+	if len(servers) == 0 {
+		servers = cc.zkServers
+	}
+	if _, err := cc.hostProvider.Update(servers); err != nil {
+		return err
+	}
+	log.Warnf("%v: rejoining election on servers=%v after failed switch", cc.Id(), servers)
+	return cc.connectAndRun(make(chan struct{}), false)
+}
+
+// probeEnsemble returns a connection to servers once it has a session.
+func (cc *Coordinator) probeEnsemble(ctx context.Context, servers []string) (*zk.Conn, error) {
+	probe, eventCh, err := zk.Connect(servers, cc.sessionTimeout)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case ev := <-eventCh:
+			if ev.Type == zk.EventSession && ev.State == zk.StateHasSession {
+				return probe, nil
+			}
+		case <-ctx.Done():
+			probe.Close()
+			return nil, fmt.Errorf("waiting for a session: %s", ctx.Err())
+		}
+	}
+}
+
+// migrateCheckpoint copies the checkpoint from the current ensemble to the
+// new one when the local member leads, unless the new ensemble already has
+// one.
+func (cc *Coordinator) migrateCheckpoint(from *zk.Conn, to *zk.Conn) error {
+	if cc.Mode() != primitives.Leader {
+		return nil
+	}
+	zNode := cc.candidatesPath() + "/" + checkpointZNodeName
+	data, _, err := from.Get(zNode)
+	if err == zk.ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// NB: Without ContainerPaths, as the new ensemble's capabilities are unknown.
+	if _, err := util.EnsurePath(to, cc.candidatesPath(), util.EnsurePathOptions{ACL: cc.PathACL}); err != nil {
+		return err
+	}
+	if _, err := to.Create(zNode, data, 0, zk.WorldACL(zk.PermAll)); err == zk.ErrNodeExists {
+		log.Infof("%v: keeping the checkpoint already present on the new ensemble", cc.Id())
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestSwitchEnsemble(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(oldServers []string) {
+		electionPath := testutil.Namespace(t)
+		cc, err := cluster.NewCoordinator(oldServers, zkTimeout, electionPath, "switch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := cc.SwitchEnsemble(ctx, oldServers); err != errorlib.NotRunningError {
+			t.Errorf("Expected NotRunningError before Start but actual=%v", err)
+		}
+
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil && err != cluster.AlreadyStoppedError {
+				t.Error(err)
+			}
+		}()
+		if err := cc.SwitchEnsemble(ctx, nil); err == nil {
+			t.Errorf("Expected an error when switching to no servers")
+		}
+		if err := cc.SaveCheckpoint([]byte("progress")); err != nil {
+			t.Fatal(err)
+		}
+
+		updates := make(chan primitives.Update, 100)
+		cc.Subscribe(updates)
+
+		// NB: A separate test cluster, so the Coordinator really moves.
+		testutil.WithZk(t, 1, "", func(newServers []string) {
+			if err := cc.SwitchEnsemble(ctx, newServers); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for cc.Mode() != primitives.Leader && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if leader := cc.Leader(); leader == nil || leader.Uuid != cc.LocalNode.Uuid {
+				t.Errorf("Expected the local member to lead on the new ensemble but leader=%+v", leader)
+			}
+			if stats := cc.Stats(); stats.ElectionsJoined != 2 {
+				t.Errorf("Expected ElectionsJoined=2 but actual=%v", stats.ElectionsJoined)
+			}
+
+			checkpoint, err := cc.LoadCheckpoint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checkpoint == nil || string(checkpoint.Data) != "progress" {
+				t.Errorf("Expected the checkpoint to be carried over but actual=%+v", checkpoint)
+			}
+			if err := cc.SaveCheckpoint([]byte("more progress")); err != nil {
+				t.Errorf("Expected saving on the new ensemble to succeed but actual=%v", err)
+			}
+
+			for len(updates) > 0 {
+				if update := <-updates; update.Type == primitives.LeaderUpdate && update.Leader.Uuid != cc.LocalNode.Uuid {
+					t.Errorf("Expected subscribers to never observe another leader during the switch, but got update=%+v", update)
+				}
+			}
+
+			// NB: Before the new ensemble goes away.
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		})
+	})
+}
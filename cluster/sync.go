@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// syncResult carries the outcome of a sync issued on behalf of the election
+// loop back to it.
+type syncResult struct {
+	started   time.Time
+	err       error
+	replyChan chan error
+}
+
+// Sync brings the local view of the election up to date with the ensemble
+// leader: once it returns, Leader() and Mode() reflect every membership
+// change committed before the call, including those made through other
+// members' sessions.  Useful for a one-off linearizable read without enabling
+// SyncReads.
+func (cc *Coordinator) Sync(ctx context.Context) error {
+	if cc.Conn() == nil {
+		return errorlib.NotRunningError
+	}
+	// NB: Buffered so the election loop never blocks on an abandoned request.
+	replyChan := make(chan error, 1)
+	select {
+	case cc.syncRequestsChan <- replyChan:
+	case <-ctx.Done():
+		return fmt.Errorf("%v: sync: %s", cc.Id(), ctx.Err())
+	}
+	select {
+	case err := <-replyChan:
+		if err != nil {
+			return fmt.Errorf("%v: syncing path=%v: %s", cc.Id(), cc.candidatesPath(), err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%v: sync: %s", cc.Id(), ctx.Err())
+	}
+}
+
+// LeaderFresh is the strict counterpart of Leader(): it always syncs first, so
+// the result reflects every change committed before the call regardless of
+// SyncReads.
+func (cc *Coordinator) LeaderFresh(ctx context.Context) (*primitives.Node, error) {
+	if err := cc.Sync(ctx); err != nil {
+		return nil, err
+	}
+	return cc.leader(), nil
+}
+
+// LeaderWithin serves the watch-maintained local view of the leader provided
+// it was last synced (see Sync) no longer than staleness ago, and otherwise
+// syncs first.  Bounds how stale the result may be while keeping frequent
+// calls cheap; Leader() itself never syncs unless SyncReads is set.
+func (cc *Coordinator) LeaderWithin(ctx context.Context, staleness time.Duration) (*primitives.Node, error) {
+	if cc.syncedWithin(staleness) {
+		return cc.leader(), nil
+	}
+	return cc.LeaderFresh(ctx)
+}
+
+// syncedWithin reports whether the local view was synced no longer than
+// bound ago.
+func (cc *Coordinator) syncedWithin(bound time.Duration) bool {
+	if bound <= 0 {
+		return false
+	}
+	cc.leaderLock.Lock()
+	lastSynced := cc.lastSynced
+	cc.leaderLock.Unlock()
+	return !lastSynced.IsZero() && cc.clock().Since(lastSynced) <= bound
+}
+
+// syncForRead syncs ahead of a read as per SyncReads.  Reads have no way to
+// report errors, so failures are logged and the local view is served.
+func (cc *Coordinator) syncForRead() {
+	if cc.Conn() == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cc.requestTimeout())
+	defer cancel()
+	if err := cc.Sync(ctx); err != nil {
+		log.Warnf("%v: serving possibly stale view after sync failure: %s", cc.Id(), err)
+	}
+}
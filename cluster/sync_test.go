@@ -0,0 +1,115 @@
+package cluster_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/errorlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestSyncReads(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			ccs          = []*cluster.Coordinator{}
+		)
+		for i := 0; i < 2; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.SyncReads = i == 1
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			if err := ccs[1].Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, ccs)
+
+		// The departure is reflected straight away, without waiting for the
+		// watch to fire.
+		if err := ccs[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if leader := ccs[1].Leader(); leader == nil || leader.Uuid != ccs[1].LocalNode.Uuid {
+			t.Errorf("Expected synced read to reflect the departure of the leader, but leader=%v", leader)
+		}
+		members, err := ccs[1].Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(members) != 1 {
+			t.Errorf("Expected synced read to list 1 member but actual=%v", len(members))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := ccs[0].Sync(ctx); err != errorlib.NotRunningError {
+			t.Errorf("Expected err=%s when syncing a stopped Coordinator but actual=%v", errorlib.NotRunningError, err)
+		}
+	})
+}
+
+func TestLeaderFresh(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			ccs          = []*cluster.Coordinator{}
+		)
+		for i := 0; i < 2; i++ {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ccs = append(ccs, cc)
+		}
+		defer func() {
+			if err := ccs[1].Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, ccs)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := ccs[1].Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		leader, err := ccs[1].LeaderWithin(ctx, time.Minute) // Served locally.
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := ccs[0].LocalNode.Uuid; leader == nil || leader.Uuid != expected {
+			t.Errorf("Expected bounded read to serve leader=%v but leader=%v", expected, leader)
+		}
+
+		if err := ccs[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		leader, err = ccs[1].LeaderFresh(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leader == nil || leader.Uuid != ccs[1].LocalNode.Uuid {
+			t.Errorf("Expected fresh read to reflect the departure of the leader, but leader=%v", leader)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+// CaptureCuratorFixtures regenerates ../curator-fixtures.json by running real
+// Curator LeaderLatch and LeaderSelector participants against a ZooKeeper
+// server and recording the zNodes they create.
+//
+// Usage (requires curator-recipes and jackson-databind on the classpath):
+//
+//     java -cp "$CLASSPATH" CaptureCuratorFixtures.java 127.0.0.1:2181 > ../curator-fixtures.json
+import com.fasterxml.jackson.databind.ObjectMapper;
+import com.fasterxml.jackson.databind.SerializationFeature;
+import java.util.ArrayList;
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+import java.util.UUID;
+import org.apache.curator.framework.CuratorFramework;
+import org.apache.curator.framework.CuratorFrameworkFactory;
+import org.apache.curator.framework.recipes.leader.LeaderLatch;
+import org.apache.curator.framework.recipes.leader.LeaderSelector;
+import org.apache.curator.framework.recipes.leader.LeaderSelectorListenerAdapter;
+import org.apache.curator.retry.RetryOneTime;
+
+public class CaptureCuratorFixtures {
+    public static void main(String[] args) throws Exception {
+        String base = "/zklib-fixtures-" + UUID.randomUUID();
+        List<Map<String, Object>> fixtures = new ArrayList<>();
+        try (CuratorFramework client = CuratorFrameworkFactory.newClient(args[0], new RetryOneTime(100))) {
+            client.start();
+            client.blockUntilConnected();
+
+            String latchPath = base + "/latch";
+            List<LeaderLatch> latches = new ArrayList<>();
+            for (String id : new String[] {"billing-worker-a", "billing-worker-b", "billing-worker-c"}) {
+                LeaderLatch latch = new LeaderLatch(client, latchPath, id);
+                latch.start();
+                latches.add(latch);
+                // NB: Started one at a time so the sequence order is deterministic.
+                while (latch.getParticipants().stream().noneMatch(p -> p.getId().equals(id))) {
+                    Thread.sleep(10);
+                }
+            }
+            latches.get(0).await();
+            fixtures.add(capture(client, "LeaderLatch", latchPath, latches.get(0).getLeader().getId()));
+            for (LeaderLatch latch : latches) {
+                latch.close();
+            }
+
+            String selectorPath = base + "/selector";
+            List<LeaderSelector> selectors = new ArrayList<>();
+            for (String id : new String[] {"10.0.0.4:8080", "10.0.0.7:8080"}) {
+                LeaderSelector selector = new LeaderSelector(client, selectorPath, new LeaderSelectorListenerAdapter() {
+                    @Override
+                    public void takeLeadership(CuratorFramework client) throws Exception {
+                        Thread.currentThread().join();
+                    }
+                });
+                selector.setId(id);
+                selector.start();
+                selectors.add(selector);
+                while (selector.getParticipants().stream().noneMatch(p -> p.getId().equals(id))) {
+                    Thread.sleep(10);
+                }
+            }
+            while (!selectors.get(0).hasLeadership()) {
+                Thread.sleep(10);
+            }
+            fixtures.add(capture(client, "LeaderSelector", selectorPath, selectors.get(0).getLeader().getId()));
+            for (LeaderSelector selector : selectors) {
+                selector.close();
+            }
+
+            client.delete().deletingChildrenIfNeeded().forPath(base);
+        }
+        System.out.println(new ObjectMapper().enable(SerializationFeature.INDENT_OUTPUT).writeValueAsString(fixtures));
+    }
+
+    private static Map<String, Object> capture(CuratorFramework client, String recipe, String path, String leader) throws Exception {
+        List<Map<String, String>> children = new ArrayList<>();
+        for (String name : client.getChildren().forPath(path)) {
+            Map<String, String> child = new LinkedHashMap<>();
+            child.put("name", name);
+            child.put("id", new String(client.getData().forPath(path + "/" + name), "UTF-8"));
+            children.add(child);
+        }
+        Map<String, Object> fixture = new LinkedHashMap<>();
+        fixture.put("recipe", recipe);
+        fixture.put("children", children);
+        fixture.put("leader", leader);
+        return fixture;
+    }
+}
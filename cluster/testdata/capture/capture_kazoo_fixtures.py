@@ -0,0 +1,60 @@
+"""Regenerates ../kazoo-fixtures.json by running real kazoo Election
+contenders against a ZooKeeper server and recording the zNodes they create.
+
+Usage (requires kazoo):
+
+    python capture_kazoo_fixtures.py 127.0.0.1:2181 > ../kazoo-fixtures.json
+"""
+import json
+import sys
+import threading
+import time
+import uuid
+
+from kazoo.client import KazooClient
+
+
+def main(hosts):
+    base = "/zklib-fixtures-%s" % uuid.uuid4()
+    path = base + "/election"
+    clients, elections = [], []
+    elected = threading.Event()
+    release = threading.Event()
+
+    def lead():
+        elected.set()
+        release.wait()
+
+    for identifier in ("ingest-py-1", "ingest-py-2", "ingest-py-3"):
+        client = KazooClient(hosts=hosts)
+        client.start()
+        clients.append(client)
+        election = client.Election(path, identifier)
+        elections.append(election)
+        threading.Thread(target=election.run, args=(lead,), daemon=True).start()
+        # NB: Started one at a time so the sequence order is deterministic.
+        while identifier not in election.contenders():
+            time.sleep(0.01)
+    elected.wait()
+
+    children = []
+    for name in clients[0].get_children(path):
+        data, _ = clients[0].get(path + "/" + name)
+        children.append({"name": name, "id": data.decode("utf-8")})
+    fixture = {
+        "recipe": "KazooElection",
+        "children": children,
+        "leader": elections[0].contenders()[0],
+    }
+
+    release.set()
+    for election in elections:
+        election.cancel()
+    clients[0].delete(base, recursive=True)
+    for client in clients:
+        client.stop()
+    print(json.dumps([fixture], indent=2))
+
+
+if __name__ == "__main__":
+    main(sys.argv[1])
@@ -0,0 +1,308 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	TieredUpdatesChanSize = 100
+
+	// DatacenterLabel is set on the Nodes of TieredElection members to the
+	// name of their datacenter.
+	DatacenterLabel = "datacenter"
+
+	tieredDatacentersDir = "datacenters"
+	tieredGlobalDir      = "global"
+)
+
+var tieredRetryInterval = 1 * time.Second
+
+// TieredElection conducts hierarchical cross-datacenter leader election:
+// every member joins the election of its own datacenter at
+// <basePath>/datacenters/<datacenter>, and whichever member leads a datacenter
+// additionally joins the global election at <basePath>/global.  The global
+// leader is therefore always the leader of some datacenter, and losing
+// datacenter leadership means leaving the global election.
+//
+// Updates for both tiers are delivered on the single Updates() stream;
+// IsGlobal tells them apart.
+type TieredElection struct {
+	Local  *Coordinator // Datacenter election.
+	Global *Coordinator // Global election, only joined while leading the datacenter.
+
+	datacenter    string
+	basePath      string
+	localUpdates  chan primitives.Update
+	globalUpdates chan primitives.Update
+	updates       chan primitives.Update
+	globalRunning bool
+	quitChan      chan struct{}
+	doneChan      chan struct{}
+	lock          sync.Mutex
+}
+
+func NewTieredElection(zkServers []string, sessionTimeout time.Duration, basePath string, datacenter string, data string) (*TieredElection, error) {
+	if datacenter == "" || strings.Contains(datacenter, "/") {
+		return nil, fmt.Errorf("NewTieredElection: invalid datacenter=%q, must be non-empty and must not contain '/'", datacenter)
+	}
+	te := &TieredElection{
+		datacenter:    datacenter,
+		basePath:      util.NormalizePath(basePath),
+		localUpdates:  make(chan primitives.Update, TieredUpdatesChanSize),
+		globalUpdates: make(chan primitives.Update, TieredUpdatesChanSize),
+		updates:       make(chan primitives.Update, TieredUpdatesChanSize),
+	}
+	local, err := NewCoordinator(zkServers, sessionTimeout, te.DatacenterPath(datacenter), data, te.localUpdates)
+	if err != nil {
+		return nil, fmt.Errorf("NewTieredElection: %s", err)
+	}
+	local.LocalNode.Labels = map[string]string{DatacenterLabel: datacenter}
+	global, err := NewCoordinator(zkServers, sessionTimeout, te.basePath+"/"+tieredGlobalDir, data, te.globalUpdates)
+	if err != nil {
+		return nil, fmt.Errorf("NewTieredElection: %s", err)
+	}
+	te.Local = local
+	te.Global = global
+	return te, nil
+}
+
+// Updates returns the stream of leadership updates for both the datacenter
+// and the global election.
+func (te *TieredElection) Updates() <-chan primitives.Update {
+	return te.updates
+}
+
+// IsGlobal reports whether update pertains to the global election rather than
+// the datacenter election.
+func (te *TieredElection) IsGlobal(update primitives.Update) bool {
+	return update.ElectionPath == te.Global.leaderElectionPath
+}
+
+// Datacenter returns the name of the local datacenter.
+func (te *TieredElection) Datacenter() string {
+	return te.datacenter
+}
+
+// DatacenterPath returns the election path for the named datacenter.
+func (te *TieredElection) DatacenterPath(datacenter string) string {
+	return te.basePath + "/" + tieredDatacentersDir + "/" + datacenter
+}
+
+// Start joins the datacenter election.  The global election is joined and
+// left automatically as datacenter leadership comes and goes.
+func (te *TieredElection) Start() error {
+	te.lock.Lock()
+	if te.quitChan != nil {
+		te.lock.Unlock()
+		return fmt.Errorf("TieredElection datacenter=%v: already started", te.datacenter)
+	}
+	te.quitChan = make(chan struct{})
+	te.doneChan = make(chan struct{})
+	go te.run(te.quitChan, te.doneChan)
+	te.lock.Unlock()
+
+	if err := te.Local.Start(); err != nil {
+		te.stopRunning()
+		return err
+	}
+	return nil
+}
+
+// Stop leaves the global election, if joined, and then the datacenter
+// election.
+func (te *TieredElection) Stop() error {
+	if !te.stopRunning() {
+		return fmt.Errorf("TieredElection datacenter=%v: already stopped", te.datacenter)
+	}
+	// NB: The run goroutine has exited, so nothing else joins or leaves the
+	// global election concurrently.
+	te.lock.Lock()
+	globalRunning := te.globalRunning
+	te.globalRunning = false
+	te.lock.Unlock()
+	if globalRunning {
+		if err := te.Global.Stop(); err != nil {
+			log.Warnf("TieredElection datacenter=%v: leaving global election: %s", te.datacenter, err)
+		}
+	}
+	return te.Local.Stop()
+}
+
+// stopRunning stops the run goroutine and reports whether it was running.
+func (te *TieredElection) stopRunning() bool {
+	te.lock.Lock()
+	quitChan, doneChan := te.quitChan, te.doneChan
+	te.quitChan, te.doneChan = nil, nil
+	te.lock.Unlock()
+	if quitChan == nil {
+		return false
+	}
+	close(quitChan)
+	<-doneChan
+	return true
+}
+
+// DatacenterLeader returns the leader of the local datacenter, or nil.
+func (te *TieredElection) DatacenterLeader() *primitives.Node {
+	return te.Local.Leader()
+}
+
+// GlobalLeader returns the global leader, or nil if there isn't one right
+// now.  Its DatacenterLabel tells which datacenter it belongs to.
+//
+// Members other than the datacenter leader do not participate in the global
+// election, so for them this reads the global election from ZooKeeper.
+func (te *TieredElection) GlobalLeader() (*primitives.Node, error) {
+	te.lock.Lock()
+	globalRunning := te.globalRunning
+	te.lock.Unlock()
+	if globalRunning {
+		if leader := te.Global.Leader(); leader != nil {
+			return leader, nil
+		}
+	}
+
+	var leader *primitives.Node
+	ctx, cancel := context.WithTimeout(context.Background(), te.Local.requestTimeout())
+	defer cancel()
+	err := te.Local.Do(ctx, func(conn *zk.Conn) (err error) {
+		leader, err = te.Global.observeLeader(conn)
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("TieredElection datacenter=%v: reading global leader: %s", te.datacenter, err)
+	}
+	return leader, nil
+}
+
+// IsGlobalLeader reports whether the local member is the global leader.
+func (te *TieredElection) IsGlobalLeader() bool {
+	te.lock.Lock()
+	defer te.lock.Unlock()
+	return te.globalRunning && te.Global.Mode() == primitives.Leader
+}
+
+// run follows datacenter leadership, joining and leaving the global election
+// accordingly, and relays updates from both tiers.
+func (te *TieredElection) run(quitChan chan struct{}, doneChan chan struct{}) {
+	defer close(doneChan)
+	var retryCh <-chan time.Time
+	for {
+		select {
+		case update := <-te.localUpdates:
+			te.relay(update)
+			if !te.follow(te.Local.Mode() == primitives.Leader) {
+				retryCh = te.Local.clock().After(tieredRetryInterval)
+			}
+
+		case update := <-te.globalUpdates:
+			te.relay(update)
+
+		case <-retryCh:
+			retryCh = nil
+			if !te.follow(te.Local.Mode() == primitives.Leader) {
+				retryCh = te.Local.clock().After(tieredRetryInterval)
+			}
+
+		case <-quitChan:
+			return
+		}
+	}
+}
+
+// follow joins or leaves the global election as per leadingDatacenter, and
+// reports whether it succeeded.  Only invoked by the run goroutine, which is
+// thus the only writer of globalRunning until Stop; te.lock is not held
+// across Global.Start() and Global.Stop() so readers never block on them.
+func (te *TieredElection) follow(leadingDatacenter bool) bool {
+	te.lock.Lock()
+	globalRunning := te.globalRunning
+	te.lock.Unlock()
+
+	if leadingDatacenter == globalRunning {
+		return true
+	}
+	if leadingDatacenter {
+		log.Infof("TieredElection datacenter=%v: leading the datacenter, joining global election", te.datacenter)
+		te.Local.leaderLock.Lock()
+		localNode := te.Local.LocalNode
+		te.Local.leaderLock.Unlock()
+		te.Global.leaderLock.Lock()
+		te.Global.LocalNode = localNode
+		te.Global.leaderLock.Unlock()
+		if err := te.Global.Start(); err != nil {
+			log.Warnf("TieredElection datacenter=%v: joining global election (will retry): %s", te.datacenter, err)
+			return false
+		}
+		te.lock.Lock()
+		te.globalRunning = true
+		te.lock.Unlock()
+		return true
+	}
+	log.Infof("TieredElection datacenter=%v: no longer leading the datacenter, leaving global election", te.datacenter)
+	te.lock.Lock()
+	te.globalRunning = false
+	te.lock.Unlock()
+	if err := te.Global.Stop(); err != nil {
+		log.Warnf("TieredElection datacenter=%v: leaving global election: %s", te.datacenter, err)
+	}
+	return true
+}
+
+func (te *TieredElection) relay(update primitives.Update) {
+	select {
+	case te.updates <- update:
+	default:
+		log.Warnf("TieredElection datacenter=%v: updates chan full, dropped update for election=%v", te.datacenter, update.ElectionPath)
+	}
+}
+
+// observeLeader reads the leader of the election through conn without
+// participating in it.  MinMembers and StickyWindow are not taken into
+// account.
+func (cc *Coordinator) observeLeader(conn *zk.Conn) (*primitives.Node, error) {
+	candidatesPath := cc.candidatesPath()
+	children, _, err := conn.Children(candidatesPath)
+	if err == zk.ErrNoNode {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	members := map[string]primitives.Node{}
+	for _, child := range children {
+		if !cc.PathLayout.IsCandidate(child) {
+			continue
+		}
+		data, _, err := conn.Get(candidatesPath + "/" + child)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		node, err := cc.decodeNode(conn, data)
+		if err != nil {
+			return nil, fmt.Errorf("child=%v: %s", child, err)
+		}
+		members[child] = node
+	}
+	present := make([]string, 0, len(members))
+	for child := range members {
+		present = append(present, child)
+	}
+	leader, ok := cc.elect(present, members)
+	if !ok {
+		return nil, nil
+	}
+	node := members[leader]
+	return &node, nil
+}
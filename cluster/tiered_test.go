@@ -0,0 +1,101 @@
+package cluster_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestTieredElection(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			basePath = testutil.Namespace(t)
+			members  = []*cluster.TieredElection{}
+		)
+		for _, dc := range []string{"east", "east", "west", "west"} {
+			te, err := cluster.NewTieredElection(zkServers, zkTimeout, basePath, dc, fmt.Sprintf("%v-%v", dc, len(members)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := te.Start(); err != nil {
+				t.Fatal(err)
+			}
+			members = append(members, te)
+		}
+		stopped := map[int]bool{}
+		defer func() {
+			for i, te := range members {
+				if !stopped[i] {
+					if err := te.Stop(); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}()
+
+		// globalLeader waits for every running member to agree on a global
+		// leader which leads its own datacenter.
+		globalLeader := func() *primitives.Node {
+			deadline := time.Now().Add(10 * time.Second)
+			for time.Now().Before(deadline) {
+				var (
+					expected *primitives.Node
+					agreed   = true
+				)
+				for i, te := range members {
+					if stopped[i] {
+						continue
+					}
+					leader, err := te.GlobalLeader()
+					if err != nil || leader == nil || (expected != nil && leader.Uuid != expected.Uuid) {
+						agreed = false
+						break
+					}
+					expected = leader
+				}
+				if agreed {
+					return expected
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			t.Fatalf("members did not agree on a global leader within 10s")
+			return nil
+		}
+
+		leader := globalLeader()
+		dc := leader.Labels[cluster.DatacenterLabel]
+		leaderIndex := -1
+		for i, te := range members {
+			if te.Local.LocalNode.Uuid == leader.Uuid {
+				leaderIndex = i
+				if te.Datacenter() != dc {
+					t.Errorf("Expected global leader's datacenter label=%v to match its datacenter=%v", dc, te.Datacenter())
+				}
+				if !te.IsGlobalLeader() {
+					t.Errorf("Expected member i=%v to consider itself the global leader", i)
+				}
+				if dcLeader := te.DatacenterLeader(); dcLeader == nil || dcLeader.Uuid != leader.Uuid {
+					t.Errorf("Expected global leader to also lead datacenter=%v, but datacenter leader=%v", dc, dcLeader)
+				}
+			} else if te.IsGlobalLeader() {
+				t.Errorf("Expected member i=%v not to consider itself the global leader", i)
+			}
+		}
+		if leaderIndex == -1 {
+			t.Fatalf("Global leader=%v is not among the members", leader)
+		}
+
+		// Global leadership moves on once the global leader departs.
+		if err := members[leaderIndex].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		stopped[leaderIndex] = true
+		if next := globalLeader(); next.Uuid == leader.Uuid {
+			t.Errorf("Expected a new global leader after the previous one departed")
+		}
+	})
+}
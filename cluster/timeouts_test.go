@@ -0,0 +1,73 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestMembersRequestTimeout(t *testing.T) {
+	// A blackhole address which never completes the TCP handshake.
+	cc, err := cluster.NewCoordinator([]string{"10.255.255.1:2181"}, 10*time.Second, "/"+testlib.CurrentRunningTest(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.ConnectTimeout = 100 * time.Millisecond
+	cc.RequestTimeout = 250 * time.Millisecond
+	if err := cc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cc.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	started := time.Now()
+	if _, err := cc.Members(); err == nil {
+		t.Fatalf("Expected Members() against a hung ensemble to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Expected Members() to give up after about %s, but it took %s", cc.RequestTimeout, elapsed)
+	}
+}
+
+func TestMembersRequestTimeoutFakeClock(t *testing.T) {
+	cc, err := cluster.NewCoordinator([]string{"10.255.255.1:2181"}, 10*time.Second, "/"+testlib.CurrentRunningTest(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc := testutil.NewFakeClock(time.Now())
+	cc.Clock = fc
+	cc.ConnectTimeout = 100 * time.Millisecond
+	cc.RequestTimeout = 1 * time.Hour
+	if err := cc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := cc.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := cc.Members()
+		errChan <- err
+	}()
+	if !fc.BlockUntilTimeout(1, 5*time.Second) {
+		t.Fatalf("Timed out waiting for Members() to wait on the fake clock")
+	}
+	fc.Advance(cc.RequestTimeout)
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatalf("Expected Members() against a hung ensemble to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected Members() to give up once the fake clock passed the request timeout")
+	}
+}
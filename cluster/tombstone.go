@@ -0,0 +1,136 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// tombstonesDirName holds a persistent zNode per departed member, named after
+// its candidate zNode, recording why it departed.  It lives alongside the
+// candidates and is not sequential, so is never mistaken for a candidate.
+const tombstonesDirName = "tombstones"
+
+// tombstoneTTL is how long tombstones are kept around for the remaining
+// members to read before being pruned.
+var tombstoneTTL = 10 * time.Minute
+
+// tombstone is the content of a tombstone zNode.
+type tombstone struct {
+	Reason string          `json:"reason"`
+	At     time.Time       `json:"at"`
+	Node   primitives.Node `json:"node"`
+}
+
+// tombstonesPath returns where the tombstones of the candidates under
+// candidatesPath live.  Writers and readers must both derive it from the
+// candidates path (see PathLayout.CandidatesPath), not the election path.
+func tombstonesPath(candidatesPath string) string {
+	return candidatesPath + "/" + tombstonesDirName
+}
+
+// writeTombstone records that the member owning the candidate zNode named
+// zNode is departing for the given reason, and prunes expired tombstones.  The
+// tombstone is encrypted by sealer, unless nil.
+func writeTombstone(conn *zk.Conn, candidatesPath string, zNode string, t tombstone, sealer payloadSealer) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if data, err = seal(sealer, data); err != nil {
+		return err
+	}
+	dir := tombstonesPath(candidatesPath)
+	if _, err := util.CreateP(conn, dir, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil {
+		return fmt.Errorf("creating tombstones dir=%v: %s", dir, err)
+	}
+	if _, err := conn.Create(dir+"/"+zNode, data, 0, zk.WorldACL(zk.PermAll)); err == zk.ErrNodeExists {
+		if _, err := conn.Set(dir+"/"+zNode, data, -1); err != nil {
+			return fmt.Errorf("updating tombstone=%v: %s", zNode, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("creating tombstone=%v: %s", zNode, err)
+	}
+	pruneTombstones(conn, dir, t.At)
+	return nil
+}
+
+// pruneTombstones deletes tombstones older than tombstoneTTL.  Failures are
+// only logged since the next writer will try again.
+func pruneTombstones(conn *zk.Conn, dir string, now time.Time) {
+	children, _, err := conn.Children(dir)
+	if err != nil {
+		log.Warnf("Pruning tombstones: listing dir=%v: %s", dir, err)
+		return
+	}
+	cutoff := now.Add(-tombstoneTTL).UnixNano() / int64(time.Millisecond)
+	for _, child := range children {
+		exists, stat, err := conn.Exists(dir + "/" + child)
+		if err != nil || !exists || stat.Mtime >= cutoff {
+			continue
+		}
+		if err := conn.Delete(dir+"/"+child, stat.Version); err != nil && err != zk.ErrNoNode && err != zk.ErrBadVersion {
+			log.Warnf("Pruning tombstones: deleting tombstone=%v: %s", child, err)
+		}
+	}
+}
+
+// leaveTombstone records that the local member is being stopped.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) leaveTombstone() {
+	cc.leaderLock.Lock()
+	zNode := cc.zNode
+	localNode := cc.inlineNode(cc.LocalNode)
+	cc.leaderLock.Unlock()
+	if zNode == "" {
+		return
+	}
+	t := tombstone{Reason: primitives.DepartureGraceful, At: cc.clock().Now(), Node: localNode}
+	if err := writeTombstone(cc.zkCli, cc.candidatesPath(), path.Base(zNode), t, cc.sealer()); err != nil {
+		log.Warnf("%v: leaving tombstone: %s", cc.Id(), err)
+	}
+}
+
+// departures returns why the members owning the candidate zNodes named
+// zNodes departed, in the same order.  Members which left no tombstone are
+// presumed to have lost their session.  The tombstones are read with the
+// requests pipelined, so a burst of departures costs the election loop about
+// one round trip.
+func (cc *Coordinator) departures(zkCli *zk.Conn, zNodes []string) []tombstone {
+	var (
+		dir      = tombstonesPath(cc.candidatesPath())
+		paths    = make([]string, len(zNodes))
+		departed = make([]tombstone, len(zNodes))
+	)
+	for i, zNode := range zNodes {
+		paths[i] = dir + "/" + zNode
+	}
+	for i, result := range cc.getPipelined(zkCli, paths, time.Now()) {
+		departed[i].Reason = primitives.DepartureSessionExpired
+		if result.err == zk.ErrNoNode {
+			continue
+		} else if result.err != nil {
+			log.Warnf("%v: reading tombstone=%v: %s", cc.Id(), zNodes[i], result.err)
+			continue
+		}
+		var t tombstone
+		data, err := unseal(cc.sealer(), result.data)
+		if err == nil {
+			err = json.Unmarshal(data, &t)
+		}
+		if err != nil {
+			log.Warnf("%v: decoding tombstone=%v: %s", cc.Id(), zNodes[i], err)
+			continue
+		}
+		departed[i] = t
+	}
+	return departed
+}
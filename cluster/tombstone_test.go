@@ -0,0 +1,139 @@
+package cluster_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestDepartureReasons(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			subChan = make(chan primitives.Update, 100)
+			leader  = ncc(t, zkServers, "leader", subChan)
+			stopped = ncc(t, zkServers, "stopped")
+			evicted = ncc(t, zkServers, "evicted")
+		)
+		defer leader.Stop()
+		defer evicted.Stop()
+
+		// A member which goes away without a chance to leave a tombstone.
+		crashedConn, _, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer crashedConn.Close()
+		crashed := primitives.NewNode("crashed")
+		data, err := json.Marshal(crashed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := crashedConn.Create(testutil.Namespace(t)+"/n_", data, zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll)); err != nil {
+			t.Fatal(err)
+		}
+
+		waitForDelta := func(node primitives.Node, joined bool) primitives.MemberDelta {
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case update := <-subChan:
+					for _, delta := range update.Deltas {
+						if delta.Node.Uuid == node.Uuid && delta.Joined == joined {
+							return delta
+						}
+					}
+				case <-timeout:
+					t.Fatalf("Timed out waiting for member=%v joined=%v", node, joined)
+				}
+			}
+		}
+
+		if delta := waitForDelta(*crashed, true); delta.Reason != "" || delta.ZNode == "" {
+			t.Errorf("Expected join delta with a zNode and no reason but actual=%+v", delta)
+		}
+
+		if err := stopped.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := primitives.DepartureGraceful, waitForDelta(stopped.LocalNode, false).Reason; actual != expected {
+			t.Errorf("Expected stopped member departure reason=%v but actual=%v", expected, actual)
+		}
+
+		if err := leader.ForceRemoveMember(evicted.LocalNode.Uuid.String(), evicted.Status().SessionId); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := primitives.DepartureEvicted, waitForDelta(evicted.LocalNode, false).Reason; actual != expected {
+			t.Errorf("Expected evicted member departure reason=%v but actual=%v", expected, actual)
+		}
+
+		crashedConn.Close()
+		delta := waitForDelta(*crashed, false)
+		if expected, actual := primitives.DepartureSessionExpired, delta.Reason; actual != expected {
+			t.Errorf("Expected crashed member departure reason=%v but actual=%v", expected, actual)
+		}
+		if delta.Node.Hostname != crashed.Hostname {
+			t.Errorf("Expected crashed member departure to carry node=%v but actual=%v", crashed, delta.Node)
+		}
+	})
+}
+
+// TestDepartureReasonsMembersDir verifies an administrative eviction is
+// reported as such when the candidates live in a MembersDir.
+func TestDepartureReasonsMembersDir(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		var (
+			electionPath = testutil.Namespace(t)
+			layout       = cluster.PathLayout{MembersDir: "members"}
+			subChan      = make(chan primitives.Update, 100)
+			ccs          = []*cluster.Coordinator{}
+		)
+		for _, data := range []string{"leader", "evicted"} {
+			cc, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cc.PathLayout = layout
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err = cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Stop()
+			ccs = append(ccs, cc)
+		}
+		leader, evicted := ccs[0], ccs[1]
+		leader.Subscribe(subChan, cluster.FilterMembershipChanges)
+
+		err := util.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			return cluster.ForceRemoveMember(conn, layout.CandidatesPath(electionPath), nil, nil, evicted.LocalNode.Uuid.String(), evicted.Status().SessionId)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case update := <-subChan:
+				for _, delta := range update.Deltas {
+					if delta.Node.Uuid == evicted.LocalNode.Uuid && !delta.Joined {
+						if expected, actual := primitives.DepartureEvicted, delta.Reason; actual != expected {
+							t.Errorf("Expected evicted member departure reason=%v but actual=%v", expected, actual)
+						}
+						return
+					}
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for the evicted member to depart")
+			}
+		}
+	})
+}
@@ -3,6 +3,8 @@ package cluster
 import (
 	"time"
 
+	"github.com/gigawattio/zklib/clock"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/cenkalti/backoff"
 )
@@ -23,3 +25,39 @@ func retryUntilSuccess(name string, operation func() error, strategy backoff.Bac
 		break
 	}
 }
+
+// retryUntilSuccessOrAbort will keep attempting an operation until it
+// succeeds, or until abortChan is closed or timeoutChan (which may be nil)
+// fires.  Waits between attempts are measured by clk.  Returns false if
+// aborted or timed out.
+func retryUntilSuccessOrAbort(name string, operation func() error, strategy backoff.BackOff, clk clock.Clock, abortChan <-chan struct{}, timeoutChan <-chan time.Time) bool {
+	for {
+		err := operation()
+		if err == nil {
+			return true
+		}
+		nextWait := strategy.NextBackOff()
+		if nextWait == backoff.Stop {
+			strategy.Reset()
+			nextWait = strategy.NextBackOff()
+		}
+		log.Errorf("%s notified of error: %s [next wait=%s]", name, err, nextWait)
+		select {
+		case <-abortChan:
+			return false
+		case <-timeoutChan:
+			log.Errorf("%s timed out [giving up]", name)
+			return false
+		case <-clk.After(nextWait):
+		}
+	}
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
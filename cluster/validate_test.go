@@ -0,0 +1,69 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/util"
+)
+
+func TestValidators(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		validators := []util.Validator{util.RequireJSONFields("version")}
+
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, testutil.Namespace(t), "not json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.Validators = validators
+		cc.ValidateReads = true
+		if err := cc.Start(); err == nil || !strings.Contains(err.Error(), "invalid data") {
+			cc.Stop()
+			t.Fatalf("Expected Start to reject malformed data but actual err=%v", err)
+		}
+
+		if err := cc.SetData(`{"version": 1}`); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer cc.Stop()
+		if err := cc.SetData(`{"name": "x"}`); err == nil || !strings.Contains(err.Error(), `missing field "version"`) {
+			t.Fatalf("Expected SetData to reject malformed data but actual err=%v", err)
+		} else if _, ok := err.(util.ValidationError); !ok {
+			t.Errorf("Expected a util.ValidationError but actual=%T", err)
+		}
+		nodes, err := cc.Members()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 || nodes[0].Data != `{"version": 1}` {
+			t.Fatalf("Expected the rejected data not to be published but members=%+v", nodes)
+		}
+
+		// A member of an older version publishing malformed data.
+		legacy := ncc(t, zkServers, "legacy")
+		defer legacy.Stop()
+		if _, err := legacy.Members(); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			_, err := cc.Members()
+			if err != nil && strings.Contains(err.Error(), "invalid data") {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected Members to reject the malformed member but actual err=%v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package cluster_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestLeaderVerificationDemotesOnLostZNode(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		path := testutil.Namespace(t)
+		subChan := make(chan primitives.Update, 10)
+		cc, err := cluster.NewCoordinator(zkServers, zkTimeout, path, "verify", subChan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.LeaderVerifyInterval = 100 * time.Millisecond
+		if err := cc.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer cc.Stop()
+
+		expectMode := func(mode string) {
+			deadline := time.After(5 * time.Second)
+			for {
+				select {
+				case update := <-subChan:
+					if update.Mode == mode {
+						return
+					}
+				case <-deadline:
+					t.Fatalf("Timed out waiting for update with mode=%v", mode)
+				}
+			}
+		}
+		expectMode(primitives.Leader)
+
+		// Pull the election zNode out from under the leader.
+		err = zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			sessions, err := cluster.ListMemberSessions(conn, path, nil)
+			if err != nil {
+				return err
+			}
+			for _, session := range sessions {
+				if err := conn.Delete(session.ZNode, -1); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectMode(primitives.Follower)
+		// Having rejoined, the sole member must regain leadership.
+		expectMode(primitives.Leader)
+	})
+}
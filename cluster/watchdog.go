@@ -0,0 +1,96 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// markProgress records that the election loop is alive and well.
+func (cc *Coordinator) markProgress() {
+	cc.progressLock.Lock()
+	cc.lastProgress = cc.clock().Now()
+	cc.progressLock.Unlock()
+}
+
+func (cc *Coordinator) sinceProgress() time.Duration {
+	cc.progressLock.Lock()
+	defer cc.progressLock.Unlock()
+	return cc.clock().Since(cc.lastProgress)
+}
+
+func (cc *Coordinator) watchdog(stopChan chan chan struct{}) {
+	ticker := cc.clock().NewTicker(cc.WatchdogTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if cc.wedged() {
+				log.Warnf("%v: watchdog: no session and no progress for %s, restarting", cc.Id(), cc.sinceProgress())
+				if err := cc.restart(); err != nil {
+					log.Errorf("%v: watchdog: restart failed: %s", cc.Id(), err)
+				}
+			}
+
+		case ackChan := <-stopChan:
+			ackChan <- struct{}{}
+			return
+		}
+	}
+}
+
+// startWatchdog launches the watchdog when WatchdogTimeout is set and it isn't
+// running already.
+//
+// Must only be invoked while holding cc.stateLock.
+func (cc *Coordinator) startWatchdog() {
+	if cc.WatchdogTimeout > 0 && cc.watchdogStopChan == nil {
+		cc.watchdogStopChan = make(chan chan struct{})
+		watchdogStopChan := cc.watchdogStopChan
+		cc.resources.Go("watchdog", func() { cc.watchdog(watchdogStopChan) })
+	}
+}
+
+// wedged reports whether the Coordinator has neither a session nor made any
+// progress within the WatchdogTimeout.
+func (cc *Coordinator) wedged() bool {
+	cc.stateLock.Lock()
+	zkCli := cc.zkCli
+	cc.stateLock.Unlock()
+
+	if zkCli == nil {
+		return false
+	}
+	return zkCli.State() != zk.StateHasSession && cc.sinceProgress() > cc.WatchdogTimeout
+}
+
+// restart performs a full internal restart: the current election loop and
+// connection are torn down, then a new connection is established and the
+// election rejoined.
+func (cc *Coordinator) restart() error {
+	cc.stateLock.Lock()
+	defer cc.stateLock.Unlock()
+
+	if cc.zkCli == nil {
+		return fmt.Errorf("%v: not running", cc.Id())
+	}
+
+	cc.leaderLock.Lock()
+	record := cc.zNode
+	cc.leaderLock.Unlock()
+
+	cc.teardown()
+
+	cc.leaderLock.Lock()
+	if cc.PersistentMembership {
+		cc.zNode = record // NB: Outlives the session, so is kept when rejoining.
+	}
+	cc.leaderNode = nil
+	cc.leaderZNode = ""
+	cc.leaderLock.Unlock()
+
+	return cc.connectAndRun(nil, true)
+}
@@ -0,0 +1,135 @@
+package cluster_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+// flakyProxy forwards TCP connections to a target address and can simulate an
+// outage by severing all connections and refusing new ones.
+type flakyProxy struct {
+	listener net.Listener
+	target   string
+	down     bool
+	conns    []net.Conn
+	lock     sync.Mutex
+}
+
+func newFlakyProxy(t *testing.T, target string) *flakyProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &flakyProxy{
+		listener: listener,
+		target:   target,
+	}
+	go proxy.serve()
+	return proxy
+}
+
+func (proxy *flakyProxy) Addr() string {
+	return proxy.listener.Addr().String()
+}
+
+func (proxy *flakyProxy) SetDown(down bool) {
+	proxy.lock.Lock()
+	defer proxy.lock.Unlock()
+
+	proxy.down = down
+	if down {
+		for _, conn := range proxy.conns {
+			conn.Close()
+		}
+		proxy.conns = nil
+	}
+}
+
+func (proxy *flakyProxy) Close() error {
+	proxy.SetDown(true)
+	return proxy.listener.Close()
+}
+
+func (proxy *flakyProxy) serve() {
+	for {
+		conn, err := proxy.listener.Accept()
+		if err != nil {
+			return
+		}
+		proxy.lock.Lock()
+		if proxy.down {
+			proxy.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		upstream, err := net.Dial("tcp", proxy.target)
+		if err != nil {
+			proxy.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		proxy.conns = append(proxy.conns, conn, upstream)
+		proxy.lock.Unlock()
+
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}
+}
+
+func TestWatchdogRestartsWedgedCoordinator(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		proxy := newFlakyProxy(t, zkServers[0])
+		defer proxy.Close()
+
+		updates := make(chan primitives.Update, 100)
+		cc, err := cluster.NewCoordinator([]string{proxy.Addr()}, zkTimeout, testutil.Namespace(t), "watched", updates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc.WatchdogTimeout = 500 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		proxy.SetDown(true)
+		time.Sleep(2 * cc.WatchdogTimeout)
+		proxy.SetDown(false)
+
+		timeout := time.After(10 * time.Second)
+		for {
+			select {
+			case update := <-updates:
+				if update.Type != primitives.RecoveredUpdate {
+					continue
+				}
+				if update.Mode != primitives.Leader {
+					t.Errorf("Expected sole member to be leader after recovery, but mode=%v", update.Mode)
+				}
+				return
+			case <-timeout:
+				t.Fatalf("Timed out waiting for recovered update")
+			}
+		}
+	})
+}
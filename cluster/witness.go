@@ -0,0 +1,38 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// WitnessLabel marks the Nodes of witnesses, see Coordinator.Witness.
+const WitnessLabel = "zklib.witness"
+
+// NewWitness creates a Coordinator which participates in the election at
+// leaderElectionPath as a witness only.
+func NewWitness(zkServers []string, sessionTimeout time.Duration, leaderElectionPath string, subscribers ...chan primitives.Update) (*Coordinator, error) {
+	cc, err := NewCoordinator(zkServers, sessionTimeout, leaderElectionPath, "", subscribers...)
+	if err != nil {
+		return nil, err
+	}
+	cc.Witness = true
+	return cc, nil
+}
+
+// IsWitness reports whether node belongs to a witness.
+func IsWitness(node primitives.Node) bool {
+	return node.Labels[WitnessLabel] == "true"
+}
+
+// markWitness labels the local node as a witness.
+//
+// Must only be invoked while holding cc.leaderLock.
+func (cc *Coordinator) markWitness() {
+	labels := make(map[string]string, len(cc.LocalNode.Labels)+1)
+	for key, value := range cc.LocalNode.Labels {
+		labels[key] = value
+	}
+	labels[WitnessLabel] = "true"
+	cc.LocalNode.Labels = labels
+}
@@ -0,0 +1,67 @@
+package cluster_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+func TestWitness(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+
+		// The witness joins first, so would lead were it not a witness.
+		witness, err := cluster.NewWitness(zkServers, zkTimeout, electionPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		member, err := cluster.NewCoordinator(zkServers, zkTimeout, electionPath, "member")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cc := range []*cluster.Coordinator{witness, member} {
+			cc.MinMembers = 2
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			err := cc.StartAndWait(ctx)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer func() {
+			if err := witness.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		waitForAgreement(t, []*cluster.Coordinator{witness, member})
+
+		// The witness counts toward MinMembers but never leads.
+		for _, cc := range []*cluster.Coordinator{witness, member} {
+			if leader := cc.Leader(); leader == nil || leader.Uuid != member.LocalNode.Uuid {
+				t.Errorf("%v: Expected the member to lead, but leader=%v", cc.Id(), leader)
+			}
+		}
+		if mode := witness.Mode(); mode != primitives.Follower {
+			t.Errorf("Expected witness mode=%v but actual=%v", primitives.Follower, mode)
+		}
+		if !cluster.IsWitness(witness.LocalNode) || cluster.IsWitness(member.LocalNode) {
+			t.Errorf("Expected only the witness to be labelled as such")
+		}
+
+		// A lone witness does not lead either.
+		if err := member.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for witness.Leader() != nil && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if leader := witness.Leader(); leader != nil {
+			t.Errorf("Expected no leader with only the witness present, but leader=%v", leader)
+		}
+	})
+}
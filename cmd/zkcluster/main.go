@@ -0,0 +1,178 @@
+// Command zkcluster provides administrative operations for zklib cluster
+// elections.
+//
+// Usage:
+//
+//	zkcluster -servers 127.0.0.1:2181 -path /my/election members
+//	zkcluster -servers 127.0.0.1:2181 -path /my/election force-remove <uuid> <session-id>
+//	zkcluster -servers 127.0.0.1:2181 -path /my/election usage
+//	zkcluster -servers 127.0.0.1:2181 ensemble
+//	zkcluster -servers 127.0.0.1:2181 reconfig add server.4=10.0.0.4:2888:3888;2181
+//	zkcluster -servers 127.0.0.1:2181 reconfig remove 4
+//
+// members and force-remove decode the candidate zNodes with -codec, and with
+// -key-file set, decrypt them as an EncryptingCodec does, so force-removals
+// leave tombstones the members can read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	servers        = flag.String("servers", "127.0.0.1:2181", "Comma-separated list of ZooKeeper host:port pairs")
+	electionPath   = flag.String("path", "", "Election path")
+	membersDir     = flag.String("members-dir", "", "Candidates subdirectory of the election path, when the election uses a PathLayout with a MembersDir")
+	sessionTimeout = flag.Duration("timeout", 5*time.Second, "ZooKeeper session timeout")
+	codecName      = flag.String("codec", "json", "Codec the members encode their candidate zNodes with: json, compressed or curator")
+	keyFile        = flag.String("key-file", "", "File holding the AES key the members encrypt with, when they use an EncryptingCodec")
+	keyId          = flag.String("key-id", "", "Id of the key in -key-file")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] members|force-remove <uuid> <session-id>|usage|ensemble|reconfig add|remove <server>\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 || (*electionPath == "" && flag.Arg(0) != "ensemble" && flag.Arg(0) != "reconfig") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	path := cluster.PathLayout{MembersDir: *membersDir}.CandidatesPath(util.NormalizePath(*electionPath))
+	zkServers := strings.Split(*servers, ",")
+
+	switch args[0] {
+	case "members":
+		codec, err := newCodec()
+		if err != nil {
+			return err
+		}
+		return util.WithZkSession(zkServers, *sessionTimeout, func(conn *zk.Conn) error {
+			sessions, err := cluster.ListMemberSessions(conn, path, codec)
+			if err != nil {
+				return err
+			}
+			for _, session := range sessions {
+				fmt.Printf("%v\tsession=0x%x\tzNode=%v\thostname=%v\n", session.Node.Uuid, uint64(session.SessionId), session.ZNode, session.Node.Hostname)
+			}
+			return nil
+		})
+
+	case "force-remove":
+		if len(args) != 3 {
+			return fmt.Errorf("force-remove requires exactly 2 arguments: <uuid> <session-id>")
+		}
+		// NB: Session ids are printed unsigned, so parse them the same way.
+		sessionId, err := strconv.ParseUint(args[2], 0, 64)
+		if err != nil {
+			return fmt.Errorf("parsing session-id=%q: %s", args[2], err)
+		}
+		codec, err := newCodec()
+		if err != nil {
+			return err
+		}
+		return util.WithZkSession(zkServers, *sessionTimeout, func(conn *zk.Conn) error {
+			var err error
+			if encrypting, ok := codec.(cluster.EncryptingCodec); ok {
+				err = cluster.ForceRemoveMember(conn, path, codec, encrypting, args[1], int64(sessionId))
+			} else {
+				err = cluster.ForceRemoveMember(conn, path, codec, nil, args[1], int64(sessionId))
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Removed member uuid=%v\n", args[1])
+			return nil
+		})
+
+	case "usage":
+		return util.WithZkSession(zkServers, *sessionTimeout, func(conn *zk.Conn) error {
+			usage, err := util.MeasureUsage(conn, path, util.Quota{})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("path=%v\tchildren=%v\tlargest=%v bytes (%v)\tmax=%v bytes\tusage=%.1f%%\n", usage.Path, usage.Children, usage.LargestPayload, usage.LargestZNode, usage.MaxPayloadBytes, 100*usage.Fraction())
+			return nil
+		})
+
+	case "ensemble":
+		return util.WithZkSession(zkServers, *sessionTimeout, func(conn *zk.Conn) error {
+			servers, version, err := cluster.GetEnsemble(conn)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("version=%v\n", version)
+			for _, server := range servers {
+				fmt.Println(server)
+			}
+			return nil
+		})
+
+	case "reconfig":
+		if len(args) != 3 || (args[1] != "add" && args[1] != "remove") {
+			return fmt.Errorf("reconfig requires exactly 2 arguments: add <server.id=spec> | remove <id>")
+		}
+		var joining, leaving []string
+		if args[1] == "add" {
+			joining = []string{args[2]}
+		} else {
+			leaving = []string{args[2]}
+		}
+		return util.WithZkSession(zkServers, *sessionTimeout, func(conn *zk.Conn) error {
+			if err := cluster.Reconfig(conn, joining, leaving, -1); err != nil {
+				return err
+			}
+			fmt.Printf("Reconfigured ensemble (%v %v)\n", args[1], args[2])
+			return nil
+		})
+
+	default:
+		return fmt.Errorf("unrecognized command %q", args[0])
+	}
+}
+
+// newCodec returns the codec selected by -codec, wrapped in an EncryptingCodec
+// when -key-file is set.
+func newCodec() (cluster.NodeCodec, error) {
+	var codec cluster.NodeCodec
+	switch *codecName {
+	case "json":
+		codec = cluster.JSONCodec{}
+	case "compressed":
+		codec = cluster.CompressingCodec{}
+	case "curator":
+		codec = cluster.CuratorCodec{}
+	default:
+		return nil, fmt.Errorf("unrecognized codec %q", *codecName)
+	}
+	if *keyFile == "" {
+		return codec, nil
+	}
+	key, err := ioutil.ReadFile(*keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading key-file=%v: %s", *keyFile, err)
+	}
+	keys := util.StaticKeys{Current: *keyId, Keys: map[string][]byte{*keyId: key}}
+	return cluster.EncryptingCodec{Codec: codec, Keys: keys}, nil
+}
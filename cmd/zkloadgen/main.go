@@ -0,0 +1,148 @@
+// Command zkloadgen spins up N simulated cluster members against a real
+// ensemble and reports election and membership latencies, for capacity
+// planning.
+//
+// Usage:
+//
+//	zkloadgen -servers 127.0.0.1:2181 -path /loadgen -members 50 -duration 5m -failover-every 10s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/zklib/bench"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+)
+
+var (
+	servers        = flag.String("servers", "127.0.0.1:2181", "Comma-separated list of ZooKeeper host:port pairs")
+	electionPath   = flag.String("path", "/zkloadgen", "Election path")
+	sessionTimeout = flag.Duration("timeout", 5*time.Second, "ZooKeeper session timeout")
+	numMembers     = flag.Int("members", 10, "Number of simulated members")
+	duration       = flag.Duration("duration", 1*time.Minute, "How long to run for")
+	failoverEvery  = flag.Duration("failover-every", 10*time.Second, "Interval between forced leader failovers, 0 to disable")
+	membersEvery   = flag.Duration("members-every", 1*time.Second, "Interval between Members() latency probes, 0 to disable")
+	reportEvery    = flag.Duration("report-every", 10*time.Second, "Interval between progress reports")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n\nflags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *numMembers < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt)
+		<-sigChan
+		cancel()
+	}()
+
+	bench.DefaultSessionTimeout = *sessionTimeout
+	started := time.Now()
+	c, err := bench.NewCluster(ctx, strings.Split(*servers, ","), util.NormalizePath(*electionPath), *numMembers)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := c.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "error stopping members: %s\n", err)
+		}
+	}()
+	converged, err := c.WaitForConvergence(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("started %v members in %s, converged after a further %s\n", *numMembers, time.Since(started)-converged, converged)
+
+	// Updates are counted from the perspective of one member, which is
+	// replaced whenever it gets failed over.
+	var (
+		updates    = make(chan primitives.Update, 1000)
+		subscribed = c.Members[0]
+	)
+	subscribed.Subscribe(updates)
+
+	var (
+		failovers     []time.Duration
+		membersProbes []time.Duration
+		numUpdates    int
+		failoverCh    <-chan time.Time
+		membersCh     <-chan time.Time
+		reportTicker  = time.NewTicker(*reportEvery)
+	)
+	defer reportTicker.Stop()
+	if *failoverEvery > 0 {
+		ticker := time.NewTicker(*failoverEvery)
+		defer ticker.Stop()
+		failoverCh = ticker.C
+	}
+	if *membersEvery > 0 {
+		ticker := time.NewTicker(*membersEvery)
+		defer ticker.Stop()
+		membersCh = ticker.C
+	}
+
+	report := func() {
+		fmt.Printf("[%s] updates=%v\n", time.Since(started)/time.Second*time.Second, numUpdates)
+		fmt.Printf("\tfailover convergence: %v\n", bench.Summarize(failovers))
+		fmt.Printf("\tMembers() latency:    %v\n", bench.Summarize(membersProbes))
+	}
+	defer report()
+
+	for {
+		select {
+		case <-updates:
+			numUpdates++
+
+		case <-failoverCh:
+			wasSubscribed := c.Leader() == subscribed
+			elapsed, err := c.Failover(ctx)
+			if wasSubscribed && len(c.Members) > 0 {
+				subscribed = c.Members[0]
+				subscribed.Subscribe(updates)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failover: %s\n", err)
+				continue
+			}
+			failovers = append(failovers, elapsed)
+
+		case <-membersCh:
+			elapsed, err := bench.MembersLatency(c.Members[len(c.Members)-1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "members: %s\n", err)
+				continue
+			}
+			membersProbes = append(membersProbes, elapsed)
+
+		case <-reportTicker.C:
+			report()
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package config
+
+// Config-driven Coordinator bootstrap.
+//
+// A Config holds everything needed to construct a fully optioned Coordinator,
+// so deployments needn't hard-code connection details.  It is loaded from a
+// JSON or YAML file, e.g.:
+//
+//	servers: [zk1:2181, zk2:2181, zk3:2181]
+//	sessionTimeout: 10s
+//	namespace: /myapp/prod
+//	electionPath: scheduler
+//	acl:
+//	- {scheme: world, id: anyone, perms: cdrwa}
+//	retry:
+//	  interval: 100ms
+//
+// from environment variables (see FromEnv), or from a file with environment
+// variable overrides.  Validation errors are FieldErrors naming the offending
+// field by its file key.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"gopkg.in/yaml.v2"
+)
+
+type Config struct {
+	Servers        []string `json:"servers" yaml:"servers"` // ZooKeeper host/port pairs.
+	SessionTimeout Duration `json:"sessionTimeout" yaml:"sessionTimeout"`
+	ConnectTimeout Duration `json:"connectTimeout,omitempty" yaml:"connectTimeout,omitempty"`
+	RequestTimeout Duration `json:"requestTimeout,omitempty" yaml:"requestTimeout,omitempty"`
+
+	// Namespace is prepended to ElectionPath, e.g. "/myapp/prod".
+	Namespace    string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	ElectionPath string `json:"electionPath" yaml:"electionPath"`
+	Data         string `json:"data,omitempty" yaml:"data,omitempty"` // Member data.
+
+	// ACL is applied to the zNodes created for a missing election path.
+	ACL []ACL `json:"acl,omitempty" yaml:"acl,omitempty"`
+
+	Retry Retry `json:"retry,omitempty" yaml:"retry,omitempty"`
+
+	MinMembers           int      `json:"minMembers,omitempty" yaml:"minMembers,omitempty"`
+	WatchPredecessor     bool     `json:"watchPredecessor,omitempty" yaml:"watchPredecessor,omitempty"`
+	SyncReads            bool     `json:"syncReads,omitempty" yaml:"syncReads,omitempty"`
+	ContainerPaths       bool     `json:"containerPaths,omitempty" yaml:"containerPaths,omitempty"`
+	EnsembleDiscovery    bool     `json:"ensembleDiscovery,omitempty" yaml:"ensembleDiscovery,omitempty"`
+	LeaderVerifyInterval Duration `json:"leaderVerifyInterval,omitempty" yaml:"leaderVerifyInterval,omitempty"`
+	WatchdogTimeout      Duration `json:"watchdogTimeout,omitempty" yaml:"watchdogTimeout,omitempty"`
+	HandoffDelay         Duration `json:"handoffDelay,omitempty" yaml:"handoffDelay,omitempty"`
+}
+
+// Retry is the retry policy for failed ZooKeeper operations.
+type Retry struct {
+	Interval          Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	WatchRearmTimeout Duration `json:"watchRearmTimeout,omitempty" yaml:"watchRearmTimeout,omitempty"`
+}
+
+// ACL is a zk.ACL with permissions spelled as in zkCli, e.g. "cdrwa".
+type ACL struct {
+	Scheme string `json:"scheme" yaml:"scheme"`
+	ID     string `json:"id" yaml:"id"`
+	Perms  string `json:"perms" yaml:"perms"`
+}
+
+// FieldError is a validation error for the named field.
+type FieldError struct {
+	Field  string // File key, e.g. "retry.interval".
+	Reason string
+}
+
+func (err FieldError) Error() string {
+	return fmt.Sprintf("config: %v: %v", err.Field, err.Reason)
+}
+
+// Load reads a Config from a JSON file, or a YAML file if its extension is
+// .yaml or .yml, and validates it.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	}
+	return ParseJSON(data)
+}
+
+func ParseJSON(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("config: parsing JSON: %s", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func ParseYAML(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("config: parsing YAML: %s", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate returns a FieldError for the first invalid field.
+func (config *Config) Validate() error {
+	if len(config.Servers) == 0 {
+		return FieldError{"servers", "at least one server is required"}
+	}
+	for i, server := range config.Servers {
+		if strings.TrimSpace(server) == "" {
+			return FieldError{fmt.Sprintf("servers[%v]", i), "must not be empty"}
+		}
+	}
+	if config.ElectionPath == "" {
+		return FieldError{"electionPath", "is required"}
+	}
+	if config.Namespace != "" && !strings.HasPrefix(config.Namespace, "/") {
+		return FieldError{"namespace", fmt.Sprintf("must be absolute, got %q", config.Namespace)}
+	}
+	if config.SessionTimeout.Duration <= 0 && config.SessionTimeout.invalid == "" {
+		return FieldError{"sessionTimeout", "must be positive"}
+	}
+	for _, d := range []struct {
+		field    string
+		duration Duration
+	}{
+		{"sessionTimeout", config.SessionTimeout},
+		{"connectTimeout", config.ConnectTimeout},
+		{"requestTimeout", config.RequestTimeout},
+		{"retry.interval", config.Retry.Interval},
+		{"retry.watchRearmTimeout", config.Retry.WatchRearmTimeout},
+		{"leaderVerifyInterval", config.LeaderVerifyInterval},
+		{"watchdogTimeout", config.WatchdogTimeout},
+		{"handoffDelay", config.HandoffDelay},
+	} {
+		if d.duration.invalid != "" {
+			return FieldError{d.field, fmt.Sprintf("invalid duration %q", d.duration.invalid)}
+		}
+		if d.duration.Duration < 0 {
+			return FieldError{d.field, "must not be negative"}
+		}
+	}
+	for i, acl := range config.ACL {
+		if _, err := acl.zkACL(); err != nil {
+			return FieldError{fmt.Sprintf("acl[%v]", i), err.Error()}
+		}
+	}
+	if config.MinMembers < 0 {
+		return FieldError{"minMembers", "must not be negative"}
+	}
+	return nil
+}
+
+// LeaderElectionPath returns the ElectionPath within the Namespace.
+func (config *Config) LeaderElectionPath() string {
+	return util.NormalizePath(config.Namespace + "/" + config.ElectionPath)
+}
+
+// NewCoordinator validates the Config and constructs a Coordinator from it.
+func (config *Config) NewCoordinator(subscribers ...chan primitives.Update) (*cluster.Coordinator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	cc, err := cluster.NewCoordinator(config.Servers, config.SessionTimeout.Duration, config.LeaderElectionPath(), config.Data, subscribers...)
+	if err != nil {
+		return nil, err
+	}
+	cc.ConnectTimeout = config.ConnectTimeout.Duration
+	cc.RequestTimeout = config.RequestTimeout.Duration
+	cc.RetryInterval = config.Retry.Interval.Duration
+	cc.WatchRearmTimeout = config.Retry.WatchRearmTimeout.Duration
+	cc.MinMembers = config.MinMembers
+	cc.WatchPredecessor = config.WatchPredecessor
+	cc.SyncReads = config.SyncReads
+	cc.ContainerPaths = config.ContainerPaths
+	cc.EnsembleDiscovery = config.EnsembleDiscovery
+	cc.LeaderVerifyInterval = config.LeaderVerifyInterval.Duration
+	cc.WatchdogTimeout = config.WatchdogTimeout.Duration
+	cc.HandoffDelay = config.HandoffDelay.Duration
+	for _, acl := range config.ACL {
+		zkACL, _ := acl.zkACL() // NB: Already validated.
+		cc.PathACL = append(cc.PathACL, zkACL)
+	}
+	return cc, nil
+}
+
+var permLetters = map[rune]int32{
+	'c': zk.PermCreate,
+	'd': zk.PermDelete,
+	'r': zk.PermRead,
+	'w': zk.PermWrite,
+	'a': zk.PermAdmin,
+}
+
+func (acl ACL) zkACL() (zk.ACL, error) {
+	if acl.Scheme == "" {
+		return zk.ACL{}, fmt.Errorf("scheme is required")
+	}
+	if acl.Perms == "" {
+		return zk.ACL{}, fmt.Errorf("perms is required")
+	}
+	var perms int32
+	for _, letter := range acl.Perms {
+		perm, ok := permLetters[letter]
+		if !ok {
+			return zk.ACL{}, fmt.Errorf("invalid permission %q in perms=%q, must be a combination of \"cdrwa\"", letter, acl.Perms)
+		}
+		perms |= perm
+	}
+	return zk.ACL{Scheme: acl.Scheme, ID: acl.ID, Perms: perms}, nil
+}
+
+// Duration is a time.Duration spelled as in time.ParseDuration, e.g. "1.5s".
+// Plain numbers are taken as seconds.
+type Duration struct {
+	time.Duration
+	invalid string // Unparseable value, reported by Validate.
+}
+
+func (d *Duration) set(value string) {
+	d.Duration, d.invalid = 0, ""
+	if parsed, err := time.ParseDuration(value); err == nil {
+		d.Duration = parsed
+	} else if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		d.Duration = time.Duration(seconds * float64(time.Second))
+	} else {
+		d.invalid = value
+	}
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if value != nil {
+		d.set(fmt.Sprint(value))
+	}
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	d.set(value)
+	return nil
+}
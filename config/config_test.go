@@ -0,0 +1,140 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/config"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const exampleYAML = `
+servers: [zk1:2181, zk2:2181]
+sessionTimeout: 10s
+namespace: /myapp/prod
+electionPath: scheduler
+acl:
+- {scheme: world, id: anyone, perms: r}
+- {scheme: digest, id: "admin:hash", perms: cdrwa}
+retry:
+  interval: 100ms
+minMembers: 2
+watchPredecessor: true
+`
+
+func TestParseYAML(t *testing.T) {
+	cfg, err := config.ParseYAML([]byte(exampleYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := cfg.NewCoordinator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "/myapp/prod/scheduler", cfg.LeaderElectionPath(); actual != expected {
+		t.Errorf("Expected election path=%v but actual=%v", expected, actual)
+	}
+	if cc.RetryInterval != 100*time.Millisecond || cc.MinMembers != 2 || !cc.WatchPredecessor {
+		t.Errorf("Expected options to be applied, but RetryInterval=%v MinMembers=%v WatchPredecessor=%v", cc.RetryInterval, cc.MinMembers, cc.WatchPredecessor)
+	}
+	expectedACL := []zk.ACL{
+		{Perms: zk.PermRead, Scheme: "world", ID: "anyone"},
+		{Perms: zk.PermAll, Scheme: "digest", ID: "admin:hash"},
+	}
+	if len(cc.PathACL) != len(expectedACL) {
+		t.Fatalf("Expected PathACL=%+v but actual=%+v", expectedACL, cc.PathACL)
+	}
+	for i := range expectedACL {
+		if cc.PathACL[i] != expectedACL[i] {
+			t.Errorf("Expected PathACL[%v]=%+v but actual=%+v", i, expectedACL[i], cc.PathACL[i])
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	cfg, err := config.ParseJSON([]byte(`{"servers": ["zk1:2181"], "sessionTimeout": 5, "electionPath": "/scheduler", "retry": {"watchRearmTimeout": "2s"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SessionTimeout.Duration != 5*time.Second {
+		t.Errorf("Expected plain numbers to be taken as seconds, but sessionTimeout=%v", cfg.SessionTimeout)
+	}
+	if cfg.Retry.WatchRearmTimeout.Duration != 2*time.Second {
+		t.Errorf("Expected retry.watchRearmTimeout=2s but actual=%v", cfg.Retry.WatchRearmTimeout)
+	}
+}
+
+func TestValidationNamesField(t *testing.T) {
+	testCases := []struct {
+		yaml  string
+		field string
+	}{
+		{`{sessionTimeout: 1s, electionPath: x}`, "servers"},
+		{`{servers: [zk1], sessionTimeout: 1s}`, "electionPath"},
+		{`{servers: [zk1], electionPath: x}`, "sessionTimeout"},
+		{`{servers: [zk1], sessionTimeout: 5 parsecs, electionPath: x}`, "sessionTimeout"},
+		{`{servers: [zk1], sessionTimeout: 1s, electionPath: x, retry: {interval: soon}}`, "retry.interval"},
+		{`{servers: [zk1], sessionTimeout: 1s, electionPath: x, namespace: relative}`, "namespace"},
+		{`{servers: [zk1], sessionTimeout: 1s, electionPath: x, acl: [{scheme: world, id: anyone, perms: rx}]}`, "acl[0]"},
+		{`{servers: [zk1, " "], sessionTimeout: 1s, electionPath: x}`, "servers[1]"},
+	}
+	for i, testCase := range testCases {
+		_, err := config.ParseYAML([]byte(testCase.yaml))
+		fieldErr, ok := err.(config.FieldError)
+		if !ok {
+			t.Errorf("[i=%v] Expected a FieldError but actual err=%v", i, err)
+			continue
+		}
+		if fieldErr.Field != testCase.field {
+			t.Errorf("[i=%v] Expected error for field=%v but actual=%v (%s)", i, testCase.field, fieldErr.Field, fieldErr)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := map[string]string{
+		"TEST_ZKLIB_SERVERS":         "zk1:2181, zk2:2181",
+		"TEST_ZKLIB_SESSION_TIMEOUT": "3s",
+		"TEST_ZKLIB_ELECTION_PATH":   "scheduler",
+		"TEST_ZKLIB_ACL":             "digest:admin:hash:rw",
+		"TEST_ZKLIB_SYNC_READS":      "true",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	cfg, err := config.FromEnv("TEST_ZKLIB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Servers) != 2 || cfg.Servers[1] != "zk2:2181" {
+		t.Errorf("Expected 2 servers but actual=%v", cfg.Servers)
+	}
+	if cfg.SessionTimeout.Duration != 3*time.Second || !cfg.SyncReads {
+		t.Errorf("Expected sessionTimeout=3s and syncReads but actual=%v and %v", cfg.SessionTimeout, cfg.SyncReads)
+	}
+	if len(cfg.ACL) != 1 || cfg.ACL[0] != (config.ACL{Scheme: "digest", ID: "admin:hash", Perms: "rw"}) {
+		t.Errorf("Unexpected ACL=%+v", cfg.ACL)
+	}
+
+	// Environment variables override file settings.
+	fileCfg, err := config.ParseYAML([]byte(exampleYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fileCfg.ApplyEnv("TEST_ZKLIB"); err != nil {
+		t.Fatal(err)
+	}
+	if fileCfg.SessionTimeout.Duration != 3*time.Second || fileCfg.Namespace != "/myapp/prod" {
+		t.Errorf("Expected sessionTimeout to be overridden and namespace to be kept, but actual=%v and %v", fileCfg.SessionTimeout, fileCfg.Namespace)
+	}
+
+	os.Setenv("TEST_ZKLIB_MIN_MEMBERS", "lots")
+	defer os.Unsetenv("TEST_ZKLIB_MIN_MEMBERS")
+	if _, err := config.FromEnv("TEST_ZKLIB"); err == nil || err.(config.FieldError).Field != "minMembers" {
+		t.Errorf("Expected a FieldError for minMembers but actual err=%v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultEnvPrefix is used by FromEnv and ApplyEnv when no prefix is given.
+const DefaultEnvPrefix = "ZKLIB"
+
+// envVar maps an environment variable suffix to the Config field it sets.
+type envVar struct {
+	suffix string // e.g. "SESSION_TIMEOUT", read as ZKLIB_SESSION_TIMEOUT.
+	field  string // File key, for FieldErrors.
+	set    func(config *Config, value string) error
+}
+
+var envVars = []envVar{
+	{"SERVERS", "servers", func(config *Config, value string) error {
+		config.Servers = splitList(value)
+		return nil
+	}},
+	{"SESSION_TIMEOUT", "sessionTimeout", setDuration(func(config *Config) *Duration { return &config.SessionTimeout })},
+	{"CONNECT_TIMEOUT", "connectTimeout", setDuration(func(config *Config) *Duration { return &config.ConnectTimeout })},
+	{"REQUEST_TIMEOUT", "requestTimeout", setDuration(func(config *Config) *Duration { return &config.RequestTimeout })},
+	{"NAMESPACE", "namespace", func(config *Config, value string) error {
+		config.Namespace = value
+		return nil
+	}},
+	{"ELECTION_PATH", "electionPath", func(config *Config, value string) error {
+		config.ElectionPath = value
+		return nil
+	}},
+	{"DATA", "data", func(config *Config, value string) error {
+		config.Data = value
+		return nil
+	}},
+	{"ACL", "acl", func(config *Config, value string) error {
+		acls := []ACL{}
+		for _, entry := range splitList(value) {
+			// scheme:id:perms, where the id may itself contain colons.
+			first, last := strings.Index(entry, ":"), strings.LastIndex(entry, ":")
+			if first == -1 || first == last {
+				return fmt.Errorf("invalid entry %q, must be of the form scheme:id:perms", entry)
+			}
+			acls = append(acls, ACL{Scheme: entry[:first], ID: entry[first+1 : last], Perms: entry[last+1:]})
+		}
+		config.ACL = acls
+		return nil
+	}},
+	{"RETRY_INTERVAL", "retry.interval", setDuration(func(config *Config) *Duration { return &config.Retry.Interval })},
+	{"RETRY_WATCH_REARM_TIMEOUT", "retry.watchRearmTimeout", setDuration(func(config *Config) *Duration { return &config.Retry.WatchRearmTimeout })},
+	{"MIN_MEMBERS", "minMembers", func(config *Config, value string) (err error) {
+		config.MinMembers, err = strconv.Atoi(value)
+		return
+	}},
+	{"WATCH_PREDECESSOR", "watchPredecessor", setBool(func(config *Config) *bool { return &config.WatchPredecessor })},
+	{"SYNC_READS", "syncReads", setBool(func(config *Config) *bool { return &config.SyncReads })},
+	{"CONTAINER_PATHS", "containerPaths", setBool(func(config *Config) *bool { return &config.ContainerPaths })},
+	{"ENSEMBLE_DISCOVERY", "ensembleDiscovery", setBool(func(config *Config) *bool { return &config.EnsembleDiscovery })},
+	{"LEADER_VERIFY_INTERVAL", "leaderVerifyInterval", setDuration(func(config *Config) *Duration { return &config.LeaderVerifyInterval })},
+	{"WATCHDOG_TIMEOUT", "watchdogTimeout", setDuration(func(config *Config) *Duration { return &config.WatchdogTimeout })},
+	{"HANDOFF_DELAY", "handoffDelay", setDuration(func(config *Config) *Duration { return &config.HandoffDelay })},
+}
+
+// FromEnv builds a Config from environment variables named after the file
+// keys, e.g. ZKLIB_SERVERS=zk1:2181,zk2:2181 and ZKLIB_RETRY_INTERVAL=100ms
+// with the default prefix.  ACLs are given as comma-separated scheme:id:perms
+// entries.
+func FromEnv(prefix string) (*Config, error) {
+	config := &Config{}
+	if err := config.ApplyEnv(prefix); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides fields with whichever of the environment variables read
+// by FromEnv are set, e.g. on top of a Config loaded from a file.  The result
+// is not validated.
+func (config *Config) ApplyEnv(prefix string) error {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	for _, v := range envVars {
+		name := prefix + "_" + v.suffix
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := v.set(config, strings.TrimSpace(value)); err != nil {
+			return FieldError{v.field, fmt.Sprintf("from %v: %s", name, err)}
+		}
+	}
+	return nil
+}
+
+func setDuration(field func(config *Config) *Duration) func(config *Config, value string) error {
+	return func(config *Config, value string) error {
+		field(config).set(value)
+		return nil
+	}
+}
+
+func setBool(field func(config *Config) *bool) func(config *Config, value string) error {
+	return func(config *Config, value string) (err error) {
+		*field(config), err = strconv.ParseBool(value)
+		return
+	}
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
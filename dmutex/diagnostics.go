@@ -0,0 +1,155 @@
+package dmutex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// LockOwner is the owner metadata published in every lock zNode.
+type LockOwner struct {
+	Hostname  string `json:"hostname"`
+	Pid       int    `json:"pid"`
+	Breakable bool   `json:"breakable,omitempty"` // Whether the owner opted in to having its lock broken, see LockManager.Breakable.
+	Broken    bool   `json:"broken,omitempty"`    // Set by the contender breaking the lock, just before deleting it.
+	SessionId int64  `json:"-"`                   // ZooKeeper session of the owner.
+}
+
+// WaiterInfo describes a contender queued for a lock, see WaitersInfo.
+type WaiterInfo struct {
+	ZNode  string        // Full path of the contender's lock zNode.
+	Holder bool          // Whether the contender is first in line, i.e. holds the lock.
+	Owner  LockOwner     // Empty when the contender did not publish any metadata.
+	Since  time.Time     // When the contender joined the queue, or acquired the lock for the holder, as per the ZooKeeper server's clock.
+	Wait   time.Duration // How long ago Since was, as per the local clock.
+}
+
+// WaitersInfo lists the contenders for the lock at path in order, the holder
+// first, for diagnosing stuck or contended locks.
+func WaitersInfo(conn *zk.Conn, path string) ([]WaiterInfo, error) {
+	path = zkutil.NormalizePath(path)
+	children, _, err := conn.Children(path)
+	if err == zk.ErrNoNode {
+		return []WaiterInfo{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("LockManager: listing children of path=%v: %s", path, err)
+	}
+	zkutil.SortBySequence(children)
+
+	waiters := make([]WaiterInfo, 0, len(children))
+	for _, child := range children {
+		data, stat, err := conn.Get(path + "/" + child)
+		if err == zk.ErrNoNode {
+			continue // Departed meanwhile.
+		} else if err != nil {
+			return nil, fmt.Errorf("LockManager: reading lock zNode=%v: %s", child, err)
+		}
+		waiters = append(waiters, waiterInfo(path+"/"+child, data, stat, len(waiters) == 0))
+	}
+	return waiters, nil
+}
+
+// WaitersInfo lists the contenders for the shard lock key hashes into, see
+// the package level WaitersInfo.
+func (lm *LockManager) WaitersInfo(key string) ([]WaiterInfo, error) {
+	conn, err := lm.connection()
+	if err != nil {
+		return nil, err
+	}
+	return WaitersInfo(conn, lm.shardFor(key).path)
+}
+
+func waiterInfo(zNode string, data []byte, stat *zk.Stat, holder bool) WaiterInfo {
+	since := stat.Ctime
+	if holder {
+		since = stat.Mtime // Touched upon acquisition.
+	}
+	info := WaiterInfo{
+		ZNode:  zNode,
+		Holder: holder,
+		Since:  time.Unix(0, since*int64(time.Millisecond)),
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &info.Owner); err != nil {
+			log.Debugf("LockManager: ignoring unparseable owner metadata of lock zNode=%v: %s", zNode, err)
+		}
+	}
+	info.Owner.SessionId = stat.EphemeralOwner
+	info.Wait = time.Since(info.Since)
+	return info
+}
+
+// ownerData returns the owner metadata to publish in lock zNodes.
+func (lm *LockManager) ownerData() []byte {
+	owner := LockOwner{Pid: os.Getpid(), Breakable: lm.Breakable}
+	owner.Hostname, _ = os.Hostname()
+	data, _ := json.Marshal(&owner)
+	return data
+}
+
+// suspectAbandoned is invoked by the contender next in line once the holder
+// of a lock has held it for longer than MaxHoldTime.  It logs the suspected
+// abandoned lock, and breaks it when BreakAbandoned is set and the holder is
+// Breakable.  The lock zNode, whose data and version are given, is first
+// marked as broken so the holder learns why it loses the lock (LeaseBroken)
+// and deletes it; should the holder not do so within the session timeout,
+// e.g. because it is wedged, the contender deletes it instead.
+func (lm *LockManager) suspectAbandoned(ctx context.Context, conn *zk.Conn, holder WaiterInfo, data []byte, version int32) {
+	if !lm.BreakAbandoned || !holder.Owner.Breakable {
+		log.Warnf("LockManager: suspected abandoned lock zNode=%v, held for %s (over MaxHoldTime=%s) by host=%v pid=%v session=%#x", holder.ZNode, holder.Wait, lm.MaxHoldTime, holder.Owner.Hostname, holder.Owner.Pid, holder.Owner.SessionId)
+		return
+	}
+	log.Warnf("LockManager: breaking suspected abandoned lock zNode=%v, held for %s (over MaxHoldTime=%s) by host=%v pid=%v session=%#x", holder.ZNode, holder.Wait, lm.MaxHoldTime, holder.Owner.Hostname, holder.Owner.Pid, holder.Owner.SessionId)
+	var owner LockOwner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		log.Warnf("LockManager: breaking lock zNode=%v: %s", holder.ZNode, err)
+		return
+	}
+	owner.Broken = true
+	marked, _ := json.Marshal(&owner)
+	// NB: Conditional on the version read, so a lock released and reacquired
+	// meanwhile isn't broken.
+	if _, err := conn.Set(holder.ZNode, marked, version); err != nil {
+		if err != zk.ErrNoNode && err != zk.ErrBadVersion {
+			log.Warnf("LockManager: marking lock zNode=%v as broken: %s", holder.ZNode, err)
+		}
+		return
+	}
+	exists, _, watch, err := conn.ExistsW(holder.ZNode)
+	if err != nil || !exists {
+		return
+	}
+	lm.resources.Watch(holder.ZNode, watch)
+	defer lm.resources.Unwatch(watch)
+	select {
+	case <-watch:
+		return // Acknowledged by the holder.
+	case <-time.After(lm.sessionTimeout):
+	case <-ctx.Done():
+		return
+	}
+	if err := conn.Delete(holder.ZNode, -1); err != nil && err != zk.ErrNoNode {
+		log.Warnf("LockManager: breaking lock zNode=%v: %s", holder.ZNode, err)
+	}
+}
+
+// broken reports whether the lock zNode has been marked as broken by a
+// contender, see suspectAbandoned.
+func (lm *LockManager) broken(conn *zk.Conn, zNode string) bool {
+	data, _, err := conn.Get(zNode)
+	if err == zk.ErrNoNode {
+		return false // Deletion is handled by the caller.
+	} else if err != nil {
+		log.Warnf("LockManager: reading lock zNode=%v: %s", zNode, err)
+		return false
+	}
+	var owner LockOwner
+	return json.Unmarshal(data, &owner) == nil && owner.Broken
+}
@@ -2,6 +2,7 @@ package dmutex_test
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
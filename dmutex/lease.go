@@ -0,0 +1,184 @@
+package dmutex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	leaseWatchRetryInterval = 50 * time.Millisecond
+)
+
+var (
+	LeaseReleased       = errors.New("Lease: released")
+	LeaseSessionExpired = errors.New("Lease: ZooKeeper session expired")
+	LeaseZNodeLost      = errors.New("Lease: lock zNode disappeared")
+	LeaseBroken         = errors.New("Lease: lock broken by a contender, having been held for too long")
+)
+
+// Lease represents exclusive ownership of a key.  It remains valid for as long
+// as the ZooKeeper session backing it is alive (renewal happens implicitly via
+// session heartbeats) and the lock zNode exists.
+//
+// Once revoked, Done() is closed and Err() reports the reason.  When
+// revocation was not requested via Release(), the Revoked callback is invoked
+// so holders know exactly when to stop using the resource.
+//
+// Leases are scoped to the LockManager which granted them: stopping it
+// revokes them with LockManagerNotRunning.  They are independent of any
+// cluster.Coordinator, whose membership uses a session of its own, and no
+// semaphore recipe exists to pair them with yet.
+type Lease struct {
+	Key     string
+	ZNode   string
+	Revoked func(lease *Lease, err error)
+	manager *LockManager
+	done    chan struct{}
+	err     error
+	once    sync.Once
+	errLock sync.Mutex
+}
+
+// Done returns a channel which is closed once the lease is no longer held.
+func (lease *Lease) Done() <-chan struct{} {
+	return lease.done
+}
+
+// Err returns nil while the lease is held, otherwise the reason it ended.
+func (lease *Lease) Err() error {
+	lease.errLock.Lock()
+	defer lease.errLock.Unlock()
+	return lease.err
+}
+
+// Release voluntarily gives up the lease and the underlying key lock.
+func (lease *Lease) Release() error {
+	if !lease.end(LeaseReleased) {
+		return LockManagerKeyNotHeld
+	}
+	shard := lease.manager.shardFor(lease.Key)
+	shard.lock.Lock()
+	delete(shard.leases, lease)
+	shard.lock.Unlock()
+	return lease.manager.ReleaseKey(lease.Key)
+}
+
+// revoke must only be invoked while holding the lease's shard lock.
+func (lease *Lease) revoke(err error) {
+	if !lease.end(err) {
+		return
+	}
+	log.Infof("Lease for key=%v zNode=%v revoked: %s", lease.Key, lease.ZNode, err)
+	if lease.Revoked != nil {
+		lease.manager.resources.Go("lease-revoked", func() { lease.Revoked(lease, err) })
+	}
+}
+
+func (lease *Lease) end(err error) (ended bool) {
+	lease.once.Do(func() {
+		lease.errLock.Lock()
+		lease.err = err
+		lease.errLock.Unlock()
+		close(lease.done)
+		ended = true
+	})
+	return
+}
+
+// AcquireLease blocks until the lock for key has been obtained or ctx is done,
+// and returns a Lease tied to the lifetime of the lock.  The revoked callback
+// is optional.
+func (lm *LockManager) AcquireLease(ctx context.Context, key string, revoked func(lease *Lease, err error)) (*Lease, error) {
+	if err := lm.AcquireKey(ctx, key); err != nil {
+		return nil, err
+	}
+	shard := lm.shardFor(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	lease := &Lease{
+		Key:     key,
+		ZNode:   shard.zNode,
+		Revoked: revoked,
+		manager: lm,
+		done:    make(chan struct{}),
+	}
+	shard.leases[lease] = struct{}{}
+	return lease, nil
+}
+
+// watchShard revokes all leases on the shard if the lock zNode for the given
+// generation disappears.
+func (lm *LockManager) watchShard(conn *zk.Conn, shard *lockShard, zNode string, generation int) {
+	for {
+		exists, _, watch, err := conn.ExistsW(zNode)
+		if err == zk.ErrConnectionClosed || err == zk.ErrClosing || err == zk.ErrSessionExpired {
+			return
+		}
+		if err == nil && exists {
+			ev := <-lm.resources.Watch(zNode, watch)
+			lm.resources.Unwatch(watch)
+			if ev.Type == zk.EventNodeDataChanged && lm.broken(conn, zNode) {
+				shard.lock.Lock()
+				if shard.generation == generation && shard.zNode == zNode {
+					lm.dropShard(shard, LeaseBroken)
+				}
+				shard.lock.Unlock()
+				// NB: Deleting it acknowledges the break to the contender.
+				if err := conn.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+					log.Warnf("LockManager: deleting broken lock zNode=%v: %s", zNode, err)
+				}
+				return
+			}
+			if ev.Type != zk.EventNodeDeleted {
+				if ev.Type == zk.EventNotWatching {
+					// Session expiry is handled by the connection event handler.
+					return
+				}
+				continue
+			}
+		} else if err != nil {
+			log.Warnf("LockManager: watching lock zNode=%v: %s", zNode, err)
+			time.Sleep(leaseWatchRetryInterval)
+			continue
+		}
+
+		shard.lock.Lock()
+		if shard.generation == generation && shard.zNode == zNode {
+			lm.dropShard(shard, LeaseZNodeLost)
+		}
+		shard.lock.Unlock()
+		return
+	}
+}
+
+// revokeAll drops every shard lock, e.g. when the session has expired.
+func (lm *LockManager) revokeAll(err error) {
+	for _, shard := range lm.shards {
+		shard.lock.Lock()
+		if shard.zNode != "" {
+			lm.dropShard(shard, err)
+		}
+		shard.lock.Unlock()
+	}
+}
+
+// dropShard forgets about the held shard lock and revokes its leases.  It must
+// only be invoked while holding shard.lock.
+func (lm *LockManager) dropShard(shard *lockShard, err error) {
+	lm.resources.Disown(shard.zNode)
+	for lease := range shard.leases {
+		lease.revoke(err)
+	}
+	shard.leases = map[*Lease]struct{}{}
+	shard.keys = map[string]struct{}{}
+	shard.zNode = ""
+	close(shard.released)
+	shard.released = make(chan struct{})
+}
@@ -0,0 +1,391 @@
+package dmutex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	DefaultNumLockShards = 64
+)
+
+var (
+	LockManagerAlreadyStarted = errors.New("LockManager: already started")
+	LockManagerNotRunning     = errors.New("LockManager: not running")
+	LockManagerKeyNotHeld     = errors.New("LockManager: key is not locked")
+
+	DefaultLockLinger = time.Duration(0) // Default duration to keep idle shard locks before releasing them.
+)
+
+var (
+	worldAllAcl = zk.WorldACL(zk.PermAll)
+)
+
+// LockManager provides fine-grained per-key locks by hashing keys into a
+// bounded set of shard lock zNodes.  All shards share a single ZooKeeper
+// session, and concurrent local acquisitions of the same shard share a single
+// zNode and watch.
+//
+// Keys which hash to the same shard are mutually exclusive across processes,
+// but may be held concurrently within one process.
+type LockManager struct {
+	zkServers      []string
+	sessionTimeout time.Duration
+	basePath       string
+	Linger         time.Duration // How long to keep holding an idle shard lock in anticipation of further acquisitions.
+
+	// MaxHoldTime, when non-zero, is how long a shard lock may be held before
+	// the contender next in line logs it as suspected abandoned, e.g. by a
+	// process which is wedged yet still heartbeating.  Holders touch their lock
+	// zNode upon acquisition, and the contender compares its modification time
+	// with that of its own freshly touched zNode, so hold times are measured
+	// from acquisition by the ZooKeeper server's clock.
+	MaxHoldTime time.Duration
+
+	// BreakAbandoned additionally makes the contender next in line break
+	// suspected abandoned locks whose holders are Breakable: the holder's
+	// leases are revoked with LeaseBroken and its lock zNode deleted.
+	BreakAbandoned bool
+
+	// Breakable opts the locks held by this LockManager in to being broken by
+	// contenders with BreakAbandoned set.  Must be set before Start().
+	Breakable bool
+
+	conn      *zk.Conn
+	shards    []*lockShard
+	resources primitives.Tracker
+	stateLock sync.Mutex
+}
+
+type lockShard struct {
+	path       string
+	lock       sync.Mutex
+	keys       map[string]struct{}
+	leases     map[*Lease]struct{}
+	zNode      string        // Full path of the held lock zNode, empty when the shard lock is not held.
+	pending    chan struct{} // Non-nil while a ZooKeeper acquisition is in flight.
+	released   chan struct{} // Closed and replaced every time a key is released.
+	generation int           // Incremented every time the shard lock is acquired.
+}
+
+func NewLockManager(zkServers []string, sessionTimeout time.Duration, basePath string, numShards int) *LockManager {
+	if numShards <= 0 {
+		numShards = DefaultNumLockShards
+	}
+	basePath = zkutil.NormalizePath(basePath)
+	shards := make([]*lockShard, numShards)
+	for i := range shards {
+		shards[i] = &lockShard{
+			path:     fmt.Sprintf("%v/shard-%04d", basePath, i),
+			keys:     map[string]struct{}{},
+			leases:   map[*Lease]struct{}{},
+			released: make(chan struct{}),
+		}
+	}
+	lm := &LockManager{
+		zkServers:      zkServers,
+		sessionTimeout: sessionTimeout,
+		basePath:       basePath,
+		Linger:         DefaultLockLinger,
+		shards:         shards,
+	}
+	return lm
+}
+
+func (lm *LockManager) Start() error {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+
+	if lm.conn != nil {
+		return LockManagerAlreadyStarted
+	}
+
+	conn, eventCh, err := zk.Connect(lm.zkServers, lm.sessionTimeout)
+	if err != nil {
+		return fmt.Errorf("LockManager: connecting: %s", err)
+	}
+	lm.conn = conn
+
+	lm.resources.Go("session-events", func() {
+		for ev := range eventCh {
+			log.Debugf("LockManager basePath=%v: received event=%+v", lm.basePath, ev)
+			if ev.Type == zk.EventSession && ev.State == zk.StateExpired {
+				log.Warnf("LockManager basePath=%v: session expired, dropping all held locks", lm.basePath)
+				lm.revokeAll(LeaseSessionExpired)
+			}
+		}
+	})
+
+	log.Infof("LockManager basePath=%v started with numShards=%v", lm.basePath, len(lm.shards))
+	return nil
+}
+
+// Stop closes the ZooKeeper session, which releases all held shard locks.
+func (lm *LockManager) Stop() error {
+	lm.stateLock.Lock()
+	conn := lm.conn
+	lm.conn = nil
+	lm.stateLock.Unlock()
+
+	if conn == nil {
+		return LockManagerNotRunning
+	}
+
+	conn.Close()
+
+	// NB: Shard locks must not be acquired while holding stateLock.
+	lm.revokeAll(LockManagerNotRunning)
+
+	log.Infof("LockManager basePath=%v stopped", lm.basePath)
+	return nil
+}
+
+// Resources returns an account of the watches, background goroutines and lock
+// zNodes the LockManager currently holds.
+func (lm *LockManager) Resources() primitives.Resources {
+	return lm.resources.Resources()
+}
+
+// AcquireKey blocks until the lock for key has been obtained or ctx is done.
+func (lm *LockManager) AcquireKey(ctx context.Context, key string) error {
+	shard := lm.shardFor(key)
+
+	for {
+		conn, err := lm.connection()
+		if err != nil {
+			return err
+		}
+
+		shard.lock.Lock()
+
+		// Wait for any local holder of the same key.
+		if _, held := shard.keys[key]; held {
+			released := shard.released
+			shard.lock.Unlock()
+			select {
+			case <-released:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// Piggy-back on the already held shard lock.
+		if shard.zNode != "" {
+			shard.keys[key] = struct{}{}
+			shard.lock.Unlock()
+			return nil
+		}
+
+		// Share the in-flight acquisition with other local waiters.
+		if pending := shard.pending; pending != nil {
+			shard.lock.Unlock()
+			select {
+			case <-pending:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		pending := make(chan struct{})
+		shard.pending = pending
+		shard.lock.Unlock()
+
+		zNode, err := lm.lockPath(ctx, conn, shard.path)
+
+		shard.lock.Lock()
+		shard.pending = nil
+		close(pending)
+		if err != nil {
+			shard.lock.Unlock()
+			return err
+		}
+		shard.zNode = zNode
+		shard.generation++
+		shard.keys[key] = struct{}{}
+		generation := shard.generation
+		lm.resources.Go("watch-shard", func() { lm.watchShard(conn, shard, zNode, generation) })
+		shard.lock.Unlock()
+
+		log.Debugf("LockManager acquired shard=%v for key=%v", shard.path, key)
+		return nil
+	}
+}
+
+// ReleaseKey releases a lock previously obtained via AcquireKey.
+func (lm *LockManager) ReleaseKey(key string) error {
+	shard := lm.shardFor(key)
+
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	if _, held := shard.keys[key]; !held {
+		return LockManagerKeyNotHeld
+	}
+	delete(shard.keys, key)
+	close(shard.released)
+	shard.released = make(chan struct{})
+
+	if len(shard.keys) > 0 || shard.zNode == "" {
+		return nil
+	}
+
+	if lm.Linger > 0 {
+		generation := shard.generation
+		time.AfterFunc(lm.Linger, func() {
+			shard.lock.Lock()
+			defer shard.lock.Unlock()
+			if shard.generation == generation && len(shard.keys) == 0 && shard.zNode != "" {
+				if err := lm.unlockShard(shard); err != nil {
+					log.Warnf("LockManager: releasing lingering shard=%v: %s", shard.path, err)
+				}
+			}
+		})
+		return nil
+	}
+	return lm.unlockShard(shard)
+}
+
+// unlockShard must only be invoked while holding shard.lock.
+func (lm *LockManager) unlockShard(shard *lockShard) error {
+	for lease := range shard.leases {
+		lease.end(LeaseReleased)
+	}
+	shard.leases = map[*Lease]struct{}{}
+
+	conn, err := lm.connection()
+	if err != nil {
+		// Session is gone, and so is the ephemeral zNode.
+		lm.resources.Disown(shard.zNode)
+		shard.zNode = ""
+		return nil
+	}
+	zNode := shard.zNode
+	shard.zNode = ""
+	if err := conn.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+		return fmt.Errorf("LockManager: deleting lock zNode=%v: %s", zNode, err)
+	}
+	lm.resources.Disown(zNode)
+	log.Debugf("LockManager released shard=%v", shard.path)
+	return nil
+}
+
+func (lm *LockManager) connection() (*zk.Conn, error) {
+	lm.stateLock.Lock()
+	defer lm.stateLock.Unlock()
+
+	if lm.conn == nil {
+		return nil, LockManagerNotRunning
+	}
+	return lm.conn, nil
+}
+
+func (lm *LockManager) shardFor(key string) *lockShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return lm.shards[h.Sum32()%uint32(len(lm.shards))]
+}
+
+// lockPath implements the standard ZooKeeper lock recipe under path, where
+// each contender only watches its immediate predecessor.  The full path of the
+// created lock zNode is returned once the lock is held.
+func (lm *LockManager) lockPath(ctx context.Context, conn *zk.Conn, path string) (string, error) {
+	if _, err := zkutil.CreateP(conn, path, []byte{}, 0, worldAllAcl); err != nil {
+		return "", fmt.Errorf("LockManager: creating path=%v: %s", path, err)
+	}
+	data := lm.ownerData()
+	zNode, err := conn.CreateProtectedEphemeralSequential(path+"/lock-", data, worldAllAcl)
+	if err != nil {
+		return "", fmt.Errorf("LockManager: creating lock zNode under path=%v: %s", path, err)
+	}
+	lm.resources.Own(zNode)
+
+	abandon := func(err error) (string, error) {
+		if delErr := conn.Delete(zNode, -1); delErr != nil && delErr != zk.ErrNoNode {
+			log.Warnf("LockManager: deleting abandoned lock zNode=%v: %s", zNode, delErr)
+		} else {
+			lm.resources.Disown(zNode)
+		}
+		return "", err
+	}
+
+	var (
+		name      = zNode[strings.LastIndex(zNode, "/")+1:]
+		suspected string // Holder zNode already reported as suspected abandoned.
+	)
+
+	for {
+		children, _, err := conn.Children(path)
+		if err != nil {
+			return abandon(fmt.Errorf("LockManager: listing children of path=%v: %s", path, err))
+		}
+		zkutil.SortBySequence(children)
+
+		idx := -1
+		for i, child := range children {
+			if child == name {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			return abandon(fmt.Errorf("LockManager: lock zNode=%v disappeared while waiting", zNode))
+		}
+		if idx == 0 {
+			// Touch the zNode so its modification time marks the acquisition.
+			if _, err := conn.Set(zNode, data, -1); err != nil {
+				return abandon(fmt.Errorf("LockManager: touching acquired lock zNode=%v: %s", zNode, err))
+			}
+			return zNode, nil
+		}
+
+		predecessor := path + "/" + children[idx-1]
+		exists, stat, watch, err := conn.ExistsW(predecessor)
+		if err != nil {
+			return abandon(fmt.Errorf("LockManager: watching predecessor of zNode=%v: %s", zNode, err))
+		}
+		if !exists {
+			continue
+		}
+		lm.resources.Watch(predecessor, watch)
+
+		// Next in line keeps an eye on how long the holder has held the lock,
+		// as per the server's clock: touching the local zNode yields the
+		// server's current time to compare the holder's acquisition with.
+		var overdueCh <-chan time.Time
+		if idx == 1 && lm.MaxHoldTime > 0 && predecessor != suspected {
+			touched, err := conn.Set(zNode, data, -1)
+			if err != nil {
+				lm.resources.Unwatch(watch)
+				return abandon(fmt.Errorf("LockManager: touching lock zNode=%v: %s", zNode, err))
+			}
+			held := time.Duration(touched.Mtime-stat.Mtime) * time.Millisecond
+			overdueCh = time.After(lm.MaxHoldTime - held)
+		}
+		select {
+		case <-watch:
+			lm.resources.Unwatch(watch)
+		case <-overdueCh:
+			lm.resources.Unwatch(watch)
+			suspected = predecessor
+			if holderData, holderStat, err := conn.Get(predecessor); err == nil {
+				lm.suspectAbandoned(ctx, conn, waiterInfo(predecessor, holderData, holderStat, true), holderData, holderStat.Version)
+			}
+		case <-ctx.Done():
+			lm.resources.Unwatch(watch)
+			return abandon(ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package dmutex_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/dmutex"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var zkTimeout = 5 * time.Second
+
+func TestLockManager(t *testing.T) {
+	zkPath := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, zkPath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		// A single shard forces every key to contend for the same lock zNode.
+		var (
+			lm1 = dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+			lm2 = dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+		)
+		for _, lm := range []*dmutex.LockManager{lm1, lm2} {
+			if err := lm.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer lm.Stop()
+		}
+
+		if err := lm1.AcquireKey(context.Background(), "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := lm1.AcquireKey(context.Background(), "b"); err != nil {
+			t.Fatalf("Acquiring a distinct key in an already held shard should succeed locally: %s", err)
+		}
+
+		// The same key must not be handed out twice locally.
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		if err := lm1.AcquireKey(ctx, "a"); err != context.DeadlineExceeded {
+			t.Fatalf("Expected err=%s when acquiring an already held key but actual err=%v", context.DeadlineExceeded, err)
+		}
+		cancel()
+
+		// Other processes must wait for the shard.
+		ctx, cancel = context.WithTimeout(context.Background(), 250*time.Millisecond)
+		if err := lm2.AcquireKey(ctx, "c"); err != context.DeadlineExceeded {
+			t.Fatalf("Expected err=%s when acquiring a key in a shard held elsewhere but actual err=%v", context.DeadlineExceeded, err)
+		}
+		cancel()
+
+		acquired := make(chan error, 1)
+		go func() {
+			acquired <- lm2.AcquireKey(context.Background(), "c")
+		}()
+
+		for _, key := range []string{"a", "b"} {
+			if err := lm1.ReleaseKey(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := lm1.ReleaseKey("a"); err != dmutex.LockManagerKeyNotHeld {
+			t.Fatalf("Expected err=%s when releasing an unheld key but actual err=%v", dmutex.LockManagerKeyNotHeld, err)
+		}
+
+		select {
+		case err := <-acquired:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for second LockManager to acquire the shard")
+		}
+		if err := lm2.ReleaseKey("c"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLockManagerLease(t *testing.T) {
+	zkPath := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, zkPath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		lm := dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+		if err := lm.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		revokedCh := make(chan error, 1)
+		lease, err := lm.AcquireLease(context.Background(), "a", func(_ *dmutex.Lease, err error) {
+			revokedCh <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lease.Err(); err != nil {
+			t.Fatalf("Expected freshly acquired lease to be valid but err=%s", err)
+		}
+
+		// Losing the lock zNode out from under the holder must revoke the lease.
+		err = zkutil.WithZkSession(zkServers, zkTimeout, func(conn *zk.Conn) error {
+			return conn.Delete(lease.ZNode, -1)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case err := <-revokedCh:
+			if err != dmutex.LeaseZNodeLost {
+				t.Fatalf("Expected revocation err=%s but actual=%v", dmutex.LeaseZNodeLost, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for lease revocation")
+		}
+		select {
+		case <-lease.Done():
+		default:
+			t.Fatalf("Expected lease Done() chan to be closed after revocation")
+		}
+
+		// A voluntary release must not trigger the callback.
+		lease, err = lm.AcquireLease(context.Background(), "a", func(_ *dmutex.Lease, err error) {
+			revokedCh <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lease.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if err := lease.Err(); err != dmutex.LeaseReleased {
+			t.Fatalf("Expected err=%s after release but actual=%v", dmutex.LeaseReleased, err)
+		}
+		select {
+		case err := <-revokedCh:
+			t.Fatalf("Unexpected revocation callback after voluntary release: %s", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// Stopping the LockManager revokes the leases still held.
+		lease, err = lm.AcquireLease(context.Background(), "b", func(_ *dmutex.Lease, err error) {
+			revokedCh <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lm.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-revokedCh:
+			if err != dmutex.LockManagerNotRunning {
+				t.Fatalf("Expected revocation err=%s but actual=%v", dmutex.LockManagerNotRunning, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for lease revocation upon Stop")
+		}
+	})
+}
+
+func TestLockManagerDiagnostics(t *testing.T) {
+	zkPath := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, zkPath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		var (
+			holder = dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+			waiter = dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+		)
+		holder.Breakable = true
+		waiter.MaxHoldTime = 1 * time.Second
+		waiter.BreakAbandoned = true
+		for _, lm := range []*dmutex.LockManager{holder, waiter} {
+			if err := lm.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer lm.Stop()
+		}
+
+		revokedCh := make(chan error, 1)
+		lease, err := holder.AcquireLease(context.Background(), "a", func(_ *dmutex.Lease, err error) {
+			revokedCh <- err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		acquiredCh := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			acquiredCh <- waiter.AcquireKey(ctx, "b")
+		}()
+
+		// Both contenders are listed in order along with their owner metadata.
+		var waiters []dmutex.WaiterInfo
+		deadline := time.Now().Add(5 * time.Second)
+		for len(waiters) < 2 && time.Now().Before(deadline) {
+			if waiters, err = holder.WaitersInfo("a"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(waiters) != 2 {
+			t.Fatalf("Expected 2 waiters but actual=%+v", waiters)
+		}
+		if !waiters[0].Holder || waiters[0].ZNode != lease.ZNode || waiters[1].Holder {
+			t.Errorf("Expected the lease holder to be listed first, but waiters=%+v", waiters)
+		}
+		for _, waiter := range waiters {
+			if waiter.Owner.Pid != os.Getpid() || waiter.Owner.SessionId == 0 {
+				t.Errorf("Expected owner metadata for waiter=%+v", waiter)
+			}
+		}
+
+		// The holder exceeds the waiter's MaxHoldTime and has opted in to being
+		// broken, so the waiter breaks the lock.
+		select {
+		case err := <-acquiredCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for the abandoned lock to be broken")
+		}
+		select {
+		case err := <-revokedCh:
+			if err != dmutex.LeaseBroken {
+				t.Errorf("Expected revocation err=%s but actual=%v", dmutex.LeaseBroken, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("Timed out waiting for the holder's lease to be revoked")
+		}
+	})
+}
+
+func TestLockManagerResources(t *testing.T) {
+	zkPath := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, zkPath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		lm := dmutex.NewLockManager(zkServers, 5*time.Second, zkPath, 1)
+		checkLeaks := zktestutil.CheckLeaks(t, lm)
+		if err := lm.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		lease, err := lm.AcquireLease(context.Background(), "a", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources := lm.Resources()
+		if len(resources.ZNodes) != 1 || resources.ZNodes[0] != lease.ZNode {
+			t.Errorf("Expected lock zNode=%v to be owned but actual=%v", lease.ZNode, resources.ZNodes)
+		}
+		if count := countString(resources.Goroutines, "watch-shard"); count != 1 {
+			t.Errorf("Expected a single goroutine watching the lock zNode but actual=%v", resources.Goroutines)
+		}
+
+		if err := lease.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if resources := lm.Resources(); len(resources.ZNodes) != 0 {
+			t.Errorf("Expected no zNodes to be owned once released but actual=%v", resources.ZNodes)
+		}
+
+		if err := lm.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		checkLeaks()
+	})
+}
+
+func countString(haystack []string, needle string) int {
+	count := 0
+	for _, s := range haystack {
+		if s == needle {
+			count++
+		}
+	}
+	return count
+}
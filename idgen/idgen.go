@@ -0,0 +1,310 @@
+package idgen
+
+// Snowflake-style distributed ID generator.
+//
+// Every Generator leases a worker id from the bounded pool [0, MaxWorkers) by
+// creating the ephemeral zNode <basePath>/workers/<id>, then generates IDs
+// locally without further coordination.  IDs are positive 64-bit integers
+// made up of, from most to least significant, 41 bits of milliseconds since
+// Epoch, 10 bits of worker id and 12 bits of per-millisecond sequence, so
+// they are unique for as long as worker ids are and ordered by time.
+//
+// Worker ids are reclaimed when the session of their holder expires, as the
+// ephemeral zNode vanishes along with it.  A Generator therefore stops issuing
+// IDs as soon as its connection is lost, since by the time it learns of the
+// expiry another process may already have leased the same worker id, and
+// resumes once it has confirmed or re-leased a worker id.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	TimestampBits = 41
+	WorkerIdBits  = 10
+	SequenceBits  = 12
+
+	MaxWorkers = 1 << WorkerIdBits
+
+	workersDir  = "workers"
+	maxSequence = 1<<SequenceBits - 1
+)
+
+var (
+	// DefaultEpoch is the default zero point of ID timestamps.
+	DefaultEpoch = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	NotRunningError    = errors.New("idgen: not running")
+	NoWorkerIdError    = errors.New("idgen: no worker id is presently leased")
+	PoolExhaustedError = errors.New("idgen: every worker id is leased")
+
+	worldAllAcl   = zk.WorldACL(zk.PermAll)
+	retryInterval = 1 * time.Second
+)
+
+// Generator issues unique IDs.  Exported fields must be set before Start().
+type Generator struct {
+	// Epoch defaults to DefaultEpoch, and must be the same for every Generator
+	// sharing a basePath.
+	Epoch time.Time
+
+	// MaxWorkers bounds the pool of worker ids, defaults to and must not exceed
+	// the package level MaxWorkers.
+	MaxWorkers int
+
+	zkServers      []string
+	sessionTimeout time.Duration
+	basePath       string
+	conn           *zk.Conn
+	workerId       int    // -1 when none is leased.
+	zNode          string // Full path of the leased worker id zNode.
+	valid          bool   // Whether the lease is confirmed to be held by the current session.
+	lastMillis     int64
+	sequence       int64
+	quitChan       chan struct{}
+	doneChan       chan struct{}
+	lock           sync.Mutex
+}
+
+func New(zkServers []string, sessionTimeout time.Duration, basePath string) *Generator {
+	g := &Generator{
+		zkServers:      zkServers,
+		sessionTimeout: sessionTimeout,
+		basePath:       zkutil.NormalizePath(basePath),
+		workerId:       -1,
+	}
+	return g
+}
+
+// Start connects and leases a worker id.
+func (g *Generator) Start() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.conn != nil {
+		return errors.New("idgen: already started")
+	}
+	if g.MaxWorkers < 0 || g.MaxWorkers > MaxWorkers {
+		return fmt.Errorf("idgen: invalid MaxWorkers=%v, must not exceed %v", g.MaxWorkers, MaxWorkers)
+	}
+	conn, eventCh, err := zk.Connect(g.zkServers, g.sessionTimeout)
+	if err != nil {
+		return fmt.Errorf("idgen: connecting: %s", err)
+	}
+	workerId, zNode, err := g.lease(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	g.workerId, g.zNode, g.valid = workerId, zNode, true
+	g.conn = conn
+	g.quitChan = make(chan struct{})
+	g.doneChan = make(chan struct{})
+	go g.run(conn, eventCh, g.quitChan, g.doneChan)
+	return nil
+}
+
+// Stop closes the session, which releases the worker id.
+func (g *Generator) Stop() error {
+	g.lock.Lock()
+	conn, quitChan, doneChan := g.conn, g.quitChan, g.doneChan
+	g.conn, g.quitChan, g.doneChan = nil, nil, nil
+	g.valid = false
+	g.lock.Unlock()
+
+	if conn == nil {
+		return NotRunningError
+	}
+	close(quitChan)
+	<-doneChan
+	conn.Close()
+
+	g.lock.Lock()
+	g.workerId, g.zNode = -1, ""
+	g.lock.Unlock()
+	return nil
+}
+
+// WorkerId returns the leased worker id, and whether IDs may presently be
+// issued with it.
+func (g *Generator) WorkerId() (int, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.workerId, g.valid
+}
+
+// NextId returns a new unique ID, or NoWorkerIdError while the worker id lease
+// is in doubt, e.g. during a connection loss.  It never blocks: should the
+// clock go backwards or the sequence run out within a millisecond, the
+// timestamp is advanced past the wall clock instead.
+func (g *Generator) NextId() (int64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.conn == nil {
+		return 0, NotRunningError
+	}
+	if !g.valid {
+		return 0, NoWorkerIdError
+	}
+	millis := int64(time.Since(g.epoch()) / time.Millisecond)
+	if millis < g.lastMillis {
+		millis = g.lastMillis
+	}
+	if millis == g.lastMillis {
+		if g.sequence++; g.sequence > maxSequence {
+			millis++
+			g.sequence = 0
+		}
+	} else {
+		g.sequence = 0
+	}
+	if millis >= 1<<TimestampBits {
+		return 0, fmt.Errorf("idgen: timestamps exhausted, epoch=%v is too far in the past", g.epoch())
+	}
+	g.lastMillis = millis
+	return millis<<(WorkerIdBits+SequenceBits) | int64(g.workerId)<<SequenceBits | g.sequence, nil
+}
+
+// Decompose splits an ID into when it was issued, the worker id which issued
+// it and its sequence number.
+func (g *Generator) Decompose(id int64) (at time.Time, workerId int, sequence int) {
+	millis := id >> (WorkerIdBits + SequenceBits)
+	at = g.epoch().Add(time.Duration(millis) * time.Millisecond)
+	workerId = int(id>>SequenceBits) & (MaxWorkers - 1)
+	sequence = int(id & maxSequence)
+	return
+}
+
+// run follows the session, suspending ID issuance while disconnected and
+// confirming or re-leasing the worker id once a session is (re-)established.
+func (g *Generator) run(conn *zk.Conn, eventCh <-chan zk.Event, quitChan chan struct{}, doneChan chan struct{}) {
+	defer close(doneChan)
+	var retryCh <-chan time.Time
+	for {
+		select {
+		case ev := <-eventCh:
+			if ev.Type != zk.EventSession {
+				continue
+			}
+			switch ev.State {
+			case zk.StateHasSession:
+				retryCh = nil
+				if err := g.revalidate(conn); err != nil {
+					log.Warnf("idgen basePath=%v: re-leasing worker id (will retry): %s", g.basePath, err)
+					retryCh = time.After(retryInterval)
+				}
+			case zk.StateDisconnected, zk.StateExpired:
+				g.lock.Lock()
+				if g.valid {
+					log.Warnf("idgen basePath=%v: connection lost (state=%v), suspending worker id=%v", g.basePath, ev.State, g.workerId)
+				}
+				g.valid = false
+				g.lock.Unlock()
+			}
+
+		case <-retryCh:
+			retryCh = nil
+			if err := g.revalidate(conn); err != nil {
+				log.Warnf("idgen basePath=%v: re-leasing worker id (will retry): %s", g.basePath, err)
+				retryCh = time.After(retryInterval)
+			}
+
+		case <-quitChan:
+			return
+		}
+	}
+}
+
+// revalidate resumes with the leased worker id if the current session still
+// holds it, and otherwise leases a new one.
+func (g *Generator) revalidate(conn *zk.Conn) error {
+	g.lock.Lock()
+	zNode := g.zNode
+	g.lock.Unlock()
+
+	if zNode != "" {
+		exists, stat, err := conn.Exists(zNode)
+		if err != nil {
+			return err
+		}
+		if exists && stat.EphemeralOwner == conn.SessionID() {
+			g.lock.Lock()
+			g.valid = true
+			g.lock.Unlock()
+			return nil
+		}
+		log.Infof("idgen basePath=%v: worker id zNode=%v was reclaimed", g.basePath, zNode)
+	}
+	workerId, zNode, err := g.lease(conn)
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	g.workerId, g.zNode, g.valid = workerId, zNode, true
+	g.lock.Unlock()
+	return nil
+}
+
+// lease claims the lowest free worker id, and returns it along with the full
+// path of its zNode.
+func (g *Generator) lease(conn *zk.Conn) (int, string, error) {
+	workersPath := g.basePath + "/" + workersDir
+	if _, err := zkutil.EnsurePath(conn, workersPath, zkutil.EnsurePathOptions{ACL: worldAllAcl}); err != nil {
+		return -1, "", fmt.Errorf("idgen: creating path=%v: %s", workersPath, err)
+	}
+	children, _, err := conn.Children(workersPath)
+	if err != nil {
+		return -1, "", fmt.Errorf("idgen: listing worker ids: %s", err)
+	}
+	taken := make([]int, 0, len(children))
+	for _, child := range children {
+		if id, err := strconv.Atoi(child); err == nil {
+			taken = append(taken, id)
+		}
+	}
+	sort.Ints(taken)
+
+	hostname, _ := os.Hostname()
+	data := []byte(fmt.Sprintf("%v:%v", hostname, os.Getpid()))
+	for id, i := 0, 0; id < g.maxWorkers(); id++ {
+		if i < len(taken) && taken[i] == id {
+			i++
+			continue
+		}
+		zNode := fmt.Sprintf("%v/%04d", workersPath, id)
+		if _, err := conn.Create(zNode, data, zk.FlagEphemeral, worldAllAcl); err == zk.ErrNodeExists {
+			continue // Leased by someone else meanwhile.
+		} else if err != nil {
+			return -1, "", fmt.Errorf("idgen: leasing worker id=%v: %s", id, err)
+		}
+		log.Infof("idgen basePath=%v: leased worker id=%v", g.basePath, id)
+		return id, zNode, nil
+	}
+	return -1, "", PoolExhaustedError
+}
+
+func (g *Generator) epoch() time.Time {
+	if g.Epoch.IsZero() {
+		return DefaultEpoch
+	}
+	return g.Epoch
+}
+
+func (g *Generator) maxWorkers() int {
+	if g.MaxWorkers > 0 {
+		return g.MaxWorkers
+	}
+	return MaxWorkers
+}
@@ -0,0 +1,85 @@
+package idgen_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/idgen"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+)
+
+var zkTimeout = 5 * time.Second
+
+func TestGenerator(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/idgen", testlib.CurrentRunningTest())
+
+		generators := []*idgen.Generator{}
+		for i := 0; i < 3; i++ {
+			g := idgen.New(zkServers, zkTimeout, basePath)
+			g.MaxWorkers = 2
+			err := g.Start()
+			if i == 2 {
+				// The pool only holds two worker ids.
+				if err != idgen.PoolExhaustedError {
+					t.Fatalf("Expected err=%s but actual err=%v", idgen.PoolExhaustedError, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer g.Stop()
+			generators = append(generators, g)
+		}
+
+		seen := map[int64]struct{}{}
+		for i, g := range generators {
+			workerId, ok := g.WorkerId()
+			if !ok || workerId != i {
+				t.Fatalf("Expected generator #%v to hold worker id=%v but actual=%v ok=%v", i, i, workerId, ok)
+			}
+			var previous int64
+			for j := 0; j < 10000; j++ {
+				id, err := g.NextId()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if id <= previous {
+					t.Fatalf("Expected id=%v to be greater than previous=%v", id, previous)
+				}
+				if _, ok := seen[id]; ok {
+					t.Fatalf("Duplicate id=%v", id)
+				}
+				seen[id] = struct{}{}
+				previous = id
+			}
+			at, decodedWorkerId, _ := g.Decompose(previous)
+			if decodedWorkerId != workerId {
+				t.Errorf("Expected decomposed worker id=%v but actual=%v", workerId, decodedWorkerId)
+			}
+			if skew := time.Since(at); skew < -time.Second || skew > time.Second {
+				t.Errorf("Expected decomposed timestamp=%v to be close to now", at)
+			}
+		}
+
+		// Stopping releases the worker id for reuse.
+		if err := generators[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := generators[0].NextId(); err != idgen.NotRunningError {
+			t.Errorf("Expected err=%s but actual err=%v", idgen.NotRunningError, err)
+		}
+		g := idgen.New(zkServers, zkTimeout, basePath)
+		g.MaxWorkers = 2
+		if err := g.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer g.Stop()
+		if workerId, ok := g.WorkerId(); !ok || workerId != 0 {
+			t.Errorf("Expected the released worker id=0 to be reused but actual=%v ok=%v", workerId, ok)
+		}
+	})
+}
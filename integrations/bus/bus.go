@@ -0,0 +1,186 @@
+// Package bus bridges Coordinator updates onto an external message bus, so
+// services which don't participate in an election can still react to
+// leadership and membership changes.
+//
+// A Bridge subscribes to a Coordinator, serializes every update it receives
+// into an Event with an EventCodec and hands the result to a Publisher.  The
+// natsbus and kafkabus sub-packages provide Publishers for NATS and Kafka,
+// built with the "nats" and "kafka" build tags respectively so their client
+// libraries are only required when used:
+//
+//	bridge := bus.NewBridge(cc, natsbus.NewPublisher(nc), "zklib.updates")
+//	bridge.LeaderOnly = true
+//	go bridge.Run(ctx)
+//
+// Events carry the members' decoded data, as they are meant for services
+// outside the election: a cluster.EncryptingCodec only protects the data held
+// in ZooKeeper.  Use an EventCodec which encrypts, or secure the bus itself,
+// when the data is sensitive.
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+)
+
+var DefaultPublishTimeout = 5 * time.Second
+
+// Publisher delivers messages to a message bus.  key groups related messages,
+// e.g. it selects the Kafka partition, so the events of an election stay in
+// order.
+type Publisher interface {
+	Publish(ctx context.Context, topic string, key []byte, payload []byte) error
+	Close() error
+}
+
+// Event is the form in which an update is published.
+type Event struct {
+	Type         string                   `json:"type"` // e.g. "leader", see primitives.UpdateType.
+	ElectionPath string                   `json:"electionPath"`
+	Leader       *primitives.Node         `json:"leader"` // Nil when there is no leader.
+	Mode         string                   `json:"mode"`   // Mode of the publishing member.
+	Member       string                   `json:"member"` // Uuid of the publishing member.
+	Deltas       []primitives.MemberDelta `json:"deltas,omitempty"`
+	At           time.Time                `json:"at"`
+}
+
+// NewEvent returns the Event for update, as published by member at the given
+// time.
+func NewEvent(update primitives.Update, member string, at time.Time) Event {
+	event := Event{
+		Type:         update.Type.String(),
+		ElectionPath: update.ElectionPath,
+		Mode:         update.Mode,
+		Member:       member,
+		Deltas:       update.Deltas,
+		At:           at,
+	}
+	if update.Leader.Uuid != uuid.Nil {
+		leader := update.Leader
+		event.Leader = &leader
+	}
+	return event
+}
+
+// EventCodec converts between an Event and the payload of a message.
+type EventCodec interface {
+	Encode(event Event) ([]byte, error)
+	Decode(payload []byte) (Event, error)
+}
+
+// DefaultEventCodec is used when a Bridge has no Codec configured.
+var DefaultEventCodec EventCodec = JSONEventCodec{}
+
+// JSONEventCodec publishes events as JSON.
+type JSONEventCodec struct{}
+
+func (JSONEventCodec) Encode(event Event) ([]byte, error) {
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return nil, fmt.Errorf("encoding event to JSON: %s", err)
+	}
+	return payload, nil
+}
+
+func (JSONEventCodec) Decode(payload []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, fmt.Errorf("decoding %v bytes of JSON: %s", len(payload), err)
+	}
+	return event, nil
+}
+
+// Bridge forwards the updates of a Coordinator to a Publisher.  Exported
+// fields must be set before Run is invoked.
+type Bridge struct {
+	Coordinator *cluster.Coordinator
+	Publisher   Publisher
+	Topic       string
+
+	// Codec serializes events.  Defaults to DefaultEventCodec when nil.
+	Codec EventCodec
+
+	// Filter selects the kinds of updates which are forwarded, see
+	// Coordinator.Subscribe.  Zero forwards every update.
+	Filter cluster.UpdateFilter
+
+	// LeaderOnly restricts publishing to the leader, so every member may run a
+	// Bridge without each update being published once per member.
+	LeaderOnly bool
+
+	// PublishTimeout bounds each publish.  Defaults to DefaultPublishTimeout.
+	PublishTimeout time.Duration
+}
+
+func NewBridge(cc *cluster.Coordinator, publisher Publisher, topic string) *Bridge {
+	bridge := &Bridge{
+		Coordinator:    cc,
+		Publisher:      publisher,
+		Topic:          topic,
+		PublishTimeout: DefaultPublishTimeout,
+	}
+	return bridge
+}
+
+// Run forwards updates until ctx is done.  Failed publishes are logged and
+// not retried, consumers catch up with the next update.  The Publisher is
+// left open.
+func (bridge *Bridge) Run(ctx context.Context) error {
+	updates := make(chan primitives.Update, 10)
+	bridge.Coordinator.Subscribe(updates, bridge.Filter)
+	// NB: Updates are delivered without blocking, so unsubscribing with some
+	// left unreceived is fine.
+	defer bridge.Coordinator.Unsubscribe(updates)
+
+	for {
+		select {
+		case update := <-updates:
+			if err := bridge.Forward(ctx, update); err != nil {
+				log.Warnf("bus: %v: forwarding update type=%v to topic=%v: %s", bridge.Coordinator.Id(), update.Type, bridge.Topic, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Forward publishes a single update, keyed by its election path.  It is a
+// no-op on followers when LeaderOnly is set.
+func (bridge *Bridge) Forward(ctx context.Context, update primitives.Update) error {
+	if bridge.LeaderOnly && update.Mode != primitives.Leader {
+		return nil
+	}
+	event := NewEvent(update, bridge.Coordinator.LocalNode.Uuid.String(), time.Now())
+	payload, err := bridge.codec().Encode(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, bridge.publishTimeout())
+	defer cancel()
+	if err := bridge.Publisher.Publish(ctx, bridge.Topic, []byte(update.ElectionPath), payload); err != nil {
+		return fmt.Errorf("publishing: %s", err)
+	}
+	return nil
+}
+
+func (bridge *Bridge) codec() EventCodec {
+	if bridge.Codec == nil {
+		return DefaultEventCodec
+	}
+	return bridge.Codec
+}
+
+func (bridge *Bridge) publishTimeout() time.Duration {
+	if bridge.PublishTimeout > 0 {
+		return bridge.PublishTimeout
+	}
+	return DefaultPublishTimeout
+}
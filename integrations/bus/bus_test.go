@@ -0,0 +1,138 @@
+package bus_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/integrations/bus"
+	"github.com/gigawattio/zklib/testutil"
+)
+
+type message struct {
+	topic   string
+	key     string
+	payload []byte
+}
+
+// memoryPublisher collects published messages.
+type memoryPublisher struct {
+	messages []message
+	lock     sync.Mutex
+}
+
+func (publisher *memoryPublisher) Publish(_ context.Context, topic string, key []byte, payload []byte) error {
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	publisher.messages = append(publisher.messages, message{topic, string(key), payload})
+	return nil
+}
+
+func (publisher *memoryPublisher) Close() error {
+	return nil
+}
+
+func (publisher *memoryPublisher) published() []message {
+	publisher.lock.Lock()
+	defer publisher.lock.Unlock()
+	return append([]message{}, publisher.messages...)
+}
+
+func TestJSONEventCodec(t *testing.T) {
+	leader := primitives.NewNode("host-a")
+	update := primitives.Update{Type: primitives.LeaderUpdate, Leader: *leader, Mode: primitives.Leader, ElectionPath: "/election"}
+	event := bus.NewEvent(update, leader.Uuid.String(), time.Unix(1500000000, 0).UTC())
+
+	payload, err := bus.JSONEventCodec{}.Encode(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"electionPath":"/election"`) {
+		t.Errorf("Expected camelCase field names but payload=%s", payload)
+	}
+	decoded, err := bus.JSONEventCodec{}.Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != "leader" || decoded.ElectionPath != "/election" || decoded.Member != leader.Uuid.String() || !decoded.At.Equal(event.At) {
+		t.Errorf("Expected decoded event=%+v but actual=%+v", event, decoded)
+	}
+	if decoded.Leader == nil || decoded.Leader.Uuid != leader.Uuid {
+		t.Errorf("Expected decoded leader=%v but actual=%v", leader, decoded.Leader)
+	}
+
+	if event := bus.NewEvent(primitives.Update{Type: primitives.DegradedUpdate}, "", time.Now()); event.Leader != nil {
+		t.Errorf("Expected no leader for an update without one but actual=%v", event.Leader)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		electionPath := testutil.Namespace(t)
+		cc, err := cluster.NewCoordinator(zkServers, 1*time.Second, electionPath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		publisher := &memoryPublisher{}
+		bridge := bus.NewBridge(cc, publisher, "updates")
+		bridge.LeaderOnly = true
+		runCtx, stopBridge := context.WithCancel(ctx)
+		errCh := make(chan error, 1)
+		go func() { errCh <- bridge.Run(runCtx) }()
+
+		// A member joining produces an update on the leader.
+		follower, err := cluster.NewCoordinator(zkServers, 1*time.Second, electionPath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := follower.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := follower.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		var messages []message
+		for len(messages) == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("Timed out waiting for the leadership to be published")
+			case <-time.After(50 * time.Millisecond):
+				messages = publisher.published()
+			}
+		}
+		stopBridge()
+		if err := <-errCh; err != context.Canceled {
+			t.Errorf("Expected err=%v but actual=%v", context.Canceled, err)
+		}
+
+		if messages[0].topic != "updates" || messages[0].key != electionPath {
+			t.Errorf("Expected topic=updates and key=%v but actual=%+v", electionPath, messages[0])
+		}
+		event, err := bus.JSONEventCodec{}.Decode(messages[0].payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Leader == nil || event.Leader.Uuid != cc.LocalNode.Uuid {
+			t.Errorf("Expected the local member to be published as leader but actual=%+v", event)
+		}
+	})
+}
@@ -0,0 +1,7 @@
+// Package kafkabus provides a bus.Publisher for Kafka.
+//
+// It depends on github.com/Shopify/sarama, which the rest of zklib does not, so it is
+// only built with the "kafka" build tag:
+//
+//	go build -tags kafka
+package kafkabus
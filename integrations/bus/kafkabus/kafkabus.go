@@ -0,0 +1,71 @@
+//go:build kafka
+// +build kafka
+
+package kafkabus
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+)
+
+// Publisher publishes to Kafka topics through a synchronous producer.  Events
+// are keyed by election path, so those of an election land on one partition
+// and stay in order.
+type Publisher struct {
+	Producer sarama.SyncProducer
+
+	// CloseProducer has Close close Producer as well.  By default Producer
+	// belongs to the caller.
+	CloseProducer bool
+}
+
+func NewPublisher(producer sarama.SyncProducer) *Publisher {
+	publisher := &Publisher{
+		Producer: producer,
+	}
+	return publisher
+}
+
+// NewPublisherFromBrokers connects a new producer to brokers, which is closed
+// along with the Publisher.  A nil config uses the sarama defaults.
+func NewPublisherFromBrokers(brokers []string, config *sarama.Config) (*Publisher, error) {
+	if config == nil {
+		config = sarama.NewConfig()
+	}
+	// NB: Required by sync producers.
+	config.Producer.Return.Successes = true
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	publisher := &Publisher{
+		Producer:      producer,
+		CloseProducer: true,
+	}
+	return publisher, nil
+}
+
+// Publish sends the message.  The producer's own timeouts apply, ctx is only
+// checked beforehand since sarama doesn't support cancellation.
+func (publisher *Publisher) Publish(ctx context.Context, topic string, key []byte, payload []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(payload),
+	}
+	if len(key) > 0 {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	_, _, err := publisher.Producer.SendMessage(msg)
+	return err
+}
+
+func (publisher *Publisher) Close() error {
+	if publisher.CloseProducer {
+		return publisher.Producer.Close()
+	}
+	return nil
+}
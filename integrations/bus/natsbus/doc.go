@@ -0,0 +1,7 @@
+// Package natsbus provides a bus.Publisher for NATS.
+//
+// It depends on github.com/nats-io/nats.go, which the rest of zklib does not, so it is
+// only built with the "nats" build tag:
+//
+//	go build -tags nats
+package natsbus
@@ -0,0 +1,50 @@
+//go:build nats
+// +build nats
+
+package natsbus
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// KeyHeader is the message header carrying the key of a published event, as
+// NATS subjects have no notion of one.
+const KeyHeader = "Zklib-Key"
+
+// Publisher publishes to NATS subjects, flushing after each message so
+// Publish only returns once the server has received it.
+type Publisher struct {
+	Conn *nats.Conn
+
+	// CloseConn has Close close Conn as well.  By default Conn belongs to the
+	// caller.
+	CloseConn bool
+}
+
+func NewPublisher(conn *nats.Conn) *Publisher {
+	publisher := &Publisher{
+		Conn: conn,
+	}
+	return publisher
+}
+
+func (publisher *Publisher) Publish(ctx context.Context, subject string, key []byte, payload []byte) error {
+	msg := nats.NewMsg(subject)
+	msg.Data = payload
+	if len(key) > 0 {
+		msg.Header.Set(KeyHeader, string(key))
+	}
+	if err := publisher.Conn.PublishMsg(msg); err != nil {
+		return err
+	}
+	return publisher.Conn.FlushWithContext(ctx)
+}
+
+func (publisher *Publisher) Close() error {
+	if publisher.CloseConn {
+		publisher.Conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+// Package grpcresolver provides a gRPC name resolver backed by cluster
+// membership, so gRPC clients can load balance over the members of an
+// election natively.
+//
+// A target such as "zk:///my-service" resolves to the advertised addresses of
+// the members of the election at <BasePath>/my-service, and address updates
+// are pushed whenever members join or depart.  Members are listed in
+// candidate order, so with the default Election the leader comes first and
+// the default pick_first policy talks to it, while e.g. round_robin spreads
+// calls over every member:
+//
+//	resolver.Register(grpcresolver.NewBuilder(conn))
+//	client, err := grpc.Dial("zk:///my-service", grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"round_robin"}`), ...)
+//
+// It depends on google.golang.org/grpc, which the rest of zklib does not and
+// which requires a recent Go version, so it is only built with the "grpc"
+// build tag:
+//
+//	go build -tags grpc
+package grpcresolver
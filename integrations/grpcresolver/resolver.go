@@ -0,0 +1,188 @@
+//go:build grpc
+// +build grpc
+
+package grpcresolver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+	"google.golang.org/grpc/resolver"
+)
+
+const DefaultScheme = "zk"
+
+var retryInterval = 1 * time.Second
+
+// Builder is a resolver.Builder for election members.  Exported fields must
+// be set before the Builder is registered.
+type Builder struct {
+	// Conn is used to watch elections.  It belongs to the caller, who must keep
+	// it open for as long as any gRPC client using the Builder.
+	Conn *zk.Conn
+
+	// SchemeName defaults to DefaultScheme.
+	SchemeName string
+
+	// BasePath is prepended to target endpoints to form election paths.
+	BasePath string
+
+	// PathLayout and Codec must match those of the Coordinators being
+	// resolved.  Codec defaults to cluster.DefaultCodec.
+	PathLayout cluster.PathLayout
+	Codec      cluster.NodeCodec
+
+	// Address extracts each member's advertised address, defaults to
+	// cluster.DataAddress.  Members without an address are skipped.
+	Address cluster.AddressFunc
+}
+
+func NewBuilder(conn *zk.Conn) *Builder {
+	builder := &Builder{
+		Conn: conn,
+	}
+	return builder
+}
+
+// Build starts watching the election named by target.
+func (builder *Builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	endpoint := target.Endpoint()
+	if endpoint == "" {
+		return nil, fmt.Errorf("grpcresolver: target=%v names no election", target.URL.String())
+	}
+	electionPath := util.NormalizePath(builder.BasePath + "/" + endpoint)
+	r := &electionResolver{
+		builder:        builder,
+		cc:             cc,
+		candidatesPath: builder.PathLayout.CandidatesPath(electionPath),
+		resolveNowChan: make(chan struct{}, 1),
+		quitChan:       make(chan struct{}),
+		doneChan:       make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+func (builder *Builder) Scheme() string {
+	if builder.SchemeName != "" {
+		return builder.SchemeName
+	}
+	return DefaultScheme
+}
+
+// electionResolver pushes the addresses of an election's members to a
+// resolver.ClientConn.
+type electionResolver struct {
+	builder        *Builder
+	cc             resolver.ClientConn
+	candidatesPath string
+	resolveNowChan chan struct{}
+	quitChan       chan struct{}
+	doneChan       chan struct{}
+	closeOnce      sync.Once
+}
+
+func (r *electionResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNowChan <- struct{}{}:
+	default:
+	}
+}
+
+func (r *electionResolver) Close() {
+	r.closeOnce.Do(func() {
+		close(r.quitChan)
+		<-r.doneChan
+	})
+}
+
+func (r *electionResolver) run() {
+	defer close(r.doneChan)
+	for {
+		var (
+			evCh    <-chan zk.Event
+			retryCh <-chan time.Time
+		)
+		addresses, watch, err := r.resolve()
+		if err != nil {
+			log.Warnf("grpcresolver: resolving path=%v (will retry): %s", r.candidatesPath, err)
+			r.cc.ReportError(err)
+			retryCh = time.After(retryInterval)
+		} else {
+			evCh = watch
+			if err := r.cc.UpdateState(resolver.State{Addresses: addresses}); err != nil {
+				log.Debugf("grpcresolver: path=%v: ClientConn rejected update: %s", r.candidatesPath, err)
+			}
+		}
+		select {
+		case <-evCh:
+		case <-retryCh:
+		case <-r.resolveNowChan:
+		case <-r.quitChan:
+			return
+		}
+	}
+}
+
+// resolve lists the members' addresses in candidate order, and arms a watch
+// for membership changes.
+func (r *electionResolver) resolve() ([]resolver.Address, <-chan zk.Event, error) {
+	conn := r.builder.Conn
+	children, _, evCh, err := conn.ChildrenW(r.candidatesPath)
+	if err == zk.ErrNoNode {
+		// Nobody has joined yet; wait for the election to be created.
+		exists, _, existsCh, err := conn.ExistsW(r.candidatesPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		if exists {
+			return r.resolve()
+		}
+		return []resolver.Address{}, existsCh, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	addresses := []resolver.Address{}
+	for _, child := range r.builder.PathLayout.SortedCandidates(children) {
+		data, _, err := conn.Get(r.candidatesPath + "/" + child)
+		if err == zk.ErrNoNode {
+			continue // Departed meanwhile, the watch will fire.
+		} else if err != nil {
+			return nil, nil, err
+		}
+		node, err := r.builder.codec().Decode(data)
+		if err != nil {
+			log.Warnf("grpcresolver: skipping member=%v: %s", child, err)
+			continue
+		}
+		address, err := r.builder.addressOf(node)
+		if err != nil {
+			log.Debugf("grpcresolver: skipping member=%v: %s", child, err)
+			continue
+		}
+		addresses = append(addresses, resolver.Address{Addr: address})
+	}
+	return addresses, evCh, nil
+}
+
+func (builder *Builder) codec() cluster.NodeCodec {
+	if builder.Codec == nil {
+		return cluster.DefaultCodec
+	}
+	return builder.Codec
+}
+
+func (builder *Builder) addressOf(node primitives.Node) (string, error) {
+	if builder.Address != nil {
+		return builder.Address(node)
+	}
+	return cluster.DataAddress(node)
+}
@@ -0,0 +1,106 @@
+//go:build grpc
+// +build grpc
+
+package grpcresolver_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/integrations/grpcresolver"
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"google.golang.org/grpc/resolver"
+)
+
+const zkTimeout = 5 * time.Second
+
+// fakeClientConn records the addresses pushed by a resolver.
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan []string
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	addrs := []string{}
+	for _, address := range state.Addresses {
+		addrs = append(addrs, address.Addr)
+	}
+	cc.states <- addrs
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {}
+
+func TestResolver(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		testutil.WhenZkHasSession(zkEvents, func() {
+			var (
+				basePath = testutil.Namespace(t)
+				builder  = grpcresolver.NewBuilder(conn)
+				fakeCC   = &fakeClientConn{states: make(chan []string, 100)}
+			)
+			builder.BasePath = basePath
+
+			target := resolver.Target{URL: url.URL{Scheme: builder.Scheme(), Path: "/my-service"}}
+			r, err := builder.Build(target, fakeCC, resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			// waitFor waits for the resolver to push the expected addresses.
+			waitFor := func(expected []string) {
+				timeout := time.After(5 * time.Second)
+				var actual []string
+				for {
+					select {
+					case actual = <-fakeCC.states:
+						if reflect.DeepEqual(actual, expected) {
+							return
+						}
+					case <-timeout:
+						t.Fatalf("Expected addresses=%v but last actual=%v", expected, actual)
+					}
+				}
+			}
+			waitFor([]string{})
+
+			ccs := []*cluster.Coordinator{}
+			for i := 0; i < 2; i++ {
+				cc, err := cluster.NewCoordinator(zkServers, zkTimeout, basePath+"/my-service", fmt.Sprintf("127.0.0.1:%v", 9000+i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err = cc.StartAndWait(ctx)
+				cancel()
+				if err != nil {
+					t.Fatal(err)
+				}
+				ccs = append(ccs, cc)
+			}
+			waitFor([]string{"127.0.0.1:9000", "127.0.0.1:9001"})
+
+			if err := ccs[0].Stop(); err != nil {
+				t.Fatal(err)
+			}
+			waitFor([]string{"127.0.0.1:9001"})
+			if err := ccs[1].Stop(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
@@ -0,0 +1,64 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client is a Go client for the ClusterState service.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	client := &Client{
+		conn: conn,
+	}
+	return client
+}
+
+func (client *Client) GetState(ctx context.Context, req *GetStateRequest, opts ...grpc.CallOption) (*StateUpdate, error) {
+	update := &StateUpdate{}
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	if err := client.conn.Invoke(ctx, "/"+serviceName+"/GetState", req, update, opts...); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
+
+// Watch opens a stream of state updates, which lasts until ctx is done.
+func (client *Client) Watch(ctx context.Context, req *WatchRequest, opts ...grpc.CallOption) (*Watcher, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(codecName)}, opts...)
+	stream, err := client.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	watcher := &Watcher{
+		stream: stream,
+	}
+	return watcher, nil
+}
+
+// Watcher receives the updates of a Watch stream.
+type Watcher struct {
+	stream grpc.ClientStream
+}
+
+// Recv blocks until the next update arrives or the stream ends.
+func (watcher *Watcher) Recv() (*StateUpdate, error) {
+	update := &StateUpdate{}
+	if err := watcher.stream.RecvMsg(update); err != nil {
+		return nil, err
+	}
+	return update, nil
+}
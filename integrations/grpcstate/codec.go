@@ -0,0 +1,37 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// codecName is the gRPC content-subtype of the service, i.e. requests carry
+// "content-type: application/grpc+zklib-json".  NB: gRPC's codec registry is
+// process-wide, so the name is private to zklib rather than "json", which the
+// application or another library may well register a codec of its own under.
+const codecName = "zklib-json"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec encodes messages as JSON.  Being registered under its own
+// content-subtype, it leaves the protobuf codec in place for any generated
+// services sharing the grpc.Server.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return codecName
+}
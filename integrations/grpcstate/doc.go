@@ -0,0 +1,18 @@
+// Package grpcstate provides a gRPC service which streams leadership and
+// membership updates from a Coordinator, so non-Go components and remote
+// dashboards can follow cluster state without a ZooKeeper client.
+//
+// Messages are encoded as JSON under the "zklib-json" content-subtype, so no
+// code generation step is needed on either side.  Clients in other languages
+// must send "content-type: application/grpc+zklib-json" and use a JSON
+// marshaller.  The service is "zklib.cluster.v1.ClusterState" with methods:
+//
+//	GetState(GetStateRequest) returns (StateUpdate)
+//	Watch(WatchRequest) returns (stream StateUpdate)
+//
+// It depends on google.golang.org/grpc, which the rest of zklib does not and
+// which requires a recent Go version, so it is only built with the "grpc"
+// build tag:
+//
+//	go build -tags grpc
+package grpcstate
@@ -0,0 +1,111 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/integrations/grpcstate"
+	"github.com/gigawattio/zklib/testutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// serve runs the ClusterState service for cc over an in-memory listener, and
+// returns a connected client.
+func serve(t *testing.T, cc *cluster.Coordinator) (client *grpcstate.Client, cleanup func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	grpcstate.Register(s, grpcstate.NewServer(cc))
+	go s.Serve(listener)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() {
+		conn.Close()
+		s.Stop()
+	}
+	return grpcstate.NewClient(conn), cleanup
+}
+
+func TestGetStateNotStarted(t *testing.T) {
+	cc, err := cluster.NewCoordinator([]string{"127.0.0.1:1"}, 1*time.Second, "/"+testlib.CurrentRunningTest(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, cleanup := serve(t, cc)
+	defer cleanup()
+
+	update, err := client.GetState(context.Background(), &grpcstate.GetStateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update.Leader != nil || update.Mode != primitives.Follower || update.ElectionPath != "/"+testlib.CurrentRunningTest() {
+		t.Errorf("Unexpected state for a stopped coordinator: %+v", *update)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		newCoordinator := func(data string) *cluster.Coordinator {
+			cc, err := cluster.NewCoordinator(zkServers, 1*time.Second, testutil.Namespace(t), data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := cc.StartAndWait(ctx); err != nil {
+				t.Fatal(err)
+			}
+			return cc
+		}
+
+		leader := newCoordinator("leader")
+		defer leader.Stop()
+
+		client, cleanup := serve(t, leader)
+		defer cleanup()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		watcher, err := client.Watch(ctx, &grpcstate.WatchRequest{IncludeMembers: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		update, err := watcher.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.Leader == nil || update.Leader.Uuid != leader.LocalNode.Uuid || len(update.Members) != 1 {
+			t.Fatalf("Expected initial state to show the leader and 1 member but actual=%+v", *update)
+		}
+
+		follower := newCoordinator("follower")
+		defer follower.Stop()
+
+		for {
+			update, err := watcher.Recv()
+			if err != nil {
+				t.Fatalf("Expected an update showing 2 members: %s", err)
+			}
+			if len(update.Members) == 2 {
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,27 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate
+
+import (
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+type GetStateRequest struct {
+	IncludeMembers bool `json:"includeMembers,omitempty"`
+}
+
+type WatchRequest struct {
+	IncludeMembers bool `json:"includeMembers,omitempty"`
+}
+
+type StateUpdate struct {
+	Type         string            `json:"type"` // "leader", "degraded", "split-brain" or "recovered".
+	ElectionPath string            `json:"electionPath"`
+	Leader       *primitives.Node  `json:"leader,omitempty"`  // Unset when there is no leader.
+	Members      []primitives.Node `json:"members,omitempty"` // Only populated when requested.
+	Mode         string            `json:"mode"`              // Mode of the serving member.
+	At           time.Time         `json:"at"`
+}
@@ -0,0 +1,57 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+func TestStateUpdateRoundTrip(t *testing.T) {
+	leader := primitives.NewNode("host-a")
+	leader.Data = "a"
+	leader.Pid = 42
+	leader.Version = "1.2.3"
+	leader.StartedAt = time.Unix(0, 1500000000123456789).UTC()
+	leader.IP = "10.0.0.1"
+
+	updates := []StateUpdate{
+		{},
+		{Type: "degraded", ElectionPath: "/election", Mode: primitives.Follower},
+		{
+			Type:         "leader",
+			ElectionPath: "/election",
+			Leader:       leader,
+			Members:      []primitives.Node{*leader, *primitives.NewNode("host-b")},
+			Mode:         primitives.Leader,
+			At:           time.Unix(0, 1500000001000000000).UTC(),
+		},
+	}
+	for i, update := range updates {
+		data, err := codec{}.Marshal(&update)
+		if err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		var decoded StateUpdate
+		if err := (codec{}).Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("[i=%v] %s", i, err)
+		}
+		if !reflect.DeepEqual(decoded, update) {
+			t.Errorf("[i=%v] Expected decoded=%+v but actual=%+v", i, update, decoded)
+		}
+	}
+}
+
+func TestUnknownFieldsSkipped(t *testing.T) {
+	var req WatchRequest
+	if err := (codec{}).Unmarshal([]byte(`{"future":7,"includeMembers":true}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	if !req.IncludeMembers {
+		t.Errorf("Expected IncludeMembers=true after skipping unknown field")
+	}
+}
@@ -0,0 +1,131 @@
+//go:build grpc
+// +build grpc
+
+package grpcstate
+
+import (
+	"context"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+const serviceName = "zklib.cluster.v1.ClusterState"
+
+// Server implements the ClusterState service on behalf of a Coordinator.
+type Server struct {
+	Coordinator *cluster.Coordinator
+}
+
+func NewServer(cc *cluster.Coordinator) *Server {
+	server := &Server{
+		Coordinator: cc,
+	}
+	return server
+}
+
+// Register adds the ClusterState service to s.
+func Register(s *grpc.Server, server *Server) {
+	s.RegisterService(&serviceDesc, server)
+}
+
+func (server *Server) GetState(ctx context.Context, req *GetStateRequest) (*StateUpdate, error) {
+	return server.state(primitives.LeaderUpdate, req.IncludeMembers, nil), nil
+}
+
+// Watch sends the current state, followed by the state after every update
+// from the Coordinator, until the stream's context is done.
+func (server *Server) Watch(req *WatchRequest, stream grpc.ServerStream) error {
+	updates := make(chan primitives.Update, 10)
+	server.Coordinator.Subscribe(updates)
+	defer server.Coordinator.Unsubscribe(updates)
+
+	update := server.state(primitives.LeaderUpdate, req.IncludeMembers, nil)
+	for {
+		if err := stream.SendMsg(update); err != nil {
+			return err
+		}
+		select {
+		case u := <-updates:
+			update = server.state(u.Type, req.IncludeMembers, update.Members)
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// state captures the Coordinator's current state.  When membership can't be
+// determined the lastMembers are reported instead.
+func (server *Server) state(updateType primitives.UpdateType, includeMembers bool, lastMembers []primitives.Node) *StateUpdate {
+	status := server.Coordinator.Status()
+	update := &StateUpdate{
+		Type:         updateType.String(),
+		ElectionPath: status.ElectionPath,
+		Leader:       status.Leader,
+		Mode:         status.Mode,
+		At:           time.Now(),
+	}
+	if includeMembers {
+		members, err := server.Coordinator.Members()
+		if err != nil {
+			log.Warnf("grpcstate: %v: listing members: %s", status.Id, err)
+			members = lastMembers
+		}
+		update.Members = members
+	}
+	return update
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*stateServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetState",
+			Handler:    getStateHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "grpcstate",
+}
+
+type stateServer interface {
+	GetState(ctx context.Context, req *GetStateRequest) (*StateUpdate, error)
+	Watch(req *WatchRequest, stream grpc.ServerStream) error
+}
+
+func getStateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &GetStateRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(stateServer).GetState(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + serviceName + "/GetState",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(stateServer).GetState(ctx, req.(*GetStateRequest))
+	}
+	return interceptor(ctx, req, info, handler)
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &WatchRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(stateServer).Watch(req, stream)
+}
@@ -0,0 +1,10 @@
+// Package k8s provides helpers for running zklib Coordinators inside
+// Kubernetes: member data derived from the downward API, readiness checks tied
+// to Coordinator status, and optional mirroring of leadership into a
+// coordination.k8s.io Lease object for visibility via kubectl.
+//
+// It depends on k8s.io/client-go, which the rest of zklib does not and which
+// requires a recent Go version, so it is only built with the "k8s" build tag:
+//
+//	go build -tags k8s
+package k8s
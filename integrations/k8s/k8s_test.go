@@ -0,0 +1,96 @@
+//go:build k8s
+// +build k8s
+
+package k8s_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/integrations/k8s"
+	"github.com/gigawattio/zklib/testutil"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFromDownwardAPI(t *testing.T) {
+	for name, value := range map[string]string{"POD_NAME": "web-0", "POD_NAMESPACE": "prod", "NODE_NAME": "node-a", "POD_IP": "10.1.2.3"} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	info, err := k8s.FromDownwardAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := k8s.MemberInfo{PodName: "web-0", Namespace: "prod", NodeName: "node-a", PodIP: "10.1.2.3"}
+	if *info != expected {
+		t.Errorf("Expected info=%+v but actual=%+v", expected, *info)
+	}
+
+	parsed, err := k8s.ParseMemberInfo(info.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != expected {
+		t.Errorf("Expected round-tripped info=%+v but actual=%+v", expected, *parsed)
+	}
+}
+
+func TestReadinessAndLease(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		cc, err := cluster.NewCoordinator(zkServers, 1*time.Second, testutil.Namespace(t), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		handler := k8s.ReadinessHandler(cc, false)
+		probe := func() int {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/ready", nil))
+			return w.Code
+		}
+		if code := probe(); code != http.StatusServiceUnavailable {
+			t.Errorf("Expected readiness status=%v before start but actual=%v", http.StatusServiceUnavailable, code)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := cc.StartAndWait(ctx); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := cc.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		if code := probe(); code != http.StatusOK {
+			t.Errorf("Expected readiness status=%v after start but actual=%v", http.StatusOK, code)
+		}
+
+		client := fake.NewSimpleClientset()
+		recorder := k8s.NewLeaseRecorder(client, cc, "prod", "my-election", "web-0")
+		if err := recorder.Record(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := recorder.Record(ctx); err != nil {
+			t.Fatal(err)
+		}
+		lease, err := client.CoordinationV1().Leases("prod").Get(ctx, "my-election", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "web-0" {
+			t.Errorf("Expected lease holder=web-0 but actual=%v", holder)
+		}
+		if lease.Spec.RenewTime == nil {
+			t.Errorf("Expected lease renew time to be set")
+		}
+	})
+}
@@ -0,0 +1,131 @@
+//go:build k8s
+// +build k8s
+
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+var DefaultLeaseRenewInterval = 10 * time.Second
+
+// LeaseRecorder mirrors the Coordinator's leadership into a Lease object, so
+// `kubectl get lease <name>` shows the current leader.  Only the leader writes
+// to the Lease.  The Lease is informational: ZooKeeper remains the source of
+// truth for leadership.
+type LeaseRecorder struct {
+	Client        kubernetes.Interface
+	Coordinator   *cluster.Coordinator
+	Namespace     string
+	Name          string
+	Identity      string        // Recorded as the holder identity, e.g. the pod name.
+	RenewInterval time.Duration // Defaults to DefaultLeaseRenewInterval.
+}
+
+func NewLeaseRecorder(client kubernetes.Interface, cc *cluster.Coordinator, namespace string, name string, identity string) *LeaseRecorder {
+	recorder := &LeaseRecorder{
+		Client:        client,
+		Coordinator:   cc,
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		RenewInterval: DefaultLeaseRenewInterval,
+	}
+	return recorder
+}
+
+// Run records leadership whenever it changes, and renews the Lease
+// periodically while leader, until ctx is done.  The Coordinator must already
+// be started.
+func (recorder *LeaseRecorder) Run(ctx context.Context) error {
+	updates := make(chan primitives.Update, 10)
+	recorder.Coordinator.Subscribe(updates)
+	defer recorder.Coordinator.Unsubscribe(updates)
+
+	ticker := time.NewTicker(recorder.renewInterval())
+	defer ticker.Stop()
+
+	for {
+		if err := recorder.Record(ctx); err != nil {
+			log.Warnf("k8s: %v: recording lease %v/%v: %s", recorder.Coordinator.Id(), recorder.Namespace, recorder.Name, err)
+		}
+		select {
+		case <-updates:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Record writes the current leadership into the Lease if the local member is
+// the leader, creating the Lease if necessary.  It is a no-op on followers.
+func (recorder *LeaseRecorder) Record(ctx context.Context) error {
+	if recorder.Coordinator.Mode() != primitives.Leader {
+		return nil
+	}
+
+	var (
+		leases   = recorder.Client.CoordinationV1().Leases(recorder.Namespace)
+		now      = metav1.NewMicroTime(time.Now())
+		duration = int32(math.Ceil((3 * recorder.renewInterval()).Seconds()))
+	)
+
+	lease, err := leases.Get(ctx, recorder.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      recorder.Name,
+				Namespace: recorder.Namespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &recorder.Identity,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("creating lease: %s", err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("getting lease: %s", err)
+	}
+
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != recorder.Identity {
+		var transitions int32
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions
+		}
+		transitions++
+		lease.Spec.HolderIdentity = &recorder.Identity
+		lease.Spec.AcquireTime = &now
+		lease.Spec.LeaseTransitions = &transitions
+	}
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("updating lease: %s", err)
+	}
+	return nil
+}
+
+func (recorder *LeaseRecorder) renewInterval() time.Duration {
+	if recorder.RenewInterval > 0 {
+		return recorder.RenewInterval
+	}
+	return DefaultLeaseRenewInterval
+}
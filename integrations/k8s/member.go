@@ -0,0 +1,98 @@
+//go:build k8s
+// +build k8s
+
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variable names conventionally populated via the downward API,
+// e.g.:
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom:
+//	    fieldRef:
+//	      fieldPath: metadata.name
+var (
+	PodNameEnv      = "POD_NAME"
+	PodNamespaceEnv = "POD_NAMESPACE"
+	NodeNameEnv     = "NODE_NAME"
+	PodIPEnv        = "POD_IP"
+
+	// PodInfoDir is consulted for values missing from the environment, for
+	// use with a downwardAPI volume whose item paths are "name", "namespace",
+	// "nodename" and "podip".
+	PodInfoDir = "/etc/podinfo"
+
+	// ServiceAccountNamespaceFile is the final fallback for the namespace.
+	ServiceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// MemberInfo identifies the pod a cluster member runs in.
+type MemberInfo struct {
+	PodName   string `json:"podName"`
+	Namespace string `json:"namespace"`
+	NodeName  string `json:"nodeName,omitempty"`
+	PodIP     string `json:"podIP,omitempty"`
+}
+
+// FromDownwardAPI gathers MemberInfo from the environment, falling back to
+// PodInfoDir files.  An error is returned if the pod name or namespace cannot
+// be determined.
+func FromDownwardAPI() (*MemberInfo, error) {
+	info := &MemberInfo{
+		PodName:   lookup(PodNameEnv, "name"),
+		Namespace: lookup(PodNamespaceEnv, "namespace"),
+		NodeName:  lookup(NodeNameEnv, "nodename"),
+		PodIP:     lookup(PodIPEnv, "podip"),
+	}
+	if info.Namespace == "" {
+		info.Namespace = readTrimmed(ServiceAccountNamespaceFile)
+	}
+	if info.PodName == "" {
+		// Kubernetes sets the hostname to the pod name.
+		info.PodName, _ = os.Hostname()
+	}
+	if info.PodName == "" || info.Namespace == "" {
+		return nil, fmt.Errorf("k8s: unable to determine pod name=%q and namespace=%q from the downward API", info.PodName, info.Namespace)
+	}
+	return info, nil
+}
+
+// Data returns the JSON encoding of info, suitable for use as Coordinator
+// member data.
+func (info MemberInfo) Data() string {
+	bs, _ := json.Marshal(&info)
+	return string(bs)
+}
+
+// ParseMemberInfo decodes member data produced by MemberInfo.Data.
+func ParseMemberInfo(data string) (*MemberInfo, error) {
+	var info MemberInfo
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return nil, fmt.Errorf("k8s: parsing member info: %s", err)
+	}
+	return &info, nil
+}
+
+func lookup(envName string, podInfoFile string) string {
+	if value := os.Getenv(envName); value != "" {
+		return value
+	}
+	return readTrimmed(filepath.Join(PodInfoDir, podInfoFile))
+}
+
+func readTrimmed(filename string) string {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bs))
+}
@@ -0,0 +1,35 @@
+//go:build k8s
+// +build k8s
+
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// Ready reports whether cc has joined its election and knows who the leader
+// is.  If requireLeader is true, only the leader is considered ready, which
+// is useful for routing Service traffic exclusively to the leader.
+func Ready(cc *cluster.Coordinator, requireLeader bool) bool {
+	if cc.Leader() == nil {
+		return false
+	}
+	return !requireLeader || cc.Mode() == primitives.Leader
+}
+
+// ReadinessHandler returns an http.Handler suitable for a readinessProbe, it
+// responds 200 when Ready and 503 otherwise.
+func ReadinessHandler(cc *cluster.Coordinator, requireLeader bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if !Ready(cc, requireLeader) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "not ready: mode=%v\n", cc.Mode())
+			return
+		}
+		fmt.Fprintf(w, "ready: mode=%v\n", cc.Mode())
+	})
+}
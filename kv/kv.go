@@ -0,0 +1,401 @@
+package kv
+
+// Small key/value store API backed by zNodes, using zNode versions for
+// optimistic concurrency control.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	// AnyVersion matches any existing version in Delete.
+	AnyVersion int32 = -1
+
+	// Absent may be passed to CompareAndSwap to only succeed if the key does
+	// not yet exist.
+	Absent int32 = -2
+
+	WatchChanSize = 10
+)
+
+var (
+	NotFoundError        = errors.New("kv: key not found")
+	VersionMismatchError = errors.New("kv: version mismatch")
+	InvalidKeyError      = errors.New("kv: invalid key, must be non-empty and must not contain '/'")
+	TTLUnsupportedError  = errors.New("kv: TTL keys are not supported by the server")
+
+	watchRetryInterval = 1 * time.Second
+)
+
+var (
+	worldAllAcl = zk.WorldACL(zk.PermAll)
+)
+
+// Entry is a versioned value.
+type Entry struct {
+	Key     string
+	Value   []byte
+	Version int32
+	Deleted bool
+}
+
+// Store scopes keys under a namespace zNode.
+type Store struct {
+	conn      *zk.Conn
+	namespace string
+
+	// Container creates the namespace as a container zNode, so the server
+	// cleans it up after the last key is deleted.  Falls back to a persistent
+	// zNode on servers older than ZooKeeper 3.5.3.
+	Container bool
+
+	// Validators vet values before they are written, rejecting malformed ones
+	// with a util.ValidationError.  With ValidateReads set values are vetted
+	// when read, too: Get fails on malformed values and Watch skips them.
+	Validators    []zkutil.Validator
+	ValidateReads bool
+
+	// Encryptor, when non-nil, encrypts values at rest.  Validators see the
+	// plaintext.
+	Encryptor *zkutil.Encryptor
+
+	// Quota, when non-nil, limits the size of values (as stored) and the
+	// number of keys, rejecting writes exceeding it with a util.QuotaError.
+	// See Usage.
+	Quota *zkutil.Quota
+
+	resources primitives.Tracker
+}
+
+func NewStore(conn *zk.Conn, namespace string) *Store {
+	store := &Store{
+		conn:      conn,
+		namespace: zkutil.NormalizePath(namespace),
+	}
+	return store
+}
+
+// Get returns the current value and version for key, or NotFoundError.
+func (store *Store) Get(key string) (*Entry, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, stat, err := store.conn.Get(path)
+	if err == zk.ErrNoNode {
+		return nil, NotFoundError
+	} else if err != nil {
+		return nil, fmt.Errorf("kv: getting key=%v: %s", key, err)
+	}
+	if data, err = store.open(data); err != nil {
+		return nil, fmt.Errorf("kv: getting key=%v: %s", key, err)
+	}
+	if store.ValidateReads {
+		if err := zkutil.Validate(store.Validators, path, data); err != nil {
+			return nil, err
+		}
+	}
+	entry := &Entry{
+		Key:     key,
+		Value:   data,
+		Version: stat.Version,
+	}
+	return entry, nil
+}
+
+// Put unconditionally sets the value for key and returns the new version.
+func (store *Store) Put(key string, value []byte) (int32, error) {
+	for {
+		version, err := store.CompareAndSwap(key, Absent, value)
+		if err != VersionMismatchError {
+			return version, err
+		}
+		if version, err = store.CompareAndSwap(key, AnyVersion, value); err != NotFoundError {
+			return version, err
+		}
+		// Key was deleted in between, try again.
+	}
+}
+
+// CompareAndSwap sets the value for key only if its current version matches
+// expectedVersion.  Pass Absent to require that the key does not exist yet, or
+// AnyVersion to overwrite whatever is there.  On success the new version is
+// returned, otherwise VersionMismatchError or NotFoundError.
+func (store *Store) CompareAndSwap(key string, expectedVersion int32, value []byte) (int32, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := zkutil.Validate(store.Validators, path, value); err != nil {
+		return 0, err
+	}
+	if value, err = store.seal(value); err != nil {
+		return 0, fmt.Errorf("kv: setting key=%v: %s", key, err)
+	}
+	if err := store.checkQuota(path, value, expectedVersion == Absent); err != nil {
+		return 0, err
+	}
+
+	if expectedVersion == Absent {
+		if err := store.ensureNamespace(); err != nil {
+			return 0, err
+		}
+		if _, err := store.conn.Create(path, value, 0, worldAllAcl); err == zk.ErrNodeExists {
+			return 0, VersionMismatchError
+		} else if err != nil {
+			return 0, fmt.Errorf("kv: creating key=%v: %s", key, err)
+		}
+		return 0, nil
+	}
+
+	stat, err := store.conn.Set(path, value, expectedVersion)
+	switch err {
+	case nil:
+		return stat.Version, nil
+	case zk.ErrNoNode:
+		return 0, NotFoundError
+	case zk.ErrBadVersion:
+		return 0, VersionMismatchError
+	default:
+		return 0, fmt.Errorf("kv: setting key=%v: %s", key, err)
+	}
+}
+
+// CreateWithTTL creates key, which the server then deletes unless it is
+// modified (e.g. via Put) at least every ttl.  Returns VersionMismatchError
+// when the key already exists.
+//
+// Returns TTLUnsupportedError, without creating the key, when the server lacks
+// TTL zNode support (older than ZooKeeper 3.5.3, or without
+// zookeeper.extendedTypesEnabled).
+//
+// NB: Each call dials the server and creates the key over a short-lived
+// anonymous session of its own rather than the Store's (see util.CreateTTL),
+// so it costs a connection and handshake, and ACLs requiring authentication
+// refuse it.
+func (store *Store) CreateWithTTL(key string, value []byte, ttl time.Duration) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err := zkutil.Validate(store.Validators, path, value); err != nil {
+		return err
+	}
+	if value, err = store.seal(value); err != nil {
+		return fmt.Errorf("kv: creating key=%v: %s", key, err)
+	}
+	if err := store.checkQuota(path, value, true); err != nil {
+		return err
+	}
+	if err := store.ensureNamespace(); err != nil {
+		return err
+	}
+	_, err = zkutil.CreateTTL(store.conn, path, value, 0, worldAllAcl, ttl)
+	if err == zkutil.ErrUnsupported {
+		return TTLUnsupportedError
+	} else if err == zk.ErrNodeExists {
+		return VersionMismatchError
+	} else if err != nil {
+		return fmt.Errorf("kv: creating key=%v: %s", key, err)
+	}
+	return nil
+}
+
+// Delete removes key if its current version matches version (or AnyVersion).
+func (store *Store) Delete(key string, version int32) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	switch err := store.conn.Delete(path, version); err {
+	case nil:
+		return nil
+	case zk.ErrNoNode:
+		return NotFoundError
+	case zk.ErrBadVersion:
+		return VersionMismatchError
+	default:
+		return fmt.Errorf("kv: deleting key=%v: %s", key, err)
+	}
+}
+
+// Keys lists all keys in the namespace.
+func (store *Store) Keys() ([]string, error) {
+	children, _, err := store.conn.Children(store.namespace)
+	if err == zk.ErrNoNode {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("kv: listing keys: %s", err)
+	}
+	return children, nil
+}
+
+// Watch delivers the current entry for key and then every subsequent change,
+// until ctx is done.  Deletions are delivered as entries with Deleted set.
+func (store *Store) Watch(ctx context.Context, key string) (<-chan Entry, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(chan Entry, WatchChanSize)
+
+	store.resources.Go("watch", func() {
+		defer close(entries)
+
+		var last *Entry
+
+		for {
+			var (
+				entry = Entry{Key: key}
+				watch <-chan zk.Event
+			)
+
+			data, stat, dataWatch, err := store.conn.GetW(path)
+			if err == zk.ErrNoNode {
+				// Watch for creation instead.
+				var exists bool
+				if exists, _, watch, err = store.conn.ExistsW(path); err == nil && exists {
+					continue
+				}
+				store.resources.Watch(path, watch)
+				entry.Deleted = true
+			} else if err == nil {
+				entry.Version = stat.Version
+				watch = store.resources.Watch(path, dataWatch)
+				var verr error
+				if entry.Value, verr = store.open(data); verr == nil && store.ValidateReads {
+					verr = zkutil.Validate(store.Validators, path, entry.Value)
+				}
+				if verr != nil {
+					log.Warnf("kv: watching key=%v: skipping version=%v: %s", key, stat.Version, verr)
+					select {
+					case <-watch:
+						store.resources.Unwatch(watch)
+						continue
+					case <-ctx.Done():
+						store.resources.Unwatch(watch)
+						return
+					}
+				}
+			}
+			if err != nil {
+				log.Warnf("kv: watching key=%v (will retry): %s", key, err)
+				select {
+				case <-time.After(watchRetryInterval):
+					continue
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if last == nil || last.Deleted != entry.Deleted || last.Version != entry.Version || (!entry.Deleted && string(last.Value) != string(entry.Value)) {
+				select {
+				case entries <- entry:
+				case <-ctx.Done():
+					store.resources.Unwatch(watch)
+					return
+				}
+				last = &entry
+			}
+
+			select {
+			case <-watch:
+				store.resources.Unwatch(watch)
+			case <-ctx.Done():
+				store.resources.Unwatch(watch)
+				return
+			}
+		}
+	})
+
+	return entries, nil
+}
+
+// Resources returns an account of the watches and goroutines held by the
+// Store's running Watches.
+func (store *Store) Resources() primitives.Resources {
+	return store.resources.Resources()
+}
+
+// Usage reports the number of keys and the largest value relative to Quota
+// (or the default limits when nil).
+func (store *Store) Usage() (zkutil.Usage, error) {
+	var quota zkutil.Quota
+	if store.Quota != nil {
+		quota = *store.Quota
+	}
+	usage, err := zkutil.MeasureUsage(store.conn, store.namespace, quota)
+	if err != nil {
+		return usage, fmt.Errorf("kv: %s", err)
+	}
+	return usage, nil
+}
+
+// checkQuota vets writing value to path against Quota, creating specifies
+// whether a key is about to be added.
+func (store *Store) checkQuota(path string, value []byte, creating bool) error {
+	if store.Quota == nil {
+		return nil
+	}
+	if err := store.Quota.CheckPayload(store.namespace, path, len(value)); err != nil {
+		return err
+	}
+	if !creating || store.Quota.MaxChildren == 0 {
+		return nil
+	}
+	children, err := zkutil.CountChildren(store.conn, store.namespace)
+	if err != nil {
+		return fmt.Errorf("kv: counting keys: %s", err)
+	}
+	quotaErr := store.Quota.CheckChildren(store.namespace, children)
+	if quotaErr == nil {
+		return nil
+	}
+	// NB: Creating an existing key fails anyway, e.g. as Put falls back to
+	// overwriting it.
+	if exists, _, err := store.conn.Exists(path); err == nil && exists {
+		return nil
+	}
+	return quotaErr
+}
+
+// seal encrypts value when an Encryptor is configured.
+func (store *Store) seal(value []byte) ([]byte, error) {
+	if store.Encryptor == nil {
+		return value, nil
+	}
+	return store.Encryptor.Seal(value)
+}
+
+// open decrypts data when an Encryptor is configured.
+func (store *Store) open(data []byte) ([]byte, error) {
+	if store.Encryptor == nil {
+		return data, nil
+	}
+	return store.Encryptor.Open(data)
+}
+
+func (store *Store) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "/") {
+		return "", InvalidKeyError
+	}
+	return store.namespace + "/" + key, nil
+}
+
+func (store *Store) ensureNamespace() error {
+	if _, err := zkutil.EnsurePath(store.conn, store.namespace, zkutil.EnsurePathOptions{ACL: worldAllAcl, Container: store.Container}); err != nil {
+		return fmt.Errorf("kv: creating namespace=%v: %s", store.namespace, err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package kv_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/kv"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+var (
+	zkTimeout       = 5 * time.Second
+	zkDeleteRetries = 10
+)
+
+func TestStore(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			namespace := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+			if err := zkutil.RecursivelyDelete(conn, namespace, zkDeleteRetries); err != nil {
+				t.Fatal(err)
+			}
+
+			store := kv.NewStore(conn, namespace)
+			checkLeaks := zktestutil.CheckLeaks(t, store)
+
+			if _, err := store.Get("missing"); err != kv.NotFoundError {
+				t.Fatalf("Expected err=%s but actual=%v", kv.NotFoundError, err)
+			}
+			if _, err := store.Put("a/b", []byte("x")); err != kv.InvalidKeyError {
+				t.Fatalf("Expected err=%s but actual=%v", kv.InvalidKeyError, err)
+			}
+
+			version, err := store.CompareAndSwap("k", kv.Absent, []byte("1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.CompareAndSwap("k", kv.Absent, []byte("2")); err != kv.VersionMismatchError {
+				t.Fatalf("Expected err=%s when creating an existing key but actual=%v", kv.VersionMismatchError, err)
+			}
+			if _, err := store.CompareAndSwap("k", version+1, []byte("2")); err != kv.VersionMismatchError {
+				t.Fatalf("Expected err=%s for stale version but actual=%v", kv.VersionMismatchError, err)
+			}
+			if version, err = store.CompareAndSwap("k", version, []byte("2")); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := store.Get("k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := "2", string(entry.Value); actual != expected {
+				t.Fatalf("Expected value=%q but actual=%q", expected, actual)
+			}
+			if entry.Version != version {
+				t.Fatalf("Expected version=%v but actual=%v", version, entry.Version)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			entries, err := store.Watch(ctx, "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectEntry := func(value string, deleted bool) {
+				select {
+				case entry := <-entries:
+					if entry.Deleted != deleted || string(entry.Value) != value {
+						t.Fatalf("Expected watched entry value=%q deleted=%v but actual=%+v", value, deleted, entry)
+					}
+				case <-time.After(zkTimeout):
+					t.Fatalf("Timed out after %s waiting for watched entry value=%q", zkTimeout, value)
+				}
+			}
+			expectEntry("2", false)
+
+			if _, err := store.Put("k", []byte("3")); err != nil {
+				t.Fatal(err)
+			}
+			expectEntry("3", false)
+
+			if err := store.Delete("k", kv.AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+			expectEntry("", true)
+
+			keys, err := store.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 0 {
+				t.Fatalf("Expected no keys to remain but found keys=%v", keys)
+			}
+
+			if resources := store.Resources(); len(resources.Watches) != 1 {
+				t.Errorf("Expected the deleted key's creation to be watched but actual=%v", resources)
+			}
+			cancel()
+			checkLeaks()
+		})
+	})
+}
+
+func TestStoreCreateWithTTL(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			store := kv.NewStore(conn, zktestutil.Namespace(t)+"/kv")
+			store.Container = true
+
+			if err := store.CreateWithTTL("session", []byte("x"), time.Minute); err == kv.TTLUnsupportedError {
+				if _, err := store.Get("session"); err != kv.NotFoundError {
+					t.Errorf("Expected no key to be created without TTL support, but err=%v", err)
+				}
+				t.Skip("TTL zNodes are not enabled on the test server")
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.CreateWithTTL("session", []byte("y"), time.Minute); err != kv.VersionMismatchError {
+				t.Fatalf("Expected err=%s when creating an existing key but actual=%v", kv.VersionMismatchError, err)
+			}
+			entry, err := store.Get("session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(entry.Value) != "x" {
+				t.Errorf("Expected value=x but actual=%s", string(entry.Value))
+			}
+			// Modifications keep TTL keys alive.
+			if _, err := store.Put("session", []byte("z")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete("session", kv.AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
+
+func TestStoreValidators(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			namespace := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+			if err := zkutil.RecursivelyDelete(conn, namespace, zkDeleteRetries); err != nil {
+				t.Fatal(err)
+			}
+
+			// An older writer without validators.
+			legacy := kv.NewStore(conn, namespace)
+			if _, err := legacy.Put("legacy", []byte("plain text")); err != nil {
+				t.Fatal(err)
+			}
+
+			store := kv.NewStore(conn, namespace)
+			store.Validators = []zkutil.Validator{zkutil.RequireJSONFields("version")}
+			store.ValidateReads = true
+
+			if _, err := store.Put("k", []byte(`{"name": "x"}`)); err == nil || !strings.Contains(err.Error(), `missing field "version"`) {
+				t.Fatalf("Expected a validation error but actual=%v", err)
+			} else if _, ok := err.(zkutil.ValidationError); !ok {
+				t.Errorf("Expected a util.ValidationError but actual=%T", err)
+			}
+			if err := store.CreateWithTTL("k", []byte("{"), time.Minute); err == nil {
+				t.Fatalf("Expected a validation error for malformed JSON")
+			}
+			if _, err := store.Get("k"); err != kv.NotFoundError {
+				t.Fatalf("Expected rejected values not to be written but actual err=%v", err)
+			}
+			if _, err := store.Put("k", []byte(`{"version": 1}`)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get("k"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get("legacy"); err == nil || !strings.Contains(err.Error(), "invalid data") {
+				t.Fatalf("Expected reading the malformed value to fail but actual=%v", err)
+			}
+		})
+	})
+}
+
+func TestStoreEncryption(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			namespace := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+			if err := zkutil.RecursivelyDelete(conn, namespace, zkDeleteRetries); err != nil {
+				t.Fatal(err)
+			}
+
+			store := kv.NewStore(conn, namespace)
+			store.Encryptor = &zkutil.Encryptor{Keys: zkutil.StaticKeys{
+				Current: "k1",
+				Keys:    map[string][]byte{"k1": []byte("0123456789abcdef")},
+			}}
+			if _, err := store.Put("k", []byte("password=hunter2")); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, _, err := conn.Get(namespace + "/k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(raw), "hunter2") {
+				t.Fatalf("Expected the stored value to be encrypted but actual=%q", raw)
+			}
+			entry, err := store.Get("k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(entry.Value) != "password=hunter2" {
+				t.Fatalf("Expected the decrypted value but actual=%q", entry.Value)
+			}
+		})
+	})
+}
+
+func TestStoreQuota(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		zktestutil.WhenZkHasSession(zkEvents, func() {
+			namespace := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+			if err := zkutil.RecursivelyDelete(conn, namespace, zkDeleteRetries); err != nil {
+				t.Fatal(err)
+			}
+
+			store := kv.NewStore(conn, namespace)
+			store.Quota = &zkutil.Quota{MaxPayloadBytes: 8, MaxChildren: 2}
+
+			if _, err := store.Put("big", []byte("0123456789")); err == nil {
+				t.Fatalf("Expected an oversized value to be rejected")
+			} else if quotaErr, ok := err.(zkutil.QuotaError); !ok || quotaErr.Limit != "payload-bytes" {
+				t.Fatalf("Expected a payload-bytes QuotaError but actual=%#v", err)
+			}
+			for _, key := range []string{"a", "b"} {
+				if _, err := store.Put(key, []byte("1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := store.Put("c", []byte("1")); err == nil {
+				t.Fatalf("Expected a third key to be rejected")
+			} else if quotaErr, ok := err.(zkutil.QuotaError); !ok || quotaErr.Limit != "children" {
+				t.Fatalf("Expected a children QuotaError but actual=%#v", err)
+			}
+			// Existing keys may still be updated.
+			if _, err := store.Put("a", []byte("2")); err != nil {
+				t.Fatal(err)
+			}
+
+			usage, err := store.Usage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if usage.Children != 2 || usage.MaxChildren != 2 || usage.LargestPayload != 1 || usage.Fraction() != 1 {
+				t.Errorf("Expected the namespace to be at its children quota but usage=%+v", usage)
+			}
+		})
+	})
+}
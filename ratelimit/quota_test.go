@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLocalQuota(t *testing.T) {
+	testCases := []struct {
+		quotas   map[string]float64
+		members  []string
+		member   string
+		expected float64
+	}{
+		{
+			quotas:   nil,
+			members:  []string{"a", "b", "c"},
+			member:   "a",
+			expected: 100.0 / 3,
+		},
+		{
+			quotas:   map[string]float64{"a": 60, "b": 40},
+			members:  []string{"a", "b", "c"},
+			member:   "b",
+			expected: 40,
+		},
+		{
+			// Fully allocated, so a newcomer must wait for the leader.
+			quotas:   map[string]float64{"a": 60, "b": 40},
+			members:  []string{"a", "b", "c"},
+			member:   "c",
+			expected: 0,
+		},
+		{
+			quotas:   map[string]float64{"a": 60},
+			members:  []string{"a", "b", "c"},
+			member:   "c",
+			expected: 20,
+		},
+	}
+	for i, testCase := range testCases {
+		var sum float64
+		for _, member := range testCase.members {
+			sum += localQuota(100, testCase.quotas, testCase.members, member)
+		}
+		if sum > 100+1e-6 {
+			t.Errorf("[i=%v] Expected quotas to sum to at most 100 but actual=%v", i, sum)
+		}
+		if actual := localQuota(100, testCase.quotas, testCase.members, testCase.member); math.Abs(actual-testCase.expected) > 1e-6 {
+			t.Errorf("[i=%v] Expected quota=%v for member=%v but actual=%v", i, testCase.expected, testCase.member, actual)
+		}
+	}
+}
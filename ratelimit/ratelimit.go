@@ -0,0 +1,318 @@
+package ratelimit
+
+// Distributed rate limiter where cluster members cooperatively share a global
+// tokens-per-second budget.
+//
+// Every member periodically publishes its observed demand in its member data.
+// The leader collects the demands, redistributes the global budget as
+// per-member quotas using max-min fairness, and publishes the quotas in its own
+// member data.  Members then enforce their quota locally with a token bucket.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	DefaultRebalanceInterval = 1 * time.Second
+	DefaultBurst             = 1 * time.Second // Default amount of quota which may accumulate in the bucket.
+
+	NotRunningError = errors.New("ratelimit: not running")
+)
+
+// memberData is what each member publishes via the Coordinator.
+type memberData struct {
+	Demand float64            `json:"demand"`           // Requested tokens per second during the last interval.
+	Quotas map[string]float64 `json:"quotas,omitempty"` // Only populated by the leader.
+}
+
+type Limiter struct {
+	Coordinator       *cluster.Coordinator
+	RebalanceInterval time.Duration
+	Burst             time.Duration
+	rate              float64
+	quota             float64
+	tokens            float64
+	requested         float64 // Tokens requested since last rebalance.
+	lastRefill        time.Time
+	lastRebalance     time.Time
+	lock              sync.Mutex
+	stopChan          chan chan struct{}
+}
+
+// NewLimiter creates a Limiter which shares tokensPerSecond among all
+// Limiters participating under path.
+func NewLimiter(zkServers []string, sessionTimeout time.Duration, path string, tokensPerSecond float64) (*Limiter, error) {
+	cc, err := cluster.NewCoordinator(zkServers, sessionTimeout, path, "{}")
+	if err != nil {
+		return nil, fmt.Errorf("NewLimiter: %s", err)
+	}
+	limiter := &Limiter{
+		Coordinator:       cc,
+		RebalanceInterval: DefaultRebalanceInterval,
+		Burst:             DefaultBurst,
+		rate:              tokensPerSecond,
+		quota:             0, // Nothing is allowed until the first quota is known.
+	}
+	return limiter, nil
+}
+
+func (limiter *Limiter) Start() error {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	if limiter.stopChan != nil {
+		return fmt.Errorf("ratelimit: already started")
+	}
+	if err := limiter.Coordinator.Start(); err != nil {
+		return err
+	}
+	now := time.Now()
+	limiter.lastRefill = now
+	limiter.lastRebalance = now
+	limiter.stopChan = make(chan chan struct{})
+	go limiter.rebalanceLoop(limiter.stopChan)
+	return nil
+}
+
+func (limiter *Limiter) Stop() error {
+	limiter.lock.Lock()
+	stopChan := limiter.stopChan
+	limiter.stopChan = nil
+	limiter.lock.Unlock()
+
+	if stopChan == nil {
+		return NotRunningError
+	}
+	ackChan := make(chan struct{})
+	stopChan <- ackChan
+	<-ackChan
+	return limiter.Coordinator.Stop()
+}
+
+// Quota returns the tokens-per-second currently allotted to this member.
+func (limiter *Limiter) Quota() float64 {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	return limiter.quota
+}
+
+// Allow reports whether a single token is available right now, consuming it
+// if so.
+func (limiter *Limiter) Allow() bool {
+	return limiter.AllowN(1)
+}
+
+// AllowN reports whether n tokens are available right now, consuming them if
+// so.
+func (limiter *Limiter) AllowN(n float64) bool {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.requested += n
+	limiter.refill(time.Now())
+	if limiter.tokens < n {
+		return false
+	}
+	limiter.tokens -= n
+	return true
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (limiter *Limiter) Wait(ctx context.Context) error {
+	limiter.lock.Lock()
+	limiter.requested++
+	limiter.lock.Unlock()
+
+	for {
+		limiter.lock.Lock()
+		if limiter.stopChan == nil {
+			limiter.lock.Unlock()
+			return NotRunningError
+		}
+		limiter.refill(time.Now())
+		if limiter.tokens >= 1 {
+			limiter.tokens--
+			limiter.lock.Unlock()
+			return nil
+		}
+		wait := limiter.RebalanceInterval
+		if limiter.quota > 0 {
+			wait = time.Duration((1 - limiter.tokens) / limiter.quota * float64(time.Second))
+		}
+		limiter.lock.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// refill must only be invoked while holding limiter.lock.
+func (limiter *Limiter) refill(now time.Time) {
+	elapsed := now.Sub(limiter.lastRefill).Seconds()
+	limiter.lastRefill = now
+	capacity := math.Max(limiter.quota*limiter.Burst.Seconds(), 1)
+	limiter.tokens = math.Min(limiter.tokens+elapsed*limiter.quota, capacity)
+}
+
+func (limiter *Limiter) rebalanceLoop(stopChan chan chan struct{}) {
+	for {
+		select {
+		case <-time.After(limiter.RebalanceInterval):
+			if err := limiter.rebalance(); err != nil {
+				log.Warnf("ratelimit: %v: rebalance failed: %s", limiter.Coordinator.Id(), err)
+			}
+
+		case ackChan := <-stopChan:
+			ackChan <- struct{}{}
+			return
+		}
+	}
+}
+
+// rebalance publishes local demand, recomputes quotas when leader, and picks up
+// the local quota from the leader's published data.
+func (limiter *Limiter) rebalance() error {
+	cc := limiter.Coordinator
+
+	limiter.lock.Lock()
+	now := time.Now()
+	demand := limiter.requested / now.Sub(limiter.lastRebalance).Seconds()
+	limiter.requested = 0
+	limiter.lastRebalance = now
+	limiter.lock.Unlock()
+
+	nodes, err := cc.Members()
+	if err != nil {
+		return err
+	}
+
+	published := memberData{Demand: demand}
+
+	if cc.Mode() == primitives.Leader {
+		demands := map[string]float64{}
+		for _, node := range nodes {
+			var data memberData
+			if node.Uuid == cc.LocalNode.Uuid {
+				data = published
+			} else if err := json.Unmarshal([]byte(node.Data), &data); err != nil {
+				log.Warnf("ratelimit: %v: ignoring member=%v with malformed data: %s", cc.Id(), node.Uuid, err)
+				continue
+			}
+			demands[node.Uuid.String()] = data.Demand
+		}
+		published.Quotas = Allocate(limiter.rate, demands)
+	}
+
+	bs, err := json.Marshal(&published)
+	if err != nil {
+		return err
+	}
+	if err := cc.SetData(string(bs)); err != nil {
+		return err
+	}
+
+	var quotas map[string]float64
+	if published.Quotas != nil {
+		quotas = published.Quotas
+	} else if leader := cc.Leader(); leader != nil {
+		for _, node := range nodes {
+			if node.Uuid == leader.Uuid {
+				var data memberData
+				if err := json.Unmarshal([]byte(node.Data), &data); err != nil {
+					return fmt.Errorf("parsing leader data: %s", err)
+				}
+				quotas = data.Quotas
+				break
+			}
+		}
+	}
+
+	members := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		members = append(members, node.Uuid.String())
+	}
+	quota := localQuota(limiter.rate, quotas, members, cc.LocalNode.Uuid.String())
+
+	limiter.lock.Lock()
+	limiter.refill(time.Now())
+	limiter.quota = quota
+	limiter.lock.Unlock()
+	return nil
+}
+
+// localQuota returns member's quota.  Members not yet accounted for by the
+// leader split whatever the leader left unallocated, so the quotas in effect
+// never sum to more than total.
+func localQuota(total float64, quotas map[string]float64, members []string, member string) float64 {
+	if quota, ok := quotas[member]; ok {
+		return quota
+	}
+	var (
+		remaining   = total
+		unaccounted = 0
+	)
+	for _, m := range members {
+		if quota, ok := quotas[m]; ok {
+			remaining -= quota
+		} else {
+			unaccounted++
+		}
+	}
+	if unaccounted == 0 || remaining <= 0 {
+		return 0
+	}
+	return remaining / float64(unaccounted)
+}
+
+// Allocate divides total among the members using max-min fairness: no member
+// receives more than it asked for until every member's demand is satisfied,
+// after which any surplus is spread evenly so members have headroom to grow.
+func Allocate(total float64, demands map[string]float64) map[string]float64 {
+	quotas := make(map[string]float64, len(demands))
+	if len(demands) == 0 {
+		return quotas
+	}
+
+	unsatisfied := make(map[string]float64, len(demands))
+	for member, demand := range demands {
+		quotas[member] = 0
+		if demand > 0 {
+			unsatisfied[member] = demand
+		}
+	}
+
+	remaining := total
+	for len(unsatisfied) > 0 && remaining > 1e-9 {
+		share := remaining / float64(len(unsatisfied))
+		for member, demand := range unsatisfied {
+			grant := math.Min(share, demand-quotas[member])
+			quotas[member] += grant
+			remaining -= grant
+			if quotas[member] >= demand {
+				delete(unsatisfied, member)
+			}
+		}
+	}
+
+	if remaining > 1e-9 {
+		surplus := remaining / float64(len(quotas))
+		for member := range quotas {
+			quotas[member] += surplus
+		}
+	}
+	return quotas
+}
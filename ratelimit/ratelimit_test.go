@@ -0,0 +1,100 @@
+package ratelimit_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/ratelimit"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+)
+
+func TestAllocate(t *testing.T) {
+	testCases := []struct {
+		total    float64
+		demands  map[string]float64
+		expected map[string]float64
+	}{
+		{
+			total:    100,
+			demands:  map[string]float64{},
+			expected: map[string]float64{},
+		},
+		{
+			total:    100,
+			demands:  map[string]float64{"a": 0, "b": 0},
+			expected: map[string]float64{"a": 50, "b": 50},
+		},
+		{
+			total:    100,
+			demands:  map[string]float64{"a": 90, "b": 90},
+			expected: map[string]float64{"a": 50, "b": 50},
+		},
+		{
+			total:    100,
+			demands:  map[string]float64{"a": 10, "b": 200},
+			expected: map[string]float64{"a": 10, "b": 90},
+		},
+		{
+			total:    100,
+			demands:  map[string]float64{"a": 10, "b": 20, "c": 0},
+			expected: map[string]float64{"a": 10 + 70.0/3, "b": 20 + 70.0/3, "c": 70.0 / 3},
+		},
+	}
+	for i, testCase := range testCases {
+		actual := ratelimit.Allocate(testCase.total, testCase.demands)
+		if len(actual) != len(testCase.expected) {
+			t.Errorf("[i=%v] Expected quotas=%v but actual=%v", i, testCase.expected, actual)
+			continue
+		}
+		for member, quota := range testCase.expected {
+			if math.Abs(actual[member]-quota) > 1e-6 {
+				t.Errorf("[i=%v] Expected quota=%v for member=%v but actual=%v (all quotas=%v)", i, quota, member, actual[member], actual)
+			}
+		}
+	}
+}
+
+func TestLimiterSharesBudget(t *testing.T) {
+	zkPath := fmt.Sprintf("/%v", testlib.CurrentRunningTest())
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		defer func() {
+			if err := zkutil.ResetZk(zkServers, zkPath); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		const total = 100
+		limiters := make([]*ratelimit.Limiter, 2)
+		for i := range limiters {
+			limiter, err := ratelimit.NewLimiter(zkServers, 5*time.Second, zkPath, total)
+			if err != nil {
+				t.Fatal(err)
+			}
+			limiter.RebalanceInterval = 100 * time.Millisecond
+			if err := limiter.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer limiter.Stop()
+			limiters[i] = limiter
+		}
+
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			var sum float64
+			for _, limiter := range limiters {
+				sum += limiter.Quota()
+			}
+			if math.Abs(sum-total) < 1e-6 && limiters[0].Quota() > 0 && limiters[1].Quota() > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for quotas to converge, last sum=%v", sum)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+}
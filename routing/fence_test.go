@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestPublishFenced(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/routing", testlib.CurrentRunningTest())
+
+		// epoch is routed to, so bumping it changes the table.
+		var epoch int64
+		compute := func(members []primitives.Node, _ *Table) (map[string]string, error) {
+			return map[string]string{"epoch": fmt.Sprint(atomic.LoadInt64(&epoch))}, nil
+		}
+		apply := func(Table) error { return nil }
+		r, err := New(zkServers, 5*time.Second, basePath, "deposed", compute, apply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer r.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if _, err := r.WaitForVersion(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		err = zkutil.WithZkSession(zkServers, 5*time.Second, func(conn *zk.Conn) error {
+			zNode := basePath + "/" + tableZNode
+			before, _, err := conn.Get(zNode)
+			if err != nil {
+				return err
+			}
+			// NB: Being the only candidate, the leader keeps believing it
+			// leads once its zNode is gone, as a partitioned one would.
+			if err := conn.Delete(r.Coordinator.Status().ZNode, -1); err != nil {
+				return err
+			}
+			atomic.AddInt64(&epoch, 1)
+			if err := r.publish(conn, r.Coordinator.LocalNode.Uuid.String()); err == nil || !strings.Contains(err.Error(), "no longer leading") {
+				t.Errorf("Expected the publication to be fenced but actual err=%v", err)
+			}
+			after, _, err := conn.Get(zNode)
+			if err != nil {
+				return err
+			}
+			if string(after) != string(before) {
+				t.Errorf("Expected the fenced publication to leave table=%s but actual=%s", string(before), string(after))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,435 @@
+package routing
+
+// Leader-published routing table recipe.
+//
+// The leader of the election at <basePath>/election computes a routing table,
+// e.g. which member serves which shard, from the current members and
+// publishes it to the well-known <basePath>/table zNode whenever membership
+// changes.  Every member, the leader included, watches the table, applies each
+// new version and acknowledges it with an ephemeral <basePath>/acks/<uuid>
+// zNode holding the applied version, so the leader (or anyone else) can wait
+// for a version to have been applied everywhere.
+//
+// Tables are versioned and only ever written conditionally on the version the
+// leader last read, and fenced on the leader's candidate zNode (see
+// cluster.LeaderFence), so a leader which has left the election, e.g. after
+// its session expired, cannot overwrite its successor's table.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const (
+	electionDir = "election"
+	tableZNode  = "table"
+	acksDir     = "acks"
+)
+
+var (
+	worldAllAcl     = zk.WorldACL(zk.PermAll)
+	retryInterval   = 1 * time.Second
+	ackPollInterval = 100 * time.Millisecond
+)
+
+// Table is a published routing table.
+type Table struct {
+	Version     int64             `json:"version"` // Increases with every publication, starting at 1.
+	Routes      map[string]string `json:"routes"`  // e.g. shard -> member Uuid.
+	Leader      string            `json:"leader"`  // Uuid of the publishing leader.
+	PublishedAt time.Time         `json:"publishedAt"`
+}
+
+// ComputeFunc computes the routing table for the given members, in order of
+// succession.  previous is the currently published table, or nil when there
+// is none.  Returning routes equal to those of previous publishes nothing.
+type ComputeFunc func(members []primitives.Node, previous *Table) (routes map[string]string, err error)
+
+// ApplyFunc applies a newly published table on a member.  The table is only
+// acknowledged once it returns nil, otherwise it is retried.
+type ApplyFunc func(table Table) error
+
+// Router publishes the routing table whenever it leads, and applies every
+// published table.  Exported fields must be set before Start().
+type Router struct {
+	Coordinator *cluster.Coordinator
+	Compute     ComputeFunc
+	Apply       ApplyFunc
+
+	basePath       string
+	updates        chan primitives.Update
+	republishChan  chan struct{}
+	applied        *Table
+	appliedChanged chan struct{} // Closed and replaced whenever applied changes.
+	quitChan       chan struct{}
+	doneChan       chan struct{}
+	lock           sync.Mutex
+}
+
+func New(zkServers []string, sessionTimeout time.Duration, basePath string, data string, compute ComputeFunc, apply ApplyFunc) (*Router, error) {
+	r := &Router{
+		Compute:        compute,
+		Apply:          apply,
+		basePath:       zkutil.NormalizePath(basePath),
+		updates:        make(chan primitives.Update, 10),
+		republishChan:  make(chan struct{}, 1),
+		appliedChanged: make(chan struct{}),
+	}
+	cc, err := cluster.NewCoordinator(zkServers, sessionTimeout, r.basePath+"/"+electionDir, data, r.updates)
+	if err != nil {
+		return nil, fmt.Errorf("routing.New: %s", err)
+	}
+	r.Coordinator = cc
+	return r, nil
+}
+
+func (r *Router) Start() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.quitChan != nil {
+		return errors.New("routing: already started")
+	}
+	if r.Compute == nil || r.Apply == nil {
+		return errors.New("routing: Compute and Apply are required")
+	}
+	if err := r.Coordinator.Start(); err != nil {
+		return err
+	}
+	r.quitChan = make(chan struct{})
+	r.doneChan = make(chan struct{})
+	go r.run(r.quitChan, r.doneChan)
+	return nil
+}
+
+func (r *Router) Stop() error {
+	r.lock.Lock()
+	quitChan, doneChan := r.quitChan, r.doneChan
+	r.quitChan, r.doneChan = nil, nil
+	r.lock.Unlock()
+
+	if quitChan == nil {
+		return errors.New("routing: already stopped")
+	}
+	close(quitChan)
+	<-doneChan
+	return r.Coordinator.Stop()
+}
+
+// Table returns the most recently applied table, or nil when none has been
+// applied yet.
+func (r *Router) Table() *Table {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.applied == nil {
+		return nil
+	}
+	table := *r.applied
+	return &table
+}
+
+// WaitForVersion blocks until the local member has applied at least the given
+// version.
+func (r *Router) WaitForVersion(ctx context.Context, version int64) (*Table, error) {
+	for {
+		r.lock.Lock()
+		applied, changed := r.applied, r.appliedChanged
+		r.lock.Unlock()
+		if applied != nil && applied.Version >= version {
+			table := *applied
+			return &table, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("routing: waiting for version=%v: %s", version, ctx.Err())
+		}
+	}
+}
+
+// Republish makes the leader recompute the table even though membership has
+// not changed, e.g. because inputs to Compute have.  It has no effect on
+// followers.
+func (r *Router) Republish() {
+	select {
+	case r.republishChan <- struct{}{}:
+	default:
+	}
+}
+
+// Acks returns the version acknowledged by each member, by Uuid.
+func (r *Router) Acks(ctx context.Context) (acks map[string]int64, err error) {
+	err = r.Coordinator.Do(ctx, func(conn *zk.Conn) (err error) {
+		acks, err = Acks(conn, r.basePath)
+		return
+	})
+	return
+}
+
+// AwaitAcks blocks until every current member has acknowledged at least the
+// given version.
+func (r *Router) AwaitAcks(ctx context.Context, version int64) error {
+	for {
+		members, err := r.Coordinator.Members()
+		if err != nil {
+			return fmt.Errorf("routing: awaiting acks: %s", err)
+		}
+		acks, err := r.Acks(ctx)
+		if err != nil {
+			return fmt.Errorf("routing: awaiting acks: %s", err)
+		}
+		pending := 0
+		for _, member := range members {
+			if acks[member.Uuid.String()] < version {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-time.After(ackPollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("routing: awaiting acks of version=%v, %v member(s) pending: %s", version, pending, ctx.Err())
+		}
+	}
+}
+
+// ReadTable returns the table published under basePath, or nil when there is
+// none.  It needn't be invoked by a member.
+func ReadTable(conn *zk.Conn, basePath string) (*Table, error) {
+	table, _, err := readTable(conn, zkutil.NormalizePath(basePath)+"/"+tableZNode)
+	return table, err
+}
+
+// Acks returns the version acknowledged by each member of the table under
+// basePath, by Uuid.  It needn't be invoked by a member.
+func Acks(conn *zk.Conn, basePath string) (map[string]int64, error) {
+	acksPath := zkutil.NormalizePath(basePath) + "/" + acksDir
+	children, _, err := conn.Children(acksPath)
+	if err == zk.ErrNoNode {
+		return map[string]int64{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("routing: listing acks under path=%v: %s", acksPath, err)
+	}
+	acks := map[string]int64{}
+	for _, child := range children {
+		data, _, err := conn.Get(acksPath + "/" + child)
+		if err == zk.ErrNoNode {
+			continue // Departed meanwhile.
+		} else if err != nil {
+			return nil, fmt.Errorf("routing: reading ack of member=%v: %s", child, err)
+		}
+		version, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			log.Warnf("routing: ignoring malformed ack of member=%v: %s", child, err)
+			continue
+		}
+		acks[child] = version
+	}
+	return acks, nil
+}
+
+func (r *Router) run(quitChan chan struct{}, doneChan chan struct{}) {
+	defer close(doneChan)
+	var (
+		local     = r.Coordinator.LocalNode.Uuid.String()
+		tableCh   <-chan zk.Event
+		armedConn *zk.Conn // Connection the watch was armed with.
+		acked     int64    // Version acknowledged via armedConn's session.
+		retryCh   <-chan time.Time
+	)
+	for {
+		conn := r.Coordinator.Conn()
+		if conn != armedConn {
+			tableCh, acked = nil, 0
+			armedConn = conn
+		}
+		ok := conn != nil
+		if ok && r.Coordinator.Mode() == primitives.Leader {
+			if err := r.publish(conn, local); err != nil {
+				log.Warnf("routing path=%v: publishing table (will retry): %s", r.basePath, err)
+				ok = false
+			}
+		}
+		if ok {
+			var table *Table
+			if table, tableCh, ok = r.watch(conn); ok && table != nil {
+				ok = r.apply(*table)
+				if ok && table.Version != acked {
+					if err := r.ack(conn, local, table.Version); err != nil {
+						log.Warnf("routing path=%v: acknowledging version=%v (will retry): %s", r.basePath, table.Version, err)
+						ok = false
+					} else {
+						acked = table.Version
+					}
+				}
+			}
+		}
+		retryCh = nil
+		if !ok {
+			retryCh = time.After(retryInterval)
+		}
+
+		select {
+		case <-tableCh:
+			tableCh = nil
+		case <-r.updates:
+			acked = 0 // NB: The ack is ephemeral, so re-check it after session changes.
+		case <-r.republishChan:
+		case <-retryCh:
+		case <-quitChan:
+			return
+		}
+	}
+}
+
+// publish computes the table for the current members and publishes it unless
+// the routes are unchanged.  Only invoked by the leader.
+func (r *Router) publish(conn *zk.Conn, local string) error {
+	fence, err := r.Coordinator.LeaderFence()
+	if err != nil {
+		return err
+	}
+	members, err := r.Coordinator.Members()
+	if err != nil {
+		return err
+	}
+	zNode := r.basePath + "/" + tableZNode
+	previous, stat, err := readTable(conn, zNode)
+	if err != nil {
+		return err
+	}
+	routes, err := r.Compute(members, previous)
+	if err != nil {
+		return fmt.Errorf("computing table: %s", err)
+	}
+	if previous != nil && reflect.DeepEqual(routes, previous.Routes) {
+		return nil
+	}
+
+	table := Table{Version: 1, Routes: routes, Leader: local, PublishedAt: time.Now()}
+	if previous != nil {
+		table.Version = previous.Version + 1
+	}
+	data, err := json.Marshal(&table)
+	if err != nil {
+		return fmt.Errorf("encoding table: %s", err)
+	}
+	var write interface{} = &zk.CreateRequest{Path: zNode, Data: data, Acl: worldAllAcl}
+	if stat == nil {
+		if _, err := zkutil.EnsurePath(conn, r.basePath, zkutil.EnsurePathOptions{ACL: worldAllAcl}); err != nil {
+			return err
+		}
+	} else {
+		write = &zk.SetDataRequest{Path: zNode, Data: data, Version: stat.Version}
+	}
+	responses, err := conn.Multi(fence, write)
+	if cluster.Fenced(responses, err) {
+		return fmt.Errorf("publishing table version=%v: no longer leading: %s", table.Version, err)
+	} else if err == zk.ErrNodeExists || err == zk.ErrBadVersion || err == zk.ErrNoNode {
+		return fmt.Errorf("table was published concurrently, will recompute: %s", err)
+	} else if err != nil {
+		return err
+	}
+	log.Infof("routing path=%v: published table version=%v with %v route(s)", r.basePath, table.Version, len(routes))
+	return nil
+}
+
+// watch reads the table and arms a watch on it, or an existence watch while
+// none has been published.
+func (r *Router) watch(conn *zk.Conn) (*Table, <-chan zk.Event, bool) {
+	zNode := r.basePath + "/" + tableZNode
+	data, _, evCh, err := conn.GetW(zNode)
+	if err == zk.ErrNoNode {
+		var exists bool
+		if exists, _, evCh, err = conn.ExistsW(zNode); err == nil && exists {
+			return r.watch(conn)
+		}
+		if err == nil {
+			return nil, evCh, true
+		}
+	}
+	if err != nil {
+		log.Warnf("routing: watching path=%v (will retry): %s", zNode, err)
+		return nil, nil, false
+	}
+	table, err := decodeTable(data)
+	if err != nil {
+		log.Warnf("routing: path=%v: %s", zNode, err)
+		return nil, evCh, true
+	}
+	return table, evCh, true
+}
+
+// apply hands table to Apply unless it has already been applied.
+func (r *Router) apply(table Table) bool {
+	r.lock.Lock()
+	current := r.applied
+	r.lock.Unlock()
+	if current != nil && current.Version == table.Version && current.PublishedAt.Equal(table.PublishedAt) {
+		return true
+	}
+	if err := r.Apply(table); err != nil {
+		log.Warnf("routing path=%v: applying table version=%v (will retry): %s", r.basePath, table.Version, err)
+		return false
+	}
+	r.lock.Lock()
+	r.applied = &table
+	close(r.appliedChanged)
+	r.appliedChanged = make(chan struct{})
+	r.lock.Unlock()
+	return true
+}
+
+// ack records that the local member has applied version.
+func (r *Router) ack(conn *zk.Conn, local string, version int64) error {
+	acksPath := r.basePath + "/" + acksDir
+	zNode := acksPath + "/" + local
+	data := []byte(strconv.FormatInt(version, 10))
+	if _, err := conn.Set(zNode, data, -1); err != zk.ErrNoNode {
+		return err
+	}
+	if _, err := zkutil.EnsurePath(conn, acksPath, zkutil.EnsurePathOptions{ACL: worldAllAcl}); err != nil {
+		return err
+	}
+	if _, err := conn.Create(zNode, data, zk.FlagEphemeral, worldAllAcl); err != nil {
+		return err
+	}
+	return nil
+}
+
+func readTable(conn *zk.Conn, zNode string) (*Table, *zk.Stat, error) {
+	data, stat, err := conn.Get(zNode)
+	if err == zk.ErrNoNode {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("reading table path=%v: %s", zNode, err)
+	}
+	table, err := decodeTable(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return table, stat, nil
+}
+
+func decodeTable(data []byte) (*Table, error) {
+	table := &Table{}
+	if err := json.Unmarshal(data, table); err != nil {
+		return nil, fmt.Errorf("decoding table: %s", err)
+	}
+	return table, nil
+}
@@ -0,0 +1,137 @@
+package routing_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/routing"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+)
+
+var zkTimeout = 5 * time.Second
+
+const numShards = 6
+
+// roundRobin assigns the shards to the members in order of succession.
+func roundRobin(members []primitives.Node, _ *routing.Table) (map[string]string, error) {
+	routes := map[string]string{}
+	if len(members) == 0 {
+		return routes, nil
+	}
+	for shard := 0; shard < numShards; shard++ {
+		routes[fmt.Sprint(shard)] = members[shard%len(members)].Uuid.String()
+	}
+	return routes, nil
+}
+
+func TestRouter(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/routing", testlib.CurrentRunningTest())
+
+		var (
+			routers = []*routing.Router{}
+			applied = map[int][]int64{} // Versions applied, by router.
+			lock    sync.Mutex
+		)
+		for i := 0; i < 3; i++ {
+			i := i
+			apply := func(table routing.Table) error {
+				lock.Lock()
+				applied[i] = append(applied[i], table.Version)
+				lock.Unlock()
+				return nil
+			}
+			r, err := routing.New(zkServers, zkTimeout, basePath, fmt.Sprintf("i=%v", i), roundRobin, apply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Start(); err != nil {
+				t.Fatal(err)
+			}
+			routers = append(routers, r)
+		}
+		defer func() {
+			for _, r := range routers[:2] {
+				if err := r.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		// Wait for a table covering all three members to be applied everywhere.
+		awaitMembers := func(n int) *routing.Table {
+			for {
+				table, err := routers[0].WaitForVersion(ctx, 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				targets := map[string]bool{}
+				for _, target := range table.Routes {
+					targets[target] = true
+				}
+				if len(targets) == n {
+					if err := routers[0].AwaitAcks(ctx, table.Version); err != nil {
+						t.Fatal(err)
+					}
+					return table
+				}
+				if _, err := routers[0].WaitForVersion(ctx, table.Version+1); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		table := awaitMembers(3)
+		if len(table.Routes) != numShards {
+			t.Errorf("Expected %v routes but actual=%v", numShards, table.Routes)
+		}
+		if leader := routers[0].Coordinator.Leader(); leader == nil || table.Leader != leader.Uuid.String() {
+			t.Errorf("Expected the table to be published by the leader=%+v but actual=%v", leader, table.Leader)
+		}
+		for i, r := range routers {
+			if local := r.Table(); local == nil || local.Version < table.Version {
+				t.Errorf("Expected router #%v to have applied version>=%v but actual=%+v", i, table.Version, local)
+			}
+		}
+
+		// Remove a follower, the leader republishes without it.
+		departed := routers[2].Coordinator.LocalNode.Uuid.String()
+		if err := routers[2].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		next := awaitMembers(2)
+		if next.Version <= table.Version {
+			t.Errorf("Expected version to increase beyond %v but actual=%v", table.Version, next.Version)
+		}
+		for shard, target := range next.Routes {
+			if target == departed {
+				t.Errorf("Expected shard=%v to be moved off the departed member", shard)
+			}
+		}
+
+		acks, err := routers[1].Acks(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := acks[departed]; ok {
+			t.Errorf("Expected the departed member's ack to be gone but acks=%v", acks)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		for i, versions := range applied {
+			for j := 1; j < len(versions); j++ {
+				if versions[j] <= versions[j-1] {
+					t.Errorf("Expected router #%v to apply increasing versions but actual=%v", i, versions)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/clock"
+)
+
+// FakeClock is a clock.Clock which only moves when Advance is called, so tests
+// can step through timeouts and intervals without really waiting.
+type FakeClock struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	lock    sync.Mutex
+	cond    *sync.Cond
+}
+
+type fakeWaiter struct {
+	at     time.Time
+	period time.Duration // Non-zero for tickers.
+	ch     chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	fc := &FakeClock{
+		now: now,
+	}
+	fc.cond = sync.NewCond(&fc.lock)
+	return fc
+}
+
+func (fc *FakeClock) Now() time.Time {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.now
+}
+
+func (fc *FakeClock) Since(t time.Time) time.Duration {
+	return fc.Now().Sub(t)
+}
+
+// Sleep blocks until the clock has been advanced by at least d.
+func (fc *FakeClock) Sleep(d time.Duration) {
+	<-fc.After(d)
+}
+
+func (fc *FakeClock) After(d time.Duration) <-chan time.Time {
+	return fc.addWaiter(d, 0).ch
+}
+
+func (fc *FakeClock) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("testutil: non-positive interval for FakeClock.NewTicker")
+	}
+	return &fakeTicker{clock: fc, waiter: fc.addWaiter(d, d)}
+}
+
+func (fc *FakeClock) addWaiter(d time.Duration, period time.Duration) *fakeWaiter {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	w := &fakeWaiter{
+		at:     fc.now.Add(d),
+		period: period,
+		ch:     make(chan time.Time, 1),
+	}
+	if d <= 0 && period == 0 {
+		w.ch <- fc.now
+		return w
+	}
+	fc.waiters = append(fc.waiters, w)
+	fc.cond.Broadcast()
+	return w
+}
+
+func (fc *FakeClock) removeWaiter(w *fakeWaiter) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	for i, other := range fc.waiters {
+		if other == w {
+			fc.waiters = append(fc.waiters[0:i], fc.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker which
+// comes due.  Like time.Ticker, a ticker which isn't being drained drops
+// ticks.
+func (fc *FakeClock) Advance(d time.Duration) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+
+	fc.now = fc.now.Add(d)
+	remaining := fc.waiters[:0]
+	for _, w := range fc.waiters {
+		if w.at.After(fc.now) {
+			remaining = append(remaining, w)
+			continue
+		}
+		select {
+		case w.ch <- fc.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.at.After(fc.now) {
+				w.at = w.at.Add(w.period)
+			}
+			remaining = append(remaining, w)
+		}
+	}
+	fc.waiters = remaining
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (fc *FakeClock) Waiters() int {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return len(fc.waiters)
+}
+
+// BlockUntil waits until at least n timers or tickers are pending, which is
+// useful for ensuring a goroutine is waiting on the clock before calling
+// Advance.
+func (fc *FakeClock) BlockUntil(n int) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	for len(fc.waiters) < n {
+		fc.cond.Wait()
+	}
+}
+
+// BlockUntilTimeout is like BlockUntil, but gives up once timeout of real
+// time has elapsed.  Reports whether n timers or tickers became pending.
+func (fc *FakeClock) BlockUntilTimeout(n int, timeout time.Duration) bool {
+	var timedOut bool
+	timer := time.AfterFunc(timeout, func() {
+		fc.lock.Lock()
+		defer fc.lock.Unlock()
+		timedOut = true
+		fc.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	for len(fc.waiters) < n && !timedOut {
+		fc.cond.Wait()
+	}
+	return len(fc.waiters) >= n
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+func (ticker *fakeTicker) C() <-chan time.Time {
+	return ticker.waiter.ch
+}
+
+func (ticker *fakeTicker) Stop() {
+	ticker.clock.removeWaiter(ticker.waiter)
+}
@@ -0,0 +1,71 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	var (
+		start = time.Unix(1500000000, 0)
+		fc    = NewFakeClock(start)
+		after = fc.After(time.Second)
+	)
+	ticker := fc.NewTicker(300 * time.Millisecond)
+	defer ticker.Stop()
+
+	fc.Advance(500 * time.Millisecond)
+	select {
+	case <-after:
+		t.Fatalf("After(1s) fired after only 500ms")
+	default:
+	}
+	select {
+	case now := <-ticker.C():
+		if expected := start.Add(500 * time.Millisecond); !now.Equal(expected) {
+			t.Errorf("Expected tick at %v but actual=%v", expected, now)
+		}
+	default:
+		t.Fatalf("Expected ticker to have fired")
+	}
+
+	fc.Advance(500 * time.Millisecond)
+	select {
+	case <-after:
+	default:
+		t.Fatalf("Expected After(1s) to have fired")
+	}
+	if expected, actual := start.Add(time.Second), fc.Now(); !actual.Equal(expected) {
+		t.Errorf("Expected Now()=%v but actual=%v", expected, actual)
+	}
+	if waiters := fc.Waiters(); waiters != 1 {
+		t.Errorf("Expected only the ticker to remain pending, but waiters=%v", waiters)
+	}
+}
+
+func TestFakeClockSleep(t *testing.T) {
+	fc := NewFakeClock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		fc.Sleep(time.Minute)
+		close(done)
+	}()
+	fc.BlockUntil(1)
+	fc.Advance(time.Minute)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Sleep(1m) did not return after advancing 1m")
+	}
+}
+
+func TestFakeClockBlockUntilTimeout(t *testing.T) {
+	fc := NewFakeClock(time.Now())
+	if fc.BlockUntilTimeout(1, 50*time.Millisecond) {
+		t.Errorf("Expected BlockUntilTimeout to give up when nothing waits on the clock")
+	}
+	fc.After(time.Minute)
+	if !fc.BlockUntilTimeout(1, time.Second) {
+		t.Errorf("Expected BlockUntilTimeout to observe the pending timer")
+	}
+}
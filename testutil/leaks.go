@@ -0,0 +1,66 @@
+package testutil
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster/primitives"
+)
+
+// LeakTimeout is how long CheckLeaks waits for resources to be released.
+var LeakTimeout = 5 * time.Second
+
+// ResourceOwner accounts for what it holds, e.g. a cluster.Coordinator.
+type ResourceOwner interface {
+	Resources() primitives.Resources
+}
+
+// ResourcesFunc adapts a func, e.g. barrier.Resources, to a ResourceOwner.
+type ResourcesFunc func() primitives.Resources
+
+func (fn ResourcesFunc) Resources() primitives.Resources {
+	return fn()
+}
+
+// CheckLeaks notes the number of running goroutines and returns a func which
+// fails t unless, within LeakTimeout, every owner has released its watches,
+// goroutines and ephemeral zNodes and no more goroutines are running than
+// before.  It should be invoked before the owners are started, and the
+// returned func once they have been stopped:
+//
+//	checkLeaks := testutil.CheckLeaks(t, cc)
+//	... start, exercise and stop cc ...
+//	checkLeaks()
+func CheckLeaks(t testing.TB, owners ...ResourceOwner) func() {
+	baseline := runtime.NumGoroutine()
+	return func() {
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaked := false
+			for _, owner := range owners {
+				if !owner.Resources().Empty() {
+					leaked = true
+				}
+			}
+			if !leaked && runtime.NumGoroutine() <= baseline {
+				return
+			}
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		for i, owner := range owners {
+			if resources := owner.Resources(); !resources.Empty() {
+				t.Errorf("testutil: owner #%v leaked %v", i, resources)
+			}
+		}
+		if n := runtime.NumGoroutine(); n > baseline {
+			buf := make([]byte, 1024*1024)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Errorf("testutil: %v goroutine(s) leaked (before=%v after=%v), running goroutines:\n%s", n-baseline, baseline, n, buf)
+		}
+	}
+}
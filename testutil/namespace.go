@@ -0,0 +1,136 @@
+package testutil
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/satori/go.uuid"
+)
+
+// NamespaceRoot is the parent path of the per-test namespaces allocated by
+// WithZk.
+var NamespaceRoot = "/zklib-test"
+
+var (
+	namespaces     = map[testing.TB]string{}
+	namespacesLock sync.Mutex
+)
+
+// Namespace returns the unique ZooKeeper path WithZk allocated to t, e.g.
+// "/zklib-test/TestFoo-1b4e28ba".  Tests should root all of their zNodes below
+// it so that they may share an ensemble and run with t.Parallel().
+//
+// Must only be invoked from within a WithZk callback.
+func Namespace(t testing.TB) string {
+	namespacesLock.Lock()
+	ns, ok := namespaces[t]
+	namespacesLock.Unlock()
+	if !ok {
+		t.Fatalf("testutil.Namespace: no namespace allocated for test, must be invoked from within WithZk")
+	}
+	return ns
+}
+
+// withNamespace allocates a namespace for t, invokes fn, and then deletes the
+// namespace along with everything fn left behind.
+func withNamespace(t testing.TB, zkServers []string, fn func(zkServers []string)) {
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(testlib.CurrentRunningTest())
+	ns := path.Join(NamespaceRoot, fmt.Sprintf("%v-%v", name, strings.Split(uuid.Must(uuid.NewV4()).String(), "-")[0]))
+
+	conn, err := connectAndWait(zkServers)
+	if err != nil {
+		t.Fatalf("testutil: allocating namespace=%v: %s", ns, err)
+	}
+	defer conn.Close()
+	if err := createAll(conn, ns); err != nil {
+		t.Fatalf("testutil: allocating namespace=%v: %s", ns, err)
+	}
+
+	namespacesLock.Lock()
+	previous, nested := namespaces[t]
+	namespaces[t] = ns
+	namespacesLock.Unlock()
+	defer func() {
+		namespacesLock.Lock()
+		if nested {
+			namespaces[t] = previous
+		} else {
+			delete(namespaces, t)
+		}
+		namespacesLock.Unlock()
+
+		if err := deleteAll(conn, ns); err != nil {
+			t.Logf("testutil: problem cleaning up namespace=%v (non-fatal): %s", ns, err)
+		}
+	}()
+
+	fn(zkServers)
+}
+
+func connectAndWait(zkServers []string) (*zk.Conn, error) {
+	conn, zkEvents, err := zk.Connect(zkServers, zkTimeout)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.After(zkTimeout)
+	for {
+		select {
+		case event := <-zkEvents:
+			if event.Type == zk.EventSession && event.State == zk.StateHasSession {
+				return conn, nil
+			}
+		case <-timeout:
+			conn.Close()
+			return nil, fmt.Errorf("timed out after %s waiting for a session", zkTimeout)
+		}
+	}
+}
+
+func createAll(conn *zk.Conn, p string) error {
+	soFar := ""
+	for _, piece := range strings.Split(strings.Trim(p, "/"), "/") {
+		soFar += "/" + piece
+		if _, err := conn.Create(soFar, []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil && err != zk.ErrNodeExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAll removes p and all of its descendants.  Ephemeral zNodes of
+// sessions which are still closing may transiently reappear, so deletion is
+// retried a few times.
+func deleteAll(conn *zk.Conn, p string) (err error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = deleteTree(conn, p); err == nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return
+}
+
+func deleteTree(conn *zk.Conn, p string) error {
+	children, _, err := conn.Children(p)
+	if err == zk.ErrNoNode {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := deleteTree(conn, path.Join(p, child)); err != nil {
+			return err
+		}
+	}
+	if err := conn.Delete(p, -1); err != nil && err != zk.ErrNoNode {
+		return err
+	}
+	return nil
+}
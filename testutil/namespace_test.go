@@ -0,0 +1,57 @@
+package testutil
+
+import (
+	"testing"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestNamespace(t *testing.T) {
+	var (
+		seen       = map[string]bool{}
+		namespaces = make(chan string, 2)
+	)
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"a", "b"} {
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+					ns := Namespace(t)
+					conn, err := connectAndWait(zkServers)
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer conn.Close()
+					if exists, _, err := conn.Exists(ns); err != nil || !exists {
+						t.Fatalf("Expected namespace=%v to exist, but exists=%v err=%v", ns, exists, err)
+					}
+					if _, err := conn.Create(ns+"/leftover", []byte{}, 0, zk.WorldACL(zk.PermAll)); err != nil {
+						t.Fatal(err)
+					}
+					namespaces <- ns
+				})
+			})
+		}
+	})
+	close(namespaces)
+
+	WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		conn, err := connectAndWait(zkServers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for ns := range namespaces {
+			if seen[ns] {
+				t.Errorf("Expected namespaces to be unique, but ns=%v was allocated twice", ns)
+			}
+			seen[ns] = true
+			if exists, _, err := conn.Exists(ns); err != nil || exists {
+				t.Errorf("Expected namespace=%v to have been cleaned up, but exists=%v err=%v", ns, exists, err)
+			}
+		}
+	})
+	if len(seen) != 2 {
+		t.Errorf("Expected 2 namespaces to have been allocated, but actual=%v", len(seen))
+	}
+}
@@ -0,0 +1,84 @@
+package scenario
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// proxy forwards connections to a ZooKeeper server while up, and drops them
+// all while down.
+type proxy struct {
+	listener net.Listener
+	target   string
+	down     bool
+	conns    []net.Conn
+	lock     sync.Mutex
+}
+
+func newProxy(target string) (*proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{
+		listener: listener,
+		target:   target,
+	}
+	go p.serve()
+	return p, nil
+}
+
+func (p *proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *proxy) SetDown(down bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.down = down
+	if down {
+		for _, conn := range p.conns {
+			conn.Close()
+		}
+		p.conns = nil
+	}
+}
+
+func (p *proxy) Close() error {
+	p.SetDown(true)
+	return p.listener.Close()
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.lock.Lock()
+		if p.down {
+			p.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		upstream, err := net.Dial("tcp", p.target)
+		if err != nil {
+			p.lock.Unlock()
+			conn.Close()
+			continue
+		}
+		p.conns = append(p.conns, conn, upstream)
+		p.lock.Unlock()
+
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		go func() {
+			io.Copy(conn, upstream)
+			conn.Close()
+		}()
+	}
+}
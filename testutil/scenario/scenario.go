@@ -0,0 +1,475 @@
+package scenario
+
+// Scripted cluster scenarios.
+//
+// A Scenario declaratively scripts a sequence of steps against the members of
+// a single election, along with expectations about the outcome, e.g.:
+//
+//	scenario.New(t, zkServers, electionPath).
+//		Start(3).
+//		ExpectLeader(5 * time.Second).
+//		KillLeader().
+//		ExpectNewLeader(5 * time.Second).
+//		Partition(2).
+//		ExpectMembers(2, 5 * time.Second).
+//		Run()
+//
+// Members are numbered from zero in the order they were started.  Each one
+// reaches ZooKeeper through its own proxies, so that Partition can cut it off
+// without affecting the others, and Kill can make it vanish as abruptly as a
+// crashed process would.  The first failing step fails the test, and
+// every member is stopped once Run returns.
+//
+// NB: This lives apart from package testutil since it depends on package
+// cluster, whose own dependencies are tested using testutil.
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DefaultSessionTimeout = 1 * time.Second
+	DefaultTimeout        = 5 * time.Second
+
+	pollInterval = 10 * time.Millisecond
+)
+
+// Scenario is a script of steps.  Exported fields must be set before Run.
+type Scenario struct {
+	// Configure, when set, is invoked with each member before it is started,
+	// e.g. to set Coordinator options.
+	Configure func(i int, cc *cluster.Coordinator)
+
+	// SessionTimeout of the members, defaults to DefaultSessionTimeout.
+	SessionTimeout time.Duration
+
+	// Timeout applies to member starts and to expectations given no explicit
+	// duration, defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	t            testing.TB
+	zkServers    []string
+	electionPath string
+	steps        []step
+	members      []*member
+	leader       string // Uuid of the leader most recently expected.
+}
+
+type step struct {
+	description string
+	fn          func() error
+}
+
+type member struct {
+	cc          *cluster.Coordinator
+	proxies     []*proxy
+	running     bool
+	partitioned bool
+	killed      bool // Cut off for good, see Kill.
+}
+
+func New(t testing.TB, zkServers []string, electionPath string) *Scenario {
+	s := &Scenario{
+		t:            t,
+		zkServers:    zkServers,
+		electionPath: electionPath,
+	}
+	return s
+}
+
+// Run performs the steps in order, failing the test at the first one which
+// fails.
+func (s *Scenario) Run() {
+	defer s.cleanup()
+	for i, step := range s.steps {
+		s.t.Logf("scenario: step #%v: %v", i, step.description)
+		if err := step.fn(); err != nil {
+			s.t.Fatalf("scenario: step #%v (%v) failed: %s", i, step.description, err)
+		}
+	}
+}
+
+// Member returns the Coordinator of member i, for use from within Do.
+func (s *Scenario) Member(i int) *cluster.Coordinator {
+	return s.members[i].cc
+}
+
+// Len returns the number of members started so far, including stopped ones.
+func (s *Scenario) Len() int {
+	return len(s.members)
+}
+
+// Leader returns the index of the running member which currently leads, or -1
+// when there is none.
+func (s *Scenario) Leader() int {
+	for i, m := range s.members {
+		if m.running && !m.partitioned && m.cc.Mode() == primitives.Leader {
+			return i
+		}
+	}
+	return -1
+}
+
+// Start starts n new members.
+func (s *Scenario) Start(n int) *Scenario {
+	return s.add(fmt.Sprintf("start %v member(s)", n), func() error {
+		for i := 0; i < n; i++ {
+			if err := s.start(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Stop gracefully stops member i.
+func (s *Scenario) Stop(i int) *Scenario {
+	return s.add(fmt.Sprintf("stop member %v", i), func() error {
+		return s.stop(i)
+	})
+}
+
+// StopLeader gracefully stops whichever member currently leads.
+func (s *Scenario) StopLeader() *Scenario {
+	return s.add("stop the leader", func() error {
+		i := s.Leader()
+		if i == -1 {
+			return fmt.Errorf("no member leads")
+		}
+		s.t.Logf("scenario: member %v leads", i)
+		return s.stop(i)
+	})
+}
+
+// Kill ungracefully terminates member i, as if its process had crashed: its
+// connections are dropped without any further request reaching ZooKeeper, so
+// it leaves no tombstone and its candidate zNode remains until the session
+// expires.  Killed members can't be restarted.
+func (s *Scenario) Kill(i int) *Scenario {
+	return s.add(fmt.Sprintf("kill member %v", i), func() error {
+		return s.kill(i)
+	})
+}
+
+// KillLeader kills whichever member currently leads, see Kill.
+func (s *Scenario) KillLeader() *Scenario {
+	return s.add("kill the leader", func() error {
+		i := s.Leader()
+		if i == -1 {
+			return fmt.Errorf("no member leads")
+		}
+		s.t.Logf("scenario: member %v leads", i)
+		return s.kill(i)
+	})
+}
+
+// Restart stops and then restarts member i.
+func (s *Scenario) Restart(i int) *Scenario {
+	return s.add(fmt.Sprintf("restart member %v", i), func() error {
+		if err := s.stop(i); err != nil {
+			return err
+		}
+		return s.startMember(i)
+	})
+}
+
+// Partition cuts member i off from ZooKeeper, so that its session eventually
+// expires.
+func (s *Scenario) Partition(i int) *Scenario {
+	return s.add(fmt.Sprintf("partition member %v", i), func() error {
+		m, err := s.member(i)
+		if err != nil {
+			return err
+		}
+		m.setPartitioned(true)
+		return nil
+	})
+}
+
+// Heal reconnects a partitioned member i.
+func (s *Scenario) Heal(i int) *Scenario {
+	return s.add(fmt.Sprintf("heal member %v", i), func() error {
+		m, err := s.member(i)
+		if err != nil {
+			return err
+		}
+		if m.killed {
+			return fmt.Errorf("member %v was killed", i)
+		}
+		m.setPartitioned(false)
+		return nil
+	})
+}
+
+// Sleep pauses the scenario for d.
+func (s *Scenario) Sleep(d time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("sleep %s", d), func() error {
+		time.Sleep(d)
+		return nil
+	})
+}
+
+// Do performs a custom step.
+func (s *Scenario) Do(description string, fn func(s *Scenario) error) *Scenario {
+	return s.add(description, func() error {
+		return fn(s)
+	})
+}
+
+// ExpectLeader expects the reachable running members to agree on a leader
+// among them in time.  A zero within means Timeout.
+func (s *Scenario) ExpectLeader(within time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("expect a leader within %s", s.timeout(within)), func() error {
+		return s.expectLeader(within, false)
+	})
+}
+
+// ExpectNewLeader is like ExpectLeader, but the leader must differ from the
+// one most recently expected.
+func (s *Scenario) ExpectNewLeader(within time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("expect a new leader within %s", s.timeout(within)), func() error {
+		return s.expectLeader(within, true)
+	})
+}
+
+// ExpectMembers expects every reachable running member to see n members in
+// time.  A zero within means Timeout.
+func (s *Scenario) ExpectMembers(n int, within time.Duration) *Scenario {
+	return s.add(fmt.Sprintf("expect %v member(s) within %s", n, s.timeout(within)), func() error {
+		var last string
+		ok := s.await(within, func() bool {
+			for i, m := range s.reachable() {
+				members, err := m.cc.Members()
+				if err != nil {
+					last = fmt.Sprintf("member %v: listing members: %s", i, err)
+					return false
+				}
+				if len(members) != n {
+					last = fmt.Sprintf("member %v sees %v member(s)", i, len(members))
+					return false
+				}
+			}
+			return true
+		})
+		if !ok {
+			return fmt.Errorf("timed out, %v", last)
+		}
+		return nil
+	})
+}
+
+func (s *Scenario) add(description string, fn func() error) *Scenario {
+	s.steps = append(s.steps, step{description, fn})
+	return s
+}
+
+func (s *Scenario) expectLeader(within time.Duration, changed bool) error {
+	var (
+		last   = "no reachable running members"
+		leader string
+	)
+	ok := s.await(within, func() bool {
+		leader = ""
+		candidates := map[string]int{}
+		reachable := s.reachable()
+		for i, m := range reachable {
+			candidates[m.cc.LocalNode.Uuid.String()] = i
+		}
+		for i, m := range reachable {
+			node := m.cc.Leader()
+			if node == nil {
+				last = fmt.Sprintf("member %v knows no leader", i)
+				return false
+			}
+			uuid := node.Uuid.String()
+			if leader != "" && uuid != leader {
+				last = fmt.Sprintf("member %v disagrees about the leader", i)
+				return false
+			}
+			leader = uuid
+		}
+		if leader == "" {
+			return false
+		}
+		if _, ok := candidates[leader]; !ok {
+			last = fmt.Sprintf("leader=%v is not a reachable running member", leader)
+			return false
+		}
+		if changed && leader == s.leader {
+			last = fmt.Sprintf("leader=%v is unchanged", leader)
+			return false
+		}
+		return true
+	})
+	if !ok {
+		return fmt.Errorf("timed out, %v", last)
+	}
+	s.leader = leader
+	return nil
+}
+
+// await polls cond until it holds or within has passed.
+func (s *Scenario) await(within time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(s.timeout(within))
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// reachable returns the running members which aren't partitioned, by index.
+func (s *Scenario) reachable() map[int]*member {
+	reachable := map[int]*member{}
+	for i, m := range s.members {
+		if m.running && !m.partitioned {
+			reachable[i] = m
+		}
+	}
+	return reachable
+}
+
+func (s *Scenario) member(i int) (*member, error) {
+	if i < 0 || i >= len(s.members) {
+		return nil, fmt.Errorf("no member %v, only %v started", i, len(s.members))
+	}
+	return s.members[i], nil
+}
+
+func (s *Scenario) start() error {
+	m := &member{}
+	servers := make([]string, len(s.zkServers))
+	for i, zkServer := range s.zkServers {
+		p, err := newProxy(zkServer)
+		if err != nil {
+			m.close()
+			return err
+		}
+		m.proxies = append(m.proxies, p)
+		servers[i] = p.Addr()
+	}
+	i := len(s.members)
+	cc, err := cluster.NewCoordinator(servers, s.sessionTimeout(), s.electionPath, fmt.Sprintf("member-%v", i))
+	if err != nil {
+		m.close()
+		return err
+	}
+	m.cc = cc
+	if s.Configure != nil {
+		s.Configure(i, cc)
+	}
+	s.members = append(s.members, m)
+	return s.startMember(i)
+}
+
+func (s *Scenario) startMember(i int) error {
+	m, err := s.member(i)
+	if err != nil {
+		return err
+	}
+	if m.running {
+		return fmt.Errorf("member %v is already running", i)
+	}
+	if m.killed {
+		return fmt.Errorf("member %v was killed", i)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout(0))
+	defer cancel()
+	if err := m.cc.StartAndWait(ctx); err != nil {
+		return fmt.Errorf("starting member %v: %s", i, err)
+	}
+	m.running = true
+	return nil
+}
+
+func (s *Scenario) stop(i int) error {
+	m, err := s.member(i)
+	if err != nil {
+		return err
+	}
+	if !m.running {
+		return fmt.Errorf("member %v is not running", i)
+	}
+	m.running = false
+	if err := m.cc.Stop(); err != nil {
+		return fmt.Errorf("stopping member %v: %s", i, err)
+	}
+	return nil
+}
+
+// kill cuts member i off for good.  Its Coordinator lingers, unable to reach
+// ZooKeeper, until cleanup.
+func (s *Scenario) kill(i int) error {
+	m, err := s.member(i)
+	if err != nil {
+		return err
+	}
+	if !m.running {
+		return fmt.Errorf("member %v is not running", i)
+	}
+	m.running = false
+	m.killed = true
+	m.setPartitioned(true)
+	return nil
+}
+
+func (s *Scenario) cleanup() {
+	for i, m := range s.members {
+		// NB: Killed members are reconnected too, so their Coordinators can
+		// learn of their session's expiry and stop without hanging.
+		m.setPartitioned(false)
+		if m.running {
+			if err := s.stop(i); err != nil {
+				log.Warnf("scenario: cleaning up member %v (non-fatal): %s", i, err)
+			}
+		} else if m.killed {
+			if err := m.cc.Stop(); err != nil {
+				log.Warnf("scenario: cleaning up killed member %v (non-fatal): %s", i, err)
+			}
+		}
+		m.close()
+	}
+	s.members = nil
+}
+
+func (s *Scenario) sessionTimeout() time.Duration {
+	if s.SessionTimeout > 0 {
+		return s.SessionTimeout
+	}
+	return DefaultSessionTimeout
+}
+
+func (s *Scenario) timeout(within time.Duration) time.Duration {
+	if within > 0 {
+		return within
+	}
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (m *member) setPartitioned(partitioned bool) {
+	m.partitioned = partitioned
+	for _, p := range m.proxies {
+		p.SetDown(partitioned)
+	}
+}
+
+func (m *member) close() {
+	for _, p := range m.proxies {
+		p.Close()
+	}
+}
@@ -0,0 +1,31 @@
+package scenario_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/testutil/scenario"
+)
+
+func TestScenario(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		s := scenario.New(t, zkServers, testutil.Namespace(t))
+		s.Start(3).
+			ExpectLeader(5*time.Second).
+			ExpectMembers(3, 0).
+			StopLeader().
+			ExpectNewLeader(5*time.Second).
+			ExpectMembers(2, 0).
+			Partition(2).
+			ExpectMembers(1, 10*time.Second).
+			ExpectLeader(0).
+			Heal(2).
+			ExpectMembers(2, 10*time.Second).
+			ExpectLeader(0).
+			KillLeader().
+			ExpectNewLeader(10*time.Second).
+			ExpectMembers(1, 10*time.Second).
+			Run()
+	})
+}
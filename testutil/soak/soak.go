@@ -0,0 +1,472 @@
+package soak
+
+// Soak testing with invariant checking.
+//
+// A Runner continuously churns the members of a single election for a while,
+// starting new members and stopping existing ones at random, and meanwhile
+// asserts global invariants:
+//
+//	single-leader    - No two running members claim leadership at once, as
+//	                   sampled every SampleInterval.
+//	membership-count - Once churn settles, every running member sees exactly
+//	                   the running members.
+//	update-order     - No member is told of a departed member leading, nor of
+//	                   a departed zNode joining or departing.
+//
+// The outcome is a Report, which serializes to JSON for archiving alongside
+// release candidates, e.g.:
+//
+//	runner := soak.New(zkServers, electionPath)
+//	runner.Duration = 10 * time.Minute
+//	report, err := runner.Run(ctx)
+//	...
+//	report.WriteJSON(os.Stdout)
+//
+// NB: This lives apart from package testutil since it depends on package
+// cluster, whose own dependencies are tested using testutil.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	"github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/satori/go.uuid"
+)
+
+// Invariants checked by a Runner.
+const (
+	InvariantSingleLeader = "single-leader"
+	InvariantMembership   = "membership-count"
+	InvariantUpdateOrder  = "update-order"
+)
+
+// Churn operations performed by a Runner.
+const (
+	OpStart      = "start"
+	OpStop       = "stop"
+	OpStopLeader = "stop-leader"
+	OpShutdown   = "shutdown"
+)
+
+var (
+	DefaultMembers        = 3
+	DefaultDuration       = 30 * time.Second
+	DefaultChurnInterval  = 1 * time.Second
+	DefaultSampleInterval = 50 * time.Millisecond
+	DefaultSettleTimeout  = 10 * time.Second
+	DefaultSessionTimeout = 1 * time.Second
+)
+
+// Runner soaks an election.  Exported fields must be set before Run.
+type Runner struct {
+	// Members is the number of members kept running on average.  Churn varies
+	// it between one and twice as many.  Defaults to DefaultMembers.
+	Members int
+
+	// Duration of the soak, defaults to DefaultDuration.
+	Duration time.Duration
+
+	// ChurnInterval is the pause after churn settles before the next churn
+	// operation, defaults to DefaultChurnInterval.
+	ChurnInterval time.Duration
+
+	// SampleInterval is how often leadership is sampled, defaults to
+	// DefaultSampleInterval.
+	SampleInterval time.Duration
+
+	// SettleTimeout bounds how long membership may take to converge after a
+	// churn operation, defaults to DefaultSettleTimeout.
+	SettleTimeout time.Duration
+
+	// SessionTimeout of the members, defaults to DefaultSessionTimeout.
+	SessionTimeout time.Duration
+
+	// Seed of the churn.  Zero picks one, which is recorded in the Report so
+	// a failing soak can be replayed.
+	Seed int64
+
+	// Configure, when set, is invoked with each member before it is started,
+	// e.g. to set Coordinator options.
+	Configure func(i int, cc *cluster.Coordinator)
+
+	zkServers    []string
+	electionPath string
+	report       *Report
+	reportLock   sync.Mutex
+	running      []*member // In order of starting.
+	started      int       // Members started so far.
+	epochs       map[int64]string
+	rand         *rand.Rand
+}
+
+type member struct {
+	i        int
+	cc       *cluster.Coordinator
+	updates  chan primitives.Update
+	doneChan chan struct{}
+}
+
+// Report is the outcome of a soak.
+type Report struct {
+	ElectionPath string         `json:"electionPath"`
+	Seed         int64          `json:"seed"`
+	Started      time.Time      `json:"started"`
+	Duration     time.Duration  `json:"duration"`
+	Operations   map[string]int `json:"operations"`
+	Samples      int            `json:"samples"`
+	Epochs       int            `json:"epochs"` // Distinct leadership epochs observed, i.e. leader candidate zNodes.
+	Updates      int            `json:"updates"`
+	Violations   []Violation    `json:"violations"`
+}
+
+// Violation is an observed breach of an invariant.
+type Violation struct {
+	At        time.Time `json:"at"`
+	Invariant string    `json:"invariant"`
+	Detail    string    `json:"detail"`
+}
+
+func New(zkServers []string, electionPath string) *Runner {
+	runner := &Runner{
+		zkServers:    zkServers,
+		electionPath: electionPath,
+	}
+	return runner
+}
+
+// Passed reports whether no invariant was violated.
+func (report *Report) Passed() bool {
+	return len(report.Violations) == 0
+}
+
+// WriteJSON writes the report as indented JSON.
+func (report *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Run soaks the election for Duration or until ctx is done, then stops every
+// member.  An error is only returned when the initial members can't be
+// started, invariant violations are recorded in the Report.
+func (runner *Runner) Run(ctx context.Context) (*Report, error) {
+	seed := runner.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	runner.rand = rand.New(rand.NewSource(seed))
+	runner.epochs = map[int64]string{}
+	runner.report = &Report{
+		ElectionPath: runner.electionPath,
+		Seed:         seed,
+		Started:      time.Now(),
+		Operations:   map[string]int{},
+		Violations:   []Violation{},
+	}
+
+	for i := 0; i < runner.size(); i++ {
+		if err := runner.start(); err != nil {
+			runner.cleanup()
+			return nil, err
+		}
+	}
+	runner.settle(ctx)
+
+	deadline := time.Now().Add(runner.duration())
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		op := runner.pick()
+		if err := runner.churn(op); err != nil {
+			log.Warnf("soak: %v: %s", op, err)
+		}
+		runner.count(func(report *Report) { report.Operations[op]++ })
+		runner.settle(ctx)
+		runner.sampleFor(runner.churnInterval(), deadline, ctx.Done())
+	}
+
+	runner.cleanup()
+	runner.reportLock.Lock()
+	defer runner.reportLock.Unlock()
+	runner.report.Duration = time.Since(runner.report.Started)
+	runner.report.Epochs = len(runner.epochs)
+	return runner.report, nil
+}
+
+// pick chooses the next churn operation, keeping the number of running
+// members between one and twice Members.
+func (runner *Runner) pick() string {
+	n := len(runner.running)
+	switch {
+	case n <= 1:
+		return OpStart
+	case n >= 2*runner.size():
+		return []string{OpStop, OpStopLeader, OpShutdown}[runner.rand.Intn(3)]
+	}
+	return []string{OpStart, OpStart, OpStart, OpStop, OpStopLeader, OpShutdown}[runner.rand.Intn(6)]
+}
+
+func (runner *Runner) churn(op string) error {
+	switch op {
+	case OpStart:
+		return runner.start()
+	case OpStopLeader:
+		for i, m := range runner.running {
+			if m.cc.Mode() == primitives.Leader {
+				return runner.stop(i, false)
+			}
+		}
+		return fmt.Errorf("no member leads")
+	case OpShutdown:
+		return runner.stop(runner.rand.Intn(len(runner.running)), true)
+	default:
+		return runner.stop(runner.rand.Intn(len(runner.running)), false)
+	}
+}
+
+// start starts a new member.  Members aren't restarted, so each incarnation
+// has its own Uuid and updates.
+func (runner *Runner) start() error {
+	m := &member{
+		i:        runner.started,
+		updates:  make(chan primitives.Update, 1000),
+		doneChan: make(chan struct{}),
+	}
+	cc, err := cluster.NewCoordinator(runner.zkServers, runner.sessionTimeout(), runner.electionPath, fmt.Sprintf("member-%v", m.i), m.updates)
+	if err != nil {
+		return err
+	}
+	m.cc = cc
+	if runner.Configure != nil {
+		runner.Configure(m.i, cc)
+	}
+	runner.started++
+	go runner.checkUpdates(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), runner.settleTimeout())
+	defer cancel()
+	if err := cc.StartAndWait(ctx); err != nil {
+		cc.Stop()
+		close(m.updates)
+		<-m.doneChan
+		return fmt.Errorf("starting member %v: %s", m.i, err)
+	}
+	runner.running = append(runner.running, m)
+	return nil
+}
+
+// stop stops the running member at index i, via Shutdown when graceful is
+// set.
+func (runner *Runner) stop(i int, graceful bool) error {
+	m := runner.running[i]
+	runner.running = append(runner.running[:i], runner.running[i+1:]...)
+	var err error
+	if graceful {
+		ctx, cancel := context.WithTimeout(context.Background(), runner.settleTimeout())
+		err = m.cc.Shutdown(ctx)
+		cancel()
+	} else {
+		err = m.cc.Stop()
+	}
+	// NB: The election loop has exited, so nothing is sent anymore.
+	close(m.updates)
+	<-m.doneChan
+	if err != nil {
+		return fmt.Errorf("stopping member %v: %s", m.i, err)
+	}
+	return nil
+}
+
+// settle awaits every running member seeing exactly the running members,
+// sampling leadership meanwhile, and records a violation should it take
+// longer than SettleTimeout.  Gives up without one once ctx is done.
+func (runner *Runner) settle(ctx context.Context) {
+	var (
+		deadline = time.Now().Add(runner.settleTimeout())
+		last     string
+	)
+	for {
+		runner.sample()
+		if last = runner.checkMembership(); last == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			runner.violate(InvariantMembership, fmt.Sprintf("not settled after %s: %v", runner.settleTimeout(), last))
+			return
+		}
+		select {
+		case <-time.After(runner.sampleInterval()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkMembership returns why membership hasn't converged, or "" if it has.
+func (runner *Runner) checkMembership() string {
+	live := map[string]struct{}{}
+	for _, m := range runner.running {
+		live[m.cc.LocalNode.Uuid.String()] = struct{}{}
+	}
+	for _, m := range runner.running {
+		nodes, err := m.cc.Members()
+		if err != nil {
+			return fmt.Sprintf("member %v: listing members: %s", m.i, err)
+		}
+		if len(nodes) != len(live) {
+			return fmt.Sprintf("member %v sees %v member(s) but %v are running", m.i, len(nodes), len(live))
+		}
+		for _, node := range nodes {
+			if _, ok := live[node.Uuid.String()]; !ok {
+				return fmt.Sprintf("member %v sees member=%v which isn't running", m.i, node.Uuid)
+			}
+		}
+	}
+	return ""
+}
+
+// sampleFor samples leadership every SampleInterval for d, or until deadline
+// or abortChan is closed.
+func (runner *Runner) sampleFor(d time.Duration, deadline time.Time, abortChan <-chan struct{}) {
+	until := time.Now().Add(d)
+	if deadline.Before(until) {
+		until = deadline
+	}
+	for time.Now().Before(until) {
+		runner.sample()
+		select {
+		case <-time.After(runner.sampleInterval()):
+		case <-abortChan:
+			return
+		}
+	}
+}
+
+// sample flags more than one running member claiming leadership at once, and
+// records the epochs of those which do.
+func (runner *Runner) sample() {
+	runner.count(func(report *Report) { report.Samples++ })
+	var leaders []string
+	for _, m := range runner.running {
+		if m.cc.Mode() != primitives.Leader {
+			continue
+		}
+		id := m.cc.LocalNode.Uuid.String()
+		leaders = append(leaders, fmt.Sprintf("member %v (%v)", m.i, id))
+		status := m.cc.Status()
+		if status.ZNode == "" {
+			continue
+		}
+		if epoch, err := util.SequenceNumber(path.Base(status.ZNode)); err == nil {
+			runner.epochs[epoch] = id
+		}
+	}
+	if len(leaders) > 1 {
+		runner.violate(InvariantSingleLeader, fmt.Sprintf("%v members lead at once: %v", len(leaders), strings.Join(leaders, ", ")))
+	}
+}
+
+// checkUpdates verifies the order of the updates delivered to m, which are
+// consumed until its channel is closed.
+func (runner *Runner) checkUpdates(m *member) {
+	defer close(m.doneChan)
+	var (
+		departed      = map[string]bool{} // Member Uuids, until they join again.
+		departedZNode = map[string]bool{}
+	)
+	for update := range m.updates {
+		runner.count(func(report *Report) { report.Updates++ })
+		for _, delta := range update.Deltas {
+			if departedZNode[delta.ZNode] {
+				runner.violate(InvariantUpdateOrder, fmt.Sprintf("member %v told of zNode=%v changing (joined=%v) after it departed", m.i, delta.ZNode, delta.Joined))
+			}
+			id := delta.Node.Uuid.String()
+			if delta.Joined {
+				delete(departed, id)
+				continue
+			}
+			departedZNode[delta.ZNode] = true
+			if delta.Node.Uuid != uuid.Nil {
+				departed[id] = true
+			}
+		}
+		if update.Type == primitives.LeaderUpdate && departed[update.Leader.Uuid.String()] {
+			runner.violate(InvariantUpdateOrder, fmt.Sprintf("member %v told of departed member=%v leading", m.i, update.Leader.Uuid))
+		}
+	}
+}
+
+func (runner *Runner) violate(invariant string, detail string) {
+	log.Warnf("soak: %v violated: %v", invariant, detail)
+	runner.count(func(report *Report) {
+		report.Violations = append(report.Violations, Violation{At: time.Now(), Invariant: invariant, Detail: detail})
+	})
+}
+
+func (runner *Runner) count(fn func(report *Report)) {
+	runner.reportLock.Lock()
+	defer runner.reportLock.Unlock()
+	fn(runner.report)
+}
+
+func (runner *Runner) cleanup() {
+	for len(runner.running) > 0 {
+		if err := runner.stop(len(runner.running)-1, false); err != nil {
+			log.Warnf("soak: cleaning up (non-fatal): %s", err)
+		}
+	}
+}
+
+func (runner *Runner) size() int {
+	if runner.Members > 0 {
+		return runner.Members
+	}
+	return DefaultMembers
+}
+
+func (runner *Runner) duration() time.Duration {
+	if runner.Duration > 0 {
+		return runner.Duration
+	}
+	return DefaultDuration
+}
+
+func (runner *Runner) churnInterval() time.Duration {
+	if runner.ChurnInterval > 0 {
+		return runner.ChurnInterval
+	}
+	return DefaultChurnInterval
+}
+
+func (runner *Runner) sampleInterval() time.Duration {
+	if runner.SampleInterval > 0 {
+		return runner.SampleInterval
+	}
+	return DefaultSampleInterval
+}
+
+func (runner *Runner) settleTimeout() time.Duration {
+	if runner.SettleTimeout > 0 {
+		return runner.SettleTimeout
+	}
+	return DefaultSettleTimeout
+}
+
+func (runner *Runner) sessionTimeout() time.Duration {
+	if runner.SessionTimeout > 0 {
+		return runner.SessionTimeout
+	}
+	return DefaultSessionTimeout
+}
@@ -0,0 +1,43 @@
+package soak_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/testutil/soak"
+)
+
+func TestSoak(t *testing.T) {
+	testutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		runner := soak.New(zkServers, testutil.Namespace(t))
+		runner.Duration = 5 * time.Second
+		runner.ChurnInterval = 200 * time.Millisecond
+
+		report, err := runner.Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := report.WriteJSON(buf); err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("report=%v", buf.String())
+
+		if !report.Passed() {
+			t.Errorf("Expected no invariant violations but actual=%+v", report.Violations)
+		}
+		if report.Epochs == 0 || report.Samples == 0 || report.Updates == 0 {
+			t.Errorf("Expected epochs, samples and updates to be observed but actual report=%+v", report)
+		}
+		operations := 0
+		for _, n := range report.Operations {
+			operations += n
+		}
+		if operations == 0 {
+			t.Errorf("Expected members to be churned but actual operations=%v", report.Operations)
+		}
+	})
+}
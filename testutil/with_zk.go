@@ -15,19 +15,22 @@ var (
 // WithZk allows for a default ZooKeeper address to be specified, and if the
 // port is reachable that connection info will be used.  Otherwise a full test
 // cluster is launched (NB: this can take 6+ seconds).
-func WithZk(t *testing.T, size int, defaultServer string, fn func(zkServers []string)) {
+//
+// Each invocation allocates a unique namespace for the test, see Namespace,
+// which is deleted once fn returns.
+func WithZk(t testing.TB, size int, defaultServer string, fn func(zkServers []string)) {
 	if size == 1 && len(defaultServer) > 0 {
 		conn, err := net.DialTimeout("tcp", defaultServer, zkTimeout)
 		if err == nil {
 			conn.Close()
 			log.Infof("Using already-running default ZooKeeper@%v", defaultServer)
-			fn([]string{defaultServer})
+			withNamespace(t, []string{defaultServer}, fn)
 			return
 		}
 	}
 	log.Infof("Starting a new ZooKeeper test cluster..")
 	WithTestZkCluster(t, size, func(zkServers []string) {
 		time.Sleep(100 * time.Millisecond) // Give ZooKeeper a moment to start up.
-		fn(zkServers)
+		withNamespace(t, zkServers, fn)
 	})
 }
@@ -32,7 +32,7 @@ var (
 
 type testLogger struct {
 	sync.Mutex
-	t               *testing.T
+	t               testing.TB
 	prefix          string
 	suffix          string
 	bindNotifier    chan struct{}
@@ -69,7 +69,7 @@ func (logger *testLogger) Reset() *testLogger {
 	return logger
 }
 
-func WithTestZkCluster(t *testing.T, size int, fn func(zkServers []string)) {
+func WithTestZkCluster(t testing.TB, size int, fn func(zkServers []string)) {
 	// Check for an already-running cluster.
 	testAlreadyUpLock.Lock()
 	var alreadyRunningServers []string
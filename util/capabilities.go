@@ -0,0 +1,218 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Capabilities describes which optional features a ZooKeeper server offers.
+//
+// NB: PersistentWatches and MultiRead describe the server only; the
+// go-zookeeper client issues neither.
+type Capabilities struct {
+	// Version is as reported by the server, e.g. "3.5.3-beta-8ce24f9e...".
+	// Empty when the server declined the "srvr" command, in which case Major
+	// and Minor are a lower bound inferred from the presence of the dynamic
+	// configuration zNode and the feature flags are conservative.
+	Version string `json:"version,omitempty"`
+	Major   int    `json:"major"`
+	Minor   int    `json:"minor"`
+	Patch   int    `json:"patch"`
+
+	Containers        bool `json:"containers"`        // 3.5.3+
+	TTL               bool `json:"ttl"`               // 3.5.3+ with zookeeper.extendedTypesEnabled set, see DetectCapabilities.
+	PersistentWatches bool `json:"persistentWatches"` // 3.6.0+
+	MultiRead         bool `json:"multiRead"`         // 3.6.0+
+}
+
+// AtLeast reports whether the server version is major.minor.patch or newer.
+func (caps Capabilities) AtLeast(major int, minor int, patch int) bool {
+	if caps.Major != major {
+		return caps.Major > major
+	}
+	if caps.Minor != minor {
+		return caps.Minor > minor
+	}
+	return caps.Patch >= patch
+}
+
+const (
+	zkConfigPath = "/zookeeper/config"
+
+	// ttlProbePath names a zNode beneath a parent which is not expected to
+	// exist, so probing for TTL support creates nothing: servers with
+	// extended types disabled reject the request before resolving the parent,
+	// while those with them enabled reply with NoNode.
+	ttlProbePath = "/zklib-ttl-probe/probe"
+)
+
+var (
+	detectedCapabilities     = map[string]Capabilities{}   // By server address.
+	refusedOps               = map[string]map[int32]bool{} // Wire opcodes refused, by server address.
+	detectedCapabilitiesLock sync.Mutex
+)
+
+// DetectCapabilities determines the capabilities of the server conn is
+// attached to, preferably by way of the "srvr" four letter word (permitted by
+// default since 3.5.3), otherwise from the presence of /zookeeper/config.
+// Servers new enough for TTL zNodes are additionally probed with a TTL create,
+// as the feature is disabled unless zookeeper.extendedTypesEnabled is set.
+//
+// The outcome is remembered per server, so CreateContainer and CreateTTL
+// needn't probe servers which are known not to support them, and later calls
+// for the same server return it without dialing again.  Nor is TTL support
+// probed for once the server has refused a TTL create.
+func DetectCapabilities(conn *zk.Conn) (Capabilities, error) {
+	server := conn.Server()
+	if server == "" {
+		return Capabilities{}, zk.ErrConnectionClosed
+	}
+	if caps, ok := knownCapabilities(conn); ok {
+		return caps, nil
+	}
+	if version, err := srvrVersion(server); err == nil {
+		caps, err := capabilitiesOf(version)
+		if err != nil {
+			return Capabilities{}, err
+		}
+		if caps.TTL && !supported(server, opCreateTTL) {
+			caps.TTL = false
+		} else if caps.TTL {
+			if caps.TTL, err = probeTTL(conn); err != nil {
+				return Capabilities{}, err
+			}
+		}
+		detectedCapabilitiesLock.Lock()
+		detectedCapabilities[server] = caps
+		detectedCapabilitiesLock.Unlock()
+		return caps, nil
+	}
+
+	exists, _, err := conn.Exists(zkConfigPath)
+	if err != nil {
+		return Capabilities{}, fmt.Errorf("checking zNode=%v: %s", zkConfigPath, err)
+	}
+	if exists {
+		return Capabilities{Major: 3, Minor: 5}, nil
+	}
+	return Capabilities{Major: 3, Minor: 4}, nil
+}
+
+// knownCapabilities returns the capabilities previously detected for the
+// server conn is attached to.
+func knownCapabilities(conn *zk.Conn) (caps Capabilities, ok bool) {
+	detectedCapabilitiesLock.Lock()
+	defer detectedCapabilitiesLock.Unlock()
+	caps, ok = detectedCapabilities[conn.Server()]
+	return
+}
+
+// supported reports whether opcode may be issued to server, i.e. it is neither
+// known to lack the capability nor has refused the opcode before.
+func supported(server string, opcode int32) bool {
+	detectedCapabilitiesLock.Lock()
+	defer detectedCapabilitiesLock.Unlock()
+	if caps, ok := detectedCapabilities[server]; ok {
+		if (opcode == opCreateContainer && !caps.Containers) || (opcode == opCreateTTL && !caps.TTL) {
+			return false
+		}
+	}
+	return !refusedOps[server][opcode]
+}
+
+// refuse remembers that server refused opcode with ErrUnsupported.
+func refuse(server string, opcode int32) {
+	detectedCapabilitiesLock.Lock()
+	defer detectedCapabilitiesLock.Unlock()
+	if refusedOps[server] == nil {
+		refusedOps[server] = map[int32]bool{}
+	}
+	refusedOps[server][opcode] = true
+}
+
+// probeTTL reports whether the server accepts TTL creates; see ttlProbePath.
+func probeTTL(conn *zk.Conn) (bool, error) {
+	var zNode string
+	err := withWire(conn, func(session *wireSession) (err error) {
+		zNode, err = session.create(opCreateTTL, ttlProbePath, []byte{}, FlagTTL, zk.WorldACL(zk.PermAll), time.Second)
+		return
+	})
+	switch err {
+	case zk.ErrNoNode:
+		return true, nil
+	case ErrUnsupported:
+		refuse(conn.Server(), opCreateTTL)
+		return false, nil
+	case nil:
+		// Somebody created the probe's parent; clean up after ourselves.
+		if err := conn.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+			log.Warnf("DetectCapabilities: failed removing TTL probe zNode=%v: %s", zNode, err)
+		}
+		return true, nil
+	default:
+		return false, fmt.Errorf("probing TTL support: %s", err)
+	}
+}
+
+// srvrVersion issues the "srvr" command and extracts the version.
+func srvrVersion(server string) (string, error) {
+	conn, err := net.DialTimeout("tcp", server, wireDialTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(wireDialTimeout))
+	if _, err := conn.Write([]byte("srvr")); err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "Zookeeper version: ") {
+			version := strings.TrimPrefix(line, "Zookeeper version: ")
+			if i := strings.Index(version, ","); i != -1 {
+				version = version[:i] // Strip the build date.
+			}
+			return version, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no version in srvr output from server=%v", server)
+}
+
+// capabilitiesOf derives the capabilities of a server version such as
+// "3.5.3-beta-8ce24f9e675cbefffb8f21a47e06b42864475a60".  TTL only says the
+// version is recent enough; whether the feature is enabled requires probeTTL.
+func capabilitiesOf(version string) (Capabilities, error) {
+	caps := Capabilities{Version: version}
+	numeric := version
+	if i := strings.Index(numeric, "-"); i != -1 {
+		numeric = numeric[:i]
+	}
+	pieces := strings.Split(numeric, ".")
+	if len(pieces) != 3 {
+		return Capabilities{}, fmt.Errorf("unrecognized server version=%q", version)
+	}
+	numbers := []*int{&caps.Major, &caps.Minor, &caps.Patch}
+	for i, piece := range pieces {
+		n, err := strconv.Atoi(piece)
+		if err != nil {
+			return Capabilities{}, fmt.Errorf("unrecognized server version=%q: %s", version, err)
+		}
+		*numbers[i] = n
+	}
+	caps.Containers = caps.AtLeast(3, 5, 3)
+	caps.TTL = caps.AtLeast(3, 5, 3)
+	caps.PersistentWatches = caps.AtLeast(3, 6, 0)
+	caps.MultiRead = caps.AtLeast(3, 6, 0)
+	return caps, nil
+}
@@ -0,0 +1,114 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestCapabilitiesOf(t *testing.T) {
+	testCases := []struct {
+		version    string
+		expected   Capabilities
+		shouldFail bool
+	}{
+		{
+			version:  "3.4.13-2d71af4dbe22557fda74f9a9b4309b15a7487f03",
+			expected: Capabilities{Major: 3, Minor: 4, Patch: 13},
+		},
+		{
+			version:  "3.5.2-alpha-1750793",
+			expected: Capabilities{Major: 3, Minor: 5, Patch: 2},
+		},
+		{
+			version:  "3.5.3-beta-8ce24f9e675cbefffb8f21a47e06b42864475a60",
+			expected: Capabilities{Major: 3, Minor: 5, Patch: 3, Containers: true, TTL: true},
+		},
+		{
+			version:  "3.6.1--104dcb3e3fb464b30c5186d229e00af9f332524b",
+			expected: Capabilities{Major: 3, Minor: 6, Patch: 1, Containers: true, TTL: true, PersistentWatches: true, MultiRead: true},
+		},
+		{version: "", shouldFail: true},
+		{version: "3.x.0", shouldFail: true},
+	}
+	for i, testCase := range testCases {
+		caps, err := capabilitiesOf(testCase.version)
+		if testCase.shouldFail {
+			if err == nil {
+				t.Errorf("[i=%v] Expected version=%q to be rejected", i, testCase.version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[i=%v] %s", i, err)
+			continue
+		}
+		testCase.expected.Version = testCase.version
+		if caps != testCase.expected {
+			t.Errorf("[i=%v] Expected caps=%+v but actual=%+v", i, testCase.expected, caps)
+		}
+	}
+}
+
+func TestDetectCapabilities(t *testing.T) {
+	testutil.WithTestZkCluster(t, 1, func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		testutil.WhenZkHasSession(zkEvents, func() {
+			caps, err := DetectCapabilities(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !caps.AtLeast(3, 4, 0) {
+				t.Errorf("Expected at least version 3.4.0 but caps=%+v", caps)
+			}
+			if caps.Version != "" {
+				if known, ok := knownCapabilities(conn); !ok || known != caps {
+					t.Errorf("Expected detected caps=%+v to be remembered, but known=%+v ok=%v", caps, known, ok)
+				}
+			}
+			if caps.AtLeast(3, 5, 3) {
+				supported, err := probeTTL(conn)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if supported != caps.TTL {
+					t.Errorf("Expected caps.TTL=%v to match the probe's outcome=%v", caps.TTL, supported)
+				}
+				if exists, _, err := conn.Exists(ttlProbePath); err != nil {
+					t.Fatal(err)
+				} else if exists {
+					t.Errorf("Expected probe zNode=%v not to exist", ttlProbePath)
+				}
+			}
+		})
+	})
+}
+
+func TestSupported(t *testing.T) {
+	const server = "supported.test:2181"
+	if !supported(server, opCreateTTL) || !supported(server, opCreateContainer) {
+		t.Fatalf("Expected operations to be attempted against an unknown server")
+	}
+	refuse(server, opCreateTTL)
+	if supported(server, opCreateTTL) {
+		t.Errorf("Expected a refused operation not to be attempted again")
+	}
+	if !supported(server, opCreateContainer) {
+		t.Errorf("Expected other operations to still be attempted")
+	}
+
+	detectedCapabilitiesLock.Lock()
+	detectedCapabilities[server] = Capabilities{Major: 3, Minor: 4}
+	detectedCapabilitiesLock.Unlock()
+	if supported(server, opCreateContainer) {
+		t.Errorf("Expected operations the server is known to lack not to be attempted")
+	}
+}
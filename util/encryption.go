@@ -0,0 +1,153 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Every payload sealed by an Encryptor is wrapped in an envelope, which opens
+// with encryptionMagic followed by the envelope version.  The magic's first
+// byte differs from the Compression headers and from the first byte of any
+// JSON document, and it is long enough that a plain payload doesn't start with
+// it by accident, so sealed and plain payloads are told apart.
+const (
+	encryptionMagic          = "\xe5zkenc"
+	encryptionVersion   byte = 1
+	encryptionHeaderLen      = len(encryptionMagic) + 2 // Magic, version and key id length.
+)
+
+var (
+	UnknownKeyError          = errors.New("unknown encryption key id")
+	NotEncryptedError        = errors.New("payload is not encrypted")
+	UnsupportedEnvelopeError = errors.New("unsupported encryption envelope version")
+)
+
+// KeyProvider supplies the AES keys (16, 24 or 32 bytes) of an Encryptor, by
+// id.  Every payload records the id of the key it was sealed with, so keys
+// may be rotated: switch the current key and retain the previous ones for as
+// long as payloads sealed with them may be around.
+type KeyProvider interface {
+	// CurrentKey returns the key new payloads are sealed with.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given id, or UnknownKeyError.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding the keys in memory.
+type StaticKeys struct {
+	Current string            // Id of the key to seal with.
+	Keys    map[string][]byte // Keys by id.
+}
+
+func (keys StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := keys.Key(keys.Current)
+	return keys.Current, key, err
+}
+
+func (keys StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := keys.Keys[id]
+	if !ok {
+		return nil, UnknownKeyError
+	}
+	return key, nil
+}
+
+// Encryptor seals zNode payloads with AES-GCM, so they aren't readable by
+// anyone with access to the ensemble but not to the keys.
+//
+// A sealed payload is laid out as the envelope magic, the envelope version
+// (one byte), the length of the key id (one byte), the key id, the nonce and
+// finally the ciphertext.  Everything up to the nonce is authenticated along
+// with the plaintext.  Payloads without the envelope magic, e.g. those written
+// before encryption was enabled, are passed through by Open unless
+// RequireEncryption is set, so encryption may be enabled during a rolling
+// deploy.
+type Encryptor struct {
+	Keys              KeyProvider
+	RequireEncryption bool // Makes Open reject plain payloads with NotEncryptedError.
+}
+
+// Seal encrypts plaintext with the current key.
+func (encryptor Encryptor) Seal(plaintext []byte) ([]byte, error) {
+	id, key, err := encryptor.Keys.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("getting current encryption key: %s", err)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("encryption key id=%q is longer than 255 bytes", id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key id=%v: %s", id, err)
+	}
+	header := append(append([]byte(encryptionMagic), encryptionVersion, byte(len(id))), id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generating nonce: %s", err)
+	}
+	sealed := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, header), nil
+}
+
+// Open decrypts data sealed by Seal with any key known to the KeyProvider.
+func (encryptor Encryptor) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(encryptionMagic)) {
+		if encryptor.RequireEncryption {
+			return nil, NotEncryptedError
+		}
+		return data, nil
+	}
+	id, ok := EncryptionKeyId(data)
+	if !ok {
+		if len(data) > len(encryptionMagic) && data[len(encryptionMagic)] != encryptionVersion {
+			return nil, fmt.Errorf("%s: %v", UnsupportedEnvelopeError, data[len(encryptionMagic)])
+		}
+		return nil, fmt.Errorf("decrypting %v byte payload: truncated", len(data))
+	}
+	key, err := encryptor.Keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("getting encryption key id=%v: %s", id, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key id=%v: %s", id, err)
+	}
+	headerLen := encryptionHeaderLen + len(id)
+	if len(data) < headerLen+aead.NonceSize() {
+		return nil, fmt.Errorf("decrypting %v byte payload: truncated", len(data))
+	}
+	header, nonce, ciphertext := data[:headerLen], data[headerLen:headerLen+aead.NonceSize()], data[headerLen+aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %v byte payload with key id=%v: %s", len(data), id, err)
+	}
+	return plaintext, nil
+}
+
+// EncryptionKeyId returns the id of the key data was sealed with, ok is false
+// when data isn't a sealed payload.  Useful to find payloads still sealed with
+// a retired key.
+func EncryptionKeyId(data []byte) (id string, ok bool) {
+	if len(data) < encryptionHeaderLen || !bytes.HasPrefix(data, []byte(encryptionMagic)) || data[len(encryptionMagic)] != encryptionVersion {
+		return "", false
+	}
+	idLen := int(data[encryptionHeaderLen-1])
+	if len(data) < encryptionHeaderLen+idLen {
+		return "", false
+	}
+	return string(data[encryptionHeaderLen : encryptionHeaderLen+idLen]), true
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
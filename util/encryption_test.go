@@ -0,0 +1,93 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncryptor(t *testing.T) {
+	keys := StaticKeys{
+		Current: "k1",
+		Keys: map[string][]byte{
+			"k1": bytes.Repeat([]byte{1}, 32),
+			"k2": bytes.Repeat([]byte{2}, 16),
+		},
+	}
+	encryptor := Encryptor{Keys: keys}
+	plaintext := []byte(`{"secret": "hunter2"}`)
+
+	sealed, err := encryptor.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("hunter2")) {
+		t.Fatalf("Expected the sealed payload not to contain the plaintext")
+	}
+	if id, ok := EncryptionKeyId(sealed); !ok || id != "k1" {
+		t.Errorf("Expected key id=k1 but actual=%v (ok=%v)", id, ok)
+	}
+	if opened, err := encryptor.Open(sealed); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Round-trip mismatch, opened=%q err=%v", opened, err)
+	}
+
+	// After rotation payloads sealed with the previous key remain readable.
+	keys.Current = "k2"
+	rotated := Encryptor{Keys: keys}
+	resealed, err := rotated.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := EncryptionKeyId(resealed); id != "k2" {
+		t.Errorf("Expected key id=k2 after rotation but actual=%v", id)
+	}
+	for _, data := range [][]byte{sealed, resealed} {
+		if opened, err := rotated.Open(data); err != nil || !bytes.Equal(opened, plaintext) {
+			t.Errorf("Round-trip mismatch after rotation, opened=%q err=%v", opened, err)
+		}
+	}
+
+	// Retired keys can no longer be read.
+	delete(keys.Keys, "k1")
+	if _, err := rotated.Open(sealed); err == nil || !strings.Contains(err.Error(), UnknownKeyError.Error()) {
+		t.Errorf("Expected err=%s but actual=%v", UnknownKeyError, err)
+	}
+
+	tampered := append([]byte{}, resealed...)
+	tampered[len(tampered)-1] ^= 0xff
+	if _, err := rotated.Open(tampered); err == nil {
+		t.Errorf("Expected tampering to be detected")
+	}
+
+	// Plain payloads pass through unless encryption is required.
+	if opened, err := rotated.Open(plaintext); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected plain payload to pass through, opened=%q err=%v", opened, err)
+	}
+	rotated.RequireEncryption = true
+	if _, err := rotated.Open(plaintext); err != NotEncryptedError {
+		t.Errorf("Expected err=%s but actual=%v", NotEncryptedError, err)
+	}
+}
+
+func TestEncryptorEnvelope(t *testing.T) {
+	encryptor := Encryptor{Keys: StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}}}
+
+	// Plain payloads merely sharing the envelope's first byte pass through.
+	legacy := []byte{0xe5, 0x01, 0x02, 'k', '1'}
+	if _, ok := EncryptionKeyId(legacy); ok {
+		t.Errorf("Expected payload=%q not to be taken for a sealed one", legacy)
+	}
+	if opened, err := encryptor.Open(legacy); err != nil || !bytes.Equal(opened, legacy) {
+		t.Errorf("Expected plain payload to pass through, opened=%q err=%v", opened, err)
+	}
+
+	sealed, err := encryptor.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := append([]byte{}, sealed...)
+	future[len(encryptionMagic)]++
+	if _, err := encryptor.Open(future); err == nil || !strings.Contains(err.Error(), UnsupportedEnvelopeError.Error()) {
+		t.Errorf("Expected err=%s but actual=%v", UnsupportedEnvelopeError, err)
+	}
+}
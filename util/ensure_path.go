@@ -0,0 +1,104 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// FlagContainer is the create flag of container zNodes.
+const FlagContainer int32 = 4
+
+// EnsurePathOptions controls how EnsurePath creates missing zNodes.
+type EnsurePathOptions struct {
+	// ACL is applied to created zNodes.  When nil they inherit the ACL of the
+	// deepest pre-existing ancestor.
+	ACL []zk.ACL
+
+	// Container creates zNodes as containers, which the server deletes once
+	// their last child is gone, on ZooKeeper 3.5.3 and newer.  Persistent
+	// zNodes are created instead on older servers, with a warning logged.
+	// Where the parent's ACL denies anonymous creation EnsurePath fails with
+	// ErrAnonymousNoAuth, see CreateContainer.
+	//
+	// NB: Every container created costs a connection and session handshake of
+	// its own, see CreateContainer.
+	Container bool
+}
+
+// EnsurePath creates path along with any missing parents, similarly to `mkdir
+// -p`, and returns the zNodes which were created.  Existing zNodes are left
+// untouched.
+func EnsurePath(conn *zk.Conn, path string, opts EnsurePathOptions) (created []string, err error) {
+	var (
+		soFar     string
+		inherited []zk.ACL
+	)
+	for _, piece := range strings.Split(strings.Trim(path, "/"), "/") {
+		parent := soFar
+		if parent == "" {
+			parent = "/"
+		}
+		soFar += "/" + piece
+
+		exists, _, err := conn.Exists(soFar)
+		if err != nil {
+			return created, fmt.Errorf("checking zNode=%v: %s", soFar, err)
+		}
+		if exists {
+			continue
+		}
+
+		acl := opts.ACL
+		if acl == nil {
+			if inherited == nil {
+				if inherited, _, err = conn.GetACL(parent); err != nil {
+					return created, fmt.Errorf("getting ACL of zNode=%v: %s", parent, err)
+				}
+			}
+			acl = inherited
+		}
+
+		var zNode string
+		if opts.Container {
+			zNode, err = CreateContainer(conn, soFar, []byte{}, acl)
+			if err == ErrUnsupported {
+				log.Warnf("EnsurePath: server does not support container zNodes, creating persistent zNode=%v instead", soFar)
+				zNode, err = conn.Create(soFar, []byte{}, 0, acl)
+			}
+		} else {
+			zNode, err = conn.Create(soFar, []byte{}, 0, acl)
+		}
+		if err == zk.ErrNodeExists {
+			continue // Created concurrently.
+		} else if err != nil {
+			return created, fmt.Errorf("creating zNode=%v: %s", soFar, err)
+		}
+		created = append(created, zNode)
+	}
+	return created, nil
+}
+
+// CreateContainer creates a container zNode.  Returns ErrUnsupported when the
+// server predates container zNodes.
+//
+// NB: go-zookeeper has no support for containers, so each is created over a
+// short-lived anonymous session of its own, i.e. a fresh connection and
+// handshake per call; ErrAnonymousNoAuth results when the parent's ACL
+// requires authentication.  Servers known not to support containers, per
+// DetectCapabilities or an earlier refusal, aren't dialed at all.
+func CreateContainer(conn *zk.Conn, path string, data []byte, acl []zk.ACL) (zNode string, err error) {
+	if !supported(conn.Server(), opCreateContainer) {
+		return "", ErrUnsupported
+	}
+	err = withWire(conn, func(session *wireSession) error {
+		zNode, err = session.create(opCreateContainer, path, data, FlagContainer, acl, 0)
+		return err
+	})
+	if err == ErrUnsupported {
+		refuse(conn.Server(), opCreateContainer)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/zklib/testutil"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestEnsurePath(t *testing.T) {
+	testutil.WithTestZkCluster(t, 1, func(zkServers []string) {
+		conn, zkEvents, err := zk.Connect(zkServers, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		testutil.WhenZkHasSession(zkEvents, func() {
+			root := "/TestEnsurePath"
+			if err := RecursivelyDelete(conn, root); err != nil {
+				t.Fatal(err)
+			}
+			restricted := zk.WorldACL(zk.PermRead | zk.PermWrite | zk.PermCreate | zk.PermDelete)
+			created, err := EnsurePath(conn, root+"/a", EnsurePathOptions{ACL: restricted})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := []string{root, root + "/a"}; !reflect.DeepEqual(created, expected) {
+				t.Errorf("Expected created=%v but actual=%v", expected, created)
+			}
+
+			// Missing zNodes inherit the deepest existing ancestor's ACL.
+			created, err = EnsurePath(conn, root+"/a/b/c", EnsurePathOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := []string{root + "/a/b", root + "/a/b/c"}; !reflect.DeepEqual(created, expected) {
+				t.Errorf("Expected created=%v but actual=%v", expected, created)
+			}
+			acl, _, err := conn.GetACL(root + "/a/b/c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(acl, restricted) {
+				t.Errorf("Expected inherited ACL=%+v but actual=%+v", restricted, acl)
+			}
+
+			// Idempotent.
+			if created, err = EnsurePath(conn, root+"/a/b/c", EnsurePathOptions{}); err != nil || len(created) != 0 {
+				t.Errorf("Expected no zNodes to be created for an existing path, but created=%v err=%v", created, err)
+			}
+
+			// Containers fall back to persistent zNodes on older servers.
+			if _, err := EnsurePath(conn, root+"/container/child", EnsurePathOptions{Container: true}); err != nil {
+				t.Fatal(err)
+			}
+			if exists, _, err := conn.Exists(root + "/container/child"); err != nil || !exists {
+				t.Errorf("Expected container path to exist, but exists=%v err=%v", exists, err)
+			}
+
+			// Anonymous container creation under an authenticated parent fails
+			// loudly instead of quietly creating a persistent zNode.
+			if err := conn.AddAuth("digest", []byte("user:secret")); err != nil {
+				t.Fatal(err)
+			}
+			protected := zk.DigestACL(zk.PermAll, "user", "secret")
+			if _, err := conn.Create(root+"/protected", []byte{}, 0, protected); err != nil {
+				t.Fatal(err)
+			}
+			if caps, err := DetectCapabilities(conn); err == nil && caps.Containers {
+				if _, err := EnsurePath(conn, root+"/protected/container", EnsurePathOptions{ACL: protected, Container: true}); err == nil {
+					t.Errorf("Expected anonymous container creation under a protected parent to fail")
+				}
+			}
+
+			if err := RecursivelyDelete(conn, root); err != nil {
+				t.Error(err)
+			}
+		})
+	})
+}
@@ -0,0 +1,161 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// DefaultMaxPayloadBytes is the payload size limit of Quotas which don't set
+// one.  It leaves headroom for the request overhead below ZooKeeper's default
+// jute.maxbuffer of 1MiB, beyond which the server drops the connection rather
+// than returning an error.
+const DefaultMaxPayloadBytes = 1024*1024 - 16*1024
+
+// Quota limits what may be written under a managed path.
+type Quota struct {
+	MaxPayloadBytes int // Of the path and its descendants, defaults to DefaultMaxPayloadBytes.
+	MaxChildren     int // Of the path itself, unlimited when zero.
+}
+
+func (quota Quota) maxPayloadBytes() int {
+	if quota.MaxPayloadBytes > 0 {
+		return quota.MaxPayloadBytes
+	}
+	return DefaultMaxPayloadBytes
+}
+
+// QuotaError is returned when a write would exceed a Quota.
+type QuotaError struct {
+	Path   string // The managed path whose quota applies.
+	ZNode  string // The zNode being written or, for children, created under.
+	Limit  string // "payload-bytes" or "children".
+	Max    int
+	Actual int
+}
+
+func (err QuotaError) Error() string {
+	return fmt.Sprintf("quota of path=%v exceeded by zNode=%v: %v=%v exceeds max=%v", err.Path, err.ZNode, err.Limit, err.Actual, err.Max)
+}
+
+// Quotas maps managed paths to their limits.  A quota covers the path's whole
+// subtree, and the most specific one applies.
+type Quotas map[string]Quota
+
+// For returns the quota covering zNode along with its managed path.
+func (quotas Quotas) For(zNode string) (quota Quota, path string, ok bool) {
+	for managed, q := range quotas {
+		managed = NormalizePath(managed)
+		if (zNode == managed || strings.HasPrefix(zNode, managed+"/")) && (!ok || len(managed) > len(path)) {
+			quota, path, ok = q, managed, true
+		}
+	}
+	return
+}
+
+// CheckPayload returns a QuotaError if writing size bytes to zNode exceeds
+// the quota covering it.
+func (quotas Quotas) CheckPayload(zNode string, size int) error {
+	quota, path, ok := quotas.For(zNode)
+	if !ok {
+		return nil
+	}
+	return quota.CheckPayload(path, zNode, size)
+}
+
+// CheckChildren returns a QuotaError if creating another child under parent,
+// which currently has children children, exceeds the quota of parent.
+func (quotas Quotas) CheckChildren(parent string, children int) error {
+	quota, path, ok := quotas.For(parent)
+	if !ok || path != NormalizePath(parent) {
+		return nil
+	}
+	return quota.CheckChildren(path, children)
+}
+
+// CheckPayload returns a QuotaError if writing size bytes to zNode, under
+// the managed path, exceeds quota.
+func (quota Quota) CheckPayload(path string, zNode string, size int) error {
+	if max := quota.maxPayloadBytes(); size > max {
+		return QuotaError{Path: path, ZNode: zNode, Limit: "payload-bytes", Max: max, Actual: size}
+	}
+	return nil
+}
+
+// CheckChildren returns a QuotaError if creating another child under the
+// managed path, which currently has children children, exceeds quota.
+func (quota Quota) CheckChildren(path string, children int) error {
+	if quota.MaxChildren > 0 && children >= quota.MaxChildren {
+		return QuotaError{Path: path, ZNode: path, Limit: "children", Max: quota.MaxChildren, Actual: children + 1}
+	}
+	return nil
+}
+
+// CountChildren returns the number of children of path, zero when it doesn't
+// exist, for use with CheckChildren.
+func CountChildren(conn *zk.Conn, path string) (int, error) {
+	exists, stat, err := conn.Exists(path)
+	if err != nil || !exists {
+		return 0, err
+	}
+	return int(stat.NumChildren), nil
+}
+
+// Usage is the current usage of a managed path relative to its quota.
+type Usage struct {
+	Path            string `json:"path"`
+	Children        int    `json:"children"`
+	MaxChildren     int    `json:"maxChildren,omitempty"` // Zero when unlimited.
+	LargestZNode    string `json:"largestZNode,omitempty"`
+	LargestPayload  int    `json:"largestPayload"` // Bytes, of the path or any of its children.
+	MaxPayloadBytes int    `json:"maxPayloadBytes"`
+}
+
+// Fraction returns how close usage is to the quota, as the larger of the
+// children and payload fractions, e.g. for alerting once above 0.8.
+func (usage Usage) Fraction() float64 {
+	fraction := float64(usage.LargestPayload) / float64(usage.MaxPayloadBytes)
+	if usage.MaxChildren > 0 {
+		if children := float64(usage.Children) / float64(usage.MaxChildren); children > fraction {
+			fraction = children
+		}
+	}
+	return fraction
+}
+
+// MeasureUsage reports the usage of path relative to quota.  Costs a request
+// per child.  A missing path has no usage.
+func MeasureUsage(conn *zk.Conn, path string, quota Quota) (Usage, error) {
+	usage := Usage{
+		Path:            path,
+		MaxChildren:     quota.MaxChildren,
+		MaxPayloadBytes: quota.maxPayloadBytes(),
+	}
+	exists, stat, err := conn.Exists(path)
+	if err != nil {
+		return usage, fmt.Errorf("measuring usage of path=%v: %s", path, err)
+	} else if !exists {
+		return usage, nil
+	}
+	usage.LargestZNode, usage.LargestPayload = path, int(stat.DataLength)
+	children, _, err := conn.Children(path)
+	if err == zk.ErrNoNode {
+		return usage, nil
+	} else if err != nil {
+		return usage, fmt.Errorf("measuring usage of path=%v: %s", path, err)
+	}
+	sort.Strings(children)
+	usage.Children = len(children)
+	for _, child := range children {
+		exists, stat, err := conn.Exists(path + "/" + child)
+		if err != nil {
+			return usage, fmt.Errorf("measuring usage of path=%v: %s", path, err)
+		}
+		if exists && int(stat.DataLength) > usage.LargestPayload {
+			usage.LargestZNode, usage.LargestPayload = path+"/"+child, int(stat.DataLength)
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	quotas := Quotas{
+		"/app":             {MaxPayloadBytes: 100},
+		"/app/queue/":      {MaxPayloadBytes: 10, MaxChildren: 2},
+		"/app/queue-other": {MaxChildren: 1},
+	}
+
+	testCases := []struct {
+		zNode string
+		path  string // Expected managed path, empty when uncovered.
+	}{
+		{"/app", "/app"},
+		{"/app/config", "/app"},
+		{"/app/queue", "/app/queue"},
+		{"/app/queue/item-0000000001", "/app/queue"},
+		{"/app/queue-other/x", "/app/queue-other"},
+		{"/application", ""},
+		{"/other", ""},
+	}
+	for i, testCase := range testCases {
+		_, path, ok := quotas.For(testCase.zNode)
+		if path != testCase.path || ok != (testCase.path != "") {
+			t.Errorf("[i=%v] Expected zNode=%v to be covered by path=%q but actual=%q (ok=%v)", i, testCase.zNode, testCase.path, path, ok)
+		}
+	}
+
+	if err := quotas.CheckPayload("/app/config", 100); err != nil {
+		t.Errorf("Expected a payload at the limit to be accepted but err=%s", err)
+	}
+	err := quotas.CheckPayload("/app/queue/item-0000000001", 11)
+	if quotaErr, ok := err.(QuotaError); !ok || quotaErr.Path != "/app/queue" || quotaErr.Limit != "payload-bytes" || quotaErr.Max != 10 || quotaErr.Actual != 11 {
+		t.Errorf("Expected a payload-bytes QuotaError but actual=%#v", err)
+	}
+	if err := quotas.CheckPayload("/other", DefaultMaxPayloadBytes*2); err != nil {
+		t.Errorf("Expected uncovered paths to be unlimited but err=%s", err)
+	}
+	if err := (Quota{}).CheckPayload("/x", "/x/y", DefaultMaxPayloadBytes+1); err == nil {
+		t.Errorf("Expected payloads beyond DefaultMaxPayloadBytes to be rejected by default")
+	}
+
+	if err := quotas.CheckChildren("/app/queue", 1); err != nil {
+		t.Errorf("Expected a second child to be accepted but err=%s", err)
+	}
+	err = quotas.CheckChildren("/app/queue", 2)
+	if quotaErr, ok := err.(QuotaError); !ok || quotaErr.Limit != "children" || quotaErr.Actual != 3 {
+		t.Errorf("Expected a children QuotaError but actual=%#v", err)
+	}
+	if err := quotas.CheckChildren("/app/queue/item-0000000001", 100); err != nil {
+		t.Errorf("Expected children limits not to apply to descendants but err=%s", err)
+	}
+
+	usage := Usage{Children: 3, MaxChildren: 4, LargestPayload: 10, MaxPayloadBytes: 100}
+	if fraction := usage.Fraction(); fraction != 0.75 {
+		t.Errorf("Expected fraction=0.75 but actual=%v", fraction)
+	}
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// sequenceLen is the number of digits ZooKeeper appends to sequential zNodes.
+const sequenceLen = 10
+
+// SequenceNumber extracts the ZooKeeper-assigned sequence number suffix from a
+// sequential zNode name (or path).
+func SequenceNumber(zNode string) (int64, error) {
+	if len(zNode) < sequenceLen {
+		return 0, fmt.Errorf("zNode=%v is too short to contain a sequence number", zNode)
+	}
+	n, err := strconv.ParseInt(zNode[len(zNode)-sequenceLen:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing sequence number from zNode=%v: %s", zNode, err)
+	}
+	return n, nil
+}
+
+// SortBySequence orders sequential zNode names by their sequence numbers.
+// Names without a valid sequence number are sorted to the end.
+func SortBySequence(zNodes []string) {
+	sort.Stable(bySequence(zNodes))
+}
+
+type bySequence []string
+
+func (zNodes bySequence) Len() int {
+	return len(zNodes)
+}
+
+func (zNodes bySequence) Less(i, j int) bool {
+	a, aErr := SequenceNumber(zNodes[i])
+	b, bErr := SequenceNumber(zNodes[j])
+	if aErr != nil {
+		return false
+	} else if bErr != nil {
+		return true
+	}
+	return a < b
+}
+
+func (zNodes bySequence) Swap(i, j int) {
+	zNodes[i], zNodes[j] = zNodes[j], zNodes[i]
+}
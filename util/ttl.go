@@ -0,0 +1,39 @@
+package util
+
+import (
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Create flags of TTL zNodes.
+const (
+	FlagTTL           int32 = 5
+	FlagSequentialTTL int32 = 6
+)
+
+// CreateTTL creates a persistent zNode which the server deletes once it has
+// gone unmodified for ttl and has no children.  flags may include
+// zk.FlagSequence.  Returns ErrUnsupported when the server predates TTL zNodes
+// (ZooKeeper 3.5.3) or has them disabled, as is the default unless
+// zookeeper.extendedTypesEnabled is set.
+//
+// NB: Created over a short-lived anonymous session of its own, at the cost of
+// a connection and handshake per call, see CreateContainer.
+func CreateTTL(conn *zk.Conn, path string, data []byte, flags int32, acl []zk.ACL, ttl time.Duration) (zNode string, err error) {
+	if !supported(conn.Server(), opCreateTTL) {
+		return "", ErrUnsupported
+	}
+	mode := FlagTTL
+	if flags&zk.FlagSequence != 0 {
+		mode = FlagSequentialTTL
+	}
+	err = withWire(conn, func(session *wireSession) error {
+		zNode, err = session.create(opCreateTTL, path, data, mode, acl, ttl)
+		return err
+	})
+	if err == ErrUnsupported {
+		refuse(conn.Server(), opCreateTTL)
+	}
+	return
+}
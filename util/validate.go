@@ -0,0 +1,50 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Validator checks a payload about to be written to, or read from, zNode and
+// returns a descriptive error when it is malformed, e.g. to keep members of a
+// mixed-version fleet from corrupting shared zNodes.  For zNodes which don't
+// exist yet, such as sequential ones, zNode is their parent.
+type Validator func(zNode string, data []byte) error
+
+// ValidationError is returned when a Validator rejects a payload.
+type ValidationError struct {
+	ZNode string
+	Err   error // As returned by the Validator.
+}
+
+func (err ValidationError) Error() string {
+	return fmt.Sprintf("invalid data for zNode=%v: %s", err.ZNode, err.Err)
+}
+
+// Validate runs validators against data in turn, returning a ValidationError
+// for the first to reject it.
+func Validate(validators []Validator, zNode string, data []byte) error {
+	for _, validator := range validators {
+		if err := validator(zNode, data); err != nil {
+			return ValidationError{ZNode: zNode, Err: err}
+		}
+	}
+	return nil
+}
+
+// RequireJSONFields returns a Validator accepting JSON objects which carry
+// every one of the given top-level fields.
+func RequireJSONFields(fields ...string) Validator {
+	return func(_ string, data []byte) error {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return fmt.Errorf("not a JSON object: %s", err)
+		}
+		for _, field := range fields {
+			if _, ok := object[field]; !ok {
+				return fmt.Errorf("missing field %q", field)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	validators := []Validator{
+		RequireJSONFields("version", "shard"),
+		func(_ string, data []byte) error {
+			if len(data) > 64 {
+				return errors.New("too large")
+			}
+			return nil
+		},
+	}
+	testCases := []struct {
+		data     string
+		expected string // Prefix of the error, empty when valid.
+	}{
+		{`{"version": 2, "shard": "a"}`, ""},
+		{`{"version": 2}`, `invalid data for zNode=/items: missing field "shard"`},
+		{`["version", "shard"]`, "invalid data for zNode=/items: not a JSON object: "},
+		{`{"version": 2, "shard": "a", "padding": "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"}`, "invalid data for zNode=/items: too large"},
+	}
+	for i, testCase := range testCases {
+		err := Validate(validators, "/items", []byte(testCase.data))
+		if testCase.expected == "" {
+			if err != nil {
+				t.Errorf("[i=%v] Expected data=%v to be valid but err=%s", i, testCase.data, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), testCase.expected) {
+			t.Errorf("[i=%v] Expected err=%q but actual=%v", i, testCase.expected, err)
+		}
+		if _, ok := err.(ValidationError); !ok {
+			t.Errorf("[i=%v] Expected a ValidationError but actual=%T", i, err)
+		}
+	}
+	if err := Validate(nil, "/items", []byte("anything")); err != nil {
+		t.Errorf("Expected no validators to accept anything but err=%s", err)
+	}
+}
@@ -0,0 +1,223 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// The github.com/samuel/go-zookeeper client offers no way to issue operations
+// introduced by ZooKeeper 3.5 such as container and TTL creates, so those are
+// issued over a short-lived session of our own speaking just enough of the
+// wire protocol.  Each such operation dials the server and handshakes afresh,
+// and the session is anonymous: it is not the caller's, and carries none of the
+// credentials added to the main connection, so operations the ACLs deny to
+// anyone are refused with ErrAnonymousNoAuth rather than zk.ErrNoAuth.  Servers
+// refusing an operation are remembered (see refuse), so it isn't reattempted.
+
+var (
+	// ErrUnsupported is returned when the server does not implement an
+	// operation, e.g. container zNodes on ZooKeeper older than 3.5.3.
+	ErrUnsupported = errors.New("zk: operation not supported by the server")
+
+	// ErrAnonymousNoAuth is returned when an operation issued over an
+	// anonymous session is denied by the ACLs.
+	ErrAnonymousNoAuth = errors.New("zk: not authenticated, the operation was issued over an anonymous session which lacks the connection's credentials")
+)
+
+const (
+	opCreateContainer int32 = 19
+	opCreateTTL       int32 = 21
+	opCloseSession    int32 = -11
+
+	errCodeUnimplemented = -6
+)
+
+var wireDialTimeout = 5 * time.Second
+
+// wireErrors maps server error codes to go-zookeeper's errors.
+var wireErrors = map[int32]error{
+	errCodeUnimplemented: ErrUnsupported,
+	-100:                 zk.ErrAPIError,
+	-101:                 zk.ErrNoNode,
+	-102:                 zk.ErrNoAuth,
+	-103:                 zk.ErrBadVersion,
+	-108:                 zk.ErrNoChildrenForEphemerals,
+	-110:                 zk.ErrNodeExists,
+	-111:                 zk.ErrNotEmpty,
+	-112:                 zk.ErrSessionExpired,
+	-114:                 zk.ErrInvalidACL,
+	-115:                 zk.ErrAuthFailed,
+}
+
+type wireSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+	xid  int32
+}
+
+// dialWire establishes an anonymous session with server.
+func dialWire(server string, sessionTimeout time.Duration) (*wireSession, error) {
+	conn, err := net.DialTimeout("tcp", server, wireDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	session := &wireSession{conn: conn, r: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(wireDialTimeout))
+
+	var req bytes.Buffer
+	writeInt32(&req, 0) // Protocol version.
+	writeInt64(&req, 0) // Last zxid seen.
+	writeInt32(&req, int32(sessionTimeout/time.Millisecond))
+	writeInt64(&req, 0) // Session id.
+	writeBuffer(&req, make([]byte, 16))
+	if err := session.send(req.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := session.receive()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(resp) < 16 || binary.BigEndian.Uint64(resp[8:16]) == 0 {
+		conn.Close()
+		return nil, zk.ErrSessionExpired
+	}
+	return session, nil
+}
+
+// create issues a create-style operation and returns the created path.  ttl
+// is only sent for opCreateTTL.
+func (session *wireSession) create(opcode int32, path string, data []byte, flags int32, acl []zk.ACL, ttl time.Duration) (string, error) {
+	var req bytes.Buffer
+	session.xid++
+	writeInt32(&req, session.xid)
+	writeInt32(&req, opcode)
+	writeString(&req, path)
+	writeBuffer(&req, data)
+	writeInt32(&req, int32(len(acl)))
+	for _, entry := range acl {
+		writeInt32(&req, entry.Perms)
+		writeString(&req, entry.Scheme)
+		writeString(&req, entry.ID)
+	}
+	writeInt32(&req, flags)
+	if opcode == opCreateTTL {
+		writeInt64(&req, int64(ttl/time.Millisecond))
+	}
+
+	session.conn.SetDeadline(time.Now().Add(wireDialTimeout))
+	if err := session.send(req.Bytes()); err != nil {
+		return "", err
+	}
+	resp, err := session.receive()
+	if err != nil {
+		if err == io.EOF {
+			// Servers predating the operation drop the connection.
+			return "", ErrUnsupported
+		}
+		return "", err
+	}
+	if len(resp) < 16 {
+		return "", fmt.Errorf("zk: short reply of %v bytes", len(resp))
+	}
+	if code := int32(binary.BigEndian.Uint32(resp[12:16])); code != 0 {
+		if err, ok := wireErrors[code]; ok && err == zk.ErrNoAuth {
+			return "", ErrAnonymousNoAuth
+		} else if ok {
+			return "", err
+		}
+		return "", fmt.Errorf("zk: server error code=%v", code)
+	}
+	body := resp[16:]
+	if len(body) < 4 {
+		return path, nil
+	}
+	n := int(binary.BigEndian.Uint32(body[0:4]))
+	if n < 0 || 4+n > len(body) {
+		return path, nil
+	}
+	return string(body[4 : 4+n]), nil
+}
+
+// close ends the session so the server needn't wait for it to time out.
+func (session *wireSession) close() {
+	var req bytes.Buffer
+	session.xid++
+	writeInt32(&req, session.xid)
+	writeInt32(&req, opCloseSession)
+	session.conn.SetDeadline(time.Now().Add(wireDialTimeout))
+	if session.send(req.Bytes()) == nil {
+		session.receive()
+	}
+	session.conn.Close()
+}
+
+func (session *wireSession) send(packet []byte) error {
+	frame := make([]byte, 4+len(packet))
+	binary.BigEndian.PutUint32(frame, uint32(len(packet)))
+	copy(frame[4:], packet)
+	_, err := session.conn.Write(frame)
+	return err
+}
+
+func (session *wireSession) receive() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(session.r, header[:]); err != nil {
+		return nil, err
+	}
+	packet := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(session.r, packet); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// withWire runs fn with an anonymous session to the server conn is attached
+// to.
+func withWire(conn *zk.Conn, fn func(session *wireSession) error) error {
+	server := conn.Server()
+	if server == "" {
+		return zk.ErrConnectionClosed
+	}
+	session, err := dialWire(server, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	return fn(session)
+}
+
+func writeInt32(buf *bytes.Buffer, v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	buf.Write(b[:])
+}
+
+func writeInt64(buf *bytes.Buffer, v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	buf.Write(b[:])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeInt32(buf, int32(len(s)))
+	buf.WriteString(s)
+}
+
+func writeBuffer(buf *bytes.Buffer, b []byte) {
+	if b == nil {
+		writeInt32(buf, -1)
+		return
+	}
+	writeInt32(buf, int32(len(b)))
+	buf.Write(b)
+}
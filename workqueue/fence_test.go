@@ -0,0 +1,60 @@
+package workqueue
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+func TestMoveFenced(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/workqueue", testlib.CurrentRunningTest())
+		q, err := New(zkServers, 5*time.Second, basePath, "deposed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer q.Stop()
+		for deadline := time.Now().Add(5 * time.Second); q.Coordinator.Mode() != primitives.Leader; time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for the queue to lead")
+			}
+		}
+
+		err = zkutil.WithZkSession(zkServers, 5*time.Second, func(conn *zk.Conn) error {
+			from := basePath + "/fenced-item"
+			if _, err := conn.Create(from, []byte("work"), 0, worldAllAcl); err != nil {
+				return err
+			}
+			// NB: Being the only candidate, the leader keeps believing it
+			// leads once its zNode is gone, as a partitioned one would.
+			if err := conn.Delete(q.Coordinator.Status().ZNode, -1); err != nil {
+				return err
+			}
+			if err := q.move(conn, from, "member"); err == nil || !strings.Contains(err.Error(), "no longer leading") {
+				t.Errorf("Expected the move to be fenced but actual err=%v", err)
+			}
+			if data, _, err := conn.Get(from); err != nil || string(data) != "work" {
+				t.Errorf("Expected the fenced item to be left in place but actual data=%q err=%v", string(data), err)
+			}
+			if exists, _, err := conn.Exists(q.partitionPath("member") + "/" + path.Base(from)); err != nil || exists {
+				t.Errorf("Expected the fenced item not to be assigned but actual exists=%v err=%v", exists, err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,494 @@
+package workqueue
+
+// Leader-driven work assignment recipe.
+//
+// Work items are submitted under <basePath>/pending.  The leader of the
+// election at <basePath>/election moves each of them to the least loaded
+// member's partition of the <basePath>/assignments subtree, and moves the
+// items of departed members on to the remaining ones.  Members watch their
+// own partition, process what appears there and report completion, which
+// deletes the item.
+//
+// Items are reassigned only when their member departs, never between live
+// members, so an item is processed at least once and, barring departures
+// mid-processing, exactly once.  Members being drained (see
+// cluster.IsDraining) keep the items already assigned to them but are
+// assigned no more.
+//
+// Every move is fenced on the leader's candidate zNode (see
+// cluster.LeaderFence), so a leader which has left the election, e.g. after
+// its session expired, cannot move items behind its successor's back.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gigawattio/zklib/cluster"
+	"github.com/gigawattio/zklib/cluster/primitives"
+	zkutil "github.com/gigawattio/zklib/util"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/samuel/go-zookeeper/zk"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	ItemsChanSize = 100
+
+	electionDir    = "election"
+	pendingDir     = "pending"
+	assignmentsDir = "assignments"
+	itemPrefix     = "item-"
+)
+
+var (
+	worldAllAcl   = zk.WorldACL(zk.PermAll)
+	retryInterval = 1 * time.Second
+)
+
+// Item is a unit of work.
+type Item struct {
+	Id   string
+	Data []byte
+}
+
+// Queue participates in work assignment as a member, and as the assigner
+// whenever it leads.
+type Queue struct {
+	Coordinator *cluster.Coordinator
+
+	// Validators vet the data of items submitted via Submit, rejecting
+	// malformed ones with a util.ValidationError.
+	Validators []zkutil.Validator
+
+	// Quota, when non-nil, limits the size of items submitted via Submit and
+	// the number of pending items, rejecting submissions exceeding it with a
+	// util.QuotaError.  See Usage.
+	Quota *zkutil.Quota
+
+	basePath  string
+	items     chan Item
+	updates   chan primitives.Update
+	delivered map[string]struct{} // Ids of the local items handed out and not yet gone.
+	quitChan  chan struct{}
+	doneChan  chan struct{}
+	lock      sync.Mutex
+}
+
+func New(zkServers []string, sessionTimeout time.Duration, basePath string, data string) (*Queue, error) {
+	q := &Queue{
+		basePath:  zkutil.NormalizePath(basePath),
+		items:     make(chan Item, ItemsChanSize),
+		updates:   make(chan primitives.Update, 10),
+		delivered: map[string]struct{}{},
+	}
+	cc, err := cluster.NewCoordinator(zkServers, sessionTimeout, q.basePath+"/"+electionDir, data, q.updates)
+	if err != nil {
+		return nil, fmt.Errorf("workqueue.New: %s", err)
+	}
+	q.Coordinator = cc
+	return q, nil
+}
+
+// Submit adds a work item to the queue at basePath and returns its id, once
+// validators (if any) have accepted its data.  It needn't be invoked by a
+// member.
+func Submit(conn *zk.Conn, basePath string, data []byte, validators ...zkutil.Validator) (string, error) {
+	id, err := newItemId()
+	if err != nil {
+		return "", err
+	}
+	if err := submit(conn, basePath, id, data, validators); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// submit creates the item with the given id.  Ids are generated up front
+// rather than by a sequential create, so a retry following a lost reply finds
+// the item already there instead of submitting a duplicate.
+func submit(conn *zk.Conn, basePath string, id string, data []byte, validators []zkutil.Validator) error {
+	pendingPath := zkutil.NormalizePath(basePath) + "/" + pendingDir
+	if err := zkutil.Validate(validators, pendingPath, data); err != nil {
+		return err
+	}
+	zNode := pendingPath + "/" + id
+	_, err := conn.Create(zNode, data, 0, worldAllAcl)
+	if err == zk.ErrNoNode {
+		if _, err = zkutil.EnsurePath(conn, pendingPath, zkutil.EnsurePathOptions{ACL: worldAllAcl}); err == nil {
+			_, err = conn.Create(zNode, data, 0, worldAllAcl)
+		}
+	}
+	if err == zk.ErrNodeExists {
+		return nil // Created by an attempt whose reply was lost.
+	} else if err != nil {
+		return fmt.Errorf("submitting work item under path=%v: %s", pendingPath, err)
+	}
+	return nil
+}
+
+func newItemId() (string, error) {
+	uid, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("generating work item id: %s", err)
+	}
+	return itemPrefix + uid.String(), nil
+}
+
+// Items returns the stream of work items assigned to the local member.  Each
+// must eventually be reported via Complete.
+func (q *Queue) Items() <-chan Item {
+	return q.items
+}
+
+func (q *Queue) Start() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.quitChan != nil {
+		return errors.New("workqueue: already started")
+	}
+	if err := q.Coordinator.Start(); err != nil {
+		return err
+	}
+	q.quitChan = make(chan struct{})
+	q.doneChan = make(chan struct{})
+	go q.run(q.quitChan, q.doneChan)
+	return nil
+}
+
+// Stop leaves the queue.  Items assigned to the local member which have not
+// been completed are reassigned by the leader once the departure is noticed.
+func (q *Queue) Stop() error {
+	q.lock.Lock()
+	quitChan, doneChan := q.quitChan, q.doneChan
+	q.quitChan, q.doneChan = nil, nil
+	q.lock.Unlock()
+
+	if quitChan == nil {
+		return errors.New("workqueue: already stopped")
+	}
+	close(quitChan)
+	<-doneChan
+	return q.Coordinator.Stop()
+}
+
+// Submit adds a work item using the Coordinator's connection.  Retried
+// attempts reuse the same id, so the item is submitted at most once.
+func (q *Queue) Submit(ctx context.Context, data []byte) (string, error) {
+	id, err := newItemId()
+	if err != nil {
+		return "", err
+	}
+	err = q.Coordinator.Do(ctx, func(conn *zk.Conn) error {
+		if err := q.checkQuota(conn, data); err != nil {
+			return err
+		}
+		return submit(conn, q.basePath, id, data, q.Validators)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Usage reports the number of pending items and the largest of them relative
+// to Quota (or the default limits when nil).
+func (q *Queue) Usage(ctx context.Context) (usage zkutil.Usage, err error) {
+	var quota zkutil.Quota
+	if q.Quota != nil {
+		quota = *q.Quota
+	}
+	err = q.Coordinator.Do(ctx, func(conn *zk.Conn) (err error) {
+		usage, err = zkutil.MeasureUsage(conn, q.basePath+"/"+pendingDir, quota)
+		return
+	})
+	return
+}
+
+// checkQuota vets submitting an item with the given data against Quota.
+func (q *Queue) checkQuota(conn *zk.Conn, data []byte) error {
+	if q.Quota == nil {
+		return nil
+	}
+	pendingPath := q.basePath + "/" + pendingDir
+	if err := q.Quota.CheckPayload(pendingPath, pendingPath+"/"+itemPrefix, len(data)); err != nil {
+		return err
+	}
+	if q.Quota.MaxChildren == 0 {
+		return nil
+	}
+	pending, err := zkutil.CountChildren(conn, pendingPath)
+	if err != nil {
+		return err
+	}
+	return q.Quota.CheckChildren(pendingPath, pending)
+}
+
+// Complete reports that the local member has finished processing the item
+// with the given id, removing it from the queue.
+func (q *Queue) Complete(ctx context.Context, id string) error {
+	zNode := q.partitionPath(q.Coordinator.LocalNode.Uuid.String()) + "/" + id
+	err := q.Coordinator.Do(ctx, func(conn *zk.Conn) error {
+		if err := conn.Delete(zNode, -1); err != nil && err != zk.ErrNoNode {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("workqueue: completing item=%v: %s", id, err)
+	}
+	return nil
+}
+
+func (q *Queue) run(quitChan chan struct{}, doneChan chan struct{}) {
+	defer close(doneChan)
+	var (
+		local               = q.Coordinator.LocalNode.Uuid.String()
+		pendingCh, assignCh <-chan zk.Event
+		armedConn           *zk.Conn // Connection the watches were armed with.
+		retryCh             <-chan time.Time
+	)
+	for {
+		conn := q.Coordinator.Conn()
+		if conn != armedConn {
+			pendingCh, assignCh = nil, nil
+			armedConn = conn
+		}
+		ok := conn != nil
+		if ok && q.Coordinator.Mode() == primitives.Leader {
+			if err := q.assign(conn); err != nil {
+				log.Warnf("workqueue path=%v: assigning work (will retry): %s", q.basePath, err)
+				ok = false
+			} else if pendingCh == nil {
+				pendingCh, ok = q.watch(conn, q.basePath+"/"+pendingDir)
+			}
+		} else {
+			pendingCh = nil
+		}
+		if ok && assignCh == nil {
+			assignCh, ok = q.watch(conn, q.partitionPath(local))
+		}
+		if ok {
+			ok = q.deliver(conn, local, quitChan)
+		}
+		retryCh = nil
+		if !ok {
+			retryCh = time.After(retryInterval)
+		}
+
+		select {
+		case <-pendingCh:
+			pendingCh = nil
+		case <-assignCh:
+			assignCh = nil
+		case <-q.updates:
+		case <-retryCh:
+		case <-quitChan:
+			return
+		}
+	}
+}
+
+// watch arms a children watch on zNode, or an existence watch while it is
+// missing.
+func (q *Queue) watch(conn *zk.Conn, zNode string) (<-chan zk.Event, bool) {
+	_, _, evCh, err := conn.ChildrenW(zNode)
+	if err == zk.ErrNoNode {
+		var exists bool
+		if exists, _, evCh, err = conn.ExistsW(zNode); err == nil && exists {
+			return q.watch(conn, zNode)
+		}
+	}
+	if err != nil {
+		log.Warnf("workqueue: watching path=%v (will retry): %s", zNode, err)
+		return nil, false
+	}
+	return evCh, true
+}
+
+// deliver hands out newly assigned local items.
+func (q *Queue) deliver(conn *zk.Conn, local string, quitChan chan struct{}) bool {
+	partitionPath := q.partitionPath(local)
+	children, _, err := conn.Children(partitionPath)
+	if err == zk.ErrNoNode {
+		return true
+	} else if err != nil {
+		log.Warnf("workqueue: listing path=%v (will retry): %s", partitionPath, err)
+		return false
+	}
+	sort.Strings(children)
+
+	q.lock.Lock()
+	current := map[string]struct{}{}
+	fresh := []string{}
+	for _, child := range children {
+		current[child] = struct{}{}
+		if _, ok := q.delivered[child]; !ok {
+			fresh = append(fresh, child)
+		}
+	}
+	for id := range q.delivered {
+		if _, ok := current[id]; !ok {
+			delete(q.delivered, id)
+		}
+	}
+	q.lock.Unlock()
+
+	for _, id := range fresh {
+		data, _, err := conn.Get(partitionPath + "/" + id)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			log.Warnf("workqueue: reading item=%v (will retry): %s", id, err)
+			return false
+		}
+		select {
+		case q.items <- Item{Id: id, Data: data}:
+		case <-quitChan:
+			return true
+		}
+		q.lock.Lock()
+		q.delivered[id] = struct{}{}
+		q.lock.Unlock()
+	}
+	return true
+}
+
+// assign moves pending items and those of departed members to the least
+// loaded members which aren't draining.  Only invoked by the leader.
+func (q *Queue) assign(conn *zk.Conn) error {
+	members, err := q.Coordinator.Members()
+	if err != nil {
+		return err
+	}
+	var (
+		present = map[string]struct{}{}
+		load    = map[string]int{} // Of the members eligible for more work.
+	)
+	for _, member := range members {
+		present[member.Uuid.String()] = struct{}{}
+		if !cluster.IsDraining(member) {
+			load[member.Uuid.String()] = 0
+		}
+	}
+	if len(load) == 0 {
+		return nil
+	}
+
+	assignmentsPath := q.basePath + "/" + assignmentsDir
+	if _, err := zkutil.EnsurePath(conn, assignmentsPath, zkutil.EnsurePathOptions{ACL: worldAllAcl}); err != nil {
+		return err
+	}
+	partitions, _, err := conn.Children(assignmentsPath)
+	if err != nil {
+		return err
+	}
+	orphaned := []string{} // Paths of items assigned to departed members.
+	for _, partition := range partitions {
+		items, _, err := conn.Children(assignmentsPath + "/" + partition)
+		if err == zk.ErrNoNode {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, ok := present[partition]; ok {
+			if _, ok := load[partition]; ok {
+				load[partition] = len(items)
+			}
+			continue
+		}
+		sort.Strings(items)
+		for _, item := range items {
+			orphaned = append(orphaned, assignmentsPath+"/"+partition+"/"+item)
+		}
+	}
+
+	pendingPath := q.basePath + "/" + pendingDir
+	pending, _, err := conn.Children(pendingPath)
+	if err != nil && err != zk.ErrNoNode {
+		return err
+	}
+	sort.Strings(pending)
+	for _, item := range pending {
+		orphaned = append(orphaned, pendingPath+"/"+item)
+	}
+
+	for _, from := range orphaned {
+		member := leastLoaded(load)
+		if err := q.move(conn, from, member); err != nil {
+			return err
+		}
+		load[member]++
+	}
+
+	// Tidy up the partitions of departed members.
+	for _, partition := range partitions {
+		if _, ok := present[partition]; !ok {
+			if err := conn.Delete(assignmentsPath+"/"+partition, -1); err != nil && err != zk.ErrNoNode && err != zk.ErrNotEmpty {
+				log.Warnf("workqueue: removing partition=%v: %s", partition, err)
+			}
+		}
+	}
+	return nil
+}
+
+// move atomically reassigns the item at from to member, provided the local
+// member still leads.
+func (q *Queue) move(conn *zk.Conn, from string, member string) error {
+	fence, err := q.Coordinator.LeaderFence()
+	if err != nil {
+		return err
+	}
+	data, stat, err := conn.Get(from)
+	if err == zk.ErrNoNode {
+		return nil // Completed meanwhile.
+	} else if err != nil {
+		return err
+	}
+	partitionPath := q.partitionPath(member)
+	if _, err := conn.Create(partitionPath, []byte{}, 0, worldAllAcl); err != nil && err != zk.ErrNodeExists {
+		return err
+	}
+	to := partitionPath + "/" + path.Base(from)
+	responses, err := conn.Multi(
+		fence,
+		&zk.CreateRequest{Path: to, Data: data, Acl: worldAllAcl},
+		&zk.DeleteRequest{Path: from, Version: stat.Version},
+	)
+	if cluster.Fenced(responses, err) {
+		return fmt.Errorf("moving item from=%v to=%v: no longer leading: %s", from, to, err)
+	} else if err == zk.ErrNoNode || err == zk.ErrBadVersion {
+		return nil // Completed or reassigned meanwhile.
+	} else if err != nil {
+		return fmt.Errorf("moving item from=%v to=%v: %s", from, to, err)
+	}
+	log.Debugf("workqueue: assigned item from=%v to=%v", from, to)
+	return nil
+}
+
+func (q *Queue) partitionPath(member string) string {
+	return q.basePath + "/" + assignmentsDir + "/" + member
+}
+
+// leastLoaded returns the member with the fewest items, breaking ties by
+// uuid.
+func leastLoaded(load map[string]int) string {
+	members := make([]string, 0, len(load))
+	for member := range load {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	best := members[0]
+	for _, member := range members[1:] {
+		if load[member] < load[best] {
+			best = member
+		}
+	}
+	return best
+}
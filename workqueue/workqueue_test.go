@@ -0,0 +1,146 @@
+package workqueue_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gigawattio/testlib"
+	"github.com/gigawattio/zklib/cluster"
+	zktestutil "github.com/gigawattio/zklib/testutil"
+	"github.com/gigawattio/zklib/workqueue"
+)
+
+var zkTimeout = 5 * time.Second
+
+func TestQueue(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/workqueue", testlib.CurrentRunningTest())
+
+		queues := []*workqueue.Queue{}
+		for i := 0; i < 2; i++ {
+			q, err := workqueue.New(zkServers, zkTimeout, basePath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Start(); err != nil {
+				t.Fatal(err)
+			}
+			queues = append(queues, q)
+		}
+		defer func() {
+			if err := queues[1].Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		const numItems = 4
+		for i := 0; i < numItems; i++ {
+			if _, err := queues[0].Submit(ctx, []byte(fmt.Sprintf("work-%v", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Every item is assigned to exactly one member; leave those of the
+		// first member incomplete.
+		var (
+			seen       = map[string]int{}
+			incomplete = 0
+			timeout    = time.After(10 * time.Second)
+		)
+		for len(seen) < numItems {
+			select {
+			case item := <-queues[0].Items():
+				seen[string(item.Data)]++
+				incomplete++
+			case item := <-queues[1].Items():
+				seen[string(item.Data)]++
+				if err := queues[1].Complete(ctx, item.Id); err != nil {
+					t.Fatal(err)
+				}
+			case <-timeout:
+				t.Fatalf("Expected %v items to be assigned but only saw %v", numItems, len(seen))
+			}
+		}
+		for data, n := range seen {
+			if n != 1 {
+				t.Errorf("Expected item=%v to be assigned once but was assigned %v times", data, n)
+			}
+		}
+		if incomplete == 0 || incomplete == numItems {
+			t.Errorf("Expected work to be spread over both members, but the first member got %v of %v items", incomplete, numItems)
+		}
+
+		// Incomplete work of departed members is reassigned.
+		if err := queues[0].Stop(); err != nil {
+			t.Fatal(err)
+		}
+		for reassigned := 0; reassigned < incomplete; {
+			select {
+			case item := <-queues[1].Items():
+				reassigned++
+				if err := queues[1].Complete(ctx, item.Id); err != nil {
+					t.Fatal(err)
+				}
+			case <-timeout:
+				t.Fatalf("Expected %v items to be reassigned but only saw %v", incomplete, reassigned)
+			}
+		}
+	})
+}
+
+func TestQueueSkipsDraining(t *testing.T) {
+	zktestutil.WithZk(t, 1, "127.0.0.1:2181", func(zkServers []string) {
+		basePath := fmt.Sprintf("/%v/workqueue", testlib.CurrentRunningTest())
+
+		queues := []*workqueue.Queue{}
+		for i := 0; i < 2; i++ {
+			q, err := workqueue.New(zkServers, zkTimeout, basePath, fmt.Sprintf("i=%v", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 {
+				q.Coordinator.LocalNode.Labels = map[string]string{cluster.DrainingLabel: "true"}
+			}
+			if err := q.Start(); err != nil {
+				t.Fatal(err)
+			}
+			queues = append(queues, q)
+		}
+		defer func() {
+			for _, q := range queues {
+				if err := q.Stop(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		const numItems = 4
+		for i := 0; i < numItems; i++ {
+			if _, err := queues[0].Submit(ctx, []byte(fmt.Sprintf("work-%v", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		timeout := time.After(10 * time.Second)
+		for received := 0; received < numItems; {
+			select {
+			case item := <-queues[0].Items():
+				received++
+				if err := queues[0].Complete(ctx, item.Id); err != nil {
+					t.Fatal(err)
+				}
+			case item := <-queues[1].Items():
+				t.Fatalf("Expected the draining member to be assigned nothing, but it got item=%v", item.Id)
+			case <-timeout:
+				t.Fatalf("Expected %v items to be assigned but only saw %v", numItems, received)
+			}
+		}
+	})
+}